@@ -0,0 +1,38 @@
+package htsdb
+
+// FindAdapter returns the position of the first occurrence of adapter in seq
+// with at most maxMismatch mismatches, or -1 if there is none. Occurrences
+// that run past the 3' end of seq are reported if at least minOverlap bases
+// of the adapter prefix overlap seq; mismatches are then limited in
+// proportion to the overlap. Comparison ignores case and N never matches.
+func FindAdapter(seq, adapter string, maxMismatch, minOverlap int) int {
+	if len(adapter) == 0 {
+		return -1
+	}
+	if minOverlap <= 0 || minOverlap > len(adapter) {
+		minOverlap = len(adapter)
+	}
+	for i := 0; i+minOverlap <= len(seq); i++ {
+		n := len(adapter)
+		allowed := maxMismatch
+		if i+n > len(seq) {
+			n = len(seq) - i
+			allowed = maxMismatch * n / len(adapter)
+		}
+		mm := 0
+		for j := 0; j < n && mm <= allowed; j++ {
+			if !baseMatch(seq[i+j], adapter[j]) {
+				mm++
+			}
+		}
+		if mm <= allowed {
+			return i
+		}
+	}
+	return -1
+}
+
+func baseMatch(a, b byte) bool {
+	a, b = a|0x20, b|0x20
+	return a == b && a != 'n'
+}
@@ -0,0 +1,28 @@
+package htsdb
+
+import "testing"
+
+var adapterTests = []struct {
+	Seq, Adapter         string
+	Mismatch, MinOverlap int
+	Pos                  int
+}{
+	{Seq: "ACGTACGTTGGAATTCTC", Adapter: "TGGAATTCTC", Pos: 8},
+	{Seq: "ACGTACGTtggaattctc", Adapter: "TGGAATTCTC", Pos: 8},
+	{Seq: "ACGTACGTTGGTATTCTC", Adapter: "TGGAATTCTC", Pos: -1},
+	{Seq: "ACGTACGTTGGTATTCTC", Adapter: "TGGAATTCTC", Mismatch: 1, Pos: 8},
+	{Seq: "ACGTACGTACGTTGGAA", Adapter: "TGGAATTCTC", MinOverlap: 5, Pos: 12},
+	{Seq: "ACGTACGTACGTTGGAA", Adapter: "TGGAATTCTC", MinOverlap: 6, Pos: -1},
+	{Seq: "ACGTACGTTGGNATTCTC", Adapter: "TGGAATTCTC", Pos: -1},
+	{Seq: "ACGT", Adapter: "", Pos: -1},
+}
+
+func TestFindAdapter(t *testing.T) {
+	for _, tt := range adapterTests {
+		got := FindAdapter(tt.Seq, tt.Adapter, tt.Mismatch, tt.MinOverlap)
+		if got != tt.Pos {
+			t.Errorf("FindAdapter(%q, %q, %d, %d): got %d, want %d",
+				tt.Seq, tt.Adapter, tt.Mismatch, tt.MinOverlap, got, tt.Pos)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package aggregate implements online accumulators for the values computed by
+// the htsdb tools. Each accumulator can be filled incrementally, e.g. while
+// streaming records, and has a Merge method so that the partial results of
+// workers, e.g. one per reference, can be combined into the final result.
+// Accumulators are not safe for concurrent use; each worker should use its
+// own and merge it once done.
+package aggregate
+
+import (
+	"math"
+	"sort"
+)
+
+// Histogram counts occurrences of integer values e.g. positions or lengths.
+// The zero value is not usable; use NewHistogram or make. A Histogram is a
+// plain map so that it can be serialized e.g. by a checkpoint.
+type Histogram map[int]uint
+
+// NewHistogram returns an empty histogram.
+func NewHistogram() Histogram {
+	return make(Histogram)
+}
+
+// Add adds n occurrences of k.
+func (h Histogram) Add(k int, n uint) {
+	if n == 0 {
+		return
+	}
+	h[k] += n
+}
+
+// Merge adds the counts of o to h.
+func (h Histogram) Merge(o Histogram) {
+	for k, v := range o {
+		h[k] += v
+	}
+}
+
+// Total returns the sum of all counts.
+func (h Histogram) Total() uint {
+	var t uint
+	for _, v := range h {
+		t += v
+	}
+	return t
+}
+
+// Keys returns the values with non-zero counts in increasing order.
+func (h Histogram) Keys() []int {
+	keys := make([]int, 0, len(h))
+	for k, v := range h {
+		if v > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Counter counts occurrences of named items e.g. features or references.
+// The zero value is not usable; use NewCounter or make.
+type Counter map[string]int
+
+// NewCounter returns an empty counter.
+func NewCounter() Counter {
+	return make(Counter)
+}
+
+// Add adds n occurrences of k.
+func (c Counter) Add(k string, n int) {
+	c[k] += n
+}
+
+// Merge adds the counts of o to c.
+func (c Counter) Merge(o Counter) {
+	for k, v := range o {
+		c[k] += v
+	}
+}
+
+// Keys returns the counted items sorted by name.
+func (c Counter) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Moments accumulates the count, mean and variance of a stream of values
+// using Welford's algorithm, which is numerically stable for long streams.
+// The zero value is an empty accumulator.
+type Moments struct {
+	n        float64
+	mean, m2 float64
+	min, max float64
+}
+
+// Add adds x to m.
+func (m *Moments) Add(x float64) {
+	m.AddN(x, 1)
+}
+
+// AddN adds n occurrences of x to m e.g. a record with copy number n.
+func (m *Moments) AddN(x float64, n float64) {
+	if n <= 0 {
+		return
+	}
+	m.Merge(Moments{n: n, mean: x, min: x, max: x})
+}
+
+// Merge adds the values accumulated by o to m.
+func (m *Moments) Merge(o Moments) {
+	if o.n == 0 {
+		return
+	}
+	if m.n == 0 {
+		*m = o
+		return
+	}
+	n := m.n + o.n
+	d := o.mean - m.mean
+	m.mean += d * o.n / n
+	m.m2 += o.m2 + d*d*m.n*o.n/n
+	m.n = n
+	m.min = math.Min(m.min, o.min)
+	m.max = math.Max(m.max, o.max)
+}
+
+// Count returns the number of values.
+func (m Moments) Count() float64 { return m.n }
+
+// Mean returns the mean or NaN if there are no values.
+func (m Moments) Mean() float64 {
+	if m.n == 0 {
+		return math.NaN()
+	}
+	return m.mean
+}
+
+// Variance returns the sample variance or NaN if there are less than two
+// values.
+func (m Moments) Variance() float64 {
+	if m.n < 2 {
+		return math.NaN()
+	}
+	return m.m2 / (m.n - 1)
+}
+
+// SD returns the sample standard deviation.
+func (m Moments) SD() float64 {
+	return math.Sqrt(m.Variance())
+}
+
+// Min returns the smallest value or NaN if there are no values.
+func (m Moments) Min() float64 {
+	if m.n == 0 {
+		return math.NaN()
+	}
+	return m.min
+}
+
+// Max returns the largest value or NaN if there are no values.
+func (m Moments) Max() float64 {
+	if m.n == 0 {
+		return math.NaN()
+	}
+	return m.max
+}
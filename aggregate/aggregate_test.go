@@ -0,0 +1,81 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h1, h2 := NewHistogram(), NewHistogram()
+	h1.Add(-2, 3)
+	h1.Add(5, 1)
+	h1.Add(7, 0)
+	h2.Add(5, 2)
+	h2.Add(0, 4)
+	h1.Merge(h2)
+	expected := map[int]uint{-2: 3, 0: 4, 5: 3}
+	if len(h1) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, h1)
+	}
+	for k, v := range expected {
+		if h1[k] != v {
+			t.Errorf("%d: expected %d, actual %d", k, v, h1[k])
+		}
+	}
+	if h1.Total() != 10 {
+		t.Errorf("expected total 10, actual %d", h1.Total())
+	}
+	keys := h1.Keys()
+	if len(keys) != 3 || keys[0] != -2 || keys[1] != 0 || keys[2] != 5 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c1, c2 := NewCounter(), NewCounter()
+	c1.Add("b", 2)
+	c2.Add("a", 1)
+	c2.Add("b", 3)
+	c1.Merge(c2)
+	if c1["a"] != 1 || c1["b"] != 5 {
+		t.Errorf("unexpected counts %v", c1)
+	}
+	if keys := c1.Keys(); len(keys) != 2 || keys[0] != "a" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestMoments(t *testing.T) {
+	var m Moments
+	if !math.IsNaN(m.Mean()) || !math.IsNaN(m.Variance()) {
+		t.Error("expected NaN for empty accumulator")
+	}
+
+	// merging partial results must equal accumulating all values at once.
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	var all, a, b Moments
+	for i, x := range values {
+		all.Add(x)
+		if i < 3 {
+			a.Add(x)
+		} else {
+			b.Add(x)
+		}
+	}
+	a.Merge(b)
+	for _, m := range []Moments{all, a} {
+		if m.Count() != 8 || math.Abs(m.Mean()-5) > 1e-12 ||
+			math.Abs(m.Variance()-32.0/7) > 1e-12 || m.Min() != 2 || m.Max() != 9 {
+			t.Errorf("unexpected moments: n %v, mean %v, var %v, min %v, max %v",
+				m.Count(), m.Mean(), m.Variance(), m.Min(), m.Max())
+		}
+	}
+
+	var w Moments
+	w.AddN(4, 3)
+	w.Add(8)
+	if w.Count() != 4 || w.Mean() != 5 || math.Abs(w.Variance()-4) > 1e-12 {
+		t.Errorf("unexpected weighted moments: n %v, mean %v, var %v", w.Count(),
+			w.Mean(), w.Variance())
+	}
+}
@@ -0,0 +1,119 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+)
+
+// CoOccurrence counts the pairs of items that are positioned within a window
+// of each other, by the classes of the two items e.g. the length or first
+// nucleotide of two reads. Pairs are ordered so that the counts are
+// symmetric. Items must be added in increasing order of position within a
+// group, e.g. a reference and strand, and all items of a group must be added
+// before the next group. Use NewCoOccurrence to create one.
+type CoOccurrence struct {
+	window  int
+	classes []string
+	obs     map[[2]string]float64
+	totals  map[string]float64
+	group   string
+	buf     []coItem
+}
+
+// coItem is a number of items of the same class at the same position.
+type coItem struct {
+	pos   int
+	class string
+	n     float64
+}
+
+// NewCoOccurrence returns an empty CoOccurrence for items whose positions
+// differ by at most window.
+func NewCoOccurrence(window int) *CoOccurrence {
+	return &CoOccurrence{window: window, obs: make(map[[2]string]float64),
+		totals: make(map[string]float64)}
+}
+
+// Add adds n items of class at pos of group. Items of the same class and
+// position can be added at once e.g. from a GROUP BY query, which avoids the
+// quadratic cost of pairing them one by one.
+func (c *CoOccurrence) Add(group string, pos int, class string, n int) {
+	if n <= 0 {
+		return
+	}
+	if group != c.group {
+		c.group, c.buf = group, c.buf[:0]
+	}
+	drop := 0
+	for drop < len(c.buf) && pos-c.buf[drop].pos > c.window {
+		drop++
+	}
+	c.buf = append(c.buf[:0], c.buf[drop:]...)
+
+	fn := float64(n)
+	for _, o := range c.buf {
+		c.obs[[2]string{class, o.class}] += fn * o.n
+		c.obs[[2]string{o.class, class}] += fn * o.n
+	}
+	c.obs[[2]string{class, class}] += fn * (fn - 1)
+	c.buf = append(c.buf, coItem{pos: pos, class: class, n: fn})
+	c.totals[class] += fn
+}
+
+// Merge adds the counts of o to c. Both must be done with their groups.
+func (c *CoOccurrence) Merge(o *CoOccurrence) {
+	for k, v := range o.obs {
+		c.obs[k] += v
+	}
+	for k, v := range o.totals {
+		c.totals[k] += v
+	}
+}
+
+// Classes returns the classes of the added items in increasing order.
+func (c *CoOccurrence) Classes() []string {
+	classes := make([]string, 0, len(c.totals))
+	for k := range c.totals {
+		classes = append(classes, k)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// Observed returns the number of ordered pairs of items of classes a and b
+// within the window.
+func (c *CoOccurrence) Observed(a, b string) float64 {
+	return c.obs[[2]string{a, b}]
+}
+
+// Expected returns the number of ordered pairs of items of classes a and b
+// that are expected within the window if classes were independent of
+// position i.e. the total number of pairs within the window times the
+// probability that two items drawn without replacement have classes a and b.
+func (c *CoOccurrence) Expected(a, b string) float64 {
+	var pairs, total float64
+	for _, v := range c.obs {
+		pairs += v
+	}
+	for _, v := range c.totals {
+		total += v
+	}
+	if total < 2 {
+		return 0
+	}
+	na, nb := c.totals[a], c.totals[b]
+	if a == b {
+		nb--
+	}
+	return pairs * na * nb / (total * (total - 1))
+}
+
+// Enrichment returns the ratio of observed to expected pairs of classes a
+// and b. It returns NaN if no pairs are expected.
+func (c *CoOccurrence) Enrichment(a, b string) float64 {
+	e := c.Expected(a, b)
+	if e == 0 {
+		return math.NaN()
+	}
+	return c.Observed(a, b) / e
+}
@@ -0,0 +1,56 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCoOccurrence(t *testing.T) {
+	c := NewCoOccurrence(10)
+	c.Add("chr1", 0, "A", 2)
+	c.Add("chr1", 5, "B", 1)
+	c.Add("chr1", 20, "B", 1)
+	c.Add("chr2", 21, "A", 1)
+
+	tests := []struct {
+		A, B     string
+		Observed float64
+	}{
+		{"A", "A", 2},
+		{"A", "B", 2},
+		{"B", "A", 2},
+		{"B", "B", 0},
+	}
+	for _, tt := range tests {
+		if got := c.Observed(tt.A, tt.B); got != tt.Observed {
+			t.Errorf("Observed(%s, %s): expected %g, actual %g", tt.A, tt.B, tt.Observed, got)
+		}
+	}
+
+	// 6 pairs and 5 items of which 3 A and 2 B.
+	if got, expected := c.Expected("A", "B"), 6*3*2/20.0; math.Abs(got-expected) > 1e-9 {
+		t.Errorf("Expected(A, B): expected %g, actual %g", expected, got)
+	}
+	if got, expected := c.Expected("A", "A"), 6*3*2/20.0; math.Abs(got-expected) > 1e-9 {
+		t.Errorf("Expected(A, A): expected %g, actual %g", expected, got)
+	}
+	var sum float64
+	for _, a := range c.Classes() {
+		for _, b := range c.Classes() {
+			sum += c.Expected(a, b)
+		}
+	}
+	if math.Abs(sum-6) > 1e-9 {
+		t.Errorf("expected pairs to sum to 6, actual %g", sum)
+	}
+
+	o := NewCoOccurrence(10)
+	o.Add("chr3", 0, "C", 2)
+	c.Merge(o)
+	if c.Observed("C", "C") != 2 || len(c.Classes()) != 3 {
+		t.Errorf("unexpected merge result: %v", c.Classes())
+	}
+	if !math.IsNaN(NewCoOccurrence(1).Enrichment("A", "A")) {
+		t.Error("expected NaN enrichment without pairs")
+	}
+}
@@ -0,0 +1,206 @@
+// Package analysis exports the computations of htsdb on records and sequences:
+// pileups and SNVs, isomiR classification, sequence statistics, correlations
+// and the batching of anchors. It is part of the stable API of htsdb together
+// with packages record, query, importer and export: its types and constants
+// are aliases of those of package htsdb and its functions call those of
+// package htsdb. They are kept compatible across minor versions of module
+// github.com/mnsmar/htsdb/v2.
+package analysis
+
+import (
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// Pileups of aligned bases and the SNVs called from them.
+type (
+	Pileup       = htsdb.Pileup
+	PileupColumn = htsdb.PileupColumn
+	SNV          = htsdb.SNV
+)
+
+// PileupSymbols are the symbols counted by a Pileup.
+const PileupSymbols = htsdb.PileupSymbols
+
+// NewPileup returns an empty Pileup for region r.
+func NewPileup(r query.Region) *Pileup {
+	return htsdb.NewPileup(r)
+}
+
+// TallySNVs compares the pileups of the forward and reverse strand reads of
+// the same region to ref, the reference sequence of the region, and returns
+// the non-reference bases whose count is at least minCount and whose fraction
+// of the depth is at least minFrac, in position order. Positions with
+// reference N, other bases and deletions are not reported.
+func TallySNVs(fwd, rev *Pileup, ref string, minCount, minFrac float64) ([]SNV, error) {
+	return htsdb.TallySNVs(fwd, rev, ref, minCount, minFrac)
+}
+
+// Annotations of isomiRs.
+type (
+	Annotation      = htsdb.Annotation
+	AnnotationIndex = htsdb.AnnotationIndex
+	IsomiR          = htsdb.IsomiR
+)
+
+// IsomiRClasses returns the classes of isomiRs in the order they are reported.
+func IsomiRClasses() []string {
+	return append([]string(nil), htsdb.IsomiRClasses...)
+}
+
+// ReadAnnotations reads the annotations in file f. GTF files are recognised by
+// the .gtf extension; only features of type featType are read unless it is
+// empty and the name of each feature is the value of its nameAttr attribute.
+// All other files are read as BED with the name and strand of the fourth and
+// sixth columns, if present.
+func ReadAnnotations(f, featType, nameAttr string) ([]Annotation, error) {
+	return htsdb.ReadAnnotations(f, featType, nameAttr)
+}
+
+// NewAnnotationIndex returns an AnnotationIndex for anns.
+func NewAnnotationIndex(anns []Annotation) AnnotationIndex {
+	return htsdb.NewAnnotationIndex(anns)
+}
+
+// Unambiguous returns the stranded annotations of anns that overlap no
+// annotation on another strand, or of unknown strand, so that the expected
+// strand of their reads is unambiguous e.g. to measure strand cross-talk.
+func Unambiguous(anns []Annotation) []Annotation {
+	return htsdb.Unambiguous(anns)
+}
+
+// ClassifyIsomiR classifies the read with sequence seq aligned with cigar at
+// the 1-based position pos on strand ori against annotation a. ref is the
+// reference sequence from the 0-based position refStart. The 3' end of the
+// read is measured without its non-templated additions, as returned by
+// NonTemplated.
+func ClassifyIsomiR(a Annotation, pos int, cigar, seq string, ori record.Orientation, ref string, refStart int) (IsomiR, error) {
+	return htsdb.ClassifyIsomiR(a, pos, cigar, seq, ori, ref, refStart)
+}
+
+// NonTemplated returns the non-templated bases added to the 3' end of the
+// read with sequence seq aligned with cigar at the 1-based position pos on
+// strand ori, in read orientation, and the start and stop in HtsdbCoords of
+// the templated part of the alignment. ref is the reference sequence from the
+// 0-based position refStart. The additions are the bases soft clipped from
+// the 3' end of the read and the consecutive bases at the 3' end of the
+// alignment that mismatch ref, keeping at least one aligned base. Alignment
+// bases outside ref are taken to match.
+func NonTemplated(pos int, cigar, seq string, ori record.Orientation, ref string, refStart int) (added string, start, stop int, err error) {
+	return htsdb.NonTemplated(pos, cigar, seq, ori, ref, refStart)
+}
+
+// NTAClass returns the class of the non-templated addition added: "none",
+// "mono-" or "oligo-" followed by the base for additions of one or more
+// copies of a single base, with U for T e.g. mono-U, and "mixed" otherwise.
+func NTAClass(added string) string {
+	return htsdb.NTAClass(added)
+}
+
+// GCFraction returns the fraction of G and C bases in seq, ignoring case. N
+// and other ambiguous bases count towards the length. It returns 0 for an
+// empty sequence.
+func GCFraction(seq string) float64 {
+	return htsdb.GCFraction(seq)
+}
+
+// MaxHomopolymer returns the length of the longest run of the same base in
+// seq, ignoring case.
+func MaxHomopolymer(seq string) int {
+	return htsdb.MaxHomopolymer(seq)
+}
+
+// DustScore returns the low-complexity score of seq, ignoring case: the
+// largest score of its windows of DustWindow bases, where the score of a
+// window is sum(c*(c-1)/2)/(l-1) over the counts c of each of its l
+// overlapping triplets. Homopolymers and short tandem repeats score high, e.g.
+// 31 for 64 A's, while random sequences score below 1. Triplets with bases
+// other than A, C, G and T are skipped. It returns 0 for sequences with fewer
+// than two scored triplets.
+func DustScore(seq string) float64 {
+	return htsdb.DustScore(seq)
+}
+
+// ReverseComplement returns the upper case reverse complement of seq. Bases
+// other than A, C, G, T and U are complemented to N.
+func ReverseComplement(seq string) string {
+	return htsdb.ReverseComplement(seq)
+}
+
+// FindAdapter returns the position of the first occurrence of adapter in seq
+// with at most maxMismatch mismatches, or -1 if there is none. Occurrences
+// that run past the 3' end of seq are reported if at least minOverlap bases
+// of the adapter prefix overlap seq; mismatches are then limited in
+// proportion to the overlap. Comparison ignores case and N never matches.
+func FindAdapter(seq, adapter string, maxMismatch, minOverlap int) int {
+	return htsdb.FindAdapter(seq, adapter, maxMismatch, minOverlap)
+}
+
+// DustWindow is the number of bases of the windows scored by DustScore.
+const DustWindow = htsdb.DustWindow
+
+// Uniformity summarizes the uniformity of coverage.
+type Uniformity = htsdb.Uniformity
+
+// Pearson returns the Pearson correlation coefficient of x and y, which must
+// have the same length. It returns NaN if either has zero variance.
+func Pearson(x, y []float64) float64 {
+	return htsdb.Pearson(x, y)
+}
+
+// Spearman returns the Spearman rank correlation coefficient of x and y.
+func Spearman(x, y []float64) float64 {
+	return htsdb.Spearman(x, y)
+}
+
+// Ranks returns the 1-based ranks of the values of x. Tied values get the
+// average of their ranks.
+func Ranks(x []float64) []float64 {
+	return htsdb.Ranks(x)
+}
+
+// CoverageUniformity returns the uniformity of depth, the per-base coverage of
+// a feature. Gini and MaxMean are NaN if no base is covered.
+func CoverageUniformity(depth []float64) Uniformity {
+	return htsdb.CoverageUniformity(depth)
+}
+
+// AnchorBatch is a batch of anchors queried together.
+type AnchorBatch = htsdb.AnchorBatch
+
+// AnchorBatches returns the windows of span bases around each of the anchor
+// positions on reference rname, merged and grouped in batches of at most
+// batch windows, so that the records near a sparse set of anchors can be
+// fetched with a few short queries instead of a scan of the whole reference.
+// The filter of each batch selects records overlapping its windows extended
+// by slack bases on each side e.g. to account for positions that are offset
+// from the record. Windows are disjoint, so a record position is counted once
+// if only records whose position is in the windows of the batch that fetched
+// them are counted, even if a record is fetched by several batches. Filters
+// follow c and inline their values like RegionsFilter. A batch less than 1
+// puts all windows in a single batch.
+func AnchorBatches(rname string, anchors []int, span, slack, batch int, c record.Coords) []AnchorBatch {
+	return htsdb.AnchorBatches(rname, anchors, span, slack, batch, c)
+}
+
+// Weighting determines how the records of an anchor are counted.
+type Weighting = htsdb.Weighting
+
+// Weightings of records.
+const (
+	WeightEach   = htsdb.WeightEach
+	WeightUnique = htsdb.WeightUnique
+	WeightCopies = htsdb.WeightCopies
+)
+
+// Weightings returns the names of the weightings accepted by ParseWeighting.
+func Weightings() []string {
+	return append([]string(nil), htsdb.Weightings...)
+}
+
+// ParseWeighting parses a weighting. Valid values are "each", "unique" and
+// "weight-by-copies".
+func ParseWeighting(s string) (Weighting, error) {
+	return htsdb.ParseWeighting(s)
+}
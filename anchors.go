@@ -0,0 +1,113 @@
+package htsdb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AnchorBatch is a group of disjoint windows around anchor positions with
+// the SQL clause that selects the records near them.
+type AnchorBatch struct {
+	// Windows are sorted, disjoint and in HtsdbCoords.
+	Windows []Region
+	// Filter selects the records overlapping the windows extended by the
+	// slack given to AnchorBatches.
+	Filter string
+}
+
+// Contains returns true if pos is in one of the windows of b.
+func (b AnchorBatch) Contains(pos int) bool {
+	i := sort.Search(len(b.Windows), func(i int) bool { return b.Windows[i].Stop >= pos })
+	return i < len(b.Windows) && b.Windows[i].Start <= pos
+}
+
+// AnchorBatches returns the windows of span bases around each of the anchor
+// positions on reference rname, merged and grouped in batches of at most
+// batch windows, so that the records near a sparse set of anchors can be
+// fetched with a few short queries instead of a scan of the whole reference.
+// The filter of each batch selects records overlapping its windows extended
+// by slack bases on each side e.g. to account for positions that are offset
+// from the record. Windows are disjoint, so a record position is counted once
+// if only records whose position is in the windows of the batch that fetched
+// them are counted, even if a record is fetched by several batches. Filters
+// follow c and inline their values like RegionsFilter. A batch less than 1
+// puts all windows in a single batch.
+func AnchorBatches(rname string, anchors []int, span, slack, batch int, c Coords) []AnchorBatch {
+	if len(anchors) == 0 {
+		return nil
+	}
+	windows := make([]Region, len(anchors))
+	for i, a := range anchors {
+		windows[i] = Region{Rname: rname, Start: a - span, Stop: a + span}
+	}
+	windows = MergeRegions(windows)
+	if batch < 1 {
+		batch = len(windows)
+	}
+	var batches []AnchorBatch
+	for len(windows) > 0 {
+		n := batch
+		if n > len(windows) {
+			n = len(windows)
+		}
+		ext := make([]Region, n)
+		for i, w := range windows[:n] {
+			ext[i] = Region{Rname: rname, Start: w.Start - slack, Stop: w.Stop + slack}
+		}
+		batches = append(batches, AnchorBatch{Windows: windows[:n:n],
+			Filter: RegionsFilter(ext, c)})
+		windows = windows[n:]
+	}
+	return batches
+}
+
+// Weighting is the policy for counting several records that share an anchor
+// position e.g. the reads of a database compared to the reads of another.
+type Weighting int
+
+// Valid weightings.
+const (
+	// WeightEach counts each record once.
+	WeightEach Weighting = iota
+	// WeightUnique counts each position once, however many records share it.
+	WeightUnique
+	// WeightCopies counts each record by its copy number.
+	WeightCopies
+)
+
+// Weightings are the command line representations of the valid weightings.
+var Weightings = []string{"each", "unique", "weight-by-copies"}
+
+// ParseWeighting parses a weighting. Valid values are "each", "unique" and
+// "weight-by-copies".
+func ParseWeighting(s string) (Weighting, error) {
+	for i, w := range Weightings {
+		if s == w {
+			return Weighting(i), nil
+		}
+	}
+	return 0, fmt.Errorf("htsdb: invalid weighting %q", s)
+}
+
+// String returns the command line representation of w.
+func (w Weighting) String() string {
+	if w >= 0 && int(w) < len(Weightings) {
+		return Weightings[w]
+	}
+	return fmt.Sprintf("Weighting(%d)", int(w))
+}
+
+// Weight returns the weight of a record with copies copy number at a
+// position with n previously counted records. It is 0 for the records after
+// the first at a position with WeightUnique.
+func (w Weighting) Weight(n, copies int) int {
+	switch w {
+	case WeightUnique:
+		if n > 0 {
+			return 0
+		}
+	case WeightCopies:
+		return copies
+	}
+	return 1
+}
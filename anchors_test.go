@@ -0,0 +1,72 @@
+package htsdb
+
+import (
+	"testing"
+)
+
+func TestAnchorBatches(t *testing.T) {
+	if b := AnchorBatches("chr1", nil, 10, 0, 2, HtsdbCoords); b != nil {
+		t.Errorf("expected no batches for no anchors, actual %v", b)
+	}
+	batches := AnchorBatches("chr1", []int{100, 5, 105, 300, 500}, 10, 2, 2, HtsdbCoords)
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, actual %d", len(batches))
+	}
+	expected := [][]Region{
+		{{"chr1", -5, 15}, {"chr1", 90, 115}},
+		{{"chr1", 290, 310}, {"chr1", 490, 510}},
+	}
+	for i, b := range batches {
+		if len(b.Windows) != len(expected[i]) {
+			t.Fatalf("batch %d: expected %v, actual %v", i, expected[i], b.Windows)
+		}
+		for k, w := range b.Windows {
+			if w != expected[i][k] {
+				t.Errorf("batch %d: expected %v, actual %v", i, expected[i][k], w)
+			}
+		}
+	}
+	filter := "((rname = 'chr1' AND start <= 17 AND stop >= -7) OR " +
+		"(rname = 'chr1' AND start <= 117 AND stop >= 88))"
+	if batches[0].Filter != filter {
+		t.Errorf("expected filter %s, actual %s", filter, batches[0].Filter)
+	}
+	for pos, ok := range map[int]bool{-5: true, 16: false, 100: true, 116: false, 310: true} {
+		in := batches[0].Contains(pos) || batches[1].Contains(pos)
+		if in != ok {
+			t.Errorf("position %d: expected %v, actual %v", pos, ok, in)
+		}
+	}
+	if b := AnchorBatches("chr1", []int{1, 100}, 1, 0, 0, HtsdbCoords); len(b) != 1 {
+		t.Errorf("expected a single batch, actual %d", len(b))
+	}
+}
+
+func TestWeighting(t *testing.T) {
+	tests := []struct {
+		s        string
+		n, copy  int
+		expected int
+	}{
+		{"each", 0, 5, 1},
+		{"each", 3, 5, 1},
+		{"unique", 0, 5, 1},
+		{"unique", 1, 5, 0},
+		{"weight-by-copies", 2, 5, 5},
+	}
+	for _, tt := range tests {
+		w, err := ParseWeighting(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != tt.s {
+			t.Errorf("expected %s, actual %s", tt.s, w)
+		}
+		if got := w.Weight(tt.n, tt.copy); got != tt.expected {
+			t.Errorf("%s(%d, %d): expected %d, actual %d", tt.s, tt.n, tt.copy, tt.expected, got)
+		}
+	}
+	if _, err := ParseWeighting("copies"); err == nil {
+		t.Error("expected error for invalid weighting")
+	}
+}
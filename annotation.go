@@ -0,0 +1,159 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/biogo/feat"
+)
+
+// Annotation is a named genomic feature e.g. a gene or a miRNA. Strand is 1,
+// -1 or 0 if unknown.
+type Annotation struct {
+	Region
+	Name   string
+	Strand int
+}
+
+// ReadAnnotations reads the annotations in file f. GTF files are recognised by
+// the .gtf extension; only features of type featType are read unless it is
+// empty and the name of each feature is the value of its nameAttr attribute.
+// All other files are read as BED with the name and strand of the fourth and
+// sixth columns, if present.
+func ReadAnnotations(f, featType, nameAttr string) ([]Annotation, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var anns []Annotation
+	if strings.HasSuffix(f, ".gtf") {
+		err = ReadGTF(fh, func(g *GTFRecord) error {
+			if featType != "" && g.Feature != featType {
+				return nil
+			}
+			a := Annotation{Region: g.Region(), Name: g.Attributes[nameAttr]}
+			switch g.Strand {
+			case feat.Forward:
+				a.Strand = 1
+			case feat.Reverse:
+				a.Strand = -1
+			}
+			anns = append(anns, a)
+			return nil
+		})
+		return anns, err
+	}
+	sc := bufio.NewScanner(fh)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") ||
+			fields[0] == "track" || fields[0] == "browser" {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("htsdb: line %d: expected at least 3 columns", line)
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("htsdb: line %d: %v", line, err)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("htsdb: line %d: %v", line, err)
+		}
+		a := Annotation{Region: Region{Rname: fields[0]}}
+		a.Start, a.Stop = BEDCoords.ToHtsdb(start, end)
+		if len(fields) > 3 {
+			a.Name = fields[3]
+		}
+		if len(fields) > 5 {
+			switch fields[5] {
+			case "+":
+				a.Strand = 1
+			case "-":
+				a.Strand = -1
+			}
+		}
+		anns = append(anns, a)
+	}
+	return anns, sc.Err()
+}
+
+// AnnotationIndex finds the annotations that overlap an interval. It is
+// meant for feature sets too large for SQL filters.
+type AnnotationIndex struct {
+	anns   map[string][]Annotation
+	maxLen map[string]int
+}
+
+// NewAnnotationIndex returns an AnnotationIndex for anns.
+func NewAnnotationIndex(anns []Annotation) AnnotationIndex {
+	idx := AnnotationIndex{anns: make(map[string][]Annotation),
+		maxLen: make(map[string]int)}
+	for _, a := range anns {
+		idx.anns[a.Rname] = append(idx.anns[a.Rname], a)
+		if l := a.Stop - a.Start + 1; l > idx.maxLen[a.Rname] {
+			idx.maxLen[a.Rname] = l
+		}
+	}
+	for _, s := range idx.anns {
+		sort.Slice(s, func(i, j int) bool { return s[i].Start < s[j].Start })
+	}
+	return idx
+}
+
+// Overlapping returns the annotations on rname that overlap start to stop in
+// HtsdbCoords. If strand is not 0, only annotations on strand are returned.
+func (idx AnnotationIndex) Overlapping(rname string, start, stop, strand int) []Annotation {
+	s := idx.anns[rname]
+	from := start - idx.maxLen[rname] + 1
+	i := sort.Search(len(s), func(i int) bool { return s[i].Start >= from })
+	var found []Annotation
+	for ; i < len(s) && s[i].Start <= stop; i++ {
+		if s[i].Stop < start || (strand != 0 && s[i].Strand != strand) {
+			continue
+		}
+		found = append(found, s[i])
+	}
+	return found
+}
+
+// Unambiguous returns the stranded annotations of anns that overlap no
+// annotation on another strand, or of unknown strand, so that the expected
+// strand of their reads is unambiguous e.g. to measure strand cross-talk.
+func Unambiguous(anns []Annotation) []Annotation {
+	idx := NewAnnotationIndex(anns)
+	var found []Annotation
+	for _, a := range anns {
+		if a.Strand == 0 {
+			continue
+		}
+		ok := true
+		for _, o := range idx.Overlapping(a.Rname, a.Start, a.Stop, 0) {
+			if o.Strand != a.Strand {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// EndOffsets returns the distances of the 5' and 3' ends of the interval
+// start to stop in HtsdbCoords from the 5' and 3' ends of a, in the
+// orientation of a; distances are positive downstream. Annotations of unknown
+// strand are taken as forward.
+func (a Annotation) EndOffsets(start, stop int) (d5, d3 int) {
+	if a.Strand < 0 {
+		return a.Stop - stop, a.Start - start
+	}
+	return start - a.Start, stop - a.Stop
+}
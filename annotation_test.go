@@ -0,0 +1,112 @@
+package htsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bed := filepath.Join(dir, "mirs.bed")
+	err = ioutil.WriteFile(bed, []byte("chr1\t10\t30\tmir-1\t0\t-\nchr2\t5\t8\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anns, err := ReadAnnotations(bed, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Annotation{
+		{Region{"chr1", 10, 29}, "mir-1", -1},
+		{Region{"chr2", 5, 7}, "", 0},
+	}
+	if len(anns) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, anns)
+	}
+	for i := range anns {
+		if anns[i] != expected[i] {
+			t.Errorf("expected %v, actual %v", expected[i], anns[i])
+		}
+	}
+}
+
+func TestAnnotationIndex(t *testing.T) {
+	idx := NewAnnotationIndex([]Annotation{
+		{Region{"chr1", 0, 99}, "long", 1},
+		{Region{"chr1", 40, 49}, "short", -1},
+		{Region{"chr1", 60, 69}, "other", 1},
+	})
+	tests := []struct {
+		start, stop, strand int
+		expected            []string
+	}{
+		{45, 46, 0, []string{"long", "short"}},
+		{45, 46, -1, []string{"short"}},
+		{50, 59, 0, []string{"long"}},
+		{95, 120, 0, []string{"long"}},
+		{100, 120, 0, nil},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, a := range idx.Overlapping("chr1", tt.start, tt.stop, tt.strand) {
+			names = append(names, a.Name)
+		}
+		if len(names) != len(tt.expected) {
+			t.Errorf("Overlapping(%d, %d, %d): expected %v, actual %v", tt.start,
+				tt.stop, tt.strand, tt.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.expected[i] {
+				t.Errorf("Overlapping(%d, %d, %d): expected %v, actual %v",
+					tt.start, tt.stop, tt.strand, tt.expected, names)
+			}
+		}
+	}
+}
+
+func TestUnambiguous(t *testing.T) {
+	anns := Unambiguous([]Annotation{
+		{Region{"chr1", 0, 99}, "a", 1},
+		{Region{"chr1", 90, 149}, "b", -1},
+		{Region{"chr1", 200, 299}, "c", 1},
+		{Region{"chr1", 250, 259}, "d", 1},
+		{Region{"chr1", 400, 499}, "e", 0},
+		{Region{"chr2", 0, 99}, "f", -1},
+		{Region{"chr2", 50, 59}, "g", 0},
+	})
+	var names []string
+	for _, a := range anns {
+		names = append(names, a.Name)
+	}
+	if expected := "c d"; strings.Join(names, " ") != expected {
+		t.Errorf("expected %q, actual %q", expected, strings.Join(names, " "))
+	}
+}
+
+func TestAnnotationEndOffsets(t *testing.T) {
+	tests := []struct {
+		a           Annotation
+		start, stop int
+		d5, d3      int
+	}{
+		{Annotation{Region{"chr1", 100, 121}, "fw", 1}, 100, 121, 0, 0},
+		{Annotation{Region{"chr1", 100, 121}, "fw", 1}, 101, 119, 1, -2},
+		{Annotation{Region{"chr1", 100, 121}, "rv", -1}, 101, 119, 2, -1},
+		{Annotation{Region{"chr1", 100, 121}, "rv", -1}, 99, 123, -2, 1},
+	}
+	for _, tt := range tests {
+		d5, d3 := tt.a.EndOffsets(tt.start, tt.stop)
+		if d5 != tt.d5 || d3 != tt.d3 {
+			t.Errorf("%s %d-%d: expected %d %d, actual %d %d", tt.a.Name, tt.start,
+				tt.stop, tt.d5, tt.d3, d5, d3)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package htsdb
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// BAMReader reads the alignments of an indexed BAM file into an htsdb record
+// so that code written for Reader can run on BAM files without importing them
+// first. Alignments are exposed with the canonical htsdb columns: start and
+// stop follow HtsdbCoords, strand is 1 or -1, copy_number is 1 and the SAM
+// fields are available as qname, flag, rname, pos, mapq, cigar, rnext, pnext,
+// tlen, seq, qual and tags. Like sqlx, fields of dest are matched to columns by
+// their db tag or lower case name. Unmapped reads are skipped.
+type BAMReader struct {
+	f       *os.File
+	r       *bam.Reader
+	idx     *bam.Index
+	it      *bam.Iterator
+	refs    map[string]*sam.Reference
+	regions []Region
+	prev    Region
+	dest    reflect.Value
+	fields  []bamField
+	err     error
+	policy  StrandPolicy
+	skipped int
+}
+
+// bamField is a field of the destination record and its column.
+type bamField struct {
+	index []int
+	col   string
+}
+
+// NewBAMReader returns a BAMReader that reads into dest, a pointer to a
+// struct, the alignments of the BAM file path that overlap regions. Regions
+// are merged and read sorted by reference name and start, and each alignment
+// is read once even if it overlaps several of them. If regions is empty, all
+// alignments are read in file order. Regions require an index in
+// path.bai or, for files ending in .bam, in the same path with extension
+// .bai.
+func NewBAMReader(path string, dest interface{}, regions []Region) (*BAMReader, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("htsdb: BAM destination must be a pointer to struct, got %T", dest)
+	}
+	fields, err := bamFields(v.Elem().Type(), nil)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := bam.NewReader(f, 1)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	br := &BAMReader{f: f, r: r, dest: v.Elem(), fields: fields,
+		regions: MergeRegions(regions), refs: make(map[string]*sam.Reference)}
+	for _, ref := range r.Header().Refs() {
+		br.refs[ref.Name()] = ref
+	}
+	if len(regions) > 0 {
+		if br.idx, err = readBAMIndex(path); err != nil {
+			br.Close()
+			return nil, err
+		}
+	}
+	return br, nil
+}
+
+// readBAMIndex reads the index of the BAM file path.
+func readBAMIndex(path string) (*bam.Index, error) {
+	paths := []string{path + ".bai"}
+	if strings.HasSuffix(path, ".bam") {
+		paths = append(paths, strings.TrimSuffix(path, ".bam")+".bai")
+	}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return bam.ReadIndex(f)
+	}
+	return nil, fmt.Errorf("htsdb: no index for BAM file %s", path)
+}
+
+// bamFields returns the fields of struct type t that map to BAM columns.
+func bamFields(t reflect.Type, parent []int) ([]bamField, error) {
+	var fields []bamField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append([]int(nil), parent...), i)
+		col := sf.Tag.Get("db")
+		if col == "-" || (sf.PkgPath != "" && !sf.Anonymous) {
+			continue
+		}
+		if sf.Anonymous && col == "" && sf.Type.Kind() == reflect.Struct {
+			embedded, err := bamFields(sf.Type, index)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		if col == "" {
+			col = strings.ToLower(sf.Name)
+		}
+		if !bamColumns[col] {
+			return nil, fmt.Errorf("htsdb: column %s is not available in BAM files", col)
+		}
+		fields = append(fields, bamField{index: index, col: col})
+	}
+	return fields, nil
+}
+
+// bamColumns are the columns that BAMReader provides.
+var bamColumns = map[string]bool{
+	"qname": true, "flag": true, "rname": true, "pos": true, "mapq": true,
+	"cigar": true, "rnext": true, "pnext": true, "tlen": true, "seq": true,
+	"qual": true, "tags": true, "start": true, "stop": true, "strand": true,
+	"copy_number": true,
+}
+
+// Next advances the iterator past the next record, which will then be
+// available through Record(). It returns false when the iteration stops,
+// either by reaching the end of the input or an error.
+func (r *BAMReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	for {
+		rec, ok := r.read()
+		if !ok {
+			return false
+		}
+		if rec.Flags&sam.Unmapped != 0 || rec.Ref == nil {
+			continue
+		}
+		if r.err = r.set(rec); r.err != nil {
+			return false
+		}
+		s, ok := r.dest.Addr().Interface().(Stranded)
+		if !ok {
+			return true
+		}
+		keep, err := r.policy.Check(s)
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if keep {
+			return true
+		}
+		r.skipped++
+	}
+}
+
+// read returns the next alignment of the file or the regions.
+func (r *BAMReader) read() (*sam.Record, bool) {
+	if r.idx == nil {
+		rec, err := r.r.Read()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return nil, false
+		}
+		return rec, true
+	}
+	for {
+		if r.it != nil {
+			if r.it.Next() {
+				rec := r.it.Record()
+				reg := r.regions[0]
+				if rec.Pos > reg.Stop || rec.End()-1 < reg.Start {
+					continue
+				}
+				// alignments that overlap the previous region were read
+				// with it; regions are merged so no earlier one can match.
+				if r.prev.Rname == reg.Rname && rec.Pos <= r.prev.Stop {
+					continue
+				}
+				return rec, true
+			}
+			if r.err = r.it.Error(); r.err != nil {
+				return nil, false
+			}
+			r.it.Close()
+			r.it = nil
+			r.prev, r.regions = r.regions[0], r.regions[1:]
+		}
+		if len(r.regions) == 0 {
+			return nil, false
+		}
+		reg := r.regions[0]
+		ref, ok := r.refs[reg.Rname]
+		if !ok {
+			r.regions = r.regions[1:]
+			continue
+		}
+		chunks, err := r.idx.Chunks(ref, reg.Start, reg.Stop+1)
+		if err != nil {
+			r.err = err
+			return nil, false
+		}
+		if r.it, r.err = bam.NewIterator(r.r, chunks); r.err != nil {
+			return nil, false
+		}
+	}
+}
+
+// set stores rec in the destination record.
+func (r *BAMReader) set(rec *sam.Record) error {
+	for _, f := range r.fields {
+		v := bamValue(rec, f.col)
+		fv := r.dest.FieldByIndex(f.index)
+		if s, ok := fv.Addr().Interface().(sql.Scanner); ok {
+			if err := s.Scan(v); err != nil {
+				return err
+			}
+			continue
+		}
+		switch x := v.(type) {
+		case int64:
+			switch fv.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				fv.SetInt(x)
+			case reflect.Float32, reflect.Float64:
+				fv.SetFloat(float64(x))
+			default:
+				return fmt.Errorf("htsdb: cannot store column %s in %s", f.col, fv.Type())
+			}
+		case string:
+			if fv.Kind() != reflect.String {
+				return fmt.Errorf("htsdb: cannot store column %s in %s", f.col, fv.Type())
+			}
+			fv.SetString(x)
+		}
+	}
+	return nil
+}
+
+// bamValue returns the value of column col for rec. Integers are returned as
+// int64 and text as string like the database driver.
+func bamValue(rec *sam.Record, col string) interface{} {
+	switch col {
+	case "qname":
+		return rec.Name
+	case "flag":
+		return int64(rec.Flags)
+	case "rname":
+		return rec.Ref.Name()
+	case "pos":
+		return int64(rec.Pos + 1)
+	case "mapq":
+		return int64(rec.MapQ)
+	case "cigar":
+		if len(rec.Cigar) == 0 {
+			return "*"
+		}
+		return rec.Cigar.String()
+	case "rnext":
+		if rec.MateRef == nil {
+			return "*"
+		}
+		return rec.MateRef.Name()
+	case "pnext":
+		return int64(rec.MatePos + 1)
+	case "tlen":
+		return int64(rec.TempLen)
+	case "seq":
+		if rec.Seq.Length == 0 {
+			return "*"
+		}
+		return string(rec.Seq.Expand())
+	case "qual":
+		if len(rec.Qual) == 0 || rec.Qual[0] == 0xff {
+			return "*"
+		}
+		q := make([]byte, len(rec.Qual))
+		for i, v := range rec.Qual {
+			q[i] = v + 33
+		}
+		return string(q)
+	case "tags":
+		tags := make([]string, len(rec.AuxFields))
+		for i, a := range rec.AuxFields {
+			tags[i] = a.String()
+		}
+		return strings.Join(tags, "\t")
+	case "start":
+		return int64(rec.Pos)
+	case "stop":
+		return int64(rec.End() - 1)
+	case "strand":
+		if rec.Flags&sam.Reverse != 0 {
+			return int64(-1)
+		}
+		return int64(1)
+	case "copy_number":
+		return int64(1)
+	}
+	return nil
+}
+
+// SetStrandPolicy sets the policy for records whose strand is not 1 or -1.
+func (r *BAMReader) SetStrandPolicy(p StrandPolicy) {
+	r.policy = p
+}
+
+// Skipped returns the number of records skipped because of their strand.
+func (r *BAMReader) Skipped() int {
+	return r.skipped
+}
+
+// Error returns the error that was encountered by the iterator.
+func (r *BAMReader) Error() error {
+	return r.err
+}
+
+// Record returns the most recent record read by a call to Next.
+func (r *BAMReader) Record() interface{} { return r.dest.Addr().Interface() }
+
+// Err returns the error that was encountered by the iterator. It is the same
+// as Error.
+func (r *BAMReader) Err() error { return r.err }
+
+// References returns the references in the header of the BAM file.
+func (r *BAMReader) References() ([]Reference, error) {
+	return bamReferences(r.r.Header().Refs()), nil
+}
+
+// bamReferences converts the references of a BAM header.
+func bamReferences(samRefs []*sam.Reference) []Reference {
+	refs := make([]Reference, len(samRefs))
+	for i, ref := range samRefs {
+		refs[i] = Reference{Chrom: ref.Name(), Length: ref.Len()}
+	}
+	return refs
+}
+
+// Close closes the BAM file.
+func (r *BAMReader) Close() {
+	if r.it != nil {
+		r.it.Close()
+	}
+	r.r.Close()
+	r.f.Close()
+}
@@ -0,0 +1,100 @@
+package htsdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+)
+
+func TestBAMFields(t *testing.T) {
+	fields, err := bamFields(reflect.TypeOf(OrientedFeature{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"strand", "rname", "start", "stop", "copy_number"}
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, fields)
+	}
+	for i, f := range fields {
+		if f.col != expected[i] {
+			t.Errorf("expected %s, actual %s", expected[i], f.col)
+		}
+	}
+	if _, err = bamFields(reflect.TypeOf(SamRecord{}), nil); err != nil {
+		t.Error(err)
+	}
+	type custom struct {
+		Score int `db:"score"`
+	}
+	if _, err = bamFields(reflect.TypeOf(custom{}), nil); err == nil {
+		t.Error("expected error for column not available in BAM files")
+	}
+}
+
+func TestBAMReaderRegions(t *testing.T) {
+	dir := t.TempDir()
+	bamPath := filepath.Join(dir, "test.bam")
+	writeTestBAM(t, bamPath)
+	writeTestBAMIndex(t, bamPath)
+
+	// the alignments are at 0-3, 10-13 and 20-23; the first overlaps two
+	// separate regions and the second two overlapping ones.
+	regions := []Region{{"chr1", 22, 30}, {"chr1", 3, 5}, {"chr1", 11, 12},
+		{"chr1", 0, 1}, {"chr1", 10, 11}}
+	var rec SamRecord
+	r, err := NewBAMReader(bamPath, &rec, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for r.Next() {
+		names = append(names, rec.Qname)
+	}
+	if err = r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"rACGT", "rGGCA", "rTTAG"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, actual %v", expected, names)
+	}
+}
+
+// writeTestBAMIndex writes the index of the BAM file path to path.bai.
+func writeTestBAMIndex(t *testing.T, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br, err := bam.NewReader(f, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	var idx bam.Index
+	for {
+		rec, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = idx.Add(rec, br.LastChunk()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := os.Create(path + ".bai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err = bam.WriteIndex(out, &idx); err != nil {
+		t.Fatal(err)
+	}
+}
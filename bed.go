@@ -0,0 +1,162 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BEDRecord is an interval of a BED file with up to 12 columns. Start and Stop
+// follow HtsdbCoords. Strand is 1, -1 or 0 if unknown. Blocks are the exons
+// of BED12 records in HtsdbCoords and are empty for other records.
+type BEDRecord struct {
+	Region
+	Name   string
+	Score  string
+	Strand int
+	Blocks []Region
+}
+
+// Cigar returns the alignment of b as a CIGAR string: each block is a match
+// and each gap between blocks is a skipped region. Records without blocks are
+// a single match.
+func (b BEDRecord) Cigar() string {
+	if len(b.Blocks) == 0 {
+		return strconv.Itoa(b.Stop-b.Start+1) + "M"
+	}
+	var s strings.Builder
+	for i, blk := range b.Blocks {
+		if i > 0 {
+			s.WriteString(strconv.Itoa(blk.Start-b.Blocks[i-1].Stop-1) + "N")
+		}
+		s.WriteString(strconv.Itoa(blk.Stop-blk.Start+1) + "M")
+	}
+	return s.String()
+}
+
+// ImportRecord returns the ImportRecord of b with start and stop in c and the
+// given copy number. The name of b is the qname, reverse strand records have
+// flag 16 and unavailable SAM fields get their SAM value for unavailable
+// information.
+func (b BEDRecord) ImportRecord(c Coords, copies int) ImportRecord {
+	flag := 0
+	if b.Strand == -1 {
+		flag = 16
+	}
+	qname := b.Name
+	if qname == "" {
+		qname = "*"
+	}
+	start, stop := c.FromHtsdb(b.Start, b.Stop)
+	return ImportRecord{
+		SamRecord: SamRecord{Qname: qname, Flag: flag, Rname: b.Rname,
+			Pos: b.Start + 1, Mapq: 255, Cigar: b.Cigar(), Rnext: "*",
+			Seq: "*", Qual: "*"},
+		Start: start, Stop: stop, Strand: b.Strand, CopyNumber: copies,
+	}
+}
+
+// ScanBED reads the records of a BED file with 3 to 12 columns from r and
+// calls fn for each one. Empty lines and track, browser or comment lines are
+// ignored. Reading stops at the first error returned by fn.
+func ScanBED(r io.Reader, fn func(BEDRecord) error) error {
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") ||
+			fields[0] == "track" || fields[0] == "browser" {
+			continue
+		}
+		b, err := parseBED(fields)
+		if err != nil {
+			return fmt.Errorf("htsdb: line %d: %v", line, err)
+		}
+		if err = fn(b); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// parseBED parses the fields of a BED line.
+func parseBED(fields []string) (BEDRecord, error) {
+	var b BEDRecord
+	if len(fields) < 3 {
+		return b, fmt.Errorf("expected at least 3 columns")
+	}
+	start, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return b, err
+	}
+	end, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return b, err
+	}
+	if end <= start || start < 0 {
+		return b, fmt.Errorf("invalid interval %d-%d", start, end)
+	}
+	b.Rname = fields[0]
+	b.Start, b.Stop = BEDCoords.ToHtsdb(start, end)
+	if len(fields) > 3 {
+		b.Name = fields[3]
+	}
+	if len(fields) > 4 {
+		b.Score = fields[4]
+	}
+	if len(fields) > 5 {
+		switch fields[5] {
+		case "+":
+			b.Strand = 1
+		case "-":
+			b.Strand = -1
+		case ".":
+		default:
+			return b, fmt.Errorf("invalid strand %q", fields[5])
+		}
+	}
+	if len(fields) < 12 {
+		return b, nil
+	}
+	n, err := strconv.Atoi(fields[9])
+	if err != nil {
+		return b, err
+	}
+	sizes := strings.Split(strings.TrimSuffix(fields[10], ","), ",")
+	starts := strings.Split(strings.TrimSuffix(fields[11], ","), ",")
+	if n < 1 || len(sizes) != n || len(starts) != n {
+		return b, fmt.Errorf("expected %d block sizes and starts", n)
+	}
+	b.Blocks = make([]Region, n)
+	for i := range b.Blocks {
+		size, err := strconv.Atoi(sizes[i])
+		if err != nil {
+			return b, err
+		}
+		off, err := strconv.Atoi(starts[i])
+		if err != nil {
+			return b, err
+		}
+		blk := Region{Rname: b.Rname, Start: start + off, Stop: start + off + size - 1}
+		if size < 1 || (i > 0 && blk.Start <= b.Blocks[i-1].Stop) {
+			return b, fmt.Errorf("invalid block %d", i+1)
+		}
+		b.Blocks[i] = blk
+	}
+	if b.Blocks[0].Start != b.Start || b.Blocks[n-1].Stop != b.Stop {
+		return b, fmt.Errorf("blocks do not span the interval")
+	}
+	return b, nil
+}
+
+// ScoreCopyNumber returns the score of b as a copy number. The score must be
+// a positive integer.
+func (b BEDRecord) ScoreCopyNumber() (int, error) {
+	n, err := strconv.Atoi(b.Score)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("htsdb: %s:%d-%d: score %q is not a positive integer",
+			b.Rname, b.Start+1, b.Stop+1, b.Score)
+	}
+	return n, nil
+}
@@ -0,0 +1,59 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanBED(t *testing.T) {
+	in := "track name=test\n" +
+		"chr1\t10\t20\tr1\t3\t-\n" +
+		"chr1\t100\t200\tr2\t0\t+\t100\t200\t0\t2\t10,20,\t0,80,\n" +
+		"chr2\t5\t8\n"
+	var recs []BEDRecord
+	err := ScanBED(strings.NewReader(in), func(b BEDRecord) error {
+		recs = append(recs, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records, actual %d", len(recs))
+	}
+	expected := []struct {
+		start, stop, strand int
+		cigar               string
+	}{
+		{10, 19, -1, "10M"},
+		{100, 199, 1, "10M70N20M"},
+		{5, 7, 0, "3M"},
+	}
+	for i, e := range expected {
+		r := recs[i]
+		if r.Start != e.start || r.Stop != e.stop || r.Strand != e.strand || r.Cigar() != e.cigar {
+			t.Errorf("record %d: expected %v, actual %+v with cigar %s", i, e, r, r.Cigar())
+		}
+	}
+	if n, err := recs[0].ScoreCopyNumber(); err != nil || n != 3 {
+		t.Errorf("expected copy number 3, actual %d, %v", n, err)
+	}
+	if _, err := recs[1].ScoreCopyNumber(); err == nil {
+		t.Error("expected error for zero score")
+	}
+	ir := recs[0].ImportRecord(BEDCoords, 3)
+	if ir.Flag != 16 || ir.Pos != 11 || ir.Start != 10 || ir.Stop != 20 || ir.CopyNumber != 3 {
+		t.Errorf("unexpected import record %+v", ir)
+	}
+
+	for _, bad := range []string{
+		"chr1\t20\t10\n",
+		"chr1\t10\t20\tr\t0\tx\n",
+		"chr1\t100\t200\tr\t0\t+\t100\t200\t0\t2\t10,20,\t0,50,\n",
+	} {
+		err := ScanBED(strings.NewReader(bad), func(BEDRecord) error { return nil })
+		if err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,573 @@
+package htsdb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// bigWig format constants; see Kent et al. 2010, BigWig and BigBed.
+const (
+	bigWigMagic     = 0x888FFC26
+	bigWigVersion   = 4
+	bptMagic        = 0x78CA8C91
+	cirTreeMagic    = 0x2468ACE0
+	bbiHeaderSize   = 64
+	bbiZoomHdrSize  = 24
+	bbiSummarySize  = 40
+	bbiItemsPerSlot = 1024
+	bbiBlockSize    = 256
+
+	// bigWigZoomBase is the reduction of the first zoom level; each next
+	// level is bigWigZoomFactor times coarser.
+	bigWigZoomBase   = 256
+	bigWigZoomFactor = 4
+	bigWigMaxZooms   = 10
+)
+
+// BigWigWriter is a TrackWriter that writes per-base values in bigWig format
+// so that tracks can be loaded in genome browsers without conversion. Like
+// BedGraphWriter, consecutive positions with equal values are merged and
+// zero values are omitted. References must be added in increasing name order
+// and positions in increasing order for each reference. Values beyond the
+// reference lengths given to NewBigWigWriter are dropped as bigWig cannot
+// hold them. Data are zlib compressed and zoom levels are built on the fly;
+// their compressed summaries are held in memory until Flush.
+type BigWigWriter struct {
+	w     io.WriteSeeker
+	names []string
+	ids   map[string]uint32
+	sizes []uint32
+
+	// pending interval.
+	rname      string
+	id         uint32
+	start, end int
+	v          float64
+	open       bool
+
+	lastID   int
+	lastEnd  int
+	offset   int64
+	dataOff  int64
+	sections []bbiSection
+	items    []byte
+	nItems   int
+	secChrom uint32
+	secStart uint32
+	secEnd   uint32
+	maxBuf   int
+	zooms    []*bigWigZoom
+	sum      bbiSummary
+	done     bool
+}
+
+// bbiSection is a compressed block of data and its extent.
+type bbiSection struct {
+	startChrom, startBase uint32
+	endChrom, endBase     uint32
+	offset, size          uint64
+}
+
+// bbiSummary summarizes the values of a set of bases.
+type bbiSummary struct {
+	bases      uint64
+	min, max   float64
+	sum, sumSq float64
+}
+
+func (s *bbiSummary) add(n int, v float64) {
+	if s.bases == 0 || v < s.min {
+		s.min = v
+	}
+	if s.bases == 0 || v > s.max {
+		s.max = v
+	}
+	s.bases += uint64(n)
+	s.sum += v * float64(n)
+	s.sumSq += v * v * float64(n)
+}
+
+// bigWigZoom accumulates the summaries of a zoom level in fixed size bins.
+type bigWigZoom struct {
+	reduction int
+	data      bytes.Buffer
+	sections  []bbiSection
+	items     []byte
+	nItems    int
+	secStart  uint32
+	secEnd    uint32
+	secChrom  uint32
+
+	// open bin.
+	chrom      uint32
+	start, end int
+	sum        bbiSummary
+	open       bool
+}
+
+// NewBigWigWriter returns a BigWigWriter that writes to w the values of the
+// references with the given lengths. The header is rewritten by Flush, so w
+// must be seekable e.g. an *os.File.
+func NewBigWigWriter(w io.WriteSeeker, lengths map[string]int) (*BigWigWriter, error) {
+	b := &BigWigWriter{w: w, ids: make(map[string]uint32), lastID: -1}
+	for name := range lengths {
+		b.names = append(b.names, name)
+	}
+	sort.Strings(b.names)
+	maxLen := 0
+	for i, name := range b.names {
+		l := lengths[name]
+		if l < 0 || l > math.MaxUint32 {
+			return nil, fmt.Errorf("htsdb: invalid length %d of %s", l, name)
+		}
+		b.ids[name] = uint32(i)
+		b.sizes = append(b.sizes, uint32(l))
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	for r := bigWigZoomBase; r < maxLen && len(b.zooms) < bigWigMaxZooms; r *= bigWigZoomFactor {
+		b.zooms = append(b.zooms, &bigWigZoom{reduction: r})
+	}
+
+	// reserve the header, zoom headers and total summary and write the
+	// reference tree after them.
+	var buf bytes.Buffer
+	buf.Write(make([]byte, bbiHeaderSize+bbiZoomHdrSize*len(b.zooms)+bbiSummarySize))
+	writeBPT(&buf, b.names, b.sizes)
+	b.dataOff = int64(buf.Len())
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	b.offset = int64(buf.Len())
+	return b, nil
+}
+
+// Add adds value v at the 0-based position pos of reference rname.
+func (b *BigWigWriter) Add(rname string, pos int, v float64) error {
+	return b.AddInterval(rname, pos, pos+1, v)
+}
+
+// AddInterval adds value v at the 0-based half-open interval [start, end) of
+// reference rname.
+func (b *BigWigWriter) AddInterval(rname string, start, end int, v float64) error {
+	if b.done {
+		return fmt.Errorf("htsdb: bigWig writer is flushed")
+	}
+	if b.open && rname == b.rname && start == b.end && v == b.v {
+		b.end = end
+		return nil
+	}
+	if err := b.flushInterval(); err != nil {
+		return err
+	}
+	if v == 0 || end <= start {
+		return nil
+	}
+	id, ok := b.ids[rname]
+	if !ok {
+		return fmt.Errorf("htsdb: unknown reference %s", rname)
+	}
+	if int(id) < b.lastID || (int(id) == b.lastID && start < b.lastEnd) {
+		return fmt.Errorf("htsdb: %s:%d added out of order", rname, start)
+	}
+	b.rname, b.id, b.start, b.end, b.v, b.open = rname, id, start, end, v, true
+	return nil
+}
+
+// Flush writes any pending data, the indices and the header. No values can
+// be added afterwards.
+func (b *BigWigWriter) Flush() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	if err := b.flushInterval(); err != nil {
+		return err
+	}
+	if err := b.flushSection(); err != nil {
+		return err
+	}
+	indexOff := b.offset
+	var buf bytes.Buffer
+	writeCIRTree(&buf, b.sections, uint64(indexOff), uint64(indexOff))
+
+	// zoom levels follow the data index; each has a count, its sections and
+	// their index.
+	zoomHdrs := make([]byte, bbiZoomHdrSize*len(b.zooms))
+	for i, z := range b.zooms {
+		if z.open {
+			z.emit(b)
+		}
+		z.flushSection(b)
+		dataOff := uint64(indexOff) + uint64(buf.Len())
+		binary.Write(&buf, binary.LittleEndian, uint32(len(z.sections)))
+		base := dataOff + 4
+		for j := range z.sections {
+			z.sections[j].offset += base
+		}
+		buf.Write(z.data.Bytes())
+		zIndexOff := uint64(indexOff) + uint64(buf.Len())
+		writeCIRTree(&buf, z.sections, zIndexOff, zIndexOff)
+		h := zoomHdrs[i*bbiZoomHdrSize:]
+		binary.LittleEndian.PutUint32(h[0:], uint32(z.reduction))
+		binary.LittleEndian.PutUint64(h[8:], dataOff)
+		binary.LittleEndian.PutUint64(h[16:], zIndexOff)
+	}
+	if _, err := b.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	// rewrite the header, zoom headers, total summary and section count.
+	summaryOff := bbiHeaderSize + len(zoomHdrs)
+	var hdr bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&hdr, le, uint32(bigWigMagic))
+	binary.Write(&hdr, le, uint16(bigWigVersion))
+	binary.Write(&hdr, le, uint16(len(b.zooms)))
+	binary.Write(&hdr, le, uint64(summaryOff+bbiSummarySize)) // reference tree
+	binary.Write(&hdr, le, uint64(b.dataOff))
+	binary.Write(&hdr, le, uint64(indexOff))
+	binary.Write(&hdr, le, uint16(0)) // field count
+	binary.Write(&hdr, le, uint16(0)) // defined field count
+	binary.Write(&hdr, le, uint64(0)) // autoSql
+	binary.Write(&hdr, le, uint64(summaryOff))
+	binary.Write(&hdr, le, uint32(b.maxBuf))
+	binary.Write(&hdr, le, uint64(0)) // extension header
+	hdr.Write(zoomHdrs)
+	binary.Write(&hdr, le, b.sum.bases)
+	binary.Write(&hdr, le, b.sum.min)
+	binary.Write(&hdr, le, b.sum.max)
+	binary.Write(&hdr, le, b.sum.sum)
+	binary.Write(&hdr, le, b.sum.sumSq)
+	if _, err := b.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := b.w.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+	if _, err := b.w.Seek(b.dataOff, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(b.w, le, uint64(len(b.sections))); err != nil {
+		return err
+	}
+	_, err := b.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// flushInterval adds the pending interval to the current data section and to
+// the zoom levels.
+func (b *BigWigWriter) flushInterval() error {
+	if !b.open {
+		return nil
+	}
+	b.open = false
+	id := b.id
+	b.lastID, b.lastEnd = int(id), b.end
+	start, end := b.start, b.end
+	if size := int(b.sizes[id]); end > size {
+		end = size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start >= end {
+		return nil
+	}
+	if b.nItems > 0 && (id != b.secChrom || b.nItems == bbiItemsPerSlot) {
+		if err := b.flushSection(); err != nil {
+			return err
+		}
+	}
+	if b.nItems == 0 {
+		b.secChrom, b.secStart = id, uint32(start)
+	}
+	b.secEnd = uint32(end)
+	var item [12]byte
+	binary.LittleEndian.PutUint32(item[0:], uint32(start))
+	binary.LittleEndian.PutUint32(item[4:], uint32(end))
+	binary.LittleEndian.PutUint32(item[8:], math.Float32bits(float32(b.v)))
+	b.items = append(b.items, item[:]...)
+	b.nItems++
+	b.sum.add(end-start, b.v)
+	for _, z := range b.zooms {
+		z.add(b, id, start, end, b.v)
+	}
+	return nil
+}
+
+// flushSection writes the current data section as a bedGraph section.
+func (b *BigWigWriter) flushSection() error {
+	if b.nItems == 0 {
+		return nil
+	}
+	var hdr [24]byte
+	le := binary.LittleEndian
+	le.PutUint32(hdr[0:], b.secChrom)
+	le.PutUint32(hdr[4:], b.secStart)
+	le.PutUint32(hdr[8:], b.secEnd)
+	hdr[20] = 1 // bedGraph
+	le.PutUint16(hdr[22:], uint16(b.nItems))
+	data, n := compressSection(hdr[:], b.items)
+	if n > b.maxBuf {
+		b.maxBuf = n
+	}
+	if _, err := b.w.Write(data); err != nil {
+		return err
+	}
+	b.sections = append(b.sections, bbiSection{b.secChrom, b.secStart, b.secChrom,
+		b.secEnd, uint64(b.offset), uint64(len(data))})
+	b.offset += int64(len(data))
+	b.items, b.nItems = b.items[:0], 0
+	return nil
+}
+
+// add adds v at [start, end) of reference chrom to the bins it overlaps.
+func (z *bigWigZoom) add(b *BigWigWriter, chrom uint32, start, end int, v float64) {
+	for start < end {
+		binStart := start / z.reduction * z.reduction
+		if z.open && (chrom != z.chrom || binStart != z.start) {
+			z.emit(b)
+		}
+		if !z.open {
+			z.chrom, z.start, z.open = chrom, binStart, true
+			z.end = binStart + z.reduction
+			if size := int(b.sizes[chrom]); z.end > size {
+				z.end = size
+			}
+			z.sum = bbiSummary{}
+		}
+		stop := end
+		if stop > z.end {
+			stop = z.end
+		}
+		z.sum.add(stop-start, v)
+		start = stop
+	}
+}
+
+// emit adds the open bin to the current zoom section.
+func (z *bigWigZoom) emit(b *BigWigWriter) {
+	z.open = false
+	if z.nItems > 0 && (z.chrom != z.secChrom || z.nItems == bbiItemsPerSlot) {
+		z.flushSection(b)
+	}
+	if z.nItems == 0 {
+		z.secChrom, z.secStart = z.chrom, uint32(z.start)
+	}
+	z.secEnd = uint32(z.end)
+	var item [32]byte
+	le := binary.LittleEndian
+	le.PutUint32(item[0:], z.chrom)
+	le.PutUint32(item[4:], uint32(z.start))
+	le.PutUint32(item[8:], uint32(z.end))
+	le.PutUint32(item[12:], uint32(z.sum.bases))
+	le.PutUint32(item[16:], math.Float32bits(float32(z.sum.min)))
+	le.PutUint32(item[20:], math.Float32bits(float32(z.sum.max)))
+	le.PutUint32(item[24:], math.Float32bits(float32(z.sum.sum)))
+	le.PutUint32(item[28:], math.Float32bits(float32(z.sum.sumSq)))
+	z.items = append(z.items, item[:]...)
+	z.nItems++
+}
+
+// flushSection compresses the current zoom section into the in-memory data
+// of the level. Section offsets are relative to the start of the data.
+func (z *bigWigZoom) flushSection(b *BigWigWriter) {
+	if z.nItems == 0 {
+		return
+	}
+	data, n := compressSection(nil, z.items)
+	if n > b.maxBuf {
+		b.maxBuf = n
+	}
+	z.sections = append(z.sections, bbiSection{z.secChrom, z.secStart, z.secChrom,
+		z.secEnd, uint64(z.data.Len()), uint64(len(data))})
+	z.data.Write(data)
+	z.items, z.nItems = z.items[:0], 0
+}
+
+// compressSection returns hdr followed by items, zlib compressed, and its
+// uncompressed size.
+func compressSection(hdr, items []byte) ([]byte, int) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(hdr)
+	zw.Write(items)
+	zw.Close()
+	return buf.Bytes(), len(hdr) + len(items)
+}
+
+// treeLevels returns the number of nodes of each level of a tree with n
+// items and nodes of up to bs children, leaves first.
+func treeLevels(n, bs int) []int {
+	levels := []int{(n + bs - 1) / bs}
+	if levels[0] == 0 {
+		levels[0] = 1
+	}
+	for levels[len(levels)-1] > 1 {
+		levels = append(levels, (levels[len(levels)-1]+bs-1)/bs)
+	}
+	return levels
+}
+
+// writeBPT writes the B+ tree that maps the sorted reference names to their
+// IDs and sizes. Nodes are written from the root down and all but the last
+// node of each level are full.
+func writeBPT(buf *bytes.Buffer, names []string, sizes []uint32) {
+	le := binary.LittleEndian
+	keySize := 1
+	for _, n := range names {
+		if len(n) > keySize {
+			keySize = len(n)
+		}
+	}
+	bs := bbiBlockSize
+	if len(names) < bs && len(names) > 0 {
+		bs = len(names)
+	}
+	base := buf.Len()
+	binary.Write(buf, le, uint32(bptMagic))
+	binary.Write(buf, le, uint32(bs))
+	binary.Write(buf, le, uint32(keySize))
+	binary.Write(buf, le, uint32(8))
+	binary.Write(buf, le, uint64(len(names)))
+	binary.Write(buf, le, uint64(0))
+
+	itemSize := keySize + 8
+	levels := treeLevels(len(names), bs)
+	starts := make([]int, len(levels))
+	off := base + 32
+	for l := len(levels) - 1; l >= 0; l-- {
+		starts[l] = off
+		items := len(names)
+		if l > 0 {
+			items = levels[l-1]
+		}
+		off += 4*levels[l] + itemSize*items
+	}
+	key := func(i int) []byte {
+		k := make([]byte, keySize)
+		copy(k, names[i])
+		return k
+	}
+	for l := len(levels) - 1; l >= 0; l-- {
+		n := len(names)
+		if l > 0 {
+			n = levels[l-1]
+		}
+		span := 1 // leaf items covered by each item of level l
+		for i := 0; i < l; i++ {
+			span *= bs
+		}
+		for node := 0; node < levels[l]; node++ {
+			from, to := node*bs, (node+1)*bs
+			if to > n {
+				to = n
+			}
+			isLeaf := uint8(0)
+			if l == 0 {
+				isLeaf = 1
+			}
+			buf.WriteByte(isLeaf)
+			buf.WriteByte(0)
+			binary.Write(buf, le, uint16(to-from))
+			for i := from; i < to; i++ {
+				buf.Write(key(i * span))
+				if l == 0 {
+					binary.Write(buf, le, uint32(i))
+					binary.Write(buf, le, sizes[i])
+					continue
+				}
+				binary.Write(buf, le, uint64(starts[l-1]+i*(4+bs*itemSize)))
+			}
+		}
+	}
+}
+
+// writeCIRTree writes the R-tree index of sections, sorted by position, to
+// buf. offset is the file offset at which the tree starts and dataEnd the end
+// of the indexed data.
+func writeCIRTree(buf *bytes.Buffer, sections []bbiSection, offset, dataEnd uint64) {
+	le := binary.LittleEndian
+	bs := bbiBlockSize
+	var first, last bbiSection
+	if len(sections) > 0 {
+		first, last = sections[0], sections[len(sections)-1]
+	}
+	base := uint64(buf.Len())
+	binary.Write(buf, le, uint32(cirTreeMagic))
+	binary.Write(buf, le, uint32(bs))
+	binary.Write(buf, le, uint64(len(sections)))
+	binary.Write(buf, le, first.startChrom)
+	binary.Write(buf, le, first.startBase)
+	binary.Write(buf, le, last.endChrom)
+	binary.Write(buf, le, last.endBase)
+	binary.Write(buf, le, dataEnd)
+	binary.Write(buf, le, uint32(bbiItemsPerSlot))
+	binary.Write(buf, le, uint32(0))
+
+	const leafSize, nodeSize = 32, 24
+	levels := treeLevels(len(sections), bs)
+	starts := make([]uint64, len(levels))
+	off := offset - base + uint64(buf.Len())
+	for l := len(levels) - 1; l >= 0; l-- {
+		starts[l] = off
+		if l == 0 {
+			off += uint64(4*levels[l] + leafSize*len(sections))
+		} else {
+			off += uint64(4*levels[l] + nodeSize*levels[l-1])
+		}
+	}
+	for l := len(levels) - 1; l >= 0; l-- {
+		n := len(sections)
+		if l > 0 {
+			n = levels[l-1]
+		}
+		span := 1
+		for i := 0; i < l; i++ {
+			span *= bs
+		}
+		for node := 0; node < levels[l]; node++ {
+			from, to := node*bs, (node+1)*bs
+			if to > n {
+				to = n
+			}
+			isLeaf := uint8(0)
+			if l == 0 {
+				isLeaf = 1
+			}
+			buf.WriteByte(isLeaf)
+			buf.WriteByte(0)
+			binary.Write(buf, le, uint16(to-from))
+			for i := from; i < to; i++ {
+				lo := sections[i*span]
+				hiIdx := (i+1)*span - 1
+				if hiIdx >= len(sections) {
+					hiIdx = len(sections) - 1
+				}
+				hi := sections[hiIdx]
+				binary.Write(buf, le, lo.startChrom)
+				binary.Write(buf, le, lo.startBase)
+				binary.Write(buf, le, hi.endChrom)
+				binary.Write(buf, le, hi.endBase)
+				if l == 0 {
+					binary.Write(buf, le, lo.offset)
+					binary.Write(buf, le, lo.size)
+					continue
+				}
+				childSize := nodeSize
+				if l == 1 {
+					childSize = leafSize
+				}
+				binary.Write(buf, le, starts[l-1]+uint64(i*(4+bs*childSize)))
+			}
+		}
+	}
+}
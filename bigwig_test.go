@@ -0,0 +1,196 @@
+package htsdb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bwInterval is an interval read back from a bigWig file.
+type bwInterval struct {
+	chrom      uint32
+	start, end uint32
+	v          float32
+}
+
+// readBPT adds the IDs and sizes of the references of the B+ tree node at off
+// of data to names.
+func readBPT(data []byte, off uint64, keySize int, names map[string][2]uint32) {
+	le := binary.LittleEndian
+	isLeaf, n := data[off], int(le.Uint16(data[off+2:]))
+	p := off + 4
+	for i := 0; i < n; i++ {
+		key := string(bytes.TrimRight(data[p:p+uint64(keySize)], "\x00"))
+		p += uint64(keySize)
+		if isLeaf == 1 {
+			names[key] = [2]uint32{le.Uint32(data[p:]), le.Uint32(data[p+4:])}
+		} else {
+			readBPT(data, le.Uint64(data[p:]), keySize, names)
+		}
+		p += 8
+	}
+}
+
+// readCIRTree returns the offsets and sizes of the sections of the R-tree
+// node at off of data.
+func readCIRTree(data []byte, off uint64) [][2]uint64 {
+	le := binary.LittleEndian
+	isLeaf, n := data[off], int(le.Uint16(data[off+2:]))
+	p := off + 4
+	var secs [][2]uint64
+	for i := 0; i < n; i++ {
+		if isLeaf == 1 {
+			secs = append(secs, [2]uint64{le.Uint64(data[p+16:]), le.Uint64(data[p+24:])})
+			p += 32
+			continue
+		}
+		secs = append(secs, readCIRTree(data, le.Uint64(data[p+16:]))...)
+		p += 24
+	}
+	return secs
+}
+
+func inflate(t *testing.T, b []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestBigWigWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "t.bw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lengths := map[string]int{"chr1": 1000000, "chr2": 300, "chrM": 100}
+	w, err := NewBigWigWriter(f, lengths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alternating values make enough sections for a multi-level index.
+	var expected []bwInterval
+	sum := 0.0
+	for i := 0; i < 600000; i++ {
+		v := float64(i%2 + 1)
+		if err = w.Add("chr1", i, v); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, bwInterval{0, uint32(i), uint32(i + 1), float32(v)})
+		sum += v
+	}
+	for _, a := range []struct {
+		rname      string
+		start, end int
+		v          float64
+	}{
+		{"chr2", 10, 20, 1.5}, {"chr2", 20, 25, 1.5}, {"chr2", 30, 31, 0},
+		{"chr2", 290, 310, 2}, {"chrM", 0, 100, 3},
+	} {
+		if err = w.AddInterval(a.rname, a.start, a.end, a.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected = append(expected, bwInterval{1, 10, 25, 1.5}, bwInterval{1, 290, 300, 2},
+		bwInterval{2, 0, 100, 3})
+	sum += 15*1.5 + 10*2 + 100*3
+	if err = w.AddInterval("chr1", 0, 1, 1); err == nil {
+		t.Error("expected error for out of order reference")
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Add("chrM", 0, 1); err == nil {
+		t.Error("expected error after Flush")
+	}
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	if le.Uint32(data) != bigWigMagic {
+		t.Fatal("wrong magic")
+	}
+	zoomLevels := int(le.Uint16(data[6:]))
+	if zoomLevels != 6 {
+		t.Errorf("expected 6 zoom levels, actual %d", zoomLevels)
+	}
+	chromTree, dataOff, indexOff := le.Uint64(data[8:]), le.Uint64(data[16:]), le.Uint64(data[24:])
+	summaryOff := le.Uint64(data[44:])
+	if got := math.Float64frombits(le.Uint64(data[summaryOff+24:])); got != sum {
+		t.Errorf("expected total sum %g, actual %g", sum, got)
+	}
+
+	// references.
+	if le.Uint32(data[chromTree:]) != bptMagic {
+		t.Fatal("wrong reference tree magic")
+	}
+	names := make(map[string][2]uint32)
+	readBPT(data, chromTree+32, int(le.Uint32(data[chromTree+8:])), names)
+	for name, l := range lengths {
+		if got := names[name]; int(got[1]) != l {
+			t.Errorf("%s: expected size %d, actual %d", name, l, got[1])
+		}
+	}
+	if names["chr2"][0] != 1 {
+		t.Errorf("chr2: expected id 1, actual %d", names["chr2"][0])
+	}
+
+	// data.
+	secs := readCIRTree(data, indexOff+48)
+	if n := le.Uint64(data[dataOff:]); int(n) != len(secs) {
+		t.Errorf("expected %d sections, actual %d", len(secs), n)
+	}
+	var got []bwInterval
+	for _, s := range secs {
+		sec := inflate(t, data[s[0]:s[0]+s[1]])
+		chrom, n := le.Uint32(sec), int(le.Uint16(sec[22:]))
+		for i := 0; i < n; i++ {
+			it := sec[24+12*i:]
+			got = append(got, bwInterval{chrom, le.Uint32(it), le.Uint32(it[4:]),
+				math.Float32frombits(le.Uint32(it[8:]))})
+		}
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d intervals, actual %d", len(expected), len(got))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("interval %d: expected %v, actual %v", i, expected[i], got[i])
+		}
+	}
+
+	// the first zoom level summarizes all bases.
+	zIndexOff := le.Uint64(data[bbiHeaderSize+16:])
+	bases, zsum := 0, 0.0
+	for _, s := range readCIRTree(data, zIndexOff+48) {
+		sec := inflate(t, data[s[0]:s[0]+s[1]])
+		for i := 0; i < len(sec); i += 32 {
+			bases += int(le.Uint32(sec[i+12:]))
+			zsum += float64(math.Float32frombits(le.Uint32(sec[i+24:])))
+		}
+	}
+	if bases != 600000+125 {
+		t.Errorf("expected %d zoom bases, actual %d", 600000+125, bases)
+	}
+	if zsum != sum {
+		t.Errorf("expected zoom sum %g, actual %g", sum, zsum)
+	}
+}
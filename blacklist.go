@@ -0,0 +1,54 @@
+package htsdb
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// Blacklist holds regions, such as the ENCODE blacklists, whose records are
+// excluded from counting, coverage and distribution computations.
+type Blacklist []Region
+
+// ReadBlacklistFile reads a blacklist from the BED file f. The empty string
+// returns an empty blacklist.
+func ReadBlacklistFile(f string) (Blacklist, error) {
+	regions, err := ReadRegionsFile(f)
+	return Blacklist(regions), err
+}
+
+// Filter returns an SQL clause that excludes records overlapping any region of
+// b under coordinate convention c. It returns the empty string if b is empty.
+func (b Blacklist) Filter(c Coords) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return "NOT " + RegionsFilter(b, c)
+}
+
+// Count returns the number of records of table that satisfy the SQL filter
+// where and overlap a region of b, i.e. the records that b excludes.
+func (b Blacklist) Count(db *sqlx.DB, table, where string, c Coords) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	cb := CountBuilder.From(table).Where(RegionsFilter(b, c))
+	if where != "" {
+		cb = cb.Where(where)
+	}
+	query, _, err := cb.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = db.Get(&n, query)
+	return n, err
+}
+
+// Apply adds the blacklist filter of b to sb. It returns sb unchanged if b is
+// empty.
+func (b Blacklist) Apply(sb squirrel.SelectBuilder, c Coords) squirrel.SelectBuilder {
+	if f := b.Filter(c); f != "" {
+		return sb.Where(f)
+	}
+	return sb
+}
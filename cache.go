@@ -0,0 +1,143 @@
+package htsdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultCacheDir is the suggested directory of a result cache.
+const DefaultCacheDir = ".htsdb-cache"
+
+// Cache stores the output of commands on disk so that re-running a command
+// with identical parameters and unchanged input files returns the stored
+// output instead of recomputing it. Entries are written atomically; a run that
+// fails or is interrupted leaves no entry.
+type Cache struct {
+	dir string
+}
+
+// OpenCache returns the cache in dir, creating the directory if needed.
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// CacheKey returns the cache key of a run of command prog of the given
+// version with command line args that reads files. Files are fingerprinted by
+// their absolute path, size and modification time so that a modified input
+// invalidates the entry; the write-ahead logs of SQLite (-wal) and DuckDB
+// (.wal) are included if they exist. Empty paths are ignored as absent
+// options, but missing files are an error: values that are not files e.g.
+// "ucsc" for --ref-map must not be passed, as they are part of args anyway.
+func CacheKey(prog, version string, args []string, files ...string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", prog, version)
+	for _, a := range args {
+		fmt.Fprintf(h, "%s\x00", a)
+	}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		for i, p := range []string{f, f + "-wal", f + ".wal"} {
+			fi, err := os.Stat(p)
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00", abs, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CheckCacheDriver returns an error if the results of databases of driver
+// cannot be cached. CacheKey fingerprints files, so it would not notice the
+// changes of PostgreSQL and MySQL databases, whose data are on a server.
+func CheckCacheDriver(driver string) error {
+	if driver == Postgres || driver == MySQL {
+		return fmt.Errorf("htsdb: cannot cache the results of %s databases; only database files are fingerprinted", driver)
+	}
+	return nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// WriteTo copies the output stored for key to w. It returns false if there
+// is no entry for key.
+func (c *Cache) WriteTo(key string, w io.Writer) (bool, error) {
+	f, err := os.Open(c.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(w, f); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CacheWriter writes to an underlying writer and records the written data as
+// the cache entry of a key. The entry is only stored when Commit is called.
+type CacheWriter struct {
+	w    io.Writer
+	tmp  *os.File
+	dest string
+	err  error
+}
+
+// Writer returns a CacheWriter that writes to w and records the output for
+// key.
+func (c *Cache) Writer(key string, w io.Writer) (*CacheWriter, error) {
+	tmp, err := ioutil.TempFile(c.dir, key+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &CacheWriter{w: w, tmp: tmp, dest: c.path(key)}, nil
+}
+
+// Write implements io.Writer. Errors recording the output do not fail the
+// write; they are returned by Commit.
+func (cw *CacheWriter) Write(p []byte) (int, error) {
+	if cw.err == nil {
+		_, cw.err = cw.tmp.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+// Commit stores the recorded output as the entry of the key.
+func (cw *CacheWriter) Commit() error {
+	name := cw.tmp.Name()
+	if err := cw.tmp.Close(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	if cw.err != nil {
+		os.Remove(name)
+		return cw.err
+	}
+	return os.Rename(name, cw.dest)
+}
+
+// Discard drops the recorded output e.g. when the run was interrupted.
+func (cw *CacheWriter) Discard() {
+	cw.tmp.Close()
+	os.Remove(cw.tmp.Name())
+}
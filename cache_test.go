@@ -0,0 +1,99 @@
+package htsdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "test.db")
+	if err = ioutil.WriteFile(db, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCache(filepath.Join(dir, DefaultCacheDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--db", db}
+	key, err := CacheKey("prog", "0.1", args, db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a discarded run leaves no entry.
+	var out bytes.Buffer
+	cw, err := c.Writer(key, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw.Write([]byte("partial"))
+	cw.Discard()
+	if ok, err := c.WriteTo(key, &out); ok || err != nil {
+		t.Fatalf("expected no entry, got %v, %v", ok, err)
+	}
+
+	// a committed run is returned.
+	out.Reset()
+	if cw, err = c.Writer(key, &out); err != nil {
+		t.Fatal(err)
+	}
+	cw.Write([]byte("result\n"))
+	if err = cw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var cached bytes.Buffer
+	if ok, err := c.WriteTo(key, &cached); !ok || err != nil {
+		t.Fatalf("expected entry, got %v, %v", ok, err)
+	}
+	if cached.String() != "result\n" || out.String() != "result\n" {
+		t.Errorf("unexpected output %q, cached %q", out.String(), cached.String())
+	}
+
+	// different args or a modified file change the key.
+	if k, _ := CacheKey("prog", "0.1", []string{"--db", db, "-v"}, db); k == key {
+		t.Error("expected different key for different args")
+	}
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(db, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := CacheKey("prog", "0.1", args, db); k == key {
+		t.Error("expected different key for modified file")
+	}
+
+	// a write-ahead log changes the key and a missing file is an error.
+	if key, err = CacheKey("prog", "0.1", args, db); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(db+".wal", []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := CacheKey("prog", "0.1", args, db); k == key {
+		t.Error("expected different key for write-ahead log")
+	}
+	if _, err = CacheKey("prog", "0.1", args, filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestCheckCacheDriver(t *testing.T) {
+	for _, d := range []string{SQLite, DuckDB} {
+		if err := CheckCacheDriver(d); err != nil {
+			t.Errorf("%s: unexpected error: %v", d, err)
+		}
+	}
+	for _, d := range []string{Postgres, MySQL} {
+		if err := CheckCacheDriver(d); err == nil {
+			t.Errorf("%s: expected error", d)
+		}
+	}
+}
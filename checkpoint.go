@@ -0,0 +1,140 @@
+package htsdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Checkpoint records the results of completed units of work (e.g. references)
+// in a file so that an interrupted run can resume without recomputing them.
+// The file contains one JSON object per line. It is safe for concurrent use.
+type Checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]json.RawMessage
+}
+
+type checkpointEntry struct {
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data"`
+}
+
+// OpenCheckpoint opens the checkpoint file at path, creating it if it does not
+// exist. Tag should describe the parameters of the run; resuming from a file
+// that was created with a different tag returns an error. Incomplete entries,
+// e.g. from a write that was interrupted, are ignored. A file whose first line
+// is not a valid tag cannot be matched to a run and is started anew.
+func OpenCheckpoint(path, tag string) (*Checkpoint, error) {
+	c := &Checkpoint{done: make(map[string]json.RawMessage)}
+	var hasTag, needNewline, stale bool
+	if f, err := os.Open(path); err == nil {
+		if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err = f.ReadAt(last, fi.Size()-1); err == nil {
+				needNewline = last[0] != '\n'
+			}
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<30)
+		for first := true; sc.Scan(); first = false {
+			var e checkpointEntry
+			err := json.Unmarshal(sc.Bytes(), &e)
+			if first {
+				var t string
+				if err != nil || e.Key != "" || json.Unmarshal(e.Data, &t) != nil {
+					stale = true
+					break
+				}
+				if t != tag {
+					f.Close()
+					return nil, fmt.Errorf(
+						"htsdb: checkpoint %s was created with different parameters", path)
+				}
+				hasTag = true
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			c.done[e.Key] = e.Data
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if stale {
+		flag |= os.O_TRUNC
+		needNewline = false
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.f = f
+	if needNewline {
+		if _, err = f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if !hasTag {
+		if err = c.write("", tag); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Load unmarshals the saved result for key into v. It returns false if key
+// has not been completed.
+func (c *Checkpoint) Load(key string, v interface{}) (bool, error) {
+	c.mu.Lock()
+	data, ok := c.done[key]
+	c.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Save marks key as completed with result v and syncs the file to disk.
+func (c *Checkpoint) Save(key string, v interface{}) error {
+	if key == "" {
+		return fmt.Errorf("htsdb: empty checkpoint key")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(key, v)
+}
+
+func (c *Checkpoint) write(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(checkpointEntry{Key: key, Data: data})
+	if err != nil {
+		return err
+	}
+	if _, err = c.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if key != "" {
+		c.done[key] = data
+	}
+	return c.f.Sync()
+}
+
+// Close closes the checkpoint file.
+func (c *Checkpoint) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,96 @@
+package htsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.state")
+
+	c, err := OpenCheckpoint(path, "span=10")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err = c.Save("chr1", map[int]int{-1: 2, 3: 4}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	c.Close()
+
+	// simulate an interrupted write.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"key":"chr2","da`)
+	f.Close()
+
+	c, err = OpenCheckpoint(path, "span=10")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer c.Close()
+	var v map[int]int
+	if ok, err := c.Load("chr1", &v); !ok || err != nil || v[3] != 4 || v[-1] != 2 {
+		t.Errorf("wrong saved result: %v, %v, %v", ok, err, v)
+	}
+	if ok, _ := c.Load("chr2", &v); ok {
+		t.Error("incomplete entry should be ignored")
+	}
+
+	if _, err = OpenCheckpoint(path, "span=20"); err == nil {
+		t.Error("expected error for different tag")
+	}
+}
+
+func TestCheckpointBadTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"corrupt": `{"key":"","da` + "\n" + `{"key":"chr1","data":{"3":4}}` + "\n",
+		"missing": `{"key":"chr1","data":{"3":4}}` + "\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name+".state")
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := OpenCheckpoint(path, "span=10")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		var v map[int]int
+		if ok, _ := c.Load("chr1", &v); ok {
+			t.Errorf("%s: entry without a valid tag should be ignored", name)
+		}
+		if err = c.Save("chr2", 1); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		c.Close()
+
+		// the file is started anew with the tag of the run.
+		if _, err = OpenCheckpoint(path, "span=20"); err == nil {
+			t.Errorf("%s: expected error for different tag", name)
+		}
+		c, err = OpenCheckpoint(path, "span=10")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if ok, _ := c.Load("chr1", &v); ok {
+			t.Errorf("%s: stale entry was kept", name)
+		}
+		var n int
+		if ok, err := c.Load("chr2", &n); !ok || err != nil || n != 1 {
+			t.Errorf("%s: wrong saved result: %v, %v, %v", name, ok, err, n)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,340 @@
+package htsdb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// ChimeraTable is the name of the table that links the two segments of
+// chimeric reads e.g. the hybrid reads of CLASH experiments.
+const ChimeraTable = "chimera"
+
+// ChimeraImportMarkerKey is the metadata key under which the number of SAM
+// records whose chimeras have been committed is stored during an import.
+const ChimeraImportMarkerKey = "chimera_import_marker"
+
+// Segment is a part of a read that aligns contiguously to a reference. Start
+// and Stop follow HtsdbCoords and Strand is 1 or -1. QStart is the number of
+// read bases before the segment, counted from the 5' end of the read.
+type Segment struct {
+	Rname       string
+	Start, Stop int
+	Strand      int
+	QStart      int
+}
+
+// NewSegment returns the Segment of an alignment at the 1-based position pos
+// of rname with the given CIGAR.
+func NewSegment(rname string, pos, strand int, cigar string) (Segment, error) {
+	refLen, lead, trail, err := ParseCigar(cigar)
+	if err != nil {
+		return Segment{}, err
+	}
+	if refLen == 0 {
+		return Segment{}, fmt.Errorf("htsdb: CIGAR %q does not consume the reference", cigar)
+	}
+	s := Segment{Rname: rname, Start: pos - 1, Stop: pos + refLen - 2, Strand: strand,
+		QStart: lead}
+	if strand == -1 {
+		s.QStart = trail
+	}
+	return s, nil
+}
+
+// Junction returns the position at which s is joined to the next segment of
+// the read if first is true or to the previous one otherwise.
+func (s Segment) Junction(first bool) int {
+	if first == (s.Strand == -1) {
+		return s.Start
+	}
+	return s.Stop
+}
+
+// ParseCigar returns the number of reference bases consumed by cigar and the
+// lengths of its leading and trailing soft or hard clips.
+func ParseCigar(cigar string) (refLen, lead, trail int, err error) {
+	n, digits, aligned := 0, false, false
+	for i := 0; i < len(cigar); i++ {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			n, digits = n*10+int(c-'0'), true
+			continue
+		}
+		if !digits {
+			return 0, 0, 0, fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+		}
+		switch c {
+		case 'S', 'H':
+			if aligned {
+				trail += n
+			} else {
+				lead += n
+			}
+		case 'M', 'D', 'N', '=', 'X':
+			refLen += n
+			aligned, trail = true, 0
+		case 'I', 'P':
+			aligned, trail = true, 0
+		default:
+			return 0, 0, 0, fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+		}
+		n, digits = 0, false
+	}
+	if digits {
+		return 0, 0, 0, fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+	}
+	return refLen, lead, trail, nil
+}
+
+// ParseSATag parses the value of a SAM SA tag i.e. a list of
+// "rname,pos,strand,CIGAR,mapQ,NM;" entries into segments.
+func ParseSATag(v string) ([]Segment, error) {
+	var segs []Segment
+	for _, entry := range strings.Split(strings.TrimSuffix(v, ";"), ";") {
+		fields := strings.Split(entry, ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("htsdb: invalid SA entry %q", entry)
+		}
+		pos, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("htsdb: invalid SA entry %q", entry)
+		}
+		strand := 1
+		switch fields[2] {
+		case "+":
+		case "-":
+			strand = -1
+		default:
+			return nil, fmt.Errorf("htsdb: invalid SA entry %q", entry)
+		}
+		s, err := NewSegment(fields[0], pos, strand, fields[3])
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, s)
+	}
+	return segs, nil
+}
+
+// Chimera links two consecutive segments of a chimeric read. Segment 1 is
+// closer to the 5' end of the read. Junction1 and Junction2 are the positions
+// of the segments that are joined in the read.
+type Chimera struct {
+	Qname      string `db:"qname"`
+	Rname1     string `db:"rname1"`
+	Start1     int    `db:"start1"`
+	Stop1      int    `db:"stop1"`
+	Strand1    int    `db:"strand1"`
+	Junction1  int    `db:"junction1"`
+	Rname2     string `db:"rname2"`
+	Start2     int    `db:"start2"`
+	Stop2      int    `db:"stop2"`
+	Strand2    int    `db:"strand2"`
+	Junction2  int    `db:"junction2"`
+	CopyNumber int    `db:"copy_number"`
+}
+
+// ChimeraBuilder is a squirrel select builder whose structure matches that of
+// Chimera and that selects from the chimera table.
+var ChimeraBuilder = squirrel.Select("qname", "rname1", "start1", "stop1",
+	"strand1", "junction1", "rname2", "start2", "stop2", "strand2", "junction2",
+	"copy_number").From(ChimeraTable)
+
+// NewChimeras returns the chimeras formed by consecutive segments of the read
+// qname, ordered from the 5' end of the read.
+func NewChimeras(qname string, segs []Segment, copies int) []Chimera {
+	sorted := append([]Segment(nil), segs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].QStart < sorted[j].QStart })
+	var chims []Chimera
+	for i := 1; i < len(sorted); i++ {
+		a, b := sorted[i-1], sorted[i]
+		c := Chimera{Qname: qname, CopyNumber: copies}
+		c.Rname1, c.Start1, c.Stop1, c.Strand1 = a.Rname, a.Start, a.Stop, a.Strand
+		c.Rname2, c.Start2, c.Stop2, c.Strand2 = b.Rname, b.Start, b.Stop, b.Strand
+		c.Junction1, c.Junction2 = a.Junction(true), b.Junction(false)
+		chims = append(chims, c)
+	}
+	return chims
+}
+
+// ReadSAMChimeras reads SAM records from r and calls fn for each chimera
+// formed by a primary alignment and the supplementary alignments listed in
+// its SA tag. Header lines, unmapped, secondary and supplementary records are
+// skipped. Reading stops at the first error returned by fn.
+func ReadSAMChimeras(r io.Reader, fn func(Chimera) error) error {
+	_, err := ScanSAMChimeras(r, 0, func(_ int, c Chimera) error { return fn(c) })
+	return err
+}
+
+// ScanSAMChimeras is like ReadSAMChimeras but skips the first since SAM
+// records, header lines excluded, without parsing them and also passes to fn
+// the 1-based number of the record each chimera comes from. When fn is called
+// for record n, all records before n have been processed; since can thus be
+// used to resume an interrupted import. It returns the number of records read.
+func ScanSAMChimeras(r io.Reader, since int, fn func(n int, c Chimera) error) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if len(text) == 0 || text[0] == '@' {
+			continue
+		}
+		if n++; n <= since {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 11 {
+			return n, fmt.Errorf("htsdb: SAM line %d: expected at least 11 columns", line)
+		}
+		flag, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return n, fmt.Errorf("htsdb: SAM line %d: %v", line, err)
+		}
+		if flag&(0x4|0x100|0x800) != 0 {
+			continue
+		}
+		var sa string
+		for _, tag := range fields[11:] {
+			if strings.HasPrefix(tag, "SA:Z:") {
+				sa = tag[5:]
+			}
+		}
+		if sa == "" {
+			continue
+		}
+		pos, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return n, fmt.Errorf("htsdb: SAM line %d: %v", line, err)
+		}
+		primary, err := NewSegment(fields[2], pos, int(FlagStrand(flag)), fields[5])
+		if err != nil {
+			return n, fmt.Errorf("htsdb: SAM line %d: %v", line, err)
+		}
+		others, err := ParseSATag(sa)
+		if err != nil {
+			return n, fmt.Errorf("htsdb: SAM line %d: %v", line, err)
+		}
+		for _, c := range NewChimeras(fields[0], append(others, primary), 1) {
+			if err := fn(n, c); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, sc.Err()
+}
+
+// CreateChimeraTable creates the chimera table in db if it does not exist.
+func CreateChimeraTable(db *sqlx.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + ChimeraTable +
+		" (qname TEXT, rname1 TEXT, start1 INTEGER, stop1 INTEGER," +
+		" strand1 INTEGER, junction1 INTEGER, rname2 TEXT, start2 INTEGER," +
+		" stop2 INTEGER, strand2 INTEGER, junction2 INTEGER, copy_number INTEGER)")
+	return err
+}
+
+// InsertChimeraStmt prepares the statement that inserts a Chimera in the
+// chimera table of db with tx.
+func InsertChimeraStmt(tx *sqlx.Tx) (*sqlx.NamedStmt, error) {
+	return tx.PrepareNamed("INSERT INTO " + ChimeraTable +
+		" (qname, rname1, start1, stop1, strand1, junction1, rname2, start2," +
+		" stop2, strand2, junction2, copy_number) VALUES (:qname, :rname1," +
+		" :start1, :stop1, :strand1, :junction1, :rname2, :start2, :stop2," +
+		" :strand2, :junction2, :copy_number)")
+}
+
+// ChimeraInserter inserts chimeras in the chimera table applying a
+// DupPolicy to chimeras that are identical to a stored one except for their
+// copy number. Duplicates are looked up by read name so the read names of the
+// chimera table should be indexed with CreateQnameIndex unless the policy is
+// DupKeep. Stored duplicates are updated by rowid so policies other than
+// DupKeep require a SQLite database.
+type ChimeraInserter struct {
+	Policy DupPolicy
+	// Inserted, Skipped and Summed count the chimeras that were inserted,
+	// skipped or added to a stored chimera.
+	Inserted, Skipped, Summed int
+
+	insert, find *sqlx.NamedStmt
+	sum          *sqlx.Stmt
+}
+
+// Begin prepares the statements of ins on tx. It must be called before Insert
+// for each new transaction e.g. when an import is committed in batches.
+func (ins *ChimeraInserter) Begin(tx *sqlx.Tx) error {
+	var err error
+	if ins.insert, err = InsertChimeraStmt(tx); err != nil {
+		return err
+	}
+	if ins.Policy == DupKeep {
+		return nil
+	}
+	if err = requireSQLite(tx, "policy "+ins.Policy.String()); err != nil {
+		return err
+	}
+	ins.find, err = tx.PrepareNamed("SELECT rowid FROM " + ChimeraTable +
+		" WHERE qname = :qname AND rname1 = :rname1 AND start1 = :start1" +
+		" AND stop1 = :stop1 AND strand1 = :strand1 AND junction1 = :junction1" +
+		" AND rname2 = :rname2 AND start2 = :start2 AND stop2 = :stop2" +
+		" AND strand2 = :strand2 AND junction2 = :junction2 LIMIT 1")
+	if err != nil {
+		return err
+	}
+	ins.sum, err = tx.Preparex(tx.Rebind("UPDATE " + ChimeraTable +
+		" SET copy_number = copy_number + ? WHERE rowid = ?"))
+	return err
+}
+
+// Insert inserts c, whose coordinates must follow the convention of the
+// database, according to the policy of ins.
+func (ins *ChimeraInserter) Insert(c Chimera) error {
+	if ins.Policy != DupKeep {
+		var rowid int64
+		err := ins.find.Get(&rowid, c)
+		if err == nil {
+			switch ins.Policy {
+			case DupSkip:
+				ins.Skipped++
+				return nil
+			case DupSum:
+				ins.Summed++
+				_, err = ins.sum.Exec(c.CopyNumber, rowid)
+				return err
+			}
+			return fmt.Errorf("htsdb: duplicate chimera of read %s", c.Qname)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	ins.Inserted++
+	_, err := ins.insert.Exec(c)
+	return err
+}
+
+// FromHtsdb returns ch with coordinates converted from HtsdbCoords to c.
+// Junctions are converted as single base positions.
+func (ch Chimera) FromHtsdb(c Coords) Chimera {
+	ch.Start1, ch.Stop1 = c.FromHtsdb(ch.Start1, ch.Stop1)
+	ch.Start2, ch.Stop2 = c.FromHtsdb(ch.Start2, ch.Stop2)
+	ch.Junction1 += c.Base
+	ch.Junction2 += c.Base
+	return ch
+}
+
+// ToHtsdb returns ch with coordinates converted from c to HtsdbCoords.
+func (ch Chimera) ToHtsdb(c Coords) Chimera {
+	ch.Start1, ch.Stop1 = c.ToHtsdb(ch.Start1, ch.Stop1)
+	ch.Start2, ch.Stop2 = c.ToHtsdb(ch.Start2, ch.Stop2)
+	ch.Junction1 -= c.Base
+	ch.Junction2 -= c.Base
+	return ch
+}
@@ -0,0 +1,141 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestParseCigar(t *testing.T) {
+	tests := []struct {
+		cigar              string
+		refLen, lead, tail int
+	}{
+		{"50M", 50, 0, 0},
+		{"5H10S20M2I3D10M15S", 33, 15, 15},
+		{"20M5N20M", 45, 0, 0},
+	}
+	for _, tt := range tests {
+		refLen, lead, tail, err := ParseCigar(tt.cigar)
+		if err != nil || refLen != tt.refLen || lead != tt.lead || tail != tt.tail {
+			t.Errorf("ParseCigar(%q): got %d, %d, %d, %v", tt.cigar, refLen, lead, tail, err)
+		}
+	}
+	for _, cigar := range []string{"M", "10", "10Z"} {
+		if _, _, _, err := ParseCigar(cigar); err == nil {
+			t.Errorf("ParseCigar(%q): expected error", cigar)
+		}
+	}
+}
+
+func TestReadSAMChimeras(t *testing.T) {
+	sam := "@HD\tVN:1.6\n" +
+		"r1\t0\tchr1\t101\t60\t20M30S\t*\t0\t0\t*\t*\tSA:Z:chr2,501,-,30M20S,60,0;\n" +
+		"r1\t2064\tchr2\t501\t60\t30M20H\t*\t0\t0\t*\t*\tSA:Z:chr1,101,+,20M30S,60,0;\n" +
+		"r2\t0\tchr1\t101\t60\t50M\t*\t0\t0\t*\t*\n"
+	var chims []Chimera
+	err := ReadSAMChimeras(strings.NewReader(sam), func(c Chimera) error {
+		chims = append(chims, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chims) != 1 {
+		t.Fatalf("expected 1 chimera, got %d", len(chims))
+	}
+	// the 3' end of the read aligns on the reverse strand of chr2 so the
+	// junction is at the end of both segments.
+	expected := Chimera{Qname: "r1",
+		Rname1: "chr1", Start1: 100, Stop1: 119, Strand1: 1, Junction1: 119,
+		Rname2: "chr2", Start2: 500, Stop2: 529, Strand2: -1, Junction2: 529,
+		CopyNumber: 1}
+	if chims[0] != expected {
+		t.Errorf("expected %+v, actual %+v", expected, chims[0])
+	}
+}
+
+func TestScanSAMChimerasSince(t *testing.T) {
+	sam := "@HD\tVN:1.6\n" +
+		"r1\t0\tchr1\t101\t60\t20M30S\t*\t0\t0\t*\t*\tSA:Z:chr2,501,-,30M20S,60,0;\n" +
+		"r2\t0\tchr1\t101\t60\t50M\t*\t0\t0\t*\t*\n" +
+		"r3\t0\tchr1\t201\t60\t20M30S\t*\t0\t0\t*\t*\tSA:Z:chr2,501,-,30M20S,60,0;\n"
+	var recs []int
+	n, err := ScanSAMChimeras(strings.NewReader(sam), 1, func(n int, c Chimera) error {
+		if c.Qname != "r3" {
+			t.Errorf("unexpected chimera of %s", c.Qname)
+		}
+		recs = append(recs, n)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(recs) != 1 || recs[0] != 3 {
+		t.Errorf("expected 3 records and chimera of record 3, got %d, %v", n, recs)
+	}
+}
+
+func TestChimeraInserter(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	if err := CreateChimeraTable(db); err != nil {
+		t.Fatal(err)
+	}
+	c := Chimera{Qname: "r1", Rname1: "chr1", Start1: 10, Stop1: 20, Strand1: 1,
+		Rname2: "chr2", Start2: 30, Stop2: 40, Strand2: -1, CopyNumber: 2}
+	tests := []struct {
+		policy DupPolicy
+		rows   int
+		copies int
+	}{
+		{DupKeep, 2, 4},
+		{DupSkip, 1, 2},
+		{DupSum, 1, 4},
+	}
+	for _, tt := range tests {
+		if _, err := db.Exec("DELETE FROM " + ChimeraTable); err != nil {
+			t.Fatal(err)
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins := &ChimeraInserter{Policy: tt.policy}
+		if err = ins.Begin(tx); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = ins.Insert(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		var rows, copies int
+		err = db.QueryRow("SELECT COUNT(*), TOTAL(copy_number) FROM "+
+			ChimeraTable).Scan(&rows, &copies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows != tt.rows || copies != tt.copies {
+			t.Errorf("%s: expected %d rows and %d copies, actual %d and %d",
+				tt.policy, tt.rows, tt.copies, rows, copies)
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	ins := &ChimeraInserter{Policy: DupError}
+	if err = ins.Begin(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = ins.Insert(c); err == nil {
+		t.Error("expected error for duplicate chimera")
+	}
+}
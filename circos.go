@@ -0,0 +1,69 @@
+package htsdb
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// Bin is the value of a fixed size genomic bin. Bin is the index of the bin on
+// reference Rname.
+type Bin struct {
+	Rname string  `db:"rname"`
+	Bin   int     `db:"bin"`
+	Value float64 `db:"value"`
+}
+
+// BinCountBuilder returns a squirrel select builder whose structure matches
+// that of Bin and that counts the records starting in each bin of size bases.
+// Records are weighted by their copy number if copies is true. c is the
+// coordinate convention of the database. Bins are sorted by reference and
+// index.
+func BinCountBuilder(c Coords, size int, copies bool) squirrel.SelectBuilder {
+	value := RecordCount
+	if copies {
+		value = CopyNumberTotal
+	}
+	bin := "(start - " + strconv.Itoa(c.Base) + ") / " + strconv.Itoa(size)
+	return squirrel.Select("rname").
+		Column(squirrel.Alias(squirrel.Expr(bin), "bin")).
+		Column(squirrel.Alias(squirrel.Expr(value), "value")).
+		GroupBy("rname", "bin").
+		OrderBy("rname", "bin")
+}
+
+// WriteCircosPlot writes bins of size bases to w as a circos plot data file
+// with one "chr start end value" line per bin. Coordinates are 0-based and
+// inclusive and values are formatted with f. The last bin of a reference is
+// clipped at its length in lens, if present.
+func WriteCircosPlot(w io.Writer, bins []Bin, size int, lens map[string]int, f FloatFormat) error {
+	bw := bufio.NewWriter(w)
+	for _, b := range bins {
+		start, end := b.Bin*size, (b.Bin+1)*size
+		if l, ok := lens[b.Rname]; ok && end > l && l > start {
+			end = l
+		}
+		_, err := bw.WriteString(b.Rname + " " + strconv.Itoa(start) + " " +
+			strconv.Itoa(end-1) + " " + f.Format(b.Value) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteCircosRegions is like WriteCircosPlot for regions of arbitrary size,
+// e.g. Tiles, with values in the same order.
+func WriteCircosRegions(w io.Writer, regions []Region, values []float64, f FloatFormat) error {
+	bw := bufio.NewWriter(w)
+	for i, r := range regions {
+		_, err := bw.WriteString(r.Rname + " " + strconv.Itoa(r.Start) + " " +
+			strconv.Itoa(r.Stop) + " " + f.Format(values[i]) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
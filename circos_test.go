@@ -0,0 +1,31 @@
+package htsdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCircosPlot(t *testing.T) {
+	bins := []Bin{{"chr1", 0, 3}, {"chr1", 2, 1.5}, {"chr2", 0, 1}}
+	var buf bytes.Buffer
+	err := WriteCircosPlot(&buf, bins, 10, map[string]int{"chr1": 25}, FloatFormat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "chr1 0 9 3\nchr1 20 24 1.5\nchr2 0 9 1\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("expected %q, actual %q", expected, got)
+	}
+}
+
+func TestWriteCircosRegions(t *testing.T) {
+	regs := []Region{{Rname: "chr1", Start: 0, Stop: 99}, {Rname: "chr1", Start: 100, Stop: 149}}
+	var buf bytes.Buffer
+	if err := WriteCircosRegions(&buf, regs, []float64{2, 0.5}, FloatFormat{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "chr1 0 99 2\nchr1 100 149 0.5\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("expected %q, actual %q", expected, got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-adapter-scan"
+const version = "0.7"
+const descr = `Scan stored read sequences for adapter sequences and report the
+number and fraction of reads that contain each adapter. Adapters that run past
+the 3' end of a read are detected if they overlap it by the minimum overlap.
+With --distro the distribution of the adapter start position in the reads is
+printed instead. Provided SQL filter will apply to all counts.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	seqCol = app.Flag("seq-column", "Column with the read sequence.").
+		Default("seq").String()
+	adapters = app.Flag("adapter", "Adapter sequence to scan for; can be repeated.").
+			PlaceHolder("<seq>").Required().Strings()
+	mismatch = app.Flag("mismatches", "Maximum number of mismatches.").
+			Default("1").Int()
+	minOverlap = app.Flag("min-overlap", "Minimum overlap of adapters at the 3' end of reads.").
+			Default("6").Int()
+	distro = app.Flag("distro", "Print the distribution of adapter start positions.").
+		Bool()
+	header = app.Flag("header", "Print header line.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+type adapterCount struct {
+	reads, copies int
+	// pos holds the read and copy counts for each adapter start position.
+	pos map[int][2]int
+}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+	table := cols.Table(*tab)
+
+	// assemble sqlx select builders
+	readsB := squirrel.Select().
+		Column(squirrel.Alias(squirrel.Expr(*seqCol), "seq")).
+		Column("copy_number").From(table)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+
+	// restrict to regions and exclude blacklisted regions.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		readsB = readsB.Where(regsFilter)
+	}
+	query, _, err := readsB.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// scan sequences.
+	counts := make([]adapterCount, len(*adapters))
+	for i := range counts {
+		counts[i].pos = make(map[int][2]int)
+	}
+	var total, totalCopies int
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq sql.NullString
+		var copyNum int
+		if err = rows.Scan(&seq, &copyNum); err != nil {
+			log.Fatal(err)
+		}
+		total++
+		totalCopies += copyNum
+		for i, a := range *adapters {
+			p := htsdb.FindAdapter(seq.String, a, *mismatch, *minOverlap)
+			if p < 0 {
+				continue
+			}
+			c := &counts[i]
+			c.reads++
+			c.copies += copyNum
+			v := c.pos[p]
+			c.pos[p] = [2]int{v[0] + 1, v[1] + copyNum}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("reads:%d, copies:%d\n", total, totalCopies)
+	}
+
+	// print results.
+	if *distro == true {
+		if *header == true {
+			fmt.Printf("adapter\tpos\tcount\tcopyNumber\n")
+		}
+		for i, a := range *adapters {
+			maxPos := -1
+			for p := range counts[i].pos {
+				if p > maxPos {
+					maxPos = p
+				}
+			}
+			for p := 0; p <= maxPos; p++ {
+				v := counts[i].pos[p]
+				fmt.Printf("%s\t%d\t%d\t%d\n", a, p, v[0], v[1])
+			}
+		}
+		return
+	}
+	if *header == true {
+		fmt.Printf("adapter\treads\tcopies\tpercent_reads\tpercent_copies\n")
+	}
+	for i, a := range *adapters {
+		c := counts[i]
+		fmt.Printf("%s\t%d\t%d\t%.2f\t%.2f\n", a, c.reads, c.copies,
+			percent(c.reads, total), percent(c.copies, totalCopies))
+	}
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
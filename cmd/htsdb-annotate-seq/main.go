@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-annotate-seq"
+const version = "0.5"
+const descr = `Compute per-read sequence annotations and store them in new
+columns of the database table: the GC fraction (gc), the length of the
+longest homopolymer (max_homopolymer) and the DUST low-complexity score (dust)
+used by --mask-low-complexity of the counting commands. Stored annotations allow fast SQL
+filters and bias analyses without rescanning sequences. Existing annotation
+columns are recomputed. Only SQLite databases are supported since records are
+updated by rowid.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	seqCol = app.Flag("seq-column", "Column with the read sequence.").
+		Default("seq").String()
+	gcCol = app.Flag("gc-column", "Column to store the GC fraction; empty to skip.").
+		Default(htsdb.GCColumn).String()
+	homoCol = app.Flag("homopolymer-column", "Column to store the longest homopolymer length; empty to skip.").
+		Default(htsdb.HomopolymerColumn).String()
+	dustCol = app.Flag("dust-column", "Column to store the DUST low-complexity score; empty to skip.").
+		Default(htsdb.DustColumn).String()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *gcCol == "" && *homoCol == "" && *dustCol == "" {
+		kingpin.Fatalf("nothing to annotate")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := sqlx.Connect("sqlite3", *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+
+	// add missing columns.
+	for col, typ := range map[string]string{*gcCol: "REAL", *homoCol: "INTEGER", *dustCol: "REAL"} {
+		if col == "" {
+			continue
+		}
+		ok, err := htsdb.HasColumn(db, *tab, col)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ok {
+			continue
+		}
+		if _, err = db.Exec("ALTER TABLE " + *tab + " ADD COLUMN " + col + " " + typ); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// store annotations while streaming sequences.
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	set := ""
+	if *gcCol != "" {
+		set += *gcCol + " = :gc"
+	}
+	if *homoCol != "" {
+		if set != "" {
+			set += ", "
+		}
+		set += *homoCol + " = :homo"
+	}
+	if *dustCol != "" {
+		if set != "" {
+			set += ", "
+		}
+		set += *dustCol + " = :dust"
+	}
+	stmt, err := tx.PrepareNamed("UPDATE " + *tab + " SET " + set + " WHERE rowid = :id")
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := tx.QueryxContext(ctx, "SELECT rowid, "+*seqCol+" FROM "+*tab)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cnt int
+	for rows.Next() {
+		var id int64
+		var seq sql.NullString
+		if err = rows.Scan(&id, &seq); err != nil {
+			log.Fatal(err)
+		}
+		vals := map[string]interface{}{"id": id, "gc": nil, "homo": nil, "dust": nil}
+		if seq.Valid && seq.String != "*" {
+			vals["gc"] = htsdb.GCFraction(seq.String)
+			vals["homo"] = htsdb.MaxHomopolymer(seq.String)
+			vals["dust"] = htsdb.DustScore(seq.String)
+		}
+		if _, err = stmt.ExecContext(ctx, vals); err != nil {
+			tx.Rollback()
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "no changes were made")
+			}
+			log.Fatal(err)
+		}
+		cnt++
+	}
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "no changes were made")
+		}
+		log.Fatal(err)
+	}
+	rows.Close()
+	if err = tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "no changes were made")
+		}
+		log.Fatal(err)
+	}
+
+	if *verbose == true {
+		log.Printf("annotated:%d\n", cnt)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-anti-join"
+const version = "0.5"
+const descr = `Select the records that are not contained in, or do not overlap,
+any feature of a BED or GTF file; the complement of htsdb-count-reads-on-feats.
+Records are printed as tab separated values with a header line or written to a
+new SQLite database with the same table schema, which can only be copied from
+SQLite and DuckDB databases. Provided SQL filter will apply to all records.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	featsFile = app.Flag("feats", "BED or GTF (.gtf) file with features.").
+			PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. exon.").
+			PlaceHolder("<type>").String()
+	mode = app.Flag("mode", "Exclude records contained in or overlapping a feature.").
+		Default("overlap").Enum("contained", "overlap")
+	refMap = app.Flag("ref-map", "Rename feature references to ucsc or ensembl style or use mapping file.").
+		PlaceHolder("<ucsc|ensembl|file>").String()
+	outFile = app.Flag("out", "File to new SQLite database to write records to.").
+		PlaceHolder("<file>").String()
+	noSync = app.Flag("no-sync", "Disable synchronous writes to the new database for speed; a crash may corrupt it.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	rename, err := htsdb.RefRenamer(*refMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read features.
+	regions, err := htsdb.ReadFeatureRegions(*featsFile, *featType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range regions {
+		regions[i].Rname = rename(regions[i].Rname)
+	}
+	idx := htsdb.NewRegionIndex(regions)
+	excluded := idx.Overlaps
+	if *mode == "contained" {
+		excluded = idx.Contains
+	}
+
+	// restrict to regions and exclude blacklisted regions.
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsIdx, blIdx := htsdb.NewRegionIndex(regs), htsdb.NewRegionIndex(bl)
+	skip := func(rname string, start, stop int) bool {
+		return (len(regs) > 0 && !regsIdx.Overlaps(rname, start, stop)) ||
+			blIdx.Overlaps(rname, start, stop)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// select records from the original table so that they can be copied.
+	readsB := squirrel.Select("*").From(*tab)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+	query, _, err := readsB.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	// locate the coordinate columns.
+	names, err := rows.Columns()
+	if err != nil {
+		log.Fatal(err)
+	}
+	colIdx := make(map[string]int)
+	for i, c := range names {
+		colIdx[c] = i
+	}
+	var rnameIdx, startIdx, stopIdx int
+	for _, c := range []struct {
+		name string
+		idx  *int
+	}{{"rname", &rnameIdx}, {"start", &startIdx}, {"stop", &stopIdx}} {
+		i, ok := colIdx[cols.Column(c.name)]
+		if !ok {
+			log.Fatalf("table %s has no column %s", *tab, cols.Column(c.name))
+		}
+		*c.idx = i
+	}
+
+	// open output.
+	var write func([]interface{}) error
+	var commit func() error
+	if *outFile != "" {
+		out, err := htsdb.Connect(htsdb.SQLite, *outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		schema, err := htsdb.TableSQL(db, *tab)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = htsdb.InitCoords(out, coords); err != nil {
+			log.Fatal(err)
+		}
+		if _, err = out.Exec(schema); err != nil {
+			log.Fatal(err)
+		}
+		opts := htsdb.DefaultLoadOptions
+		opts.Unsafe = *noSync
+		loader, err := htsdb.NewLoader(out, *tab, names, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		indexes, err := htsdb.IndexSQL(db, *tab)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, stmt := range indexes {
+			loader.DeferIndex(stmt)
+		}
+		write = func(vals []interface{}) error {
+			return loader.Add(vals...)
+		}
+		commit = loader.Close
+	} else {
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		fmt.Fprintln(w, strings.Join(names, "\t"))
+		strs := make([]string, len(names))
+		write = func(vals []interface{}) error {
+			for i, v := range vals {
+				strs[i] = toString(v)
+			}
+			_, err := fmt.Fprintln(w, strings.Join(strs, "\t"))
+			return err
+		}
+		commit = w.Flush
+	}
+
+	// filter records.
+	var kept, dropped int
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rname := toString(vals[rnameIdx])
+		start, stop := coords.ToHtsdb(toInt(vals[startIdx]), toInt(vals[stopIdx]))
+		if skip(rname, start, stop) {
+			continue
+		}
+		if excluded(rname, start, stop) {
+			dropped++
+			continue
+		}
+		if err = write(vals); err != nil {
+			log.Fatal(err)
+		}
+		kept++
+	}
+	if err = rows.Err(); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+	if err = commit(); err != nil {
+		log.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		cli.Interrupted(stop, "output is partial")
+	}
+
+	if *verbose == true {
+		log.Printf("kept:%d, excluded:%d\n", kept, dropped)
+	}
+}
+
+func toInt(v interface{}) int {
+	switch t := v.(type) {
+	case int64:
+		return int(t)
+	case float64:
+		return int(t)
+	case []byte:
+		i, _ := strconv.Atoi(string(t))
+		return i
+	case string:
+		i, _ := strconv.Atoi(t)
+		return i
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
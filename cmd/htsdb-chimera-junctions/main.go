@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-chimera-junctions"
+const version = "0.7"
+const descr = `Summarize recurrent junction pairs of chimeric reads stored in the
+chimera table. Chimeras are grouped by the reference, strand and junction
+position of their two segments; junction positions can be rounded to a
+resolution to group nearby junctions. Pairs are printed by decreasing number
+of reads, as TSV or as a circos links file.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	where = app.Flag("where", "SQL filter to inject in WHERE clause of the chimera table.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded of the chimera table.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	resolution = app.Flag("resolution", "Round junction positions down to bins of this many bases.").
+			Default("1").Int()
+	minCount = app.Flag("min-count", "Minimum number of reads of a reported junction pair.").
+			Default("2").Int()
+	format = app.Flag("format", "Output format.").
+		Default("tsv").Enum("tsv", "circos")
+	header = app.Flag("header", "Print header line for TSV output.").
+		Bool()
+	long = app.Flag("long", "Print one value per row after the junction columns (long format) for TSV output.").
+		Bool()
+)
+
+var common cli.Options
+
+// Pair is a recurrent junction pair.
+type Pair struct {
+	Rname1  string `db:"rname1"`
+	Strand1 int    `db:"strand1"`
+	Bin1    int    `db:"bin1"`
+	Rname2  string `db:"rname2"`
+	Strand2 int    `db:"strand2"`
+	Bin2    int    `db:"bin2"`
+	Count   int    `db:"count"`
+	CopyNum int    `db:"copyNum"`
+}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *resolution < 1 {
+		kingpin.Fatalf("--resolution must be positive")
+	}
+	if *long == true && *format != "tsv" {
+		kingpin.Fatalf("--long requires TSV output")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	ok, err := htsdb.TableExists(db, htsdb.ChimeraTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		log.Fatalf("no %s table; import chimeras with htsdb-import-chimeras",
+			htsdb.ChimeraTable)
+	}
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// group chimeras by junction pair.
+	base, res := strconv.Itoa(coords.Base), strconv.Itoa(*resolution)
+	b := squirrel.Select("rname1", "strand1").
+		Column(squirrel.Alias(squirrel.Expr("(junction1 - "+base+") / "+res), "bin1")).
+		Column("rname2").Column("strand2").
+		Column(squirrel.Alias(squirrel.Expr("(junction2 - "+base+") / "+res), "bin2")).
+		Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
+		Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum")).
+		From(htsdb.ChimeraTable).
+		GroupBy("rname1", "strand1", "bin1", "rname2", "strand2", "bin2").
+		Having("COUNT(*) >= "+strconv.Itoa(*minCount)).
+		OrderBy("count DESC", "rname1", "bin1", "rname2", "bin2")
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.ChimeraFilter(coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var pairs []Pair
+	if err = db.SelectContext(ctx, &pairs, query); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+
+	// print junction pairs in htsdb coordinates.
+	bw := bufio.NewWriter(os.Stdout)
+	defer bw.Flush()
+	w := htsdb.NewResultWriter(bw, []string{"rname1", "strand1", "junction1",
+		"rname2", "strand2", "junction2"}, []string{"count", "copyNumber"}, *long)
+	defer w.Flush()
+	if *header == true && *format == "tsv" {
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	size := *resolution
+	for _, p := range pairs {
+		j1, j2 := p.Bin1*size, p.Bin2*size
+		if *format == "circos" {
+			fmt.Fprintf(bw, "%s %d %d %s %d %d\n", p.Rname1, j1, j1+size-1,
+				p.Rname2, j2, j2+size-1)
+			continue
+		}
+		err = w.WriteRow(p.Rname1, strandString(p.Strand1), j1,
+			p.Rname2, strandString(p.Strand2), j2, p.Count, p.CopyNum)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func strandString(s int) string {
+	if s == -1 {
+		return "-"
+	}
+	return "+"
+}
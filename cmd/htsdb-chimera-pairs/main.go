@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-chimera-pairs"
+const version = "0.6"
+const descr = `Match the two arms of chimeric reads in the chimera table against
+two annotation sets e.g. miRNAs and their targets and print the number of
+chimeras supporting each interacting pair. An arm matches a feature it
+overlaps. By default the 5' arm is matched against the first set and the 3' arm
+against the second; with --any-order chimeras are also matched the other way
+round, as ligation can join the partners in either order. Annotation files are
+BED or GTF (.gtf).`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database with a chimera table.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	where = app.Flag("where", "SQL filter to inject in WHERE clause of the chimera table.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded of the chimera table.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	feats1 = app.Flag("feats1", "BED or GTF file with the features of the first partner e.g. miRNAs.").
+		PlaceHolder("<file>").Required().String()
+	feats2 = app.Flag("feats2", "BED or GTF file with the features of the second partner e.g. targets.").
+		PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. exon.").
+			PlaceHolder("<type>").String()
+	nameAttr = app.Flag("name-attr", "GTF attribute used as feature name.").
+			Default("gene_name").String()
+	refMap = app.Flag("ref-map", "Rename feature references to ucsc or ensembl style or use mapping file.").
+		PlaceHolder("<ucsc|ensembl|file>").String()
+	stranded = app.Flag("stranded", "Require arms to be on the strand of the features.").
+			Bool()
+	anyOrder = app.Flag("any-order", "Also match the 5' arm against the second and the 3' arm against the first set.").
+			Bool()
+	minCount = app.Flag("min-count", "Minimum number of chimeras of a reported pair.").
+			Default("1").Int()
+	header = app.Flag("header", "Print header line.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+type pair struct {
+	name1, name2 string
+}
+
+type count struct {
+	chimeras, copyNum int
+}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// get reference renaming function.
+	rename, err := htsdb.RefRenamer(*refMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read and index annotations.
+	idx1, err := readIndex(*feats1, rename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idx2, err := readIndex(*feats2, rename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// match chimera arms.
+	b := htsdb.ChimeraBuilder
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.ChimeraFilter(coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	counts := make(map[pair]*count)
+	total, matched := 0, 0
+	for rows.Next() {
+		var c htsdb.Chimera
+		if err = rows.StructScan(&c); err != nil {
+			log.Fatal(err)
+		}
+		c = c.ToHtsdb(coords)
+		total++
+		pairs := match(c, idx1, idx2, false)
+		if *anyOrder == true {
+			for p := range match(c, idx2, idx1, true) {
+				pairs[p] = true
+			}
+		}
+		if len(pairs) > 0 {
+			matched++
+		}
+		for p := range pairs {
+			cnt, ok := counts[p]
+			if !ok {
+				cnt = &count{}
+				counts[p] = cnt
+			}
+			cnt.chimeras++
+			cnt.copyNum += c.CopyNumber
+		}
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("chimeras:%d, matched:%d\n", total, matched)
+	}
+
+	// print pairs by decreasing number of chimeras.
+	keys := make([]pair, 0, len(counts))
+	for p := range counts {
+		keys = append(keys, p)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := counts[keys[i]], counts[keys[j]]
+		if ci.chimeras != cj.chimeras {
+			return ci.chimeras > cj.chimeras
+		}
+		if keys[i].name1 != keys[j].name1 {
+			return keys[i].name1 < keys[j].name1
+		}
+		return keys[i].name2 < keys[j].name2
+	})
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if *header == true {
+		fmt.Fprintf(w, "name1\tname2\tchimeras\tcopyNumber\n")
+	}
+	for _, p := range keys {
+		c := counts[p]
+		if c.chimeras < *minCount {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", p.name1, p.name2, c.chimeras, c.copyNum)
+	}
+}
+
+// readIndex reads the annotations of f with references renamed by rename.
+func readIndex(f string, rename func(string) string) (htsdb.AnnotationIndex, error) {
+	anns, err := htsdb.ReadAnnotations(f, *featType, *nameAttr)
+	if err != nil {
+		return htsdb.AnnotationIndex{}, err
+	}
+	for i := range anns {
+		anns[i].Rname = rename(anns[i].Rname)
+		if anns[i].Name == "" {
+			anns[i].Name = fmt.Sprintf("%s:%d-%d", anns[i].Rname, anns[i].Start,
+				anns[i].Stop)
+		}
+	}
+	return htsdb.NewAnnotationIndex(anns), nil
+}
+
+// match returns the pairs of features of idx5 and idx3 overlapped by the 5'
+// and 3' arm of c respectively. If swapped is true, pairs list the feature of
+// idx3 first.
+func match(c htsdb.Chimera, idx5, idx3 htsdb.AnnotationIndex, swapped bool) map[pair]bool {
+	strand1, strand2 := 0, 0
+	if *stranded == true {
+		strand1, strand2 = c.Strand1, c.Strand2
+	}
+	pairs := make(map[pair]bool)
+	f5 := idx5.Overlapping(c.Rname1, c.Start1, c.Stop1, strand1)
+	if len(f5) == 0 {
+		return pairs
+	}
+	f3 := idx3.Overlapping(c.Rname2, c.Start2, c.Stop2, strand2)
+	for _, a := range f5 {
+		for _, b := range f3 {
+			if swapped {
+				pairs[pair{b.Name, a.Name}] = true
+			} else {
+				pairs[pair{a.Name, b.Name}] = true
+			}
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/aggregate"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-co-occurrence"
+const version = "0.9"
+const descr = `Measure the positional co-occurrence of read classes. Reads are
+classified by an SQL column or expression e.g. LENGTH(sequence),
+SUBSTR(sequence, 1, 1) or an annotation column, and all pairs of reads whose
+anchor positions (5' ends by default) are within a window on the same
+reference, and strand with --ori, are counted by the classes of the two reads.
+The class by class matrix of observed over expected pairs is printed, where
+expected pairs assume that classes are independent of position; values above
+1 indicate classes that occur near each other more often than expected.
+Provided SQL filter will apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	class = app.Flag("class", "SQL column or expression that classifies reads.").
+		PlaceHolder("<SQL>").Required().String()
+	window = app.Flag("window", "Maximum distance of the anchors of co-occurring reads.").
+		Default("50").Int()
+	anchor = app.Flag("anchor", "Read position used for distances.").
+		Default("5p").Enum("5p", "3p", "mid")
+	useOri = app.Flag("ori", "Only pair reads on the same strand.").
+		Bool()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	stat = app.Flag("stat", "Statistic to print.").
+		Default("enrichment").Enum("enrichment", "observed", "expected")
+	long = app.Flag("long", "Print one value per row for each pair of classes (long format) instead of the matrix.").
+		Bool()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("4").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *window < 0 {
+		kingpin.Fatalf("--window must not be negative")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble sqlx select builders; reads of the same class at the same
+	// position are counted together.
+	n := "COUNT(*)"
+	if *copyNum == true {
+		n = htsdb.CopyNumberSum
+	}
+	b := squirrel.Select("rname", "strand").
+		Column(squirrel.Alias(squirrel.Expr(anchorExpr(*anchor, coords)), "pos")).
+		Column(squirrel.Alias(squirrel.Expr("COALESCE(CAST(("+*class+") AS TEXT), 'NA')"), "class")).
+		Column(squirrel.Alias(squirrel.Expr(n), "n")).
+		From(table).
+		GroupBy("rname", "strand", "pos", "class")
+	if *useOri == true {
+		b = b.OrderBy("rname", "strand", "pos")
+	} else {
+		b = b.OrderBy("rname", "pos")
+	}
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// count co-occurring pairs.
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	co := aggregate.NewCoOccurrence(*window)
+	var r struct {
+		Rname  string `db:"rname"`
+		Strand int    `db:"strand"`
+		Pos    int    `db:"pos"`
+		Class  string `db:"class"`
+		N      int    `db:"n"`
+	}
+	var groups int
+	for rows.Next() {
+		if err = rows.StructScan(&r); err != nil {
+			log.Fatal(err)
+		}
+		group := r.Rname
+		if *useOri == true {
+			group += "\t" + strconv.Itoa(r.Strand)
+		}
+		co.Add(group, r.Pos, r.Class, r.N)
+		groups++
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	rows.Close()
+	if *verbose == true {
+		log.Printf("positions:%d\n", groups)
+	}
+
+	// print class by class matrix.
+	classes := co.Classes()
+	if *stat == "observed" {
+		ff = htsdb.FloatFormat{Notation: 'f', Precision: 0}
+	}
+	value := func(a, c string) float64 {
+		switch *stat {
+		case "observed":
+			return co.Observed(a, c)
+		case "expected":
+			return co.Expected(a, c)
+		}
+		return co.Enrichment(a, c)
+	}
+	if *long == true {
+		w := htsdb.NewResultWriter(os.Stdout, []string{"class1", "class2"},
+			[]string{*stat}, true)
+		w.Format = ff
+		defer w.Flush()
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
+		for _, a := range classes {
+			for _, c := range classes {
+				if err = w.WriteRow(a, c, value(a, c)); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		return
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "class")
+	for _, c := range classes {
+		fmt.Fprintf(w, "\t%s", c)
+	}
+	fmt.Fprintf(w, "\n")
+	for _, a := range classes {
+		fmt.Fprintf(w, "%s", a)
+		for _, c := range classes {
+			fmt.Fprintf(w, "\t%s", ff.Format(value(a, c)))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// anchorExpr returns an SQL expression for the anchor of records under
+// coordinate convention c. Only differences between anchors are used so the
+// expression is in the coordinates of the database.
+func anchorExpr(anchor string, c htsdb.Coords) string {
+	stop := "stop"
+	if c.HalfOpen {
+		stop = "(stop - 1)"
+	}
+	switch anchor {
+	case "3p":
+		return "CASE WHEN strand = -1 THEN start ELSE " + stop + " END"
+	case "mid":
+		// DuckDB divides integers exactly so the sum is made even first.
+		return "(start + " + stop + " - (start + " + stop + ") % 2) / 2"
+	}
+	return "CASE WHEN strand = -1 THEN " + stop + " ELSE start END"
+}
@@ -2,53 +2,145 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log"
+	"math/rand"
 	"os"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/biogo/biogo/feat"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/bed"
 	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/normalize"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 // CountBuilder is a squirrel select builder whose columns match Count fields.
 var CountBuilder = squirrel.Select().
 	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
-	Column(squirrel.Alias(squirrel.Expr("CAST(TOTAL(copy_number) AS INTEGER)"), "copyNum"))
+	Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum")).
+	Column(squirrel.Alias(squirrel.Expr("COALESCE(SUM(CASE WHEN strand = 1 THEN 1e0 END), 0)"), "plus")).
+	Column(squirrel.Alias(squirrel.Expr("COALESCE(SUM(CASE WHEN strand = -1 THEN 1e0 END), 0)"), "minus")).
+	Column(squirrel.Alias(squirrel.Expr("COALESCE(SUM(CASE WHEN strand = 1 THEN 1e0 * copy_number END), 0)"), "plusCopyNum")).
+	Column(squirrel.Alias(squirrel.Expr("COALESCE(SUM(CASE WHEN strand = -1 THEN 1e0 * copy_number END), 0)"), "minusCopyNum"))
 
-// Count is a databases row with record count information.
+// Count is a databases row with record count information. Counts are also
+// split by the strand of the records.
 type Count struct {
-	Count   int `db:"count"`
-	CopyNum int `db:"copyNum"`
+	Count        int     `db:"count"`
+	CopyNum      int     `db:"copyNum"`
+	Plus         float64 `db:"plus"`
+	Minus        float64 `db:"minus"`
+	PlusCopyNum  float64 `db:"plusCopyNum"`
+	MinusCopyNum float64 `db:"minusCopyNum"`
+}
+
+// Stranded returns the counts and copy numbers of records on the same (sense)
+// and the opposite (antisense) orientation as ori.
+func (c Count) Stranded(ori feat.Orientation) (sense, antisense, senseCopyNum, antisenseCopyNum int) {
+	if ori == feat.Reverse {
+		return int(c.Minus), int(c.Plus), int(c.MinusCopyNum), int(c.PlusCopyNum)
+	}
+	return int(c.Plus), int(c.Minus), int(c.PlusCopyNum), int(c.MinusCopyNum)
 }
 
 const prog = "htsdb-count-reads-on-feats"
-const version = "0.2"
+const version = "0.21"
 const descr = `Print number of reads and read copies that are contained in
-each feature of the input file. Currently only features in the BED6 format are
-supported. Provided SQL filter will apply to all counts.`
+each feature of the input file. Counts are also split into records on the
+sense and antisense orientation of the feature; they are NA for features
+without orientation and for unstranded protocols. Currently only features in
+the BED6 format are supported.
+Lines sharing a name can be counted as a single feature e.g. the exons of a
+gene. Optionally, each feature is shuffled within its reference to compute the
+expected number of reads and an empirical enrichment p-value. If mappable
+regions are given, feature lengths for normalization and shuffled positions
+only consider mappable bases. With --uniformity, the evenness of the per-base
+coverage of each feature by the counted reads is reported as the fraction of
+bases covered, the Gini coefficient of the depths (0 for uniform coverage,
+close to 1 for a single-position pileup) and the ratio of the maximum to the
+mean depth. Provided SQL filter will apply to all counts. Reads whose strand
+is not 1 or -1 are counted on neither orientation; --invalid-strand can skip
+them from all counts or stop with an error.`
 
 var (
 	app = kingpin.New(prog, descr)
 
 	dbFile = app.Flag("db", "File to SQLite database.").
 		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
 	tab = app.Flag("table", "Database table name.").
 		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Count read copies by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
 	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
 		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
+	refMap = app.Flag("ref-map", "Rename feature references to ucsc or ensembl style or use mapping file.").
+		PlaceHolder("<ucsc|ensembl|file>").String()
 	bed6 = app.Flag("bed6", "BED6 file with features.").
 		PlaceHolder("<file>").Required().String()
+	key = app.Flag("key", "Identify features in the feat column by coordinates or BED name.").
+		Default("coords").Enum("coords", "name")
+	groupByName = app.Flag("group-by-name", "Count BED lines sharing a name as one feature; reads in their union are counted once.").
+			Bool()
 	as = app.Flag("as", "Name to print describing the count/s.").
 		Default("all").String()
 	header = app.Flag("header", "Print header line.").
 		Bool()
-	useOri = app.Flag("use-ori", "Only report counts on the orientation of the feature.").
+	long = app.Flag("long", "Print one value per row after the feature columns (long format).").
 		Bool()
+	ignoreStrand = app.Flag("ignore-strand", "Report NA for the sense and antisense columns of unstranded protocols.").
+			Bool()
+	strandPolicy = app.Flag("invalid-strand", "Handling of reads whose strand is not 1 or -1.").
+			Default("unknown").Enum(htsdb.StrandPolicies...)
+	useOri = app.Flag("use-ori", "Only report counts on the orientation of the feature; deprecated, use the sense columns.").
+		Bool()
+	norm = app.Flag("normalize", "Add a column with the count normalized by this method.").
+		Default("raw").Enum(normalize.Methods...)
+	scaleFactor = app.Flag("scale-factor", "Scale factor applied after normalization.").
+			Default("1").Float64()
+	spikeWhere = app.Flag("spike-where", "SQL filter that selects spike-in reads for spike normalization.").
+			PlaceHolder("<SQL>").String()
+	genomeSize = app.Flag("effective-genome-size", "Effective genome size for rpgc normalization; defaults to the mappable or total reference length.").
+			Default("0").Int()
+	mappability = app.Flag("mappability", "BED file with mappable regions used for feature lengths, genome size and shuffles.").
+			PlaceHolder("<file>").String()
+	uniformity = app.Flag("uniformity", "Add columns with the coverage uniformity of each feature.").
+			Bool()
+	shuffles = app.Flag("shuffle-background", "Number of feature shuffles to compute expected counts and p-values.").
+			Default("0").Int()
+	seed = app.Flag("seed", "Seed for the random shuffles.").
+		Default("1").Int64()
+	cacheDir = app.Flag("cache", "Cache results in directory so that identical runs return instantly e.g. "+htsdb.DefaultCacheDir+".").
+			PlaceHolder("<dir>").String()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("4").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("fixed").Enum(htsdb.Notations...)
 )
 
+var common cli.Options
+
 func main() {
 	var err error
 	var query string
@@ -58,32 +150,198 @@ func main() {
 	// read command line args and options
 	app.HelpFlag.Short('h')
 	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
 	if _, err := app.Parse(os.Args[1:]); err != nil {
 		kingpin.Fatalf("%s", err)
 	}
+	filter, err := htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	*where = filter
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
 
-	// assemble sqlx select builders
-	countBuilder := CountBuilder.From(*tab).Where("rname = ? AND start BETWEEN ? AND ? AND stop BETWEEN ? AND ?")
-	if *where != "" {
-		countBuilder = countBuilder.Where(*where)
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// print the cached results of an identical run or cache the output.
+	var out io.Writer = os.Stdout
+	var cw *htsdb.CacheWriter
+	if *cacheDir != "" {
+		cache, err := htsdb.OpenCache(*cacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = htsdb.CheckCacheDriver(*driver); err != nil {
+			log.Fatal(err)
+		}
+		refFile := *refMap
+		if refFile == "ucsc" || refFile == "ensembl" {
+			refFile = ""
+		}
+		key, err := htsdb.CacheKey(prog, version, os.Args[1:], *dbFile, *bed6,
+			common.Regions, common.Blacklist, *mappability, refFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ok, err := cache.WriteTo(key, os.Stdout); err != nil {
+			log.Fatal(err)
+		} else if ok {
+			return
+		}
+		if cw, err = cache.Writer(key, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		out = cw
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// get reference renaming function.
+	rename, err := htsdb.RefRenamer(*refMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
 	}
+
+	// assemble sqlx select builders
+	countBuilder := CountBuilder.Where("rname = ? AND start BETWEEN ? AND ? AND stop BETWEEN ? AND ?")
 	if *useOri == true {
 		countBuilder = countBuilder.Where("strand = ?")
 	}
 
 	// open database connections.
-	if db, err = sqlx.Connect("sqlite3", *dbFile); err != nil {
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		panic(err)
+	}
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
 		panic(err)
 	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	countBuilder = countBuilder.From(table)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		panic(err)
+	}
+
+	// handle reads whose strand is not 1 or -1.
+	policy, err := htsdb.ParseStrandPolicy(*strandPolicy)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	invalid := 0
+	if *where, invalid, err = policy.Filter(db, table, *where); err != nil {
+		log.Fatal(err)
+	}
+	if policy == htsdb.StrandSkip && invalid > 0 {
+		log.Printf("warning: skipped %d reads with invalid strand\n", invalid)
+	}
+	if *where != "" {
+		countBuilder = countBuilder.Where(*where)
+	}
+
+	// restrict to regions.
+	regs, err := common.ReadRegions()
+	if err != nil {
+		panic(err)
+	}
+	regsFilter, err := htsdb.RegionsClause(db, regs, coords)
+	if err != nil {
+		panic(err)
+	}
+	if regsFilter != "" {
+		countBuilder = countBuilder.Where(regsFilter)
+	}
+
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		panic(err)
+	}
+	if len(bl) > 0 {
+		dropped, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", dropped)
+		countBuilder = bl.Apply(countBuilder, coords)
+	}
+
+	// exclude low-complexity reads.
+	var lowComplexity string
+	if *maskLowComplexity == true {
+		if lowComplexity, err = htsdb.LowComplexityFilter(db, table, *dustThreshold); err != nil {
+			panic(err)
+		}
+		countBuilder = countBuilder.Where(lowComplexity)
+	}
 
 	// prepare statements.
 	if query, _, err = countBuilder.ToSql(); err != nil {
 		panic(err)
 	}
-	if stmt, err = db.Preparex(query); err != nil {
+	if stmt, err = db.Preparex(db.Rebind(query)); err != nil {
 		panic(err)
 	}
 
+	// get normalizer.
+	method, err := normalize.Parse(*norm)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	n, err := normalize.New(db, method, table, *where, *spikeWhere, false, *scaleFactor)
+	if err != nil {
+		panic(err)
+	}
+	normalized := method != normalize.Raw || *scaleFactor != 1
+
+	// read mappable regions and reference lengths for shuffling and the
+	// effective genome size.
+	mapp, err := htsdb.ReadMappabilityFile(*mappability)
+	if err != nil {
+		panic(err)
+	}
+	refLens := make(map[string]int)
+	if *shuffles > 0 || method == normalize.RPGC {
+		if refLens, err = htsdb.ReferenceLengths(db, table, coords); err != nil {
+			panic(err)
+		}
+	}
+	n.GenomeSize = float64(*genomeSize)
+	if *genomeSize == 0 {
+		n.GenomeSize = float64(htsdb.EffectiveGenomeSize(refLens, mapp))
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+
+	if *groupByName == true && *shuffles > 0 {
+		kingpin.Fatalf("--shuffle-background cannot be used with --group-by-name")
+	}
+	if *ignoreStrand == true && *useOri == true {
+		kingpin.Fatalf("--use-ori cannot be used with --ignore-strand")
+	}
+
 	// open BED6 scanner
 	bedS, err := bed6Scanner(*bed6)
 	if err != nil {
@@ -91,31 +349,279 @@ func main() {
 	}
 
 	// loop on the BED6 feats and count
+	values := []string{"count", "copyNumber", "sense", "antisense",
+		"senseCopyNumber", "antisenseCopyNumber"}
+	if normalized {
+		values = append(values, "normCount")
+	}
+	if *uniformity == true {
+		values = append(values, "covered", "gini", "maxMean")
+	}
+	if *shuffles > 0 {
+		values = append(values, "expected", "pvalue")
+	}
+	w := htsdb.NewResultWriter(out, []string{"category", "feat", "name", "score"},
+		values, *long)
+	w.Format = ff
 	if *header == true {
-		fmt.Printf("category\tfeat\tname\tcount\tcopyNumber\n")
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
 	}
-	for {
-		if bedS.Next() == false {
-			break
+	// flush the rows written so far if interrupted.
+	interrupted := func() {
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
 		}
-		b, _ := bedS.Feat().(*bed.Bed6)
-		start, stop, ori, chrom := b.Start(), b.End()-1, b.Orientation(), b.Location().Name()
-
+		cli.Interrupted(stop, "output is partial")
+	}
+	count := func(chrom string, start, stop int, ori interface{}) Count {
 		var c Count
+		qStart, qStop := coords.FromHtsdb(start, stop)
 		if *useOri == true {
-			if err = stmt.Get(&c, chrom, start, stop, start, stop, ori); err != nil {
-				panic(err)
+			err = stmt.GetContext(ctx, &c, chrom, qStart, qStop, qStart, qStop, ori)
+		} else {
+			err = stmt.GetContext(ctx, &c, chrom, qStart, qStop, qStart, qStop)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			panic(err)
+		}
+		return c
+	}
+	countUnion := func(regions []htsdb.Region, ori interface{}) Count {
+		var c Count
+		b := CountBuilder.From(table).Where(htsdb.ContainedFilter(regions, coords))
+		if *where != "" {
+			b = b.Where(*where)
+		}
+		if regsFilter != "" {
+			b = b.Where(regsFilter)
+		}
+		if *useOri == true {
+			b = b.Where("strand = ?", ori)
+		}
+		b = bl.Apply(b, coords)
+		if lowComplexity != "" {
+			b = b.Where(lowComplexity)
+		}
+		q, args, err := b.ToSql()
+		if err != nil {
+			panic(err)
+		}
+		if err = db.GetContext(ctx, &c, db.Rebind(q), args...); err != nil {
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			panic(err)
+		}
+		return c
+	}
+	// depth returns the per-base coverage of the merged regions of a feature,
+	// concatenated, by the records contained in them.
+	depth := func(regions []htsdb.Region, ori interface{}) []float64 {
+		merged := htsdb.MergeRegions(regions)
+		b := htsdb.FeatureBuilder.From(table).Where(htsdb.ContainedFilter(merged, coords))
+		if *where != "" {
+			b = b.Where(*where)
+		}
+		if regsFilter != "" {
+			b = b.Where(regsFilter)
+		}
+		if *useOri == true {
+			b = b.Where("strand = ?", ori)
+		}
+		b = bl.Apply(b, coords)
+		if lowComplexity != "" {
+			b = b.Where(lowComplexity)
+		}
+		q, args, err := b.ToSql()
+		if err != nil {
+			panic(err)
+		}
+		var recs []htsdb.Feature
+		if err = db.SelectContext(ctx, &recs, db.Rebind(q), args...); err != nil {
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			panic(err)
+		}
+		offsets := make([]int, len(merged))
+		length := 0
+		for i, m := range merged {
+			offsets[i] = length
+			length += m.Stop - m.Start + 1
+		}
+		d := make([]float64, length)
+		for _, rec := range recs {
+			coords.Normalize(&rec.Range)
+			for i, m := range merged {
+				if rec.Rname != m.Rname || rec.StartPos < m.Start || rec.StopPos > m.Stop {
+					continue
+				}
+				for pos := rec.StartPos; pos <= rec.StopPos; pos++ {
+					d[offsets[i]+pos-m.Start] += float64(rec.CopyNumber)
+				}
+				break
+			}
+		}
+		return d
+	}
+	process := func(f *feature) {
+		r := f.regions[0]
+		qChrom := rename(r.Rname)
+		var c Count
+		length := 0
+		if len(f.regions) == 1 {
+			length = r.Stop - r.Start + 1
+			if len(mapp) > 0 {
+				length = mapp.Bases(htsdb.Region{Rname: qChrom, Start: r.Start, Stop: r.Stop})
+			}
+			c = count(qChrom, r.Start, r.Stop, f.ori)
+		} else {
+			regions := make([]htsdb.Region, len(f.regions))
+			for i, fr := range f.regions {
+				regions[i] = htsdb.Region{Rname: rename(fr.Rname), Start: fr.Start, Stop: fr.Stop}
+				if fr.Start < r.Start {
+					r.Start = fr.Start
+				}
+				if fr.Stop > r.Stop {
+					r.Stop = fr.Stop
+				}
 			}
+			c = countUnion(regions, f.ori)
+			for _, m := range htsdb.MergeRegions(regions) {
+				if len(mapp) > 0 {
+					length += mapp.Bases(m)
+				} else {
+					length += m.Stop - m.Start + 1
+				}
+			}
+		}
+
+		id := fmt.Sprintf("%s:%d-%d:%d", r.Rname, r.Start, r.Stop, f.ori)
+		if *key == "name" {
+			id = f.name
+		}
+		row := []interface{}{*as, id, f.name, f.score, c.Count, c.CopyNum}
+		if (f.ori == feat.Forward || f.ori == feat.Reverse) && *ignoreStrand == false {
+			sense, anti, senseCopyNum, antiCopyNum := c.Stranded(f.ori)
+			row = append(row, sense, anti, senseCopyNum, antiCopyNum)
 		} else {
-			if err = stmt.Get(&c, chrom, start, stop, start, stop); err != nil {
-				panic(err)
+			row = append(row, nil, nil, nil, nil)
+		}
+		if normalized {
+			row = append(row, n.Value(float64(c.Count), length))
+		}
+		if *uniformity == true {
+			regions := make([]htsdb.Region, len(f.regions))
+			for i, fr := range f.regions {
+				regions[i] = htsdb.Region{Rname: rename(fr.Rname), Start: fr.Start, Stop: fr.Stop}
+			}
+			u := htsdb.CoverageUniformity(depth(regions, f.ori))
+			if u.Covered == 0 {
+				row = append(row, u.Covered, nil, nil)
+			} else {
+				row = append(row, u.Covered, u.Gini, u.MaxMean)
 			}
 		}
-		fmt.Printf("%s\t%s:%d-%d:%d\t%s\t%d\t%d\n", *as, chrom, start, stop, ori, b.Name(), c.Count, c.CopyNum)
+		if *shuffles > 0 {
+			// place the feature at random positions of its reference,
+			// within mappable regions if given.
+			width := r.Stop - r.Start + 1
+			randomStart := func() (int, bool) {
+				if len(mapp) > 0 {
+					return mapp.RandomStart(rnd, qChrom, width)
+				}
+				span := refLens[qChrom] - width + 1
+				if span <= 0 {
+					return 0, false
+				}
+				return rnd.Intn(span), true
+			}
+			var sum, atLeast int
+			ok := true
+			for i := 0; i < *shuffles; i++ {
+				var s int
+				if s, ok = randomStart(); !ok {
+					break
+				}
+				sc := count(qChrom, s, s+width-1, f.ori)
+				sum += sc.Count
+				if sc.Count >= c.Count {
+					atLeast++
+				}
+			}
+			if !ok {
+				row = append(row, nil, nil)
+			} else {
+				row = append(row, float64(sum)/float64(*shuffles),
+					float64(atLeast+1)/float64(*shuffles+1))
+			}
+		}
+		if err := w.WriteRow(row...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// count each BED line or, when grouping, all lines sharing a name as one
+	// feature in order of first appearance.
+	var groups []*feature
+	byName := make(map[string]*feature)
+	for {
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if bedS.Next() == false {
+			break
+		}
+		b, _ := bedS.Feat().(*bed.Bed6)
+		r := htsdb.Region{Rname: b.Location().Name(), Start: b.Start(), Stop: b.End() - 1}
+		if *groupByName == false {
+			process(&feature{name: b.Name(), score: b.FeatScore, ori: b.Orientation(),
+				regions: []htsdb.Region{r}})
+			continue
+		}
+		f, ok := byName[b.Name()]
+		if !ok {
+			f = &feature{name: b.Name(), score: b.FeatScore, ori: b.Orientation()}
+			byName[b.Name()] = f
+			groups = append(groups, f)
+		}
+		if f.ori != b.Orientation() {
+			f.ori = 0
+		}
+		f.regions = append(f.regions, r)
 	}
 	if err = bedS.Error(); err != nil {
 		panic(err)
 	}
+	for _, f := range groups {
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		process(f)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	// store results in cache.
+	if cw != nil {
+		if err = cw.Commit(); err != nil {
+			log.Printf("warning: results not cached: %s\n", err)
+		}
+	}
+}
+
+// feature is one or more BED intervals that are counted together.
+type feature struct {
+	name    string
+	score   int
+	ori     feat.Orientation
+	regions []htsdb.Region
 }
 
 func bed6Scanner(f string) (*featio.Scanner, error) {
@@ -4,20 +4,24 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 // CountBuilder is a squirrel select builder whose columns match Count fields.
 var CountBuilder = squirrel.Select().
-	Column(squirrel.Alias(squirrel.Expr("CASE WHEN rname IS NULL THEN \"\" ELSE rname END"), "rname")).
+	Column(squirrel.Alias(squirrel.Expr("CASE WHEN rname IS NULL THEN '' ELSE rname END"), "rname")).
 	Column(squirrel.Alias(squirrel.Expr("CASE WHEN strand IS NULL THEN 0 ELSE strand END"), "strand")).
 	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
-	Column(squirrel.Alias(squirrel.Expr("CAST(TOTAL(copy_number) AS INTEGER)"), "copyNum"))
+	Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum"))
 
 // Count is a databases row with record count information.
 type Count struct {
@@ -25,23 +29,48 @@ type Count struct {
 	Ori     int    `db:"strand"`
 	Count   int    `db:"count"`
 	CopyNum int    `db:"copyNum"`
+	Sample  int64  `db:"sample_id"`
 }
 
 const prog = "htsdb-count-reads"
-const version = "0.4"
+const version = "0.14"
 const descr = `Print the number of reads and read copies stored in the
-database. Supports grouping by reference, orientation or both. Provided SQL
-filter will apply to all counts.`
+database. Supports grouping by reference, orientation or both. For tables
+shared by the samples of the samples registry, --by-sample prints the counts of
+each sample with the sample name as category. Provided SQL filter will apply to
+all counts.
+With --watch, the command keeps running while the database is being filled
+e.g. by an importer during a sequencing run; only the appended rows are counted
+and the updated counts are printed after a "# rows:<n>" line whenever rows are
+appended. Rows must only be appended, never deleted or updated.`
 
 var (
 	app = kingpin.New(prog, descr)
 
 	dbFile = app.Flag("db", "File to SQLite database.").
 		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
 	tab = app.Flag("table", "Database table name.").
 		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Count read copies by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
 	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
 		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
 	as = app.Flag("as", "Name to print describing the count/s.").
 		Default("all").String()
 	header = app.Flag("header", "Print header line.").
@@ -50,19 +79,53 @@ var (
 			Bool()
 	groupByOri = app.Flag("by-ori", "Group counts by orientation.").
 			Bool()
+	groupBySample = app.Flag("by-sample", "Group counts by the sample_id of samples sharing the table.").
+			Bool()
+	long = app.Flag("long", "Print one value per row after the group columns (long format).").
+		Bool()
+	watch = app.Flag("watch", "Keep running and print updated counts as rows are appended.").
+		Bool()
+	interval = app.Flag("interval", "Polling interval for --watch.").
+			Default("5s").Duration()
 )
 
+var common cli.Options
+
 func main() {
 	// read command line args and options
 	app.HelpFlag.Short('h')
 	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		kingpin.Fatalf("%s", err)
 	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *watch == true && *driver != htsdb.SQLite {
+		kingpin.Fatalf("--watch requires the sqlite3 driver")
+	}
+	if *groupBySample == true && *sample != "" {
+		kingpin.Fatalf("--by-sample and --sample are mutually exclusive")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
 
-	// assemble sqlx select builders
-	countBuilder := CountBuilder.From(*tab)
+	// assemble sqlx select builders; the table is added per query.
+	countBuilder := CountBuilder
 	if *where != "" {
 		countBuilder = countBuilder.Where(*where)
 	}
@@ -72,53 +135,187 @@ func main() {
 	if *groupByOri == true {
 		countBuilder = countBuilder.GroupBy("strand")
 	}
+	if *groupBySample == true {
+		countBuilder = countBuilder.Column(htsdb.SampleIDColumn).GroupBy(htsdb.SampleIDColumn)
+	}
 
 	// open database connections.
 	var db *sqlx.DB
-	if db, err = sqlx.Connect("sqlite3", *dbFile); err != nil {
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		log.Fatal(err)
+	}
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
 		log.Fatal(err)
 	}
+	var sampleNames map[int64]string
+	if *groupBySample == true {
+		if sampleNames, err = htsdb.SampleNames(db, *tab); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// prepare statements.
-	query, _, err := countBuilder.ToSql()
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
 
-	// get the count
-	var counts []Count
-	if err = db.Select(&counts, query); err != nil {
+	// restrict to regions.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
 		log.Fatal(err)
 	}
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := htsdb.RegionsClause(db, regs, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		countBuilder = countBuilder.Where(regsFilter)
+	}
 
-	// print results.
-	if *groupByChrom == true && *groupByOri == true {
-		if *header == true {
-			fmt.Printf("category\tref\tori\tcount\tcopyNumber\n")
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			log.Fatal(err)
 		}
-		for _, c := range counts {
-			fmt.Printf("%s\t%s\t%d\t%d\t%d\n", *as, c.Chrom, c.Ori, c.Count, c.CopyNum)
+		log.Printf("blacklist: excluded %d records\n", n)
+		countBuilder = bl.Apply(countBuilder, coords)
+	}
+
+	// exclude low-complexity reads.
+	if *maskLowComplexity == true {
+		f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else if *groupByChrom == true {
-		if *header == true {
-			fmt.Printf("category\tref\tcount\tcopyNumber\n")
+		countBuilder = countBuilder.Where(f)
+	}
+
+	if *watch == false {
+		// get the count
+		query, _, err := countBuilder.From(table).ToSql()
+		if err != nil {
+			log.Fatal(err)
 		}
-		for _, c := range counts {
-			fmt.Printf("%s\t%s\t%d\t%d\n", *as, c.Chrom, c.Count, c.CopyNum)
+		var counts []Count
+		if err = db.SelectContext(ctx, &counts, query); err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
 		}
-	} else if *groupByOri == true {
-		if *header == true {
-			fmt.Printf("category\tori\tcount\tcopyNumber\n")
+		printCounts(counts, sampleNames)
+		return
+	}
+
+	// count appended rows and print the updated counts until interrupted.
+	w := htsdb.NewWatcher(db, *tab, *interval)
+	totals := make(map[Count]*Count)
+	for {
+		from, to, err := w.Next(ctx)
+		if ctx.Err() != nil {
+			return
 		}
-		for _, c := range counts {
-			fmt.Printf("%s\t%d\t%d\t%d\n", *as, c.Ori, c.Count, c.CopyNum)
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else {
-		if *header == true {
-			fmt.Printf("category\tcount\tcopyNumber\n")
+		query, _, err := countBuilder.From(cols.TableRows(*tab, from, to)).ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var counts []Count
+		if err = db.SelectContext(ctx, &counts, query); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatal(err)
 		}
 		for _, c := range counts {
-			fmt.Printf("%s\t%d\t%d\n", *as, c.Count, c.CopyNum)
+			k := Count{}
+			if *groupByChrom == true {
+				k.Chrom = c.Chrom
+			}
+			if *groupByOri == true {
+				k.Ori = c.Ori
+			}
+			if *groupBySample == true {
+				k.Sample = c.Sample
+			}
+			t, ok := totals[k]
+			if !ok {
+				t = &k
+				totals[k] = t
+			}
+			t.Count += c.Count
+			t.CopyNum += c.CopyNum
+		}
+		merged := make([]Count, 0, len(totals))
+		for _, t := range totals {
+			merged = append(merged, *t)
 		}
+		sort.Slice(merged, func(i, j int) bool {
+			if merged[i].Sample != merged[j].Sample {
+				return merged[i].Sample < merged[j].Sample
+			}
+			if merged[i].Chrom != merged[j].Chrom {
+				return merged[i].Chrom < merged[j].Chrom
+			}
+			return merged[i].Ori < merged[j].Ori
+		})
+		fmt.Printf("# rows:%d\n", to)
+		printCounts(merged, sampleNames)
+	}
+}
+
+// printCounts prints counts according to the grouping options. With
+// --by-sample the category is the name of the sample in names or its ID.
+func printCounts(counts []Count, names map[int64]string) {
+	keys := []string{"category"}
+	if *groupByChrom == true {
+		keys = append(keys, "ref")
+	}
+	if *groupByOri == true {
+		keys = append(keys, "ori")
+	}
+	w := htsdb.NewResultWriter(os.Stdout, keys, []string{"count", "copyNumber"}, *long)
+	if *header == true {
+		if err := w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, c := range counts {
+		row := []interface{}{*as}
+		if *groupBySample == true {
+			name, ok := names[c.Sample]
+			if !ok {
+				name = strconv.FormatInt(c.Sample, 10)
+			}
+			row[0] = name
+		}
+		if *groupByChrom == true {
+			row = append(row, c.Chrom)
+		}
+		if *groupByOri == true {
+			row = append(row, c.Ori)
+		}
+		if err := w.WriteRow(append(row, c.Count, c.CopyNum)...); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
 	}
 }
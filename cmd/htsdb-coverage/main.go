@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-coverage"
+const version = "0.3"
+const descr = `Print the per-base read coverage on one strand, or on both strands
+pooled for unstranded protocols, in bedGraph format. For paired-end data whole
+fragments can be used instead of reads. Coverage can optionally be weighted by
+the copy number of each read. Coverage can instead be written to a bigWig
+file (--bigwig) for genome browsers. References whose coverage exceeds the
+memory budget (--max-mem) are computed by streaming reads sorted by position.
+Provided SQL filter will apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	fragment = app.Flag("fragment", "Use paired-end fragments (start to start+tlen) instead of reads.").
+			Bool()
+	strand = app.Flag("strand", "Strand of reads to count; required unless --ignore-strand.").
+		PlaceHolder("<+|->").Enum("+", "-")
+	ignoreStrand = app.Flag("ignore-strand", "Pool reads of both strands for unstranded protocols.").
+			Bool()
+	copyNum = app.Flag("copy-number", "Weight coverage by read copy number.").
+		Bool()
+	bigWig = app.Flag("bigwig", "Write coverage to this bigWig file instead of stdout.").
+		PlaceHolder("<file>").String()
+	maxMem = app.Flag("max-mem", "Memory budget for per-reference coverage e.g. 2G; stream sorted reads when exceeded.").
+		PlaceHolder("<size>").String()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *strand == "" && *ignoreStrand == false {
+		kingpin.Fatalf("required flag --strand not provided")
+	}
+	if *strand != "" && *ignoreStrand == true {
+		kingpin.Fatalf("--strand cannot be used with --ignore-strand")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	budgetBytes, err := htsdb.ParseBytes(*maxMem)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	budget := htsdb.NewMemBudget(budgetBytes)
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble sqlx select builders
+	rangeB := htsdb.RangeBuilder
+	if *fragment == true {
+		rangeB = htsdb.FragmentBuilder(coords)
+	}
+	readsB := rangeB.From(table).Where("rname = ?")
+	if *ignoreStrand == false {
+		readsB = readsB.Where("strand = ?")
+	}
+	refsB := htsdb.ReferenceBuilder.From(table)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+		refsB = refsB.Where(*where)
+	}
+
+	// restrict to regions.
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if f := htsdb.RegionsFilter(regs, coords); f != "" {
+		readsB = readsB.Where(f)
+		refsB = refsB.Where(f)
+	}
+
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", n)
+		readsB = bl.Apply(readsB, coords)
+	}
+
+	ori := feat.Forward
+	if *strand == "-" {
+		ori = feat.Reverse
+	}
+	args := func(ref string) []interface{} {
+		if *ignoreStrand == true {
+			return []interface{}{ref}
+		}
+		return []interface{}{ref, ori}
+	}
+
+	// prepare statements.
+	stmt, err := prepareStmt(readsB, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sortedStmt, err := prepareStmt(readsB.OrderBy("start"), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// select reference features
+	refs, err := htsdb.SelectReferencesContext(ctx, db, refsB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Chrom < refs[j].Chrom })
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var bw *htsdb.BigWigWriter
+	if *bigWig != "" {
+		lens, err := htsdb.ReferenceLengths(db, table, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f, err := os.Create(*bigWig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if bw, err = htsdb.NewBigWigWriter(f, lens); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var r htsdb.Range
+	weight := func(r *htsdb.Range) float64 {
+		if *copyNum == true {
+			return float64(r.CopyNumber)
+		}
+		return 1
+	}
+	var checker htsdb.RangeChecker
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		if *verbose == true {
+			log.Printf("chrom:%s\n", ref.Chrom)
+		}
+
+		emit := func(start, end int, depth float64) error {
+			if bw != nil {
+				return bw.AddInterval(ref.Chrom, start, end, depth)
+			}
+			_, err := fmt.Fprintf(out, "%s\t%d\t%d\t%s\n", ref.Chrom, start, end,
+				ff.Format(depth))
+			return err
+		}
+
+		// compute coverage in memory while within the memory budget.
+		cov := make(htsdb.Coverage)
+		var reserved int64
+		inMem := true
+		rows, err := stmt.QueryxContext(ctx, args(ref.Chrom)...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r)
+			if !checker.Check(&r) {
+				continue
+			}
+			for _, p := range []int{r.Start(), r.End()} {
+				if _, ok := cov[p]; ok {
+					continue
+				}
+				if !budget.Reserve(htsdb.MapEntrySize) {
+					inMem = false
+					break
+				}
+				reserved += htsdb.MapEntrySize
+				cov[p] = 0
+			}
+			if !inMem {
+				break
+			}
+			cov.Add(r.Start(), r.End(), weight(&r))
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			budget.Release(reserved)
+			break
+		}
+		if inMem {
+			if err = cov.Intervals(emit); err != nil {
+				log.Fatal(err)
+			}
+			budget.Release(reserved)
+			continue
+		}
+
+		// fall back to streaming reads sorted by position.
+		budget.Release(reserved)
+		cov = nil
+		if *verbose == true {
+			log.Printf("chrom:%s, memory budget exceeded; streaming\n", ref.Chrom)
+		}
+		stream := htsdb.NewCoverageStream(emit)
+		rows, err = sortedStmt.QueryxContext(ctx, args(ref.Chrom)...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r)
+			if !checker.Check(&r) {
+				continue
+			}
+			if err = stream.Add(r.Start(), r.End(), weight(&r)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err = stream.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// report malformed records.
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+
+	if bw != nil {
+		if err = bw.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// flag partial output if interrupted.
+	if ctx.Err() != nil {
+		if bw == nil {
+			fmt.Fprintln(out, htsdb.InterruptedMarker)
+		}
+		if err = out.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		db.Close()
+		cli.Interrupted(stop, "output is partial")
+	}
+}
+
+func prepareStmt(b squirrel.SelectBuilder, db *sqlx.DB) (*sqlx.Stmt, error) {
+	q, _, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return db.Preparex(db.Rebind(q))
+}
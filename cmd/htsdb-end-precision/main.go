@@ -0,0 +1,320 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-end-precision"
+const version = "0.2"
+const descr = `Measure the precision of read ends at feature boundaries e.g. of
+small RNA reads at the ends of mature miRNAs. Each read is assigned to the
+overlapping feature on its strand whose ends are closest to its own and the
+offsets of its 5' and 3' ends from the feature ends are measured in the
+feature orientation, positive downstream. For each feature class and end the
+number of reads and the fractions of reads with exact, within 1 and within 2
+bases ends are printed. Features are grouped in classes by name, the fourth
+column of BED files or the GTF attribute --class-attr. The distribution of
+offsets can be written with --distro. Provided SQL filter will apply to all
+reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	featsFile = app.Flag("feats", "BED or GTF (.gtf) file with stranded features.").
+			PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. miRNA.").
+			PlaceHolder("<type>").String()
+	classAttr = app.Flag("class-attr", "GTF attribute used as feature class.").
+			Default("gene_biotype").String()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	distroFile = app.Flag("distro", "File to write the number of reads at each end offset.").
+			PlaceHolder("<file>").String()
+	maxOffset = app.Flag("max-offset", "Largest absolute offset written with --distro; larger offsets are pooled.").
+			Default("10").Int()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+// ends are the read ends measured.
+var ends = []string{"5p", "3p"}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *maxOffset < 2 {
+		kingpin.Fatalf("--max-offset must be at least 2")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read the stranded features.
+	anns, err := htsdb.ReadAnnotations(*featsFile, *featType, *classAttr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stranded := anns[:0]
+	for _, a := range anns {
+		if a.Strand != 0 {
+			stranded = append(stranded, a)
+		}
+	}
+	anns = stranded
+	if len(anns) == 0 {
+		log.Fatal("no stranded features")
+	}
+	idx := htsdb.NewAnnotationIndex(anns)
+	var refs []string
+	seen := make(map[string]bool)
+	for _, a := range anns {
+		if !seen[a.Rname] {
+			seen[a.Rname] = true
+			refs = append(refs, a.Rname)
+		}
+	}
+	sort.Strings(refs)
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble the query.
+	b := htsdb.OrientedFeatureBuilder.From(table).Where("rname = ?")
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	q, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stmt, err := db.Preparex(db.Rebind(q))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// offsets[class][end] counts reads by offset, clamped at maxOffset.
+	offsets := make(map[string][2]map[int]float64)
+	add := func(class string, end, d int, w float64) {
+		o, ok := offsets[class]
+		if !ok {
+			o = [2]map[int]float64{make(map[int]float64), make(map[int]float64)}
+			offsets[class] = o
+		}
+		if d > *maxOffset {
+			d = *maxOffset
+		} else if d < -*maxOffset {
+			d = -*maxOffset
+		}
+		o[end][d] += w
+	}
+	var r htsdb.OrientedFeature
+	var checker htsdb.RangeChecker
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		if *verbose == true {
+			log.Printf("chrom:%s\n", ref)
+		}
+		rows, err := stmt.QueryxContext(ctx, ref)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r.Range)
+			if !checker.Check(&r.Range) || r.Strand() == htsdb.Unknown {
+				continue
+			}
+
+			// assign the read to the feature with the closest ends.
+			best, bestD5, bestD3 := -1, 0, 0
+			found := idx.Overlapping(ref, r.StartPos, r.StopPos, int(r.Orient))
+			for i, a := range found {
+				d5, d3 := a.EndOffsets(r.StartPos, r.StopPos)
+				if best < 0 || abs(d5)+abs(d3) < abs(bestD5)+abs(bestD3) {
+					best, bestD5, bestD3 = i, d5, d3
+				}
+			}
+			if best < 0 {
+				continue
+			}
+			w := 1.0
+			if *copyNum == true {
+				w = float64(r.CopyNumber)
+			}
+			add(found[best].Name, 0, bestD5, w)
+			add(found[best].Name, 1, bestD3, w)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+	}
+	if ctx.Err() != nil {
+		db.Close()
+		cli.Interrupted(stop, "nothing was printed")
+	}
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+
+	classes := make([]string, 0, len(offsets))
+	for c := range offsets {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+
+	// print the fractions of reads with precise ends.
+	tsv := htsdb.NewTSVWriter(os.Stdout)
+	tsv.Format = ff
+	if err = tsv.WriteStrings([]string{"class", "end", "reads", "exact", "within1", "within2"}); err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range classes {
+		for e, end := range ends {
+			o := offsets[c][e]
+			var total, within [3]float64
+			for d, n := range o {
+				total[0] += n
+				for k := 0; k < 3; k++ {
+					if abs(d) <= k {
+						within[k] += n
+					}
+				}
+			}
+			frac := func(n float64) float64 {
+				if total[0] == 0 {
+					return math.NaN()
+				}
+				return n / total[0]
+			}
+			err = tsv.Write(c, end, total[0], frac(within[0]), frac(within[1]), frac(within[2]))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err = tsv.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	// write the distribution of offsets.
+	if *distroFile == "" {
+		return
+	}
+	f, err := os.Create(*distroFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	dw := htsdb.NewTSVWriter(f)
+	dw.Format = ff
+	if err = dw.WriteStrings([]string{"class", "end", "offset", "reads"}); err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range classes {
+		for e, end := range ends {
+			for d := -*maxOffset; d <= *maxOffset; d++ {
+				if err = dw.Write(c, end, d, offsets[c][e][d]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+	}
+	if err = dw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
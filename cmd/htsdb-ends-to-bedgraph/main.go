@@ -0,0 +1,490 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/normalize"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-ends-to-bedgraph"
+const version = "0.13"
+const descr = `Print the per-base number of read 5' or 3' ends or midpoints on
+one strand, or on both strands pooled for unstranded protocols, in bedGraph
+format. For paired-end data whole fragments can be used instead of reads. For
+large genomes counts can be aggregated in fixed size bins and written as
+binned bedGraph or fixedStep wiggle. Per-base counts can instead be written
+to a bigWig file (--bigwig) for genome browsers. Counts can optionally be weighted by the
+copy number of each read or collapsed so that each position with reads counts
+once, like the collapse options of the distro tools. --max-per-pos caps the count of each position
+before normalization to limit jackpot artifacts without collapsing reads.
+Provided SQL filter will apply to all counts. Reads whose strand is not 1 or
+-1 are only counted with --ignore-strand unless --invalid-strand skips them or
+stops with an error.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
+	pos = app.Flag("pos", "Read end or midpoint to count.").
+		Required().PlaceHolder("<5p|3p|mid>").Enum("5p", "3p", "mid")
+	offset = app.Flag("offset", "Count the position offset bases downstream of the read end or midpoint; negative for upstream.").
+		Default("0").Int()
+	fragment = app.Flag("fragment", "Use paired-end fragments (start to start+tlen) instead of reads.").
+			Bool()
+	strand = app.Flag("strand", "Strand of reads to count; required unless --ignore-strand.").
+		PlaceHolder("<+|->").Enum("+", "-")
+	ignoreStrand = app.Flag("ignore-strand", "Pool reads of both strands as forward for unstranded protocols; 5p and 3p are the leftmost and rightmost positions.").
+			Bool()
+	strandPolicy = app.Flag("invalid-strand", "Handling of reads whose strand is not 1 or -1.").
+			Default("unknown").Enum(htsdb.StrandPolicies...)
+	binSize = app.Flag("bin-size", "Aggregate counts in bins of this many bases; 1 for per-base output.").
+		Default("1").Int()
+	binAgg = app.Flag("bin-agg", "Aggregation of counts within bins.").
+		Default("sum").Enum("sum", "mean", "max")
+	format = app.Flag("format", "Output format; wig writes fixedStep wiggle.").
+		Default("bedgraph").Enum("bedgraph", "wig")
+	bigWig = app.Flag("bigwig", "Write per-base counts to this bigWig file instead of stdout.").
+		PlaceHolder("<file>").String()
+	norm = app.Flag("normalize", "Normalization of counts.").
+		Default("raw").Enum("raw", "cpm", "spike", "scale", "rpgc")
+	scaleFactor = app.Flag("scale-factor", "Scale factor applied after normalization.").
+			Default("1").Float64()
+	spikeWhere = app.Flag("spike-where", "SQL filter that selects spike-in reads for spike normalization.").
+			PlaceHolder("<SQL>").String()
+	genomeSize = app.Flag("effective-genome-size", "Effective genome size for rpgc normalization; defaults to the mappable or total reference length.").
+			Default("0").Int()
+	mappability = app.Flag("mappability", "BED file with mappable regions whose total length is the effective genome size.").
+			PlaceHolder("<file>").String()
+	copyNum = app.Flag("copy-number", "Weight counts by read copy number.").
+		Bool()
+	maxPerPos = app.Flag("max-per-pos", "Cap the count of each position; 0 for no limit.").
+			Default("0").Float64()
+	collapse = app.Flag("collapse", "Count each position with reads once.").
+			Bool()
+	maxMem = app.Flag("max-mem", "Memory budget for per-reference counts e.g. 2G; stream sorted reads when exceeded.").
+		PlaceHolder("<size>").String()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *strand == "" && *ignoreStrand == false {
+		kingpin.Fatalf("required flag --strand not provided")
+	}
+	if *strand != "" && *ignoreStrand == true {
+		kingpin.Fatalf("--strand cannot be used with --ignore-strand")
+	}
+	if *maxPerPos < 0 {
+		kingpin.Fatalf("--max-per-pos must not be negative")
+	}
+	if *collapse == true {
+		if *copyNum == true || *maxPerPos > 0 {
+			kingpin.Fatalf("--collapse cannot be used with --copy-number or --max-per-pos")
+		}
+		*maxPerPos = 1
+	}
+	if *bigWig != "" && (*binSize > 1 || *format == "wig") {
+		kingpin.Fatalf("--bigwig cannot be used with --bin-size or --format wig")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	budgetBytes, err := htsdb.ParseBytes(*maxMem)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	budget := htsdb.NewMemBudget(budgetBytes)
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// handle reads whose strand is not 1 or -1.
+	policy, err := htsdb.ParseStrandPolicy(*strandPolicy)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	invalid := 0
+	if *where, invalid, err = policy.Filter(db, table, *where); err != nil {
+		log.Fatal(err)
+	}
+	if policy == htsdb.StrandSkip && invalid > 0 {
+		log.Printf("warning: skipped %d reads with invalid strand\n", invalid)
+	}
+
+	// assemble sqlx select builders
+	rangeB, stopExpr := htsdb.RangeBuilder, "stop"
+	if *fragment == true {
+		rangeB, stopExpr = htsdb.FragmentBuilder(coords), coords.FragmentStopExpr()
+	}
+	readsB := rangeB.From(table).Where("rname = ?")
+	if *ignoreStrand == false {
+		readsB = readsB.Where("strand = ?")
+	}
+	refsB := htsdb.ReferenceBuilder.From(table)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+		refsB = refsB.Where(*where)
+	}
+
+	// restrict to regions.
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if f := htsdb.RegionsFilter(regs, coords); f != "" {
+		readsB = readsB.Where(f)
+		refsB = refsB.Where(f)
+	}
+
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", n)
+		readsB = bl.Apply(readsB, coords)
+	}
+
+	// exclude low-complexity reads.
+	if *maskLowComplexity == true {
+		f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+		if err != nil {
+			log.Fatal(err)
+		}
+		readsB = readsB.Where(f)
+	}
+
+	// get position extracting function and the expression it increases with.
+	ori := feat.Forward
+	if *strand == "-" {
+		ori = feat.Reverse
+	}
+	args := func(ref string) []interface{} {
+		if *ignoreStrand == true {
+			return []interface{}{ref}
+		}
+		return []interface{}{ref, ori}
+	}
+	anchor, err := htsdb.ParseAnchor(*pos)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	getPos := func(r feat.Range, o feat.Orientation) (int, bool) {
+		return htsdb.PosAtOK(r, o, anchor, *offset)
+	}
+	sortCol := "start"
+	if anchor == htsdb.AnchorMid {
+		sortCol = "start + " + stopExpr
+	} else if (anchor == htsdb.AnchorHead) == (ori == feat.Reverse) {
+		sortCol = stopExpr
+	}
+
+	// prepare statements.
+	stmt, err := prepareStmt(readsB, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sortedStmt, err := prepareStmt(readsB.OrderBy(sortCol), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// select reference features
+	refs, err := htsdb.SelectReferencesContext(ctx, db, refsB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Chrom < refs[j].Chrom })
+
+	// select output writer; binned output is clipped at the reference lengths
+	// if the reference table is present.
+	bgw := htsdb.NewBedGraphWriter(os.Stdout)
+	bgw.Format = ff
+	var w htsdb.TrackWriter = bgw
+	if *bigWig != "" {
+		lens, err := htsdb.ReferenceLengths(db, table, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f, err := os.Create(*bigWig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if w, err = htsdb.NewBigWigWriter(f, lens); err != nil {
+			log.Fatal(err)
+		}
+	} else if *binSize > 1 || *format == "wig" {
+		agg, err := htsdb.ParseBinAgg(*binAgg)
+		if err != nil {
+			kingpin.Fatalf("%s", err)
+		}
+		bw := htsdb.NewBinWriter(os.Stdout, *binSize, agg, *format == "wig")
+		bw.Format = ff
+		seqs, err := htsdb.SelectRefSeqs(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bw.Lengths = make(map[string]int)
+		for _, s := range seqs {
+			bw.Lengths[s.Name] = s.Length
+		}
+		w = bw
+	}
+	method, err := normalize.Parse(*norm)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	n, err := normalize.New(db, method, table, *where, *spikeWhere, *copyNum, *scaleFactor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if method == normalize.RPGC {
+		n.GenomeSize = float64(*genomeSize)
+		if *genomeSize == 0 {
+			mapp, err := htsdb.ReadMappabilityFile(*mappability)
+			if err != nil {
+				log.Fatal(err)
+			}
+			lens, err := htsdb.ReferenceLengths(db, table, coords)
+			if err != nil {
+				log.Fatal(err)
+			}
+			n.GenomeSize = float64(htsdb.EffectiveGenomeSize(lens, mapp))
+		}
+	}
+	w = &normWriter{TrackWriter: w, n: n}
+	w = &htsdb.CapWriter{TrackWriter: w, Max: *maxPerPos}
+	defer w.Flush()
+	var r htsdb.Range
+	weight := func(r *htsdb.Range) float64 {
+		if *copyNum == true {
+			return float64(r.CopyNumber)
+		}
+		return 1
+	}
+	var checker htsdb.RangeChecker
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		if *verbose == true {
+			log.Printf("chrom:%s\n", ref.Chrom)
+		}
+
+		// count in memory while within the memory budget.
+		track := make(htsdb.Track)
+		var reserved int64
+		inMem := true
+		rows, err := stmt.QueryxContext(ctx, args(ref.Chrom)...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r)
+			if !checker.Check(&r) {
+				continue
+			}
+			p, ok := getPos(&r, ori)
+			if !ok || p < 0 {
+				continue
+			}
+			if _, ok := track[p]; !ok {
+				if !budget.Reserve(htsdb.MapEntrySize) {
+					inMem = false
+					break
+				}
+				reserved += htsdb.MapEntrySize
+			}
+			track[p] += weight(&r)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			budget.Release(reserved)
+			break
+		}
+		if inMem {
+			for _, p := range track.Positions() {
+				if err = w.Add(ref.Chrom, p, track[p]); err != nil {
+					log.Fatal(err)
+				}
+			}
+			budget.Release(reserved)
+			continue
+		}
+
+		// fall back to streaming reads sorted by position.
+		budget.Release(reserved)
+		track = nil
+		if *verbose == true {
+			log.Printf("chrom:%s, memory budget exceeded; streaming\n", ref.Chrom)
+		}
+		rows, err = sortedStmt.QueryxContext(ctx, args(ref.Chrom)...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		curPos, curVal := -1, 0.0
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r)
+			if !checker.Check(&r) {
+				continue
+			}
+			p, ok := getPos(&r, ori)
+			if !ok || p < 0 {
+				continue
+			}
+			if p != curPos && curVal != 0 {
+				if err = w.Add(ref.Chrom, curPos, curVal); err != nil {
+					log.Fatal(err)
+				}
+				curVal = 0
+			}
+			curPos = p
+			curVal += weight(&r)
+		}
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		if curVal != 0 {
+			if err = w.Add(ref.Chrom, curPos, curVal); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// report malformed records.
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+
+	// flag partial output if interrupted.
+	if ctx.Err() != nil {
+		if err = w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if *bigWig == "" {
+			fmt.Println(htsdb.InterruptedMarker)
+		}
+		db.Close()
+		cli.Interrupted(stop, "output is partial")
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// normWriter is a TrackWriter that normalizes values before writing them.
+type normWriter struct {
+	htsdb.TrackWriter
+	n normalize.Normalizer
+}
+
+func (w *normWriter) Add(rname string, pos int, v float64) error {
+	return w.TrackWriter.Add(rname, pos, w.n.Value(v, 1))
+}
+
+func prepareStmt(b squirrel.SelectBuilder, db *sqlx.DB) (*sqlx.Stmt, error) {
+	q, _, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return db.Preparex(db.Rebind(q))
+}
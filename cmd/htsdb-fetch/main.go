@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/export"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-fetch"
+const version = "0.9"
+const descr = `Print all records with the given read names e.g. to inspect
+reads reported by other tools. Records are printed with all table columns as
+TSV or in SAM format. Lookups scan the whole table unless the read names are
+indexed; --index creates the index once so that subsequent lookups are fast.
+With --columns only the given columns are read; in SAM format the other fields
+are printed as unavailable e.g. * for seq. In TSV output tabs, newlines and
+backslashes in values e.g. in tags are escaped as \t, \n and \\ unless --quote
+is given, so that each record is a single line.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. qname=read_id.").
+		PlaceHolder("<col=col,...>").String()
+	qnames = app.Flag("qname", "Read name to fetch; may be repeated.").
+		PlaceHolder("<name>").Strings()
+	qnamesFile = app.Flag("qnames", "File with one read name to fetch per line.").
+			PlaceHolder("<file>").String()
+	index = app.Flag("index", "Create the read name index if missing.").
+		Bool()
+	columns = app.Flag("columns", "Comma separated columns to print instead of all.").
+		PlaceHolder("<col,...>").String()
+	format = app.Flag("format", "Output format.").
+		Default("tsv").Enum("tsv", "sam")
+	header = app.Flag("header", "Print header line for TSV output.").
+		Bool()
+	na = app.Flag("na", "Value printed for NULL in TSV output.").
+		Default("NA").String()
+	quote = app.Flag("quote", "Quote TSV fields with tabs, newlines or quotes instead of escaping them.").
+		Bool()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// read names to fetch.
+	names := *qnames
+	if *qnamesFile != "" {
+		f, err := os.Open(*qnamesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if name := strings.TrimSpace(sc.Text()); name != "" {
+				names = append(names, name)
+			}
+		}
+		if err = sc.Err(); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+	if len(names) == 0 {
+		kingpin.Fatalf("no read names given; use --qname or --qnames")
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	selCols, err := htsdb.ParseColumns(*columns)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, selCols); err != nil {
+		log.Fatal(err)
+	}
+
+	// create index or warn about full table scans.
+	if *index == true {
+		if err = htsdb.CheckUnlocked(db, *force); err != nil {
+			log.Fatal(err)
+		}
+		if err = htsdb.CreateQnameIndex(db, *tab, cols.Column("qname")); err != nil {
+			log.Fatal(err)
+		}
+	} else if ok, err := htsdb.IndexExists(db, htsdb.QnameIndexName(*tab)); err != nil {
+		log.Fatal(err)
+	} else if !ok {
+		log.Printf("warning: read names are not indexed; use --index for fast lookups\n")
+	}
+
+	// restrict to regions and exclude blacklisted regions.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	where := func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		if regsFilter != "" {
+			return b.Where(regsFilter)
+		}
+		return b
+	}
+
+	// fetch records.
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if *format == "sam" {
+		samB, err := htsdb.SamRecordColumnsBuilder(selCols)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var recs []interface{}
+		for _, name := range names {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "nothing was printed")
+			}
+			var named []htsdb.SamRecord
+			err = htsdb.SelectByName(db, where(samB.From(table)), name, &named)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for i := range named {
+				recs = append(recs, &named[i])
+			}
+		}
+		src := htsdb.NewSliceSource(recs, nil)
+		if err = export.WriteSAM(w, src, export.HeaderOpts{}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	tw := htsdb.NewTSVWriter(w)
+	tw.NA, tw.Quote = *na, *quote
+	defer tw.Flush()
+	readsB := squirrel.Select("*")
+	if len(selCols) > 0 {
+		readsB = squirrel.Select(selCols...)
+	}
+	for i, name := range names {
+		if ctx.Err() != nil {
+			if err = tw.Flush(); err != nil {
+				log.Fatal(err)
+			}
+			if err = w.Flush(); err != nil {
+				log.Fatal(err)
+			}
+			cli.Interrupted(stop, "output is partial")
+		}
+		query, args, err := where(readsB.From(table)).
+			Where("qname = ?", name).ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows, err := db.QueryxContext(ctx, db.Rebind(query), args...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i == 0 && *header == true {
+			colNames, err := rows.Columns()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = tw.WriteStrings(colNames); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for rows.Next() {
+			vals, err := rows.SliceScan()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = tw.Write(vals...); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		rows.Close()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-from-bed"
+const version = "0.7"
+const descr = `Create a database table from the intervals of a BED file with 3 to
+12 columns so that the htsdb tools can run on interval data that did not come
+from an aligner. The table has the same columns as tables created by
+htsdb-import. The name is stored as qname, the strand as 1, -1 or 0 for
+unknown, and the blocks of BED12 records as a CIGAR with skipped regions
+between them. Each interval counts once unless --score-copy-number is given,
+in which case the score, which must be a positive integer, is the copy number.
+Start and stop follow the coordinate convention given by --coords, which is
+stored in the metadata of the database and must match that of its other
+tables. The table must not exist.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database; created if missing.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	bedFile = app.Flag("bed", "BED file; may be gzipped, - for stdin.").
+		PlaceHolder("<file>").Required().String()
+	scoreCopyNum = app.Flag("score-copy-number", "Use the score column as copy number.").
+			Bool()
+	coordsName = app.Flag("coords", "Coordinate convention of start and stop e.g. htsdb, bed, sam.").
+			Default("htsdb").String()
+	noIndex = app.Flag("no-index", "Do not index the table on rname and start.").
+		Bool()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	coords, err := htsdb.ParseCoords(*coordsName)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open BED file.
+	var r io.Reader = os.Stdin
+	if *bedFile != "-" {
+		f, err := os.Open(*bedFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(*bedFile, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+	if _, err = htsdb.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.InitCoords(db, coords); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.CreateImportTable(db, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// load intervals and index them once loaded.
+	w, err := htsdb.NewWriter(db, *tab, htsdb.ImportRecord{}, htsdb.DefaultLoadOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *noIndex == false {
+		for _, stmt := range htsdb.SampleIndexes(*tab) {
+			w.DeferIndex(stmt)
+		}
+	}
+	var n int
+	err = htsdb.ScanBED(r, func(b htsdb.BEDRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		copies := 1
+		if *scoreCopyNum == true {
+			var err error
+			if copies, err = b.ScoreCopyNumber(); err != nil {
+				return err
+			}
+		}
+		n++
+		return w.Write(b.ImportRecord(htsdb.HtsdbCoords, copies))
+	})
+	if err != nil {
+		w.Abort()
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "import is partial")
+		}
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("imported:%d\n", n)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-import-chimeras"
+const version = "0.4"
+const descr = `Store the chimeric alignments of a SAM file in the chimera table
+of a database. Each primary alignment with an SA tag is split into segments
+that are ordered from the 5' end of the read; every pair of consecutive
+segments is stored as one row linking the two segments and the positions at
+which they are joined. Coordinates follow the convention of the database.
+Chimeras are appended to the table; --dup determines how chimeras identical to
+a stored one, except for copy number, are handled. The import is committed in
+batches and the number of committed SAM records is stored under the metadata
+key ` + htsdb.ChimeraImportMarkerKey + `; an interrupted import is resumed by
+passing that number to --since. Only SQLite databases are supported since
+duplicate chimeras are updated by rowid.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	samFile = app.Flag("sam", "SAM file with chimeric alignments; may be gzipped, - for stdin.").
+		PlaceHolder("<file>").Required().String()
+	dup = app.Flag("dup", "Policy for chimeras identical to a stored one: keep, skip, sum copy numbers or error.").
+		Default("keep").Enum(htsdb.DupPolicies...)
+	since = app.Flag("since", "Skip this number of SAM records, excluding headers, to resume an import.").
+		PlaceHolder("<n>").Default("0").Int()
+	batch = app.Flag("batch", "Number of SAM records per committed batch.").
+		Default("1000000").Int()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open SAM file.
+	var r io.Reader = os.Stdin
+	if *samFile != "-" {
+		f, err := os.Open(*samFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(*samFile, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+
+	// open database connections.
+	db, err := sqlx.Connect("sqlite3", *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.CreateChimeraTable(db); err != nil {
+		log.Fatal(err)
+	}
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// index read names to look up duplicates.
+	policy, err := htsdb.ParseDupPolicy(*dup)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if policy != htsdb.DupKeep {
+		if err = htsdb.CreateQnameIndex(db, htsdb.ChimeraTable, "qname"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// import chimeras in batches, storing the number of committed records.
+	ins := &htsdb.ChimeraInserter{Policy: policy}
+	var tx *sqlx.Tx
+	begin := func() error {
+		var err error
+		if tx, err = db.Beginx(); err != nil {
+			return err
+		}
+		return ins.Begin(tx)
+	}
+	commit := func(n int) error {
+		if err := htsdb.SetMeta(tx, htsdb.ChimeraImportMarkerKey, strconv.Itoa(n)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err = begin(); err != nil {
+		log.Fatal(err)
+	}
+	committed := *since
+	n, err := htsdb.ScanSAMChimeras(r, *since, func(n int, c htsdb.Chimera) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if n-1-committed >= *batch {
+			if err := commit(n - 1); err != nil {
+				return err
+			}
+			committed = n - 1
+			if *verbose == true {
+				log.Printf("committed records:%d\n", committed)
+			}
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		return ins.Insert(c.FromHtsdb(coords))
+	})
+	if err != nil {
+		tx.Rollback()
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, fmt.Sprintf("resume with --since %d", committed))
+		}
+		log.Fatalf("%s; resume with --since %d", err, committed)
+	}
+	if err = commit(n); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("records:%d, inserted:%d, skipped:%d, summed:%d\n", n,
+			ins.Inserted, ins.Skipped, ins.Summed)
+	}
+}
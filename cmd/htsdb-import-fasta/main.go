@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-import-fasta"
+const version = "0.5"
+const descr = `Store the reference sequences of a FASTA file in the reference
+table of a database. The length and MD5 checksum of each sequence are always
+stored; the sequence itself can be omitted. If the database contains records,
+their references are checked against the imported sequences to detect
+mismatched genome builds.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	fastaFile = app.Flag("fasta", "FASTA file with reference sequences; may be gzipped.").
+			PlaceHolder("<file>").Required().String()
+	noSeq = app.Flag("no-seq", "Store only sequence lengths and checksums.").
+		Bool()
+	tab = app.Flag("table", "Database table with records to check against the sequences.").
+		Default("sample").String()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open FASTA file.
+	f, err := os.Open(*fastaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(*fastaFile, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.CreateRefSeqTable(db); err != nil {
+		log.Fatal(err)
+	}
+
+	// import sequences.
+	err = htsdb.ReadFasta(r, func(name string, seq []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := htsdb.NewRefSeq(name, seq, *noSeq == false)
+		if *verbose == true {
+			log.Printf("name:%s, length:%d, md5:%s\n", s.Name, s.Length, s.MD5)
+		}
+		return htsdb.InsertRefSeq(db, s)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "import is partial")
+		}
+		log.Fatal(err)
+	}
+
+	// check records against the imported sequences.
+	ok, err := htsdb.TableExists(db, *tab)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		return
+	}
+	refs, err := htsdb.SelectReferencesContext(ctx, db, htsdb.ReferenceBuilder.From(*tab))
+	if err != nil {
+		log.Fatal(err)
+	}
+	seqs, err := htsdb.SelectRefSeqs(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range htsdb.CheckRefSeqs(refs, seqs) {
+		log.Printf("warning: %s\n", p)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-import"
+const version = "0.9"
+const descr = `Create a database table from the alignments of a SAM or BAM file.
+The table holds all SAM fields (qname, flag, rname, pos, mapq, cigar, rnext,
+pnext, tlen, seq, qual, tags) plus the start, stop, strand and copy_number
+columns used by the other htsdb tools; copy_number is 1. Start and stop follow
+the coordinate convention given by --coords, which is stored in the metadata of
+the database and must match that of its other tables. The references of the
+header are stored in the reference table. With --annotate-seq the gc,
+max_homopolymer and dust columns of htsdb-annotate-seq are also filled while
+importing. Unmapped reads are skipped. Files
+ending in .bam are read as BAM and files ending in .gz as gzipped SAM. The
+table must not exist.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database; created if missing.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Register the imported table as a sample of the samples registry.").
+		PlaceHolder("<name>").String()
+	inFile = app.Flag("in", "SAM or BAM file; - for SAM from stdin.").
+		PlaceHolder("<file>").Required().String()
+	coordsName = app.Flag("coords", "Coordinate convention of start and stop e.g. htsdb, bed, sam.").
+			Default("htsdb").String()
+	annotateSeq = app.Flag("annotate-seq", "Store the GC fraction, longest homopolymer and DUST score of each read.").
+			Bool()
+	noIndex = app.Flag("no-index", "Do not index the table on rname and start.").
+		Bool()
+	unsafe = app.Flag("unsafe", "Disable synchronous writes for a faster import; a crash may corrupt the database.").
+		Bool()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	coords, err := htsdb.ParseCoords(*coordsName)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open SAM or BAM file.
+	var r io.Reader = os.Stdin
+	if *inFile != "-" {
+		f, err := os.Open(*inFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(*inFile, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+	var src htsdb.SAMSource
+	var hdr *sam.Header
+	if strings.HasSuffix(*inFile, ".bam") {
+		br, err := bam.NewReader(r, 1)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer br.Close()
+		src, hdr = br, br.Header()
+	} else {
+		sr, err := sam.NewReader(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		src, hdr = sr, sr.Header()
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+	if _, err = htsdb.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.InitCoords(db, coords); err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.CreateImportTable(db, *tab); err != nil {
+		log.Fatal(err)
+	}
+	var rec interface{} = htsdb.ImportRecord{}
+	if *annotateSeq == true {
+		if err = htsdb.AddSeqAnnotationColumns(db, *tab); err != nil {
+			log.Fatal(err)
+		}
+		rec = htsdb.AnnotatedImportRecord{}
+	}
+
+	// store header references.
+	seqs, err := htsdb.HeaderRefSeqs(hdr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(seqs) > 0 {
+		if err = htsdb.CreateRefSeqTable(db); err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range seqs {
+			if err = htsdb.InsertRefSeq(db, s); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// load alignments and index them once loaded.
+	opts := htsdb.DefaultLoadOptions
+	opts.Unsafe = *unsafe
+	w, err := htsdb.NewWriter(db, *tab, rec, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *noIndex == false {
+		for _, stmt := range htsdb.SampleIndexes(*tab) {
+			w.DeferIndex(stmt)
+		}
+	}
+	n, unmapped, err := htsdb.ImportAlignmentsContext(ctx, w, src)
+	if err != nil {
+		w.Abort()
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "import is partial")
+		}
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if *sample != "" {
+		if err = htsdb.AddSample(db, htsdb.Sample{Name: *sample, RecordTable: *tab}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *verbose == true {
+		log.Printf("imported:%d, unmapped:%d, references:%d\n", n, unmapped, len(seqs))
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// RecordBuilder is a squirrel select builder whose columns match Record
+// fields.
+var RecordBuilder = squirrel.Select("start", "stop", "pos", "cigar", "seq", "strand", "copy_number")
+
+// Record is a read with its alignment.
+type Record struct {
+	htsdb.Range
+	Pos    int               `db:"pos"`
+	Cigar  string            `db:"cigar"`
+	Seq    string            `db:"seq"`
+	Orient htsdb.Orientation `db:"strand"`
+}
+
+const prog = "htsdb-isomirs"
+const version = "0.2"
+const descr = `Classify small RNA reads as isomiRs of the mature sequences of a
+BED or GTF file. Each read is assigned to the overlapping feature on its
+strand whose ends are closest to its own and is classified as canonical or as
+one or more of 5' templated extension (5p_ext) or trimming (5p_trim), 3'
+templated extension (3p_ext) or trimming (3p_trim) and 3' non-templated
+addition (nta). Non-templated additions are the bases soft clipped from the
+3' end of the read and the 3' aligned bases that mismatch the reference
+sequence from --fasta or, if not given, from the reference sequences stored
+in the database. For each feature the number of reads of each class is
+printed; classes other than canonical are not exclusive. Provided SQL filter
+will apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. seq=sequence.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	featsFile = app.Flag("feats", "BED or GTF (.gtf) file with stranded mature sequences.").
+			PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. miRNA.").
+			PlaceHolder("<type>").String()
+	nameAttr = app.Flag("name-attr", "GTF attribute used as feature name.").
+			Default("gene_name").String()
+	fasta = app.Flag("fasta", "FASTA file with the reference sequences.").
+		PlaceHolder("<file>").String()
+	flank = app.Flag("flank", "Bases around each feature read from the stored reference sequences.").
+		Default("50").Int()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *flank < 0 {
+		kingpin.Fatalf("--flank must not be negative")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read the stranded features.
+	all, err := htsdb.ReadAnnotations(*featsFile, *featType, *nameAttr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var anns []htsdb.Annotation
+	for _, a := range all {
+		if a.Strand != 0 {
+			anns = append(anns, a)
+		}
+	}
+	if len(anns) == 0 {
+		log.Fatal("no stranded features")
+	}
+	annIdx := make(map[htsdb.Annotation]int)
+	var refs []string
+	for i, a := range anns {
+		annIdx[a] = i
+		if !contains(refs, a.Rname) {
+			refs = append(refs, a.Rname)
+		}
+	}
+	sort.Strings(refs)
+	idx := htsdb.NewAnnotationIndex(anns)
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, []string{"pos", "cigar", "seq"}); err != nil {
+		log.Fatal(err)
+	}
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// get the reference sequence around a feature.
+	var fastaSeqs map[string]string
+	if *fasta != "" {
+		if fastaSeqs, err = readRefs(*fasta, refs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	windows := make(map[int]string)
+	refSeq := func(i int) (string, int, error) {
+		a := anns[i]
+		if fastaSeqs != nil {
+			return fastaSeqs[a.Rname], 0, nil
+		}
+		start := a.Start - *flank
+		if start < 0 {
+			start = 0
+		}
+		if s, ok := windows[i]; ok {
+			return s, start, nil
+		}
+		s, err := htsdb.RefSubseq(db, a.Rname, start, a.Stop+*flank)
+		if err != nil {
+			return "", 0, err
+		}
+		windows[i] = s
+		return s, start, nil
+	}
+
+	// assemble the query.
+	b := RecordBuilder.From(table).Where("rname = ?")
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	q, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stmt, err := db.Preparex(db.Rebind(q))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// counts[i][j] is the number of reads of feature i in class j.
+	counts := make([][]float64, len(anns))
+	classIdx := make(map[string]int)
+	for j, c := range htsdb.IsomiRClasses {
+		classIdx[c] = j
+	}
+	var r Record
+	var checker htsdb.RangeChecker
+	skipped := 0
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		if *verbose == true {
+			log.Printf("chrom:%s\n", ref)
+		}
+		rows, err := stmt.QueryxContext(ctx, ref)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				log.Fatal(err)
+			}
+			coords.Normalize(&r.Range)
+			if !checker.Check(&r.Range) || r.Orient == htsdb.Unknown {
+				continue
+			}
+
+			// assign the read to the feature with the closest ends.
+			best, bestDist := -1, 0
+			for _, a := range idx.Overlapping(ref, r.StartPos, r.StopPos, int(r.Orient)) {
+				d5, d3 := a.EndOffsets(r.StartPos, r.StopPos)
+				if d := abs(d5) + abs(d3); best < 0 || d < bestDist {
+					best, bestDist = annIdx[a], d
+				}
+			}
+			if best < 0 {
+				continue
+			}
+			seq, seqStart, err := refSeq(best)
+			if err != nil {
+				log.Fatal(err)
+			}
+			iso, err := htsdb.ClassifyIsomiR(anns[best], r.Pos, r.Cigar, r.Seq, r.Orient, seq, seqStart)
+			if err != nil {
+				skipped++
+				continue
+			}
+			w := 1.0
+			if *copyNum == true {
+				w = float64(r.CopyNumber)
+			}
+			if counts[best] == nil {
+				counts[best] = make([]float64, len(htsdb.IsomiRClasses)+1)
+			}
+			counts[best][0] += w
+			for _, c := range iso.Classes() {
+				counts[best][classIdx[c]+1] += w
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+	}
+	if ctx.Err() != nil {
+		db.Close()
+		cli.Interrupted(stop, "nothing was printed")
+	}
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+	if skipped > 0 {
+		log.Printf("warning: skipped %d reads without sequence or with inconsistent CIGAR\n", skipped)
+	}
+
+	// print the per feature summary.
+	tsv := htsdb.NewTSVWriter(os.Stdout)
+	header := append([]string{"name", "rname", "start", "end", "strand", "reads"}, htsdb.IsomiRClasses...)
+	if err = tsv.WriteStrings(header); err != nil {
+		log.Fatal(err)
+	}
+	for i, a := range anns {
+		strand := "+"
+		if a.Strand < 0 {
+			strand = "-"
+		}
+		c := counts[i]
+		if c == nil {
+			c = make([]float64, len(htsdb.IsomiRClasses)+1)
+		}
+		start, end := htsdb.BEDCoords.FromHtsdb(a.Start, a.Stop)
+		row := []interface{}{a.Name, a.Rname, start, end, strand}
+		for _, n := range c {
+			row = append(row, n)
+		}
+		if err = tsv.Write(row...); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = tsv.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readRefs returns the sequences of the references names in the FASTA file
+// f. Other sequences are not kept in memory.
+func readRefs(f string, names []string) (map[string]string, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	refs := make(map[string]string)
+	err = htsdb.ReadFasta(fh, func(name string, seq []byte) error {
+		if contains(names, name) {
+			refs[name] = strings.ToUpper(string(seq))
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
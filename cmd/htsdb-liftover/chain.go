@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chain is a UCSC chain with the header information that is needed to
+// convert coordinates.
+type chain struct {
+	score    int
+	qName    string
+	qSize    int
+	qReverse bool
+	blocks   []block
+}
+
+// block is an ungapped alignment block of a chain. tStart and tEnd are
+// 0-based, half-open coordinates on the target (old) assembly and qStart is
+// the corresponding start on the query (new) assembly strand of the chain.
+// Adjacent blocks without a gap on either assembly are merged.
+type block struct {
+	tStart, tEnd int
+	qStart       int
+}
+
+// add appends a block of size bases at tPos and qPos to c.
+func (c *chain) add(tPos, qPos, size int) {
+	if n := len(c.blocks); n > 0 {
+		last := &c.blocks[n-1]
+		if last.tEnd == tPos && last.qStart+last.tEnd-last.tStart == qPos {
+			last.tEnd += size
+			return
+		}
+	}
+	c.blocks = append(c.blocks, block{tStart: tPos, tEnd: tPos + size, qStart: qPos})
+}
+
+// blockAt returns the block of c that contains the target position pos and
+// false if pos is in a gap or outside c.
+func (c *chain) blockAt(pos int) (block, bool) {
+	i := sort.Search(len(c.blocks), func(i int) bool {
+		return c.blocks[i].tStart > pos
+	}) - 1
+	if i < 0 || pos >= c.blocks[i].tEnd {
+		return block{}, false
+	}
+	return c.blocks[i], true
+}
+
+// liftPos converts the target position pos in block b of c to the query
+// assembly.
+func (c *chain) liftPos(b block, pos int) int {
+	q := b.qStart + pos - b.tStart
+	if c.qReverse {
+		q = c.qSize - 1 - q
+	}
+	return q
+}
+
+// refChains are the chains of a target reference sorted by the start of their
+// first block. maxEnd[i] is the largest end of chains[:i+1] so that the
+// chains overlapping a position are found without scanning all of them.
+type refChains struct {
+	chains []*chain
+	maxEnd []int
+}
+
+// chainIndex holds the chains grouped by target reference.
+type chainIndex map[string]*refChains
+
+// readChains reads a UCSC chain file from r and returns the indexed chains.
+func readChains(r io.Reader) (chainIndex, error) {
+	byRef := make(map[string][]*chain)
+	sc := bufio.NewScanner(r)
+	var c *chain
+	var tPos, qPos, line int
+	for sc.Scan() {
+		line++
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "chain" {
+			if len(fields) < 12 {
+				return nil, fmt.Errorf("line %d: malformed chain header", line)
+			}
+			ints, err := atois(fields[1], fields[3], fields[5], fields[8], fields[10])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			c = &chain{score: ints[0], qName: fields[7], qSize: ints[3],
+				qReverse: fields[9] == "-"}
+			byRef[fields[2]] = append(byRef[fields[2]], c)
+			tPos, qPos = ints[2], ints[4]
+			continue
+		}
+		if c == nil {
+			return nil, fmt.Errorf("line %d: alignment data outside chain", line)
+		}
+		ints, err := atois(fields...)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(ints) != 1 && len(ints) != 3 {
+			return nil, fmt.Errorf("line %d: malformed alignment data", line)
+		}
+		size := ints[0]
+		c.add(tPos, qPos, size)
+		if len(ints) == 3 {
+			tPos += size + ints[1]
+			qPos += size + ints[2]
+		} else {
+			c = nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	idx := make(chainIndex)
+	for ref, chains := range byRef {
+		rc := &refChains{}
+		for _, c := range chains {
+			if len(c.blocks) > 0 {
+				rc.chains = append(rc.chains, c)
+			}
+		}
+		sort.SliceStable(rc.chains, func(i, j int) bool {
+			return rc.chains[i].blocks[0].tStart < rc.chains[j].blocks[0].tStart
+		})
+		rc.maxEnd = make([]int, len(rc.chains))
+		for i, c := range rc.chains {
+			rc.maxEnd[i] = c.blocks[len(c.blocks)-1].tEnd
+			if i > 0 && rc.maxEnd[i-1] > rc.maxEnd[i] {
+				rc.maxEnd[i] = rc.maxEnd[i-1]
+			}
+		}
+		idx[ref] = rc
+	}
+	return idx, nil
+}
+
+// best returns the highest-scoring chain on rname with a block that contains
+// the target positions from start to stop, inclusive, and that block. It
+// returns false if there is no such chain.
+func (idx chainIndex) best(rname string, start, stop int) (*chain, block, bool) {
+	rc := idx[rname]
+	if rc == nil {
+		return nil, block{}, false
+	}
+	var bestC *chain
+	var bestB block
+	i := sort.Search(len(rc.chains), func(i int) bool {
+		return rc.chains[i].blocks[0].tStart > start
+	}) - 1
+	for ; i >= 0 && rc.maxEnd[i] > start; i-- {
+		c := rc.chains[i]
+		b, ok := c.blockAt(start)
+		if !ok || stop >= b.tEnd {
+			continue
+		}
+		if bestC == nil || c.score >= bestC.score {
+			bestC, bestB = c, b
+		}
+	}
+	return bestC, bestB, bestC != nil
+}
+
+// lift converts the 0-based position pos on the target reference rname to the
+// new assembly through the highest-scoring chain that covers it. It returns
+// the new reference name and position, the chain that was used and false if
+// pos is not covered by any block.
+func (idx chainIndex) lift(rname string, pos int) (string, int, *chain, bool) {
+	c, b, ok := idx.best(rname, pos, pos)
+	if !ok {
+		return "", 0, nil, false
+	}
+	return c.qName, c.liftPos(b, pos), c, true
+}
+
+// liftInterval converts the 0-based, closed interval start-stop on the target
+// reference rname to the new assembly through the highest-scoring chain with a
+// single block that contains it, so that the interval is not lifted across
+// gaps or indels. It returns false if there is no such chain.
+func (idx chainIndex) liftInterval(rname string, start, stop int) (string, int, int, *chain, bool) {
+	c, b, ok := idx.best(rname, start, stop)
+	if !ok {
+		return "", 0, 0, nil, false
+	}
+	s, e := c.liftPos(b, start), c.liftPos(b, stop)
+	if s > e {
+		s, e = e, s
+	}
+	return c.qName, s, e, c, true
+}
+
+func atois(s ...string) ([]int, error) {
+	ints := make([]int, len(s))
+	for i := range s {
+		v, err := strconv.Atoi(s[i])
+		if err != nil {
+			return nil, err
+		}
+		ints[i] = v
+	}
+	return ints, nil
+}
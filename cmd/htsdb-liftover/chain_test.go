@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// overlapping has, on chr1, a low-scoring chain to chrA that overlaps a
+// high-scoring chain to chrB and a chain to chrC with a gap at 250-260 that
+// is an insertion of 10 bases in chrC. On chr2 it has a chain whose two blocks
+// are adjacent on both assemblies.
+const overlapping = `chain 50 chr1 1000 + 0 100 chrA 1000 + 0 100 1
+100
+
+chain 500 chr1 1000 + 40 60 chrB 1000 + 200 220 2
+20
+
+chain 200 chr1 1000 + 200 320 chrC 1000 + 0 130 3
+50 10 20
+60
+
+chain 10 chr2 100 + 0 20 chrD 100 + 0 20 4
+10 0 0
+10
+`
+
+func TestLiftChains(t *testing.T) {
+	idx, err := readChains(strings.NewReader(overlapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rname       string
+		start, stop int
+		name        string
+		s, e        int
+		reason      string
+	}{
+		{"chr1", 10, 14, "chrA", 10, 14, ""},
+		{"chr1", 45, 50, "chrB", 205, 210, ""},
+		{"chr1", 35, 45, "chrA", 35, 45, ""},
+		{"chr1", 270, 280, "chrC", 80, 90, ""},
+		{"chr1", 240, 265, "", 0, 0, "gapped"},
+		{"chr1", 245, 255, "", 0, 0, "partially deleted"},
+		{"chr1", 250, 255, "", 0, 0, "deleted"},
+		{"chr1", 95, 210, "", 0, 0, "split"},
+		{"chr2", 5, 15, "chrD", 5, 15, ""},
+		{"chr3", 5, 15, "", 0, 0, "deleted"},
+	}
+	for _, tt := range tests {
+		name, s, e, _, reason := lift(idx, tt.rname, tt.start, tt.stop)
+		if name != tt.name || s != tt.s || e != tt.e || reason != tt.reason {
+			t.Errorf("%s:%d-%d: expected %s:%d-%d %q, actual %s:%d-%d %q",
+				tt.rname, tt.start, tt.stop, tt.name, tt.s, tt.e, tt.reason,
+				name, s, e, reason)
+		}
+	}
+
+	// mates are lifted through the highest-scoring chain too.
+	if name, q, _, ok := idx.lift("chr1", 45); !ok || name != "chrB" || q != 205 {
+		t.Errorf("expected chrB:205, actual %s:%d %t", name, q, ok)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-liftover"
+const version = "0.6"
+const descr = `Convert the coordinates of database records between genome
+assemblies using a UCSC chain file. Records are written to a new SQLite
+database with the same table schema, which can only be copied from SQLite and
+DuckDB databases. A record is lifted only if it lies within a single ungapped
+block of a chain, using the highest-scoring chain if several overlap; all other
+records are reported in the unmapped file with the reason: deleted, partially
+deleted, gapped (the record spans a gap or indel of a chain) or split. Records
+lifted through a chain on the opposite strand have their strand negated and,
+for SAM tables, the reverse complemented bit of flag flipped, seq reverse
+complemented and qual and cigar reversed; tags are copied unchanged. Mate
+positions (rnext, pnext) are lifted too or, if they cannot be, set to * and 0;
+tlen is set to 0 unless the distance to the mate is unchanged. Provided SQL
+filter will apply to all records.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	chainFile = app.Flag("chain", "UCSC chain file; may be gzipped.").
+			PlaceHolder("<file>").Required().String()
+	outFile = app.Flag("out", "File to new SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	unmappedFile = app.Flag("unmapped", "File to write unmapped records.").
+			PlaceHolder("<file>").Required().String()
+	noSync = app.Flag("no-sync", "Disable synchronous writes to the new database for speed; a crash may corrupt it.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// read chains.
+	idx, err := openChains(*chainFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// open database connections.
+	var db, out *sqlx.DB
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if out, err = htsdb.Connect(htsdb.SQLite, *outFile); err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	// copy table schema and coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schema, err := htsdb.TableSQL(db, *tab)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = htsdb.InitCoords(out, coords); err != nil {
+		log.Fatal(err)
+	}
+	if _, err = out.Exec(schema); err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble sqlx select builders
+	readsB := squirrel.Select("*").From(*tab)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		readsB = readsB.Where(regsFilter)
+	}
+	query, _, err := readsB.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	// locate the coordinate columns.
+	cols, err := rows.Columns()
+	if err != nil {
+		log.Fatal(err)
+	}
+	colIdx := map[string]int{"pos": -1, "strand": -1}
+	for _, c := range samCols {
+		colIdx[c] = -1
+	}
+	for i, c := range cols {
+		colIdx[c] = i
+	}
+	for _, c := range []string{"rname", "start", "stop"} {
+		if _, ok := colIdx[c]; !ok {
+			log.Fatalf("table %s has no column %s", *tab, c)
+		}
+	}
+
+	// open loader; indexes are created after loading.
+	opts := htsdb.DefaultLoadOptions
+	opts.Unsafe = *noSync
+	loader, err := htsdb.NewLoader(out, *tab, cols, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexes, err := htsdb.IndexSQL(db, *tab)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, stmt := range indexes {
+		loader.DeferIndex(stmt)
+	}
+
+	// open unmapped report.
+	f, err := os.Create(*unmappedFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	unmapped := bufio.NewWriter(f)
+	defer unmapped.Flush()
+	fmt.Fprintf(unmapped, "rname\tstart\tstop\tstrand\treason\n")
+
+	// lift records.
+	var mappedCnt, unmappedCnt int
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rname := toString(vals[colIdx["rname"]])
+		start, stop := toInt(vals[colIdx["start"]]), toInt(vals[colIdx["stop"]])
+		strand := 0
+		if i := colIdx["strand"]; i >= 0 {
+			strand = toInt(vals[i])
+		}
+
+		start, stop = coords.ToHtsdb(start, stop)
+		newRname, newStart, newStop, rev, reason := lift(idx, rname, start, stop)
+		if reason != "" {
+			s, e := coords.FromHtsdb(start, stop)
+			fmt.Fprintf(unmapped, "%s\t%d\t%d\t%d\t%s\n", rname, s, e, strand,
+				reason)
+			unmappedCnt++
+			continue
+		}
+		if rev {
+			strand = int(-1 * feat.Orientation(strand))
+			reverseSAM(vals, colIdx)
+		}
+		liftMate(idx, vals, colIdx, rname, start, newRname, newStart, rev)
+		newStart, newStop = coords.FromHtsdb(newStart, newStop)
+
+		vals[colIdx["rname"]] = newRname
+		vals[colIdx["start"]] = newStart
+		vals[colIdx["stop"]] = newStop
+		if i := colIdx["strand"]; i >= 0 {
+			vals[i] = strand
+		}
+		if i := colIdx["pos"]; i >= 0 {
+			vals[i] = newStart - coords.Base + 1
+		}
+		if err = loader.Add(vals...); err != nil {
+			log.Fatal(err)
+		}
+		mappedCnt++
+	}
+	if err = rows.Err(); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+	if err = loader.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		if err = unmapped.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		cli.Interrupted(stop, "output is partial")
+	}
+
+	if *verbose == true {
+		log.Printf("mapped:%d, unmapped:%d\n", mappedCnt, unmappedCnt)
+	}
+}
+
+// lift converts the 0-based, closed interval start-stop on rname to the new
+// assembly. It returns a non-empty reason if the interval cannot be lifted
+// because it is not within a single ungapped block of a chain.
+func lift(idx chainIndex, rname string, start, stop int) (
+	newRname string, newStart, newStop int, rev bool, reason string) {
+
+	if name, s, e, c, ok := idx.liftInterval(rname, start, stop); ok {
+		return name, s, e, c.qReverse, ""
+	}
+	_, _, c1, ok1 := idx.lift(rname, start)
+	_, _, c2, ok2 := idx.lift(rname, stop)
+	switch {
+	case !ok1 && !ok2:
+		return "", 0, 0, false, "deleted"
+	case !ok1 || !ok2:
+		return "", 0, 0, false, "partially deleted"
+	case c1 == c2:
+		return "", 0, 0, false, "gapped"
+	}
+	return "", 0, 0, false, "split"
+}
+
+func openChains(f string) (chainIndex, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if strings.HasSuffix(f, ".gz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return readChains(r)
+}
+
+func toInt(v interface{}) int {
+	switch t := v.(type) {
+	case int:
+		return t
+	case int64:
+		return int(t)
+	case float64:
+		return int(t)
+	case []byte:
+		i, _ := strconv.Atoi(string(t))
+		return i
+	case string:
+		i, _ := strconv.Atoi(t)
+		return i
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
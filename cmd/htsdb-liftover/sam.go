@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mnsmar/htsdb/v2"
+)
+
+// SAM FLAG bits of the strand of a record and of its mate.
+const (
+	flagReverse     = 0x10
+	flagMateReverse = 0x20
+)
+
+// samCols are the columns of SAM records that are updated when records are
+// lifted. Tables without them are lifted by their coordinates only.
+var samCols = []string{"flag", "cigar", "rnext", "pnext", "tlen", "seq", "qual"}
+
+// reverseSAM updates the SAM columns of vals, indexed by colIdx, of a record
+// lifted through a chain on the reverse strand: the reverse complemented bit
+// of flag is flipped, seq is reverse complemented and qual and the operations
+// of cigar are reversed. Tags e.g. MD are copied unchanged.
+func reverseSAM(vals []interface{}, colIdx map[string]int) {
+	if i := colIdx["flag"]; i >= 0 && vals[i] != nil {
+		vals[i] = toInt(vals[i]) ^ flagReverse
+	}
+	if i := colIdx["seq"]; i >= 0 && vals[i] != nil {
+		if s := toString(vals[i]); s != "*" {
+			vals[i] = htsdb.ReverseComplement(s)
+		}
+	}
+	if i := colIdx["qual"]; i >= 0 && vals[i] != nil {
+		if q := toString(vals[i]); q != "*" {
+			vals[i] = reverse(q)
+		}
+	}
+	if i := colIdx["cigar"]; i >= 0 && vals[i] != nil {
+		vals[i] = reverseCigar(toString(vals[i]))
+	}
+}
+
+// liftMate lifts the mate position in the rnext and pnext columns of vals,
+// indexed by colIdx, of a record whose 0-based start on rname was lifted to
+// newStart on newRname, through a chain on the reverse strand if rev is true.
+// Mates that cannot be lifted, or that are lifted through a chain on the
+// reverse strand so that their leftmost position depends on their unknown
+// end, are marked as unavailable (*, 0). tlen is set to 0 unless the record
+// and its mate are lifted through the forward strand and their distance is
+// unchanged.
+func liftMate(idx chainIndex, vals []interface{}, colIdx map[string]int,
+	rname string, start int, newRname string, newStart int, rev bool) {
+
+	iNext, iPos := colIdx["rnext"], colIdx["pnext"]
+	if iNext < 0 || iPos < 0 {
+		return
+	}
+	rnext, pnext := toString(vals[iNext]), toInt(vals[iPos])
+	if rnext == "*" || rnext == "" || pnext == 0 {
+		return
+	}
+	mateRef := rnext
+	if rnext == "=" {
+		mateRef = rname
+	}
+	name, q, c, ok := idx.lift(mateRef, pnext-1)
+	keepTlen := false
+	if !ok || c.qReverse {
+		vals[iNext], vals[iPos] = "*", 0
+	} else {
+		vals[iNext], vals[iPos] = name, q+1
+		if name == newRname {
+			vals[iNext] = "="
+		}
+		keepTlen = !rev && mateRef == rname && name == newRname &&
+			q-newStart == pnext-1-start
+	}
+	if i := colIdx["flag"]; i >= 0 && vals[i] != nil && ok && c.qReverse {
+		vals[i] = toInt(vals[i]) ^ flagMateReverse
+	}
+	if i := colIdx["tlen"]; i >= 0 && !keepTlen {
+		vals[i] = 0
+	}
+}
+
+// reverseCigar returns the operations of cigar in reverse order. cigar is
+// returned unchanged if it is not a valid CIGAR e.g. "*".
+func reverseCigar(cigar string) string {
+	var ops []string
+	for len(cigar) > 0 {
+		i := strings.IndexAny(cigar, "MIDNSHP=X")
+		if i < 1 {
+			return cigar
+		}
+		ops = append(ops, cigar[:i+1])
+		cigar = cigar[i+1:]
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return strings.Join(ops, "")
+}
+
+// reverse returns s in reverse order.
+func reverse(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		b[len(s)-1-i] = s[i]
+	}
+	return string(b)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chains has a forward chain for chr1 and a reverse chain for chr2.
+const chains = `chain 100 chr1 100 + 0 100 chrA 200 + 50 150 1
+100
+
+chain 100 chr2 100 + 0 100 chrB 100 - 0 100 2
+100
+`
+
+func TestLiftReverseBlock(t *testing.T) {
+	idx, err := readChains(strings.NewReader(chains))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := []string{"rname", "start", "stop", "strand", "flag", "cigar",
+		"rnext", "pnext", "tlen", "seq", "qual"}
+	colIdx := make(map[string]int)
+	for i, c := range cols {
+		colIdx[c] = i
+	}
+
+	// a read on chr2:10-14 whose mate, on chr1, lifts through the forward chain.
+	vals := []interface{}{"chr2", 10, 14, 1, 0x1 | 0x40, "2S3M", "chr1", 21, 0,
+		"AACGT", "ABCDE"}
+	name, s, e, rev, reason := lift(idx, "chr2", 10, 14)
+	if reason != "" || name != "chrB" || s != 85 || e != 89 || !rev {
+		t.Fatalf("unexpected lift %s:%d-%d rev:%t reason:%q", name, s, e, rev, reason)
+	}
+	reverseSAM(vals, colIdx)
+	liftMate(idx, vals, colIdx, "chr2", 10, name, s, rev)
+	exp := []interface{}{"chr2", 10, 14, 1, 0x1 | 0x40 | flagReverse, "3M2S",
+		"chrA", 71, 0, "ACGTT", "EDCBA"}
+	if !reflect.DeepEqual(vals, exp) {
+		t.Errorf("expected %v, actual %v", exp, vals)
+	}
+
+	// a read on chr1 whose mate lifts through the reverse chain.
+	vals = []interface{}{"chr1", 10, 14, 1, 0x1, "5M", "chr2", 31, 25,
+		"ACGTA", "IIIII"}
+	liftMate(idx, vals, colIdx, "chr1", 10, "chrA", 60, false)
+	exp = []interface{}{"chr1", 10, 14, 1, 0x1 | flagMateReverse, "5M", "*", 0, 0,
+		"ACGTA", "IIIII"}
+	if !reflect.DeepEqual(vals, exp) {
+		t.Errorf("expected %v, actual %v", exp, vals)
+	}
+
+	// tlen is kept for mates at an unchanged distance.
+	vals = []interface{}{"chr1", 10, 14, 1, 0x1, "5M", "=", 31, 25,
+		"ACGTA", "IIIII"}
+	liftMate(idx, vals, colIdx, "chr1", 10, "chrA", 60, false)
+	if vals[6] != "=" || vals[7] != 81 || vals[8] != 25 {
+		t.Errorf("expected mate =:81 and tlen 25, actual %v:%v and %v", vals[6], vals[7], vals[8])
+	}
+}
+
+func TestReverseCigar(t *testing.T) {
+	for cigar, exp := range map[string]string{
+		"10M":       "10M",
+		"2S10M3I4M": "4M3I10M2S",
+		"*":         "*",
+	} {
+		if got := reverseCigar(cigar); got != exp {
+			t.Errorf("%s: expected %s, actual %s", cigar, exp, got)
+		}
+	}
+}
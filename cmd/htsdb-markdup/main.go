@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-markdup"
+const version = "0.6"
+const descr = `Identify duplicate records i.e. records with identical alignment
+(rname, start, stop, strand) or identical sequence, and print duplication
+metrics. Duplicates can optionally be marked in a new column or folded into
+the copy number of the first record; folding deletes the other records.
+Provided SQL filter will apply to all records. Only SQLite databases are
+supported since duplicates are identified by rowid.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	by = app.Flag("by", "Define duplicates by alignment coordinates or sequence.").
+		Default("coords").Enum("coords", "seq")
+	seqCol = app.Flag("seq-column", "Column with the read sequence.").
+		Default("seq").String()
+	mode = app.Flag("mode", "Only report metrics, mark duplicates or fold them into copy number.").
+		Default("report").Enum("report", "mark", "fold")
+	markCol = app.Flag("mark-column", "Column to mark duplicates in.").
+		Default("duplicate").String()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+)
+
+var common cli.Options
+
+// Metrics holds the duplication metrics.
+type Metrics struct {
+	Records int `db:"records"`
+	Unique  int `db:"uniq"`
+	Copies  int `db:"copies"`
+}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	key := "rname, start, stop, strand"
+	if *by == "seq" {
+		key = *seqCol
+	}
+
+	db, err := sqlx.Connect("sqlite3", *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// restrict to regions and exclude blacklisted regions.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := ""
+	if *where != "" {
+		filter = " WHERE " + *where
+	}
+	if regsFilter != "" {
+		filter = and(filter, regsFilter)
+	}
+
+	// measure duplication.
+	var m Metrics
+	err = db.GetContext(ctx, &m, "SELECT COUNT(*) AS records, "+
+		"COUNT(DISTINCT "+distinctExpr(key)+") AS uniq, "+
+		htsdb.CopyNumberSum+" AS copies FROM "+*tab+filter)
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		log.Fatal(err)
+	}
+
+	// mark or fold duplicates in a single transaction.
+	if *mode != "report" {
+		if err = htsdb.CheckUnlocked(db, *force); err != nil {
+			log.Fatal(err)
+		}
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keep := "SELECT MIN(rowid) FROM " + *tab + filter + " GROUP BY " + key
+		var stmts []string
+		if *mode == "mark" {
+			ok, err := htsdb.HasColumn(db, *tab, *markCol)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				stmts = append(stmts, "ALTER TABLE "+*tab+" ADD COLUMN "+*markCol+
+					" INTEGER DEFAULT 0")
+			}
+			stmts = append(stmts,
+				"UPDATE "+*tab+" SET "+*markCol+" = 0"+filter,
+				"UPDATE "+*tab+" SET "+*markCol+" = 1"+and(filter, "rowid NOT IN ("+keep+")"))
+		} else {
+			stmts = append(stmts,
+				"CREATE TEMP TABLE dupsums AS SELECT MIN(rowid) AS keep, "+
+					htsdb.CopyNumberSum+" AS copies FROM "+*tab+filter+" GROUP BY "+key,
+				"UPDATE "+*tab+" SET copy_number = "+
+					"(SELECT copies FROM dupsums WHERE keep = "+*tab+".rowid) "+
+					"WHERE rowid IN (SELECT keep FROM dupsums)",
+				"DELETE FROM "+*tab+and(filter, "rowid NOT IN (SELECT keep FROM dupsums)"),
+				"DROP TABLE dupsums")
+		}
+		for _, s := range stmts {
+			if _, err = tx.ExecContext(ctx, s); err != nil {
+				tx.Rollback()
+				if ctx.Err() != nil {
+					cli.Interrupted(stop, "no changes were made")
+				}
+				log.Fatal(err)
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "no changes were made")
+			}
+			log.Fatal(err)
+		}
+	}
+
+	// print metrics.
+	dups := m.Records - m.Unique
+	percent := 0.0
+	if m.Records > 0 {
+		percent = float64(dups) / float64(m.Records) * 100
+	}
+	fmt.Printf("total_records:%d\nunique_records:%d\nduplicate_records:%d\n"+
+		"percent_duplicates:%.2f\ntotal_copies:%d\n",
+		m.Records, m.Unique, dups, percent, m.Copies)
+}
+
+// distinctExpr returns an expression usable in COUNT(DISTINCT ...) for the
+// comma separated key columns.
+func distinctExpr(key string) string {
+	cols := strings.Split(key, ",")
+	if len(cols) == 1 {
+		return key
+	}
+	for i := range cols {
+		cols[i] = "COALESCE(" + strings.TrimSpace(cols[i]) + ", '')"
+	}
+	return strings.Join(cols, " || '|' || ")
+}
+
+// and appends cond to the WHERE clause filter.
+func and(filter, cond string) string {
+	if filter == "" {
+		return " WHERE " + cond
+	}
+	return filter + " AND " + cond
+}
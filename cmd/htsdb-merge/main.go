@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-merge"
+const version = "0.3"
+const descr = `Merge the records of several databases e.g. replicate libraries
+into a table of a new or existing database. The tables must have the same
+columns and coordinate convention, which must also match that of the other
+tables of an existing database. With --sum-copies identical alignments,
+records that are equal in all columns except qname and copy_number, are stored
+once with the total copy number and the smallest qname. With --samples the
+records keep their origin in a sample_id column and each database is
+registered as a sample, named after its file unless --name is given, that can
+be selected with --sample of the other commands; copies are then only summed
+within each sample. Provided SQL filter will apply to all databases.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFiles = app.Flag("db", "File to SQLite database; repeat for each database to merge.").
+		PlaceHolder("<file>").Required().Strings()
+	names = app.Flag("name", "Sample name for each database; defaults to the file name.").
+		PlaceHolder("<name>").Strings()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	outFile = app.Flag("out", "File to SQLite database to write the merged table to.").
+		PlaceHolder("<file>").Required().String()
+	sumCopies = app.Flag("sum-copies", "Store identical alignments once with their total copy number.").
+			Bool()
+	samples = app.Flag("samples", "Keep the origin of records in a sample_id column and register each database as a sample.").
+		Bool()
+	noSync = app.Flag("no-sync", "Disable synchronous writes to the output database for speed; a crash may corrupt it.").
+		Bool()
+	force = app.Flag("force", "Modify the output database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if len(*names) == 0 {
+		for _, f := range *dbFiles {
+			*names = append(*names, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)))
+		}
+	}
+	if len(*names) != len(*dbFiles) {
+		kingpin.Fatalf("expected %d names, got %d", len(*dbFiles), len(*names))
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// check that the tables of all databases are compatible.
+	var cols, indexes []string
+	var schema string
+	var coords htsdb.Coords
+	for i, f := range *dbFiles {
+		db, err := sqlx.Connect("sqlite3", f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err := htsdb.TableColumns(db, *tab)
+		if err != nil {
+			log.Fatal(err)
+		}
+		crd, err := htsdb.SelectCoords(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i == 0 {
+			cols, coords = c, crd
+			if schema, err = htsdb.TableSQL(db, *tab); err != nil {
+				log.Fatal(err)
+			}
+			if indexes, err = htsdb.IndexSQL(db, *tab); err != nil {
+				log.Fatal(err)
+			}
+		} else if !sameColumns(cols, c) {
+			log.Fatalf("table %s of %s has columns %s; expected %s", *tab, f,
+				strings.Join(c, ","), strings.Join(cols, ","))
+		} else if crd != coords {
+			log.Fatalf("%s uses %s coordinates; expected %s", f, crd, coords)
+		}
+		db.Close()
+	}
+	if !contains(cols, "copy_number") && *sumCopies == true {
+		log.Fatalf("table %s has no copy_number column", *tab)
+	}
+	if contains(cols, htsdb.SampleIDColumn) && *samples == true {
+		log.Fatalf("table %s already has a %s column", *tab, htsdb.SampleIDColumn)
+	}
+
+	// open output; attached databases are only visible to their connection.
+	out, err := sqlx.Connect("sqlite3", *outFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	out.SetMaxOpenConns(1)
+	if err = htsdb.CheckUnlocked(out, *force); err != nil {
+		log.Fatal(err)
+	}
+	if *noSync == true {
+		if _, err = out.Exec("PRAGMA synchronous = OFF"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = htsdb.InitCoords(out, coords); err != nil {
+		log.Fatal(err)
+	}
+	if _, err = out.Exec(schema); err != nil {
+		log.Fatal(err)
+	}
+	dest := *tab
+	if *samples == true {
+		if _, err = out.Exec("ALTER TABLE " + *tab + " ADD COLUMN " +
+			htsdb.SampleIDColumn + " INTEGER"); err != nil {
+			log.Fatal(err)
+		}
+		cols = append(cols, htsdb.SampleIDColumn)
+	}
+
+	// stage records in a temporary table to sum their copies.
+	if *sumCopies == true {
+		dest = "merge_staging"
+		if _, err = out.Exec("CREATE TEMP TABLE " + dest + " AS SELECT * FROM " +
+			*tab + " WHERE 0"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// restrict to regions and exclude blacklisted regions.
+	regsFilter, err := common.Filter(out, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// copy the records of each database.
+	interrupted := func(err error) {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	for i, f := range *dbFiles {
+		if _, err = out.Exec("ATTACH DATABASE ? AS src", f); err != nil {
+			log.Fatal(err)
+		}
+		sel := cols
+		if *samples == true {
+			sel = append(append([]string(nil), cols[:len(cols)-1]...), strconv.Itoa(i+1))
+		}
+		q := "INSERT INTO " + dest + " (" + strings.Join(cols, ", ") + ") SELECT " +
+			strings.Join(sel, ", ") + " FROM src." + *tab
+		if *where != "" {
+			q += " WHERE " + *where
+		}
+		if regsFilter != "" {
+			if *where != "" {
+				q += " AND " + regsFilter
+			} else {
+				q += " WHERE " + regsFilter
+			}
+		}
+		res, err := out.ExecContext(ctx, q)
+		if err != nil {
+			interrupted(err)
+		}
+		if _, err = out.Exec("DETACH DATABASE src"); err != nil {
+			log.Fatal(err)
+		}
+		if *verbose == true {
+			n, _ := res.RowsAffected()
+			log.Printf("db:%s, records:%d\n", f, n)
+		}
+	}
+
+	// sum the copies of identical alignments.
+	if *sumCopies == true {
+		var key, sel []string
+		for _, c := range cols {
+			switch c {
+			case "qname":
+				sel = append(sel, "MIN(qname)")
+			case "copy_number":
+				sel = append(sel, "SUM(copy_number)")
+			default:
+				key = append(key, c)
+				sel = append(sel, c)
+			}
+		}
+		res, err := out.ExecContext(ctx, "INSERT INTO "+*tab+" ("+strings.Join(cols, ", ")+
+			") SELECT "+strings.Join(sel, ", ")+" FROM "+dest+
+			" GROUP BY "+strings.Join(key, ", "))
+		if err != nil {
+			interrupted(err)
+		}
+		if _, err = out.Exec("DROP TABLE " + dest); err != nil {
+			log.Fatal(err)
+		}
+		if *verbose == true {
+			n, _ := res.RowsAffected()
+			log.Printf("merged records:%d\n", n)
+		}
+	}
+
+	// recreate the indexes and register the samples.
+	for _, stmt := range indexes {
+		if _, err = out.ExecContext(ctx, stmt); err != nil {
+			interrupted(err)
+		}
+	}
+	if *samples == true {
+		for i, name := range *names {
+			s := htsdb.Sample{Name: name, RecordTable: *tab,
+				ID: sql.NullInt64{Int64: int64(i + 1), Valid: true}}
+			if err = htsdb.AddSample(out, s); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// sameColumns returns true if a and b have the same columns in any order.
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, c := range b {
+		if !contains(a, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
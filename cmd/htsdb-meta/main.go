@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-meta"
+const version = "0.5"
+const descr = `Print or modify the metadata stored in the database. Without
+--set all key/value pairs are printed. The coordinate convention of the start
+and stop columns is stored under the "coords" key and must be one of htsdb,
+bed, sam, 0-closed, 0-halfopen, 1-closed or 1-halfopen.
+Setting the "locked" key to true protects the database from modification by
+all commands, including setting other keys, unless they are run with --force.
+The "locked" key itself can always be set.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	set = app.Flag("set", "Set metadata key to value. Can be repeated.").
+		PlaceHolder("<key=value>").Strings()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if len(*set) > 0 {
+		var pairs [][]string
+		onlyLock := true
+		for _, kv := range *set {
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) != 2 || pair[0] == "" {
+				kingpin.Fatalf("invalid key/value pair %q", kv)
+			}
+			switch pair[0] {
+			case htsdb.CoordsMetaKey:
+				c, err := htsdb.ParseCoords(pair[1])
+				if err != nil {
+					kingpin.Fatalf("%s", err)
+				}
+				pair[1] = c.String()
+			case htsdb.LockedMetaKey:
+				locked, err := strconv.ParseBool(pair[1])
+				if err != nil {
+					kingpin.Fatalf("invalid %s value %q", htsdb.LockedMetaKey, pair[1])
+				}
+				pair[1] = strconv.FormatBool(locked)
+			}
+			if pair[0] != htsdb.LockedMetaKey {
+				onlyLock = false
+			}
+			pairs = append(pairs, pair)
+		}
+		if !onlyLock {
+			if err = htsdb.CheckUnlocked(db, *force); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for i, pair := range pairs {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, fmt.Sprintf("%d of %d keys were set", i, len(pairs)))
+			}
+			if err = htsdb.SetMeta(db, pair[0], pair[1]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	meta, err := htsdb.Meta(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s\t%s\n", k, meta[k])
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// RecordBuilder is a squirrel select builder whose columns match Record
+// fields.
+var RecordBuilder = squirrel.Select("pos", "cigar", "seq", "strand", "copy_number")
+
+// Record is a read with its alignment.
+type Record struct {
+	Pos        int               `db:"pos"`
+	Cigar      string            `db:"cigar"`
+	Seq        string            `db:"seq"`
+	Orient     htsdb.Orientation `db:"strand"`
+	CopyNumber int               `db:"copy_number"`
+}
+
+const prog = "htsdb-nta"
+const version = "0.1"
+const descr = `Measure the frequency of non-templated 3' additions e.g. the
+mono-uridylation of small RNAs. The 3' end of each read is compared to the
+reference sequence from --fasta or, if not given, from the reference
+sequences stored in the database; bases soft clipped from the 3' end and the
+3' aligned bases that mismatch the reference are non-templated. Additions are
+classified as none, mono-N or oligo-N for one or more copies of base N, with
+U for T, or mixed. For each read class, defined by an SQL filter with
+--class, and addition the number and fraction of reads are printed. Provided
+SQL filter will apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. seq=sequence.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	classDefs = app.Flag("class", "Define a read class with an SQL filter e.g. mirna=\"rname LIKE 'mir%'\"; all reads form a single class if none is given. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	fasta = app.Flag("fasta", "FASTA file with the reference sequences.").
+		PlaceHolder("<file>").String()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	classes, err := htsdb.ParseFilters(*classDefs)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if len(classes) == 0 {
+		classes = htsdb.Filters{"all": ""}
+	}
+	classNames := make([]string, 0, len(classes))
+	for c := range classes {
+		classNames = append(classNames, c)
+	}
+	sort.Strings(classNames)
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, []string{"pos", "cigar", "seq"}); err != nil {
+		log.Fatal(err)
+	}
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble the query of each class.
+	b := RecordBuilder.From(table).Where("rname = ?")
+	refsB := htsdb.ReferenceBuilder.From(table)
+	if *where != "" {
+		b = b.Where(*where)
+		refsB = refsB.Where(*where)
+	}
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if f := htsdb.RegionsFilter(regs, coords); f != "" {
+		b = b.Where(f)
+		refsB = refsB.Where(f)
+	}
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = bl.Apply(b, coords)
+	stmts := make([]*sqlx.Stmt, len(classNames))
+	for i, c := range classNames {
+		cb := b
+		if classes[c] != "" {
+			cb = cb.Where(classes[c])
+		}
+		q, _, err := cb.ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if stmts[i], err = db.Preparex(db.Rebind(q)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	refs, err := htsdb.SelectReferencesContext(ctx, db, refsB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hasReads := make(map[string]bool)
+	for _, r := range refs {
+		hasReads[r.Chrom] = true
+	}
+
+	// counts[i] counts the reads of class i by addition.
+	counts := make([]map[string]float64, len(classNames))
+	for i := range counts {
+		counts[i] = make(map[string]float64)
+	}
+	skipped := 0
+	count := func(ref, seq string) error {
+		if *verbose == true {
+			log.Printf("chrom:%s\n", ref)
+		}
+		var r Record
+		for i, stmt := range stmts {
+			rows, err := stmt.QueryxContext(ctx, ref)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				if err = rows.StructScan(&r); err != nil {
+					rows.Close()
+					return err
+				}
+				added, _, _, err := htsdb.NonTemplated(r.Pos, r.Cigar, r.Seq, r.Orient, seq, 0)
+				if err != nil {
+					skipped++
+					continue
+				}
+				w := 1.0
+				if *copyNum == true {
+					w = float64(r.CopyNumber)
+				}
+				counts[i][htsdb.NTAClass(added)] += w
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+
+	// count the reads of each reference with its sequence.
+	if *fasta != "" {
+		fh, err := os.Open(*fasta)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = htsdb.ReadFasta(fh, func(name string, seq []byte) error {
+			if !hasReads[name] {
+				return nil
+			}
+			return count(name, strings.ToUpper(string(seq)))
+		})
+		fh.Close()
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, r := range refs {
+			seq, err := htsdb.RefSubseq(db, r.Chrom, 0, r.Length-1)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = count(r.Chrom, seq); err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				log.Fatal(err)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		db.Close()
+		cli.Interrupted(stop, "nothing was printed")
+	}
+	if skipped > 0 {
+		log.Printf("warning: skipped %d reads without sequence or with inconsistent CIGAR\n", skipped)
+	}
+
+	// print the frequency of each addition.
+	tsv := htsdb.NewTSVWriter(os.Stdout)
+	tsv.Format = ff
+	if err = tsv.WriteStrings([]string{"class", "nta", "reads", "fraction"}); err != nil {
+		log.Fatal(err)
+	}
+	for i, c := range classNames {
+		total := 0.0
+		var ntas []string
+		for nta, n := range counts[i] {
+			total += n
+			ntas = append(ntas, nta)
+		}
+		sort.Strings(ntas)
+		for _, nta := range ntas {
+			if err = tsv.Write(c, nta, counts[i][nta], counts[i][nta]/total); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err = tsv.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
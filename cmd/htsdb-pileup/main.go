@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Record is a database record with the alignment of a read.
+type Record struct {
+	Pos     int     `db:"pos"`
+	Cigar   string  `db:"cigar"`
+	Seq     string  `db:"seq"`
+	CopyNum float64 `db:"copy_number"`
+}
+
+// RecordBuilder is a squirrel select builder whose columns match Record
+// fields.
+var RecordBuilder = squirrel.Select("pos", "cigar", "seq", "copy_number")
+
+const prog = "htsdb-pileup"
+const version = "0.7"
+const descr = `Print the consensus base and the fraction of each base and of
+deletions at each position of the given regions e.g. amplicons, using the
+sequences and CIGARs of the reads aligned on them. The consensus is N where the
+depth or the fraction of the most frequent base is below the given minimums.
+Insertions and clipped bases are not counted. With --format fasta, the
+consensus sequence of each region is printed instead. Provided SQL filter will
+apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. seq=sequence.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Weigh reads by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	regions = app.Flag("regions", "BED file with the regions to pile up.").
+		PlaceHolder("<file>").Required().String()
+	minDepth = app.Flag("min-depth", "Minimum depth for a consensus base.").
+			Default("1").Float64()
+	minFrac = app.Flag("min-frac", "Minimum fraction of the most frequent base for a consensus base.").
+		Default("0.5").Float64()
+	format = app.Flag("format", "Output format.").
+		Default("tsv").Enum("tsv", "fasta")
+	header = app.Flag("header", "Print header line for TSV output.").
+		Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("4").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("fixed").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterBlacklist(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	regs, err := htsdb.ReadRegionsFile(*regions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// weigh each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readsB := RecordBuilder.From(table)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+	if blf := bl.Filter(coords); blf != "" {
+		readsB = readsB.Where(blf)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if *format == "tsv" && *header == true {
+		fmt.Fprintf(w, "rname\tpos\tdepth\tconsensus")
+		for _, s := range htsdb.PileupSymbols {
+			fmt.Fprintf(w, "\t%c", s)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	// pile up the reads of each region.
+	for _, r := range regs {
+		query, _, err := readsB.
+			Where(htsdb.RegionsFilter([]htsdb.Region{r}, coords)).ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows, err := db.QueryxContext(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				w.Flush()
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
+		}
+		p := htsdb.NewPileup(r)
+		var rec Record
+		for rows.Next() {
+			if err = rows.StructScan(&rec); err != nil {
+				log.Fatal(err)
+			}
+			if err = p.Add(rec.Pos, rec.Cigar, rec.Seq, rec.CopyNum); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			if ctx.Err() != nil {
+				w.Flush()
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
+		}
+		rows.Close()
+
+		if *format == "fasta" {
+			seq := make([]byte, 0, r.Stop-r.Start+1)
+			for _, c := range p.Columns() {
+				b, _ := c.Consensus(*minDepth, *minFrac)
+				if b == '-' {
+					continue
+				}
+				seq = append(seq, b)
+			}
+			fmt.Fprintf(w, ">%s:%d-%d\n%s\n", r.Rname, r.Start+1, r.Stop+1, seq)
+			continue
+		}
+		for _, c := range p.Columns() {
+			b, _ := c.Consensus(*minDepth, *minFrac)
+			fmt.Fprintf(w, "%s\t%d\t%s\t%c", c.Rname, c.Pos+1, ff.Format(c.Depth()), b)
+			for i := range htsdb.PileupSymbols {
+				fmt.Fprintf(w, "\t%s", ff.Format(c.Fraction(i)))
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	}
+}
@@ -6,18 +6,25 @@ import (
 	"os"
 	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
 	"github.com/biogo/biogo/feat"
 	"github.com/jmoiron/sqlx"
-	"github.com/mnsmar/htsdb"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 const prog = "htsdb-pos-overlap"
-const version = "0.2"
+const version = "0.9"
 const descr = `Measure the 5'/3' read positions and the number of reads on
-these positions that are occupied by a 5'/3' position of a reference.`
+these positions that are occupied by a 5'/3' position of a reference. Read
+midpoints or, for paired-end data, fragment midpoints can be used instead.
+The reads of db1 that share a position are counted by their copy numbers
+(weight-by-copies), once each or once per position (unique) with --weight1,
+like in htsdb-relative-pos-distro. Reads whose strand is not 1 or -1 are only
+measured with --ignore-strand unless --invalid-strand skips them or stops with
+an error.`
 
 type count struct {
 	posTotal, posOccupied, readsTotal, readsOccupied int
@@ -44,72 +51,187 @@ var (
 		PlaceHolder("<file>").Required().String()
 	tab1 = app.Flag("table1", "Database table name for db1.").
 		Default("sample").String()
+	colMap1 = app.Flag("col-map1", "Map canonical to foreign column names for db1.").
+		PlaceHolder("<col=col,...>").String()
 	where1 = app.Flag("where1", "SQL filter injected in WHERE clause for db1.").
 		PlaceHolder("<SQL>").String()
 	dbFile2 = app.Flag("db2", "SQLite file for database 2.").
 		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db1 and --db2 are connection strings.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
 	tab2 = app.Flag("table2", "Database table name for db2.").
 		Default("sample").String()
+	colMap2 = app.Flag("col-map2", "Map canonical to foreign column names for db2.").
+		PlaceHolder("<col=col,...>").String()
 	where2 = app.Flag("where2", "SQL filter injected in WHERE clause for db2.").
 		PlaceHolder("<SQL>").String()
 	from = app.Flag("pos", "Reference point for relative position measurement.").
-		Required().PlaceHolder("<5p|3p>").Enum("5p", "3p")
+		Required().PlaceHolder("<5p|3p|mid>").Enum("5p", "3p", "mid")
+	offset1 = app.Flag("offset1", "Offset downstream of the reference point for db1; negative for upstream.").
+		Default("0").Int()
+	offset2 = app.Flag("offset2", "Offset downstream of the reference point for db2; negative for upstream.").
+		Default("0").Int()
+	ignoreStrand = app.Flag("ignore-strand", "Pool reads of both strands as forward for unstranded protocols; 5p and 3p are the leftmost and rightmost positions.").
+			Bool()
+	strandPolicy = app.Flag("invalid-strand", "Handling of reads whose strand is not 1 or -1.").
+			Default("unknown").Enum(htsdb.StrandPolicies...)
+	weight1 = app.Flag("weight1", "Weighting of db1 reads that share a position.").
+		Default(htsdb.WeightCopies.String()).Enum(htsdb.Weightings...)
+	fragment = app.Flag("fragment", "Use paired-end fragments (start to start+tlen) instead of reads.").
+			Bool()
+	noPartial = app.Flag("no-partial", "Discard partial results when interrupted.").
+			Bool()
 	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
 )
 
+var common cli.Options
+
 func main() {
 	app.HelpFlag.Short('h')
 	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		kingpin.Fatalf("%s", err)
 	}
 
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols1, err := htsdb.ParseColumnMap(*colMap1)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	table1 := cols1.Table(*tab1)
+	cols2, err := htsdb.ParseColumnMap(*colMap2)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	table2 := cols2.Table(*tab2)
+
+	// open database connections.
+	var db1, db2 *sqlx.DB
+	if db1, err = htsdb.Connect(*driver, *dbFile1); err != nil {
+		panic(err)
+	}
+	defer db1.Close()
+	if db2, err = htsdb.Connect(*driver, *dbFile2); err != nil {
+		panic(err)
+	}
+	defer db2.Close()
+
+	// read coordinate conventions.
+	coords1, err := htsdb.SelectCoords(db1)
+	panicOnError(err)
+	coords2, err := htsdb.SelectCoords(db2)
+	panicOnError(err)
+
+	// handle reads whose strand is not 1 or -1.
+	policy, err := htsdb.ParseStrandPolicy(*strandPolicy)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	invalid1, invalid2 := 0, 0
+	*where1, invalid1, err = policy.Filter(db1, table1, *where1)
+	panicOnError(err)
+	*where2, invalid2, err = policy.Filter(db2, table2, *where2)
+	panicOnError(err)
+	if policy == htsdb.StrandSkip && invalid1+invalid2 > 0 {
+		log.Printf("warning: skipped %d reads of db1, %d of db2 with invalid strand\n", invalid1, invalid2)
+	}
+
 	// assemble sqlx select builders
-	readsBuilder1 := htsdb.RangeBuilder.From(*tab1)
-	refsBuilder1 := htsdb.ReferenceBuilder.From(*tab1)
+	rangeBuilder1, rangeBuilder2 := htsdb.RangeBuilder, htsdb.RangeBuilder
+	if *fragment == true {
+		rangeBuilder1 = htsdb.FragmentBuilder(coords1)
+		rangeBuilder2 = htsdb.FragmentBuilder(coords2)
+	}
+	readsBuilder1 := rangeBuilder1.From(table1)
+	refsBuilder1 := htsdb.ReferenceBuilder.From(table1)
 	if *where1 != "" {
 		readsBuilder1 = readsBuilder1.Where(*where1)
 		refsBuilder1 = refsBuilder1.Where(*where1)
 	}
-	readsBuilder2 := htsdb.RangeBuilder.From(*tab2)
+	readsBuilder2 := rangeBuilder2.From(table2)
 	if *where2 != "" {
 		readsBuilder2 = readsBuilder2.Where(*where2)
 	}
 
-	// open database connections.
-	var db1, db2 *sqlx.DB
-	if db1, err = sqlx.Connect("sqlite3", *dbFile1); err != nil {
-		panic(err)
+	// restrict to regions.
+	regs, err := common.ReadRegions()
+	panicOnError(err)
+	if f := htsdb.RegionsFilter(regs, coords1); f != "" {
+		readsBuilder1 = readsBuilder1.Where(f)
+		refsBuilder1 = refsBuilder1.Where(f)
 	}
-	if db2, err = sqlx.Connect("sqlite3", *dbFile2); err != nil {
-		panic(err)
+	if f := htsdb.RegionsFilter(regs, coords2); f != "" {
+		readsBuilder2 = readsBuilder2.Where(f)
+	}
+
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	panicOnError(err)
+	if len(bl) > 0 {
+		n1, err := bl.Count(db1, table1, *where1, coords1)
+		panicOnError(err)
+		n2, err := bl.Count(db2, table2, *where2, coords2)
+		panicOnError(err)
+		log.Printf("blacklist: excluded %d records from db1, %d from db2\n", n1, n2)
+		readsBuilder1 = bl.Apply(readsBuilder1, coords1)
+		refsBuilder1 = bl.Apply(refsBuilder1, coords1)
+		readsBuilder2 = bl.Apply(readsBuilder2, coords2)
 	}
 
 	// prepare statements.
-	query1, _, err := readsBuilder1.Where("strand = ? AND rname = ?").ToSql()
+	oris := []feat.Orientation{feat.Forward, feat.Reverse}
+	readsBuilder1 = readsBuilder1.Where("rname = ?")
+	readsBuilder2 = readsBuilder2.Where("rname = ?")
+	if *ignoreStrand == true {
+		oris = []feat.Orientation{feat.Forward}
+	} else {
+		readsBuilder1 = readsBuilder1.Where("strand = ?")
+		readsBuilder2 = readsBuilder2.Where("strand = ?")
+	}
+	args := func(ori feat.Orientation, ref string) []interface{} {
+		if *ignoreStrand == true {
+			return []interface{}{ref}
+		}
+		return []interface{}{ref, ori}
+	}
+	query1, _, err := readsBuilder1.ToSql()
 	panicOnError(err)
-	readsStmt1, err := db1.Preparex(query1)
+	readsStmt1, err := db1.Preparex(db1.Rebind(query1))
 	panicOnError(err)
-	query2, _, err := readsBuilder2.Where("strand = ? AND rname = ?").ToSql()
+	query2, _, err := readsBuilder2.ToSql()
 	panicOnError(err)
-	readsStmt2, err := db2.Preparex(query2)
+	readsStmt2, err := db2.Preparex(db2.Rebind(query2))
 	panicOnError(err)
 
 	// select reference features
-	refs, err := htsdb.SelectReferences(db1, refsBuilder1)
+	refs, err := htsdb.SelectReferencesContext(ctx, db1, refsBuilder1)
 
 	// get position extracting function
-	getPos := htsdb.Head
-	if *from == "3p" {
-		getPos = htsdb.Tail
+	anchor, err := htsdb.ParseAnchor(*from)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	weighting, err := htsdb.ParseWeighting(*weight1)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
 	}
 
 	// count occupied positions.
+	var checker htsdb.RangeChecker
 	counts := make(chan (*count))
 	var wg sync.WaitGroup
 	for _, ref := range refs {
-		for _, ori := range []feat.Orientation{feat.Forward, feat.Reverse} {
+		for _, ori := range oris {
 			wg.Add(1)
 			go func(ori feat.Orientation, ref htsdb.Reference) {
 				if *verbose == true {
@@ -119,28 +241,57 @@ func main() {
 				r := &htsdb.Range{}
 
 				occupied := make(map[int]bool)
-				rows2, err := readsStmt2.Queryx(ori, ref.Chrom)
+				rows2, err := readsStmt2.QueryxContext(ctx, args(ori, ref.Chrom)...)
+				if ctx.Err() != nil {
+					return
+				}
 				panicOnError(err)
 				for rows2.Next() {
 					err = rows2.StructScan(r)
 					panicOnError(err)
-					pos := getPos(r, ori)
+					coords2.Normalize(r)
+					if !checker.Check(r) {
+						continue
+					}
+					pos, ok := htsdb.PosAtOK(r, ori, anchor, *offset2)
+					if !ok {
+						continue
+					}
 					occupied[pos] = true
 				}
+				if ctx.Err() != nil {
+					return
+				}
 
 				cnt := &count{}
-				rows1, err := readsStmt1.Queryx(ori, ref.Chrom)
+				seen := make(map[int]int)
+				rows1, err := readsStmt1.QueryxContext(ctx, args(ori, ref.Chrom)...)
+				if ctx.Err() != nil {
+					return
+				}
 				panicOnError(err)
 				for rows1.Next() {
 					err = rows1.StructScan(r)
 					panicOnError(err)
-					pos := getPos(r, ori)
+					coords1.Normalize(r)
+					if !checker.Check(r) {
+						continue
+					}
+					pos, ok := htsdb.PosAtOK(r, ori, anchor, *offset1)
+					if !ok {
+						continue
+					}
+					w := weighting.Weight(seen[pos], r.CopyNumber)
+					seen[pos]++
 					if occupied[pos] {
 						cnt.posOccupied++
-						cnt.readsOccupied += r.CopyNumber
+						cnt.readsOccupied += w
 					}
 					cnt.posTotal++
-					cnt.readsTotal += r.CopyNumber
+					cnt.readsTotal += w
+				}
+				if ctx.Err() != nil {
+					return
 				}
 				counts <- cnt
 			}(ori, ref)
@@ -159,11 +310,28 @@ func main() {
 		aggr.incrementBy(v)
 	}
 
-	// print results.
+	// discard partial results if interrupted.
+	interrupted := ctx.Err() != nil
+	if interrupted && *noPartial == true {
+		db1.Close()
+		db2.Close()
+		cli.Interrupted(stop, "partial results discarded")
+	}
+
+	// report malformed records and print results.
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
 	fmt.Printf("total_pos:%d\noccupied_pos:%d\npercent_pos:%.2f\n"+
 		"total_reads:%d\noccupied_reads:%d\npercent_reads:%.2f\n",
 		aggr.posTotal, aggr.posOccupied, aggr.percentPosOccupied(),
 		aggr.readsTotal, aggr.readsOccupied, aggr.percentReadsOccupied())
+	if interrupted {
+		fmt.Println(htsdb.InterruptedMarker)
+		db1.Close()
+		db2.Close()
+		cli.Interrupted(stop, "results are partial")
+	}
 }
 
 func panicOnError(err error) {
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-qnames"
+const version = "0.2"
+const descr = `Print the read names (qnames) of the database records that pass
+the provided filters, one per line, e.g. to extract the same reads from the
+original BAM file with samtools view -N or from FASTQ files. Each name is
+printed once unless --no-distinct is given. Output is gzip compressed with
+--gzip or if --out ends in .gz.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter injected in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	distinct = app.Flag("distinct", "Print each read name once e.g. for both mates of pairs.").
+			Default("true").Bool()
+	outFile = app.Flag("out", "Output file; - for stdout.").
+		Default("-").PlaceHolder("<file>").String()
+	gz = app.Flag("gzip", "Compress output with gzip.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, []string{"qname"}); err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble the query.
+	b := squirrel.Select("qname").From(table)
+	if *distinct == true {
+		b = b.Distinct()
+	}
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// open output.
+	var out io.Writer = os.Stdout
+	if *outFile != "-" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	w := io.Writer(bw)
+	var zw *gzip.Writer
+	if *gz == true || strings.HasSuffix(*outFile, ".gz") {
+		zw = gzip.NewWriter(bw)
+		w = zw
+	}
+
+	// print read names until exhausted or interrupted.
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var qname string
+		if err = rows.Scan(&qname); err != nil {
+			log.Fatal(err)
+		}
+		if _, err = io.WriteString(w, qname+"\n"); err != nil {
+			log.Fatal(err)
+		}
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			if zw != nil {
+				zw.Close()
+			}
+			bw.Flush()
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("read names:%d\n", n)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-ref-sizes"
+const version = "0.5"
+const descr = `Print the length of each reference in chrom.sizes or circos
+karyotype format. Lengths are read from the reference table if present and
+estimated from the largest record stop otherwise. References are printed in
+natural order e.g. chr2 before chr10.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom.").
+		PlaceHolder("<col=col,...>").String()
+	format = app.Flag("format", "Output format.").
+		Default("chrom-sizes").Enum("chrom-sizes", "karyotype")
+	color = app.Flag("color", "Karyotype color of all references; defaults to the reference name.").
+		PlaceHolder("<color>").String()
+	minLen = app.Flag("min-length", "Skip references shorter than this.").
+		Default("0").Int()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// read reference lengths.
+	lens, err := htsdb.ReferenceLengthsContext(ctx, db, table, coords)
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		log.Fatal(err)
+	}
+	var refs []htsdb.Reference
+	for _, r := range htsdb.SortedReferences(lens) {
+		if r.Length >= *minLen {
+			refs = append(refs, r)
+		}
+	}
+
+	// write references.
+	w := bufio.NewWriter(os.Stdout)
+	if *format == "karyotype" {
+		err = htsdb.WriteKaryotype(w, refs, *color)
+	} else {
+		err = htsdb.WriteChromSizes(w, refs)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
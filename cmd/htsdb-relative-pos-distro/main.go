@@ -1,47 +1,98 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/alexflint/go-arg"
 	"github.com/biogo/biogo/feat"
 	"github.com/jmoiron/sqlx"
-	"github.com/mnsmar/htsdb"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/aggregate"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
 )
 
+// maxConc is the default number of concurrent workers.
 const maxConc = 12
 
+// maxPushdown is the default maximum number of db2 reads of a reference for
+// which the scan of db1 is restricted to the reads near them.
+const maxPushdown = 1000
+
+// pushdownBatch is the number of windows around db2 reads whose db1 reads are
+// selected by a single query.
+const pushdownBatch = 200
+
 // Opts is the struct with the options that the program accepts.
 type Opts struct {
-	DB1       string `arg:"required,help:SQLite3 database 1"`
-	Table1    string `arg:"required,help:table name for db1"`
-	Where1    string `arg:"help:SQL filter injected in WHERE clause of db1"`
-	Pos1      string `arg:"required,help:reference point for reads of db1; one of 5p or 3p"`
-	Collapse1 bool   `arg:"help:Collapse reads that have the same pos1"`
-	DB2       string `arg:"required,help:SQLite3 database 2"`
-	Table2    string `arg:"required,help:table name for db2"`
-	Where2    string `arg:"help:SQL filter injected in WHERE clause of db2"`
-	Pos2      string `arg:"required,help:reference point for reads of db2; one of 5p or 3p"`
-	Collapse2 bool   `arg:"help:collapse reads that have the same pos2"`
-	Span      int    `arg:"required,help:maximum distance of compared pos"`
-	GroupRef  bool   `arg:"--by-ref,help:group counts by reference"`
-	Anti      bool   `arg:"help:Compare reads on opposite instead of same orientation"`
-	Verbose   bool   `arg:"-v,help:report progress"`
+	Driver        string `help:"database driver; one of sqlite3, postgres, mysql or duckdb; db1 and db2 are connection strings for postgres and mysql"`
+	DB1           string `arg:"required" help:"SQLite3 database 1"`
+	Table1        string `arg:"required" help:"table name for db1"`
+	ColMap1       string `arg:"--col-map1" help:"map canonical to foreign column names for db1 e.g. rname=chrom"`
+	Where1        string `help:"SQL filter injected in WHERE clause of db1"`
+	WhereNot1     string `arg:"--where-not1" help:"SQL filter whose matching reads of db1 are excluded"`
+	Pos1          string `arg:"required" help:"reference point for reads of db1; one of 5p, 3p or mid"`
+	Offset1       int    `help:"offset downstream of pos1; negative for upstream e.g. 12 for P-site"`
+	Fragment1     bool   `help:"use paired-end fragments of db1 (start to start+tlen) instead of reads"`
+	Collapse1     bool   `help:"Collapse reads that have the same pos1; same as --weight1 unique"`
+	Weight1       string `help:"weighting of db1 reads that share pos1; one of each, unique or weight-by-copies"`
+	DB2           string `help:"SQLite3 database 2; required unless --self"`
+	Table2        string `help:"table name for db2; required unless --self"`
+	ColMap2       string `arg:"--col-map2" help:"map canonical to foreign column names for db2 e.g. rname=chrom"`
+	Where2        string `help:"SQL filter injected in WHERE clause of db2"`
+	WhereNot2     string `arg:"--where-not2" help:"SQL filter whose matching reads of db2 are excluded"`
+	Pos2          string `help:"reference point for reads of db2; one of 5p, 3p or mid; required unless --self"`
+	Offset2       int    `help:"offset downstream of pos2; negative for upstream"`
+	Fragment2     bool   `help:"use paired-end fragments of db2 (start to start+tlen) instead of reads"`
+	Collapse2     bool   `help:"collapse reads that have the same pos2; same as --weight2 unique"`
+	Weight2       string `help:"weighting of db2 reads that share pos2; one of each, unique or weight-by-copies"`
+	MaxPerPos     int    `arg:"--max-per-pos" help:"count at most this many reads at each position of each database to limit jackpot artifacts; 0 for no limit"`
+	Pushdown      int    `help:"scan only the reads of db1 within span of db2 reads for references with at most this many db2 reads; 0 to always scan all reads"`
+	Span          int    `arg:"required" help:"maximum distance of compared pos"`
+	Checkpoint    string `help:"file to record completed references and resume from"`
+	Cache         string `help:"cache results in directory so that identical runs return instantly e.g. .htsdb-cache"`
+	MaxMem        string `arg:"--max-mem" help:"memory budget for in-memory positions e.g. 2G; stream sorted reads when exceeded"`
+	GroupRef      bool   `arg:"--by-ref" help:"group counts by reference"`
+	Long          bool   `help:"print one value per row after the ref and pos columns (long format)"`
+	Anti          bool   `help:"Compare reads on opposite instead of same orientation"`
+	IgnoreStrand  bool   `arg:"--ignore-strand" help:"pool reads of both strands as forward for unstranded protocols; 5p and 3p are the leftmost and rightmost positions"`
+	InvalidStrand string `arg:"--invalid-strand" help:"handling of reads whose strand is not 1 or -1; one of unknown, error or skip"`
+	Self          bool   `help:"compare the reads of db1 with themselves using the db1 options for db2, excluding the pair of each read with itself"`
+	SplitStrand   bool   `arg:"--split-strand" help:"also print the pairs of forward and reverse db2 reads in separate columns"`
+	Threads       int    `help:"number of concurrent workers; each reads through its own read-only connection"`
+	Verbose       bool   `arg:"-v" help:"report progress"`
+	NoPartial     bool   `arg:"--no-partial" help:"discard partial results when interrupted"`
+	cli.Options
 }
 
 // Version returns the program version.
 func (Opts) Version() string {
-	return "htsdb-relative-pos-distro 0.6"
+	return "htsdb-relative-pos-distro 0.22"
+}
+
+// pushdown returns true if the scan of db1 can be restricted to the reads
+// near db2 reads. It requires that the db1 read count does not depend on the
+// positions of db1 reads and that db1 positions lie within the reads.
+func (o Opts) pushdown() bool {
+	return o.Pushdown > 0 && !o.Fragment1 && o.Weight1 == htsdb.WeightEach.String() && o.MaxPerPos == 0
+}
+
+// capped returns true if n reads at a position reach the --max-per-pos limit.
+func (o Opts) capped(n int) bool {
+	return o.MaxPerPos > 0 && n >= o.MaxPerPos
 }
 
 // Description returns an extended description of the program.
 func (Opts) Description() string {
-	return "Measure distribution of read relative positions in database 1 against database 2. Prints the number of read pairs at each relative position along with the total number of possible pairs and the total number of reads in each database. Positive relative positions indicate read 1 is downstream of read 2. Provided SQL filters will apply to all counts."
+	return "Measure distribution of read relative positions in database 1 against database 2. Prints the number of read pairs at each relative position along with the total number of possible pairs and the total number of reads in each database. Positive relative positions indicate read 1 is downstream of read 2. Reads of a database that share a position are counted once each, once per position (unique) or by their copy numbers (weight-by-copies) with --weight1 and --weight2; pairs and read counts use the same weights. With --self the reads of db1 are compared with themselves e.g. for the autocorrelation of read positions; the pair of each read with itself is not counted at relative position 0. With --split-strand the pairs are also split by the strand of read 2, before the relative positions of reverse reads are flipped, e.g. to diagnose strand-specific artifacts. Reads whose strand is not 1 or -1 are only compared with --ignore-strand unless --invalid-strand skips them or stops with an error. Provided SQL filters will apply to all counts."
 }
 
 func main() {
@@ -49,57 +100,249 @@ func main() {
 	var opts Opts
 	var db1, db2 *sqlx.DB
 
+	opts.Driver = htsdb.SQLite
+	opts.Threads = maxConc
+	opts.Pushdown = maxPushdown
+	opts.Weight1, opts.Weight2 = htsdb.WeightEach.String(), htsdb.WeightEach.String()
+	opts.InvalidStrand = "unknown"
 	p := arg.MustParse(&opts)
-	if opts.Pos1 != "5p" && opts.Pos1 != "3p" {
-		p.Fail("--pos1 must be either 5p or 3p")
+	if opts.Self {
+		if opts.DB2 != "" || opts.Table2 != "" || opts.Pos2 != "" {
+			p.Fail("--self cannot be used with --db2, --table2 or --pos2")
+		}
+		if opts.Anti {
+			p.Fail("--self cannot be used with --anti")
+		}
+		opts.DB2, opts.Table2, opts.ColMap2 = opts.DB1, opts.Table1, opts.ColMap1
+		opts.Where2, opts.WhereNot2 = opts.Where1, opts.WhereNot1
+		opts.Pos2, opts.Offset2, opts.Fragment2 = opts.Pos1, opts.Offset1, opts.Fragment1
+		opts.Collapse2, opts.Weight2 = opts.Collapse1, opts.Weight1
+	} else if opts.DB2 == "" || opts.Table2 == "" || opts.Pos2 == "" {
+		p.Fail("--db2, --table2 and --pos2 are required unless --self is given")
+	}
+	if opts.Threads < 1 {
+		p.Fail("--threads must be positive")
+	}
+	if opts.Driver != htsdb.SQLite && opts.Driver != htsdb.Postgres && opts.Driver != htsdb.MySQL &&
+		opts.Driver != htsdb.DuckDB {
+		p.Fail("--driver must be one of sqlite3, postgres, mysql or duckdb")
+	}
+	if opts.Cache != "" {
+		if err = htsdb.CheckCacheDriver(opts.Driver); err != nil {
+			p.Fail("--cache cannot be used with --driver " + opts.Driver)
+		}
+	}
+	if _, err = htsdb.ParseAnchor(opts.Pos1); err != nil {
+		p.Fail("--pos1 must be one of 5p, 3p or mid")
+	}
+	if _, err = htsdb.ParseAnchor(opts.Pos2); err != nil {
+		p.Fail("--pos2 must be one of 5p, 3p or mid")
+	}
+	if opts.Pushdown < 0 {
+		p.Fail("--pushdown must not be negative")
+	}
+	if opts.MaxPerPos < 0 {
+		p.Fail("--max-per-pos must not be negative")
+	}
+	if opts.Anti && opts.IgnoreStrand {
+		p.Fail("--anti cannot be used with --ignore-strand")
+	}
+	if opts.SplitStrand && opts.IgnoreStrand {
+		p.Fail("--split-strand cannot be used with --ignore-strand")
+	}
+	if opts.Collapse1 {
+		opts.Weight1 = htsdb.WeightUnique.String()
+	}
+	if opts.Collapse2 {
+		opts.Weight2 = htsdb.WeightUnique.String()
+	}
+	weight1, err := htsdb.ParseWeighting(opts.Weight1)
+	if err != nil {
+		p.Fail("--weight1 must be one of each, unique or weight-by-copies")
+	}
+	weight2, err := htsdb.ParseWeighting(opts.Weight2)
+	if err != nil {
+		p.Fail("--weight2 must be one of each, unique or weight-by-copies")
+	}
+	if opts.MaxPerPos > 0 && (weight1 == htsdb.WeightCopies || weight2 == htsdb.WeightCopies) {
+		p.Fail("--max-per-pos cannot be used with weight-by-copies")
+	}
+	policy, err := htsdb.ParseStrandPolicy(opts.InvalidStrand)
+	if err != nil {
+		p.Fail("--invalid-strand must be one of unknown, error or skip")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := opts.Start()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if opts.Pos2 != "5p" && opts.Pos2 != "3p" {
-		p.Fail("--pos2 must be either 5p or 3p")
+	defer stop()
+
+	// parameters that determine the results, for checkpoints and the cache.
+	tagOpts := opts
+	tagOpts.Checkpoint, tagOpts.Cache, tagOpts.Verbose = "", "", false
+	tagOpts.Threads, tagOpts.Pushdown = 0, 0
+	tagOpts.CPUProfile, tagOpts.MemProfile, tagOpts.Trace = "", "", ""
+	tagOpts.NoPartial = false
+	tag := fmt.Sprintf("%+v", tagOpts)
+
+	// print the cached results of an identical run or cache the output.
+	var stdout io.Writer = os.Stdout
+	var cw *htsdb.CacheWriter
+	if opts.Cache != "" {
+		cache, err := htsdb.OpenCache(opts.Cache)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := htsdb.CacheKey("htsdb-relative-pos-distro", opts.Version(),
+			[]string{tag}, opts.DB1, opts.DB2, opts.Regions, opts.Blacklist)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ok, err := cache.WriteTo(key, os.Stdout); err != nil {
+			log.Fatal(err)
+		} else if ok {
+			return
+		}
+		if cw, err = cache.Writer(key, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		stdout = cw
+	}
+
+	budgetBytes, err := htsdb.ParseBytes(opts.MaxMem)
+	if err != nil {
+		p.Fail(err.Error())
 	}
+	budget := htsdb.NewMemBudget(budgetBytes)
 
-	// open database connections.
-	if db1, err = sqlx.Connect("sqlite3", opts.DB1); err != nil {
+	cols1, err := htsdb.ParseColumnMap(opts.ColMap1)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	cols2, err := htsdb.ParseColumnMap(opts.ColMap2)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	// open a read-only connection per worker to each database.
+	if db1, err = openDB(opts.Driver, opts.DB1, opts.Threads); err != nil {
+		log.Fatal(err)
+	}
+	defer db1.Close()
+	if db2, err = openDB(opts.Driver, opts.DB2, opts.Threads); err != nil {
 		log.Fatal(err)
 	}
-	if db2, err = sqlx.Connect("sqlite3", opts.DB2); err != nil {
+	defer db2.Close()
+
+	// read coordinate conventions.
+	coords1, err := htsdb.SelectCoords(db1)
+	if err != nil {
 		log.Fatal(err)
 	}
+	coords2, err := htsdb.SelectCoords(db2)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// handle reads whose strand is not 1 or -1.
+	invalid1, invalid2 := 0, 0
+	if opts.Where1, invalid1, err = policy.Filter(db1, cols1.Table(opts.Table1), opts.Where1); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Where2, invalid2, err = policy.Filter(db2, cols2.Table(opts.Table2), opts.Where2); err != nil {
+		log.Fatal(err)
+	}
+	if policy == htsdb.StrandSkip && invalid1+invalid2 > 0 {
+		log.Printf("warning: skipped %d reads of db1, %d of db2 with invalid strand\n", invalid1, invalid2)
+	}
+
+	// read regions.
+	regs, err := opts.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// read blacklisted regions and report excluded records.
+	bl, err := opts.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bl) > 0 {
+		n1, err := bl.Count(db1, cols1.Table(opts.Table1), opts.Where1, coords1)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n2, err := bl.Count(db2, cols2.Table(opts.Table2), opts.Where2, coords2)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("blacklist: excluded %d records from db1, %d from db2\n", n1, n2)
+	}
 
 	// create select decorators.
-	decors1 := []BuilderDecorator{Table(opts.Table1), Where(opts.Where1)}
-	decors2 := []BuilderDecorator{Table(opts.Table2), Where(opts.Where2)}
+	decors1 := []BuilderDecorator{Table(cols1.Table(opts.Table1)), Where(opts.Where1), WhereNot(opts.WhereNot1),
+		Where(htsdb.RegionsFilter(regs, coords1)), Where(bl.Filter(coords1))}
+	decors2 := []BuilderDecorator{Table(cols2.Table(opts.Table2)), Where(opts.Where2), WhereNot(opts.WhereNot2),
+		Where(htsdb.RegionsFilter(regs, coords2)), Where(bl.Filter(coords2))}
 
 	// extract reference features
-	refs, err := readRefs(db1, db2, decors1, decors2)
+	refs, err := readRefs(ctx, db1, db2, decors1, decors2)
 	if err != nil {
 		log.Fatal("error reading BED:", err)
 	}
 
+	// open checkpoint to resume from.
+	var cp *htsdb.Checkpoint
+	if opts.Checkpoint != "" {
+		if cp, err = htsdb.OpenCheckpoint(opts.Checkpoint, tag); err != nil {
+			log.Fatal(err)
+		}
+		defer cp.Close()
+	}
+
 	// goroutine that sends each reference as a job to jobs.
+	var checker htsdb.RangeChecker
 	jobs := make(chan job)
 	go func() {
+		defer close(jobs)
 		for _, ref := range refs {
-			jobs <- job{
+			j := job{
+				ctx:     ctx,
 				opts:    opts,
 				ref:     ref,
 				db1:     db1,
 				db2:     db2,
+				coords1: coords1,
+				coords2: coords2,
 				decors1: decors1,
 				decors2: decors2,
+				cp:      cp,
+				budget:  budget,
+				checker: &checker,
+				weight1: weight1,
+				weight2: weight2,
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
 			}
 		}
-		close(jobs)
 	}()
 
-	// start workers that consume jobs and send results to results.
+	// start workers that consume jobs and send results to results. Both
+	// channels are unbuffered so that at most one result per worker is in
+	// flight and memory stays flat regardless of the number of references.
 	results := make(chan result)
 	var wg sync.WaitGroup
-	wg.Add(maxConc)
-	for w := 1; w <= maxConc; w++ {
-		go func() {
+	wg.Add(opts.Threads)
+	for w := 1; w <= opts.Threads; w++ {
+		go func(w int) {
 			worker(w, jobs, results)
 			wg.Done()
-		}()
+		}(w)
 	}
 
 	// goroutine that checks when all workers are done and closes results.
@@ -109,45 +352,143 @@ func main() {
 	}()
 
 	// print output
+	var buf bytes.Buffer
+	var out io.Writer = stdout
+	if opts.NoPartial == true {
+		out = &buf
+	}
+	values := []string{"pairs", "readCount1", "readCount2"}
+	if opts.SplitStrand == true {
+		values = []string{"pairs", "forwardPairs", "reversePairs", "readCount1", "readCount2"}
+	}
+	// row returns the values of relative position i.
+	row := func(i int, hist, fwd, rev aggregate.Histogram, count1, count2 int) []interface{} {
+		if opts.SplitStrand == true {
+			return []interface{}{hist[i], fwd[i], rev[i], count1, count2}
+		}
+		return []interface{}{hist[i], count1, count2}
+	}
 	if opts.GroupRef == true {
-		fmt.Printf("ref\tpos\tpairs\treadCount1\treadCount2\n")
+		w := htsdb.NewResultWriter(out, []string{"ref", "pos"}, values, opts.Long)
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
 		for res := range results {
 			for i := -opts.Span; i <= opts.Span; i++ {
-				fmt.Printf("%s\t%d\t%d\t%d\t%d\n",
-					res.job.ref.Name(), i, res.hist[i], res.count1, res.count2)
+				err = w.WriteRow(append([]interface{}{res.ref, i},
+					row(i, res.hist, res.fwd, res.rev, res.count1, res.count2)...)...)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			// flush each reference so that partial results are printed.
+			if err = w.Flush(); err != nil {
+				log.Fatal(err)
 			}
 		}
 	} else {
 		var totalCount1, totalCount2 int
-		aggrHist := make(map[int]uint)
+		aggrHist := aggregate.NewHistogram()
+		aggrFwd, aggrRev := aggregate.NewHistogram(), aggregate.NewHistogram()
 		for res := range results {
-			for k, v := range res.hist {
-				aggrHist[k] += v
-			}
+			aggrHist.Merge(res.hist)
+			aggrFwd.Merge(res.fwd)
+			aggrRev.Merge(res.rev)
 			totalCount1 += res.count1
 			totalCount2 += res.count2
 		}
 
-		fmt.Printf("pos\tpairs\treadCount1\treadCount2\n")
+		w := htsdb.NewResultWriter(out, []string{"pos"}, values, opts.Long)
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
 		for i := -opts.Span; i <= opts.Span; i++ {
-			fmt.Printf("%d\t%d\t%d\t%d\n", i, aggrHist[i], totalCount1, totalCount2)
+			vals := row(i, aggrHist, aggrFwd, aggrRev, totalCount1, totalCount2)
+			if err = w.WriteRow(append([]interface{}{i}, vals...)...); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// report malformed records.
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+
+	// flag or discard partial results if interrupted.
+	if ctx.Err() != nil {
+		consequence := "partial results discarded"
+		if opts.NoPartial == false {
+			fmt.Println(htsdb.InterruptedMarker)
+			consequence = "results are partial"
+		}
+		if cw != nil {
+			cw.Discard()
+		}
+		if cp != nil {
+			cp.Close()
+		}
+		db1.Close()
+		db2.Close()
+		cli.Interrupted(stop, consequence)
+	}
+	if _, err = buf.WriteTo(stdout); err != nil {
+		log.Fatal(err)
+	}
+
+	// store results in cache.
+	if cw != nil {
+		if err = cw.Commit(); err != nil {
+			log.Printf("warning: results not cached: %s\n", err)
 		}
 	}
 }
 
 func worker(id int, jobs <-chan job, results chan<- result) {
 	for j := range jobs {
+		if j.ctx.Err() != nil {
+			continue
+		}
 		if j.opts.Verbose == true {
 			log.Printf("wID:%d, chrom:%s\n", id, j.ref.Name())
 		}
 
+		// reuse the result of a reference completed in a previous run.
+		if j.cp != nil {
+			var saved savedResult
+			ok, err := j.cp.Load(j.ref.Name(), &saved)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if ok {
+				results <- result{hist: saved.Hist, fwd: saved.Fwd, rev: saved.Rev, ref: j.ref.Name(),
+					count1: saved.Count1, count2: saved.Count2}
+				continue
+			}
+		}
+
 		var err error
-		var r htsdb.Range
 
 		// assemble sqlx select builders
+		rangeB1, stop1 := htsdb.RangeBuilder, "stop"
+		if j.opts.Fragment1 == true {
+			rangeB1, stop1 = htsdb.FragmentBuilder(j.coords1), j.coords1.FragmentStopExpr()
+		}
+		rangeB2, stop2 := htsdb.RangeBuilder, "stop"
+		if j.opts.Fragment2 == true {
+			rangeB2, stop2 = htsdb.FragmentBuilder(j.coords2), j.coords2.FragmentStopExpr()
+		}
 		rangeDec := Where("strand = ? AND rname = ?")
-		readsB1 := DecorateBuilder(htsdb.RangeBuilder, append(j.decors1, rangeDec)...)
-		readsB2 := DecorateBuilder(htsdb.RangeBuilder, append(j.decors2, rangeDec)...)
+		oris := []feat.Orientation{feat.Forward, feat.Reverse}
+		if j.opts.IgnoreStrand == true {
+			rangeDec = Where("rname = ?")
+			oris = []feat.Orientation{feat.Forward}
+		}
+		readsB1 := DecorateBuilder(rangeB1, append(j.decors1, rangeDec)...)
+		readsB2 := DecorateBuilder(rangeB2, append(j.decors2, rangeDec)...)
 
 		// prepare statements.
 		var readsStmt1, readsStmt2 *sqlx.Stmt
@@ -157,84 +498,402 @@ func worker(id int, jobs <-chan job, results chan<- result) {
 		if readsStmt2, err = prepareStmt(readsB2, j.db2); err != nil {
 			log.Fatal(err)
 		}
-		// get position extracting function
-		getPos1 := htsdb.Head
-		if j.opts.Pos1 == "3p" {
-			getPos1 = htsdb.Tail
-		}
-		getPos2 := htsdb.Head
-		if j.opts.Pos2 == "3p" {
-			getPos2 = htsdb.Tail
+
+		// statement that counts the valid reads of db1 for pushdown.
+		var countStmt1 *sqlx.Stmt
+		if j.opts.pushdown() {
+			countB1 := DecorateBuilder(htsdb.CountBuilder,
+				append(j.decors1, rangeDec, Where(j.coords1.LenExpr()+" > 0"))...)
+			if countStmt1, err = prepareStmt(countB1, j.db1); err != nil {
+				log.Fatal(err)
+			}
 		}
 
-		hist := make(map[int]uint)
+		hist := aggregate.NewHistogram()
+		fwd, rev := aggregate.NewHistogram(), aggregate.NewHistogram()
 		var count1, count2 int
-		for _, ori := range []feat.Orientation{feat.Forward, feat.Reverse} {
-			// loop on reads in db1.
-			wig := make(map[int]uint)
+		for _, ori := range oris {
 			ori1 := ori
 			if j.opts.Anti == true {
 				ori1 = -1 * ori1
 			}
-			rows1, err := readsStmt1.Queryx(ori1, j.ref.Name())
-			if err != nil {
-				log.Fatal(err)
-			}
-			for rows1.Next() {
-				if err = rows1.StructScan(&r); err != nil {
-					log.Fatal(err)
+			it1 := newPosIter(j.ctx, j.opts.Pos1, j.opts.Offset1, ori1, j.coords1, stop1)
+			it2 := newPosIter(j.ctx, j.opts.Pos2, j.opts.Offset2, ori, j.coords2, stop2)
+			it1.anyStrand, it2.anyStrand = j.opts.IgnoreStrand, j.opts.IgnoreStrand
+			checker := &htsdb.RangeChecker{}
+			it1.checker, it2.checker = checker, checker
+
+			var oriHist aggregate.Histogram
+			var c1, c2 int
+			ok := false
+			if countStmt1 != nil {
+				oriHist, c1, c2, ok = countPushdown(j, it1, it2, readsB1, readsStmt2, countStmt1)
+				if !ok {
+					checker = &htsdb.RangeChecker{}
+					it1.checker, it2.checker = checker, checker
 				}
-				pos := getPos1(&r, ori1)
-				if _, ok := wig[pos]; ok && j.opts.Collapse1 {
-					continue
+			}
+			if !ok {
+				oriHist, c1, c2, ok = countInMem(j, it1, it2, readsStmt1, readsStmt2)
+			}
+			if !ok {
+				if j.opts.Verbose == true {
+					log.Printf("chrom:%s, memory budget exceeded; streaming\n", j.ref.Name())
 				}
-				count1++
-				wig[pos]++
+				checker = &htsdb.RangeChecker{}
+				it1.checker, it2.checker = checker, checker
+				oriHist, c1, c2 = countSorted(j, it1, it2, readsB1, readsB2)
+			}
+			j.checker.Merge(checker)
+			hist.Merge(oriHist)
+			if ori == feat.Forward {
+				fwd.Merge(oriHist)
+			} else {
+				rev.Merge(oriHist)
 			}
+			count1 += c1
+			count2 += c2
+		}
 
-			// loop on reads in db2.
-			visited := make(map[int]bool)
-			rows2, err := readsStmt2.Queryx(ori, j.ref.Name())
-			if err != nil {
+		// discard the incomplete reference if interrupted.
+		if j.ctx.Err() != nil {
+			continue
+		}
+
+		// record completed reference.
+		if j.cp != nil {
+			saved := savedResult{Hist: hist, Fwd: fwd, Rev: rev, Count1: count1, Count2: count2}
+			if err = j.cp.Save(j.ref.Name(), saved); err != nil {
 				log.Fatal(err)
 			}
-			for rows2.Next() {
-				if err = rows2.StructScan(&r); err != nil {
-					log.Fatal(err)
-				}
-				pos := getPos2(&r, ori)
-				if visited[pos] && j.opts.Collapse2 {
-					continue
-				}
-				visited[pos] = true
-				count2++
-				for relPos := -j.opts.Span; relPos <= j.opts.Span; relPos++ {
-					if pos+relPos < 0 {
-						continue
-					}
-					hist[relPos*int(ori)] += wig[pos+relPos]
-				}
+		}
+
+		// send to results; blocks until the result is aggregated.
+		results <- result{hist: hist, fwd: fwd, rev: rev, ref: j.ref.Name(), count1: count1, count2: count2}
+	}
+}
+
+// posIter iterates on the positions of the reads of a query.
+type posIter struct {
+	ctx    context.Context
+	ori    feat.Orientation
+	coords htsdb.Coords
+	anchor htsdb.Anchor
+	offset int
+	// sortCol is the column expression that pos increases with.
+	sortCol string
+	// anyStrand is true if the query does not filter by strand; all reads
+	// are then handled as having orientation ori.
+	anyStrand bool
+	// checker counts and skips malformed reads.
+	checker *htsdb.RangeChecker
+
+	rows *sqlx.Rows
+	r    htsdb.Range
+	pos  int
+}
+
+// newPosIter returns a posIter for the position offset bases downstream of
+// the pos reference point. stop is the expression of the selected stop column.
+func newPosIter(ctx context.Context, pos string, offset int, ori feat.Orientation,
+	coords htsdb.Coords, stop string) *posIter {
+
+	anchor, err := htsdb.ParseAnchor(pos)
+	if err != nil {
+		log.Fatal(err)
+	}
+	it := &posIter{ctx: ctx, ori: ori, coords: coords, anchor: anchor,
+		offset: offset, sortCol: "start"}
+	if anchor == htsdb.AnchorMid {
+		it.sortCol = "start + " + stop
+	} else if (anchor == htsdb.AnchorHead) == (ori == feat.Reverse) {
+		it.sortCol = stop
+	}
+	return it
+}
+
+// query runs stmt for ref. Iteration stops early if the context of the
+// iterator is cancelled.
+func (it *posIter) query(stmt *sqlx.Stmt, ref string) {
+	var err error
+	if it.rows, err = stmt.QueryxContext(it.ctx, it.args(ref)...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// args returns the arguments of the statements of the iterator for ref.
+func (it *posIter) args(ref string) []interface{} {
+	if it.anyStrand {
+		return []interface{}{ref}
+	}
+	return []interface{}{it.ori, ref}
+}
+
+func (it *posIter) next() bool {
+	for {
+		if !it.rows.Next() {
+			if err := it.rows.Err(); err != nil && it.ctx.Err() == nil {
+				log.Fatal(err)
+			}
+			return false
+		}
+		if err := it.rows.StructScan(&it.r); err != nil {
+			if it.ctx.Err() != nil {
+				return false
 			}
+			log.Fatal(err)
 		}
+		it.coords.Normalize(&it.r)
+		if it.checker != nil && !it.checker.Check(&it.r) {
+			continue
+		}
+		var ok bool
+		if it.pos, ok = htsdb.PosAtOK(&it.r, it.ori, it.anchor, it.offset); !ok {
+			continue
+		}
+		return true
+	}
+}
 
-		// enqueue in results channel
-		results <- result{hist: hist, job: j, count1: count1, count2: count2}
+// countInMem counts the read pairs of a single orientation by holding the
+// positions of db1 in memory. It returns false if the memory budget is
+// exceeded.
+func countInMem(j job, it1, it2 *posIter, stmt1, stmt2 *sqlx.Stmt) (
+	aggregate.Histogram, int, int, bool) {
+
+	var reserved int64
+	defer func() { j.budget.Release(reserved) }()
+	reserve := func() bool {
+		if !j.budget.Reserve(htsdb.MapEntrySize) {
+			return false
+		}
+		reserved += htsdb.MapEntrySize
+		return true
+	}
+
+	// loop on reads in db1.
+	hist := aggregate.NewHistogram()
+	var count1, count2 int
+	wig := make(map[int]uint)
+	it1.query(stmt1, j.ref.Name())
+	defer it1.rows.Close()
+	for it1.next() {
+		pos := it1.pos
+		n, ok := wig[pos]
+		w := j.weight(j.weight1, int(n), it1.r.CopyNumber)
+		if w == 0 {
+			continue
+		} else if !ok && !reserve() {
+			return nil, 0, 0, false
+		}
+		count1 += w
+		wig[pos] += uint(w)
 	}
+
+	// loop on reads in db2.
+	visited := make(map[int]int)
+	it2.query(stmt2, j.ref.Name())
+	defer it2.rows.Close()
+	for it2.next() {
+		pos := it2.pos
+		n := visited[pos]
+		w := j.weight(j.weight2, n, it2.r.CopyNumber)
+		if w == 0 {
+			continue
+		} else if n == 0 && !reserve() {
+			return nil, 0, 0, false
+		}
+		visited[pos] += w
+		count2 += w
+		for relPos := -j.opts.Span; relPos <= j.opts.Span; relPos++ {
+			if pos+relPos < 0 {
+				continue
+			}
+			hist.Add(relPos*int(it2.ori), j.pairs(relPos, wig[pos+relPos], w))
+		}
+	}
+	return hist, count1, count2, true
 }
 
-func readRefs(
+// countPushdown counts the read pairs of a single orientation by reading the
+// positions of db2 first and scanning only the reads of db1 within span of
+// them, which avoids scanning all reads of references with few db2 reads.
+// The read count of db1 is selected with count1. It returns false if db2 has
+// more than the --pushdown number of reads.
+func countPushdown(j job, it1, it2 *posIter, b1 squirrel.SelectBuilder,
+	stmt2, count1 *sqlx.Stmt) (aggregate.Histogram, int, int, bool) {
+
+	// collect the positions of db2 and their weighted read counts.
+	var count2, reads int
+	visited := make(map[int]int)
+	it2.query(stmt2, j.ref.Name())
+	defer it2.rows.Close()
+	for it2.next() {
+		if reads++; reads > j.opts.Pushdown {
+			return nil, 0, 0, false
+		}
+		pos := it2.pos
+		w := j.weight(j.weight2, visited[pos], it2.r.CopyNumber)
+		if w == 0 {
+			continue
+		}
+		visited[pos] += w
+		count2 += w
+	}
+
+	hist := aggregate.NewHistogram()
+	var c1 int
+	if err := count1.GetContext(it1.ctx, &c1, it1.args(j.ref.Name())...); err != nil {
+		if it1.ctx.Err() != nil {
+			return hist, 0, count2, true
+		}
+		log.Fatal(err)
+	}
+	if len(visited) == 0 {
+		return hist, c1, count2, true
+	}
+
+	// a db1 position lies within its read shifted by at most the offset.
+	slack := j.opts.Offset1
+	if slack < 0 {
+		slack = -slack
+	}
+	anchors := make([]int, 0, len(visited))
+	for pos := range visited {
+		anchors = append(anchors, pos)
+	}
+	batches := htsdb.AnchorBatches(j.ref.Name(), anchors, j.opts.Span, slack,
+		pushdownBatch, j.coords1)
+
+	// loop on the reads of db1 near db2 reads.
+	wig := make(map[int]uint)
+	for _, batch := range batches {
+		stmt1, err := prepareStmt(b1.Where(batch.Filter), j.db1)
+		if err != nil {
+			log.Fatal(err)
+		}
+		it1.query(stmt1, j.ref.Name())
+		for it1.next() {
+			if batch.Contains(it1.pos) {
+				wig[it1.pos]++
+			}
+		}
+		it1.rows.Close()
+		stmt1.Close()
+	}
+	for pos, n := range visited {
+		for relPos := -j.opts.Span; relPos <= j.opts.Span; relPos++ {
+			if pos+relPos < 0 {
+				continue
+			}
+			hist.Add(relPos*int(it2.ori), j.pairs(relPos, wig[pos+relPos], n))
+		}
+	}
+	return hist, c1, count2, true
+}
+
+// countSorted counts the read pairs of a single orientation by streaming the
+// reads of both databases sorted by position. Only the positions of db1
+// within span of the current db2 position are held in memory.
+func countSorted(j job, it1, it2 *posIter, b1, b2 squirrel.SelectBuilder) (
+	aggregate.Histogram, int, int) {
+
+	stmt1, err := prepareStmt(b1.OrderBy(it1.sortCol), j.db1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stmt2, err := prepareStmt(b2.OrderBy(it2.sortCol), j.db2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	it1.query(stmt1, j.ref.Name())
+	defer it1.rows.Close()
+	it2.query(stmt2, j.ref.Name())
+	defer it2.rows.Close()
+
+	hist := aggregate.NewHistogram()
+	var count1, count2 int
+	window := make(map[int]uint)
+	var queue []int
+	last1, last2 := -1, -1
+	run1, run2 := 0, 0
+	pending := it1.next()
+
+	// add adds the db1 position of a read with copies copy number to the
+	// window.
+	add := func(pos, copies int) {
+		if pos != last1 {
+			run1 = 0
+		}
+		w := j.weight(j.weight1, run1, copies)
+		if w == 0 {
+			return
+		}
+		if pos != last1 {
+			queue = append(queue, pos)
+		}
+		last1 = pos
+		run1++
+		count1 += w
+		window[pos] += uint(w)
+	}
+
+	for it2.next() {
+		pos := it2.pos
+		if pos != last2 {
+			run2 = 0
+		}
+		w := j.weight(j.weight2, run2, it2.r.CopyNumber)
+		if w == 0 {
+			continue
+		}
+		last2 = pos
+		run2++
+		count2 += w
+		for pending && it1.pos <= pos+j.opts.Span {
+			add(it1.pos, it1.r.CopyNumber)
+			pending = it1.next()
+		}
+		for len(queue) > 0 && queue[0] < pos-j.opts.Span {
+			delete(window, queue[0])
+			queue = queue[1:]
+		}
+		for relPos := -j.opts.Span; relPos <= j.opts.Span; relPos++ {
+			if pos+relPos < 0 {
+				continue
+			}
+			hist.Add(relPos*int(it2.ori), j.pairs(relPos, window[pos+relPos], w))
+		}
+	}
+	// count remaining reads in db1 without holding their positions.
+	for ; pending; pending = it1.next() {
+		if it1.pos != last1 {
+			run1 = 0
+		}
+		w := j.weight(j.weight1, run1, it1.r.CopyNumber)
+		if w == 0 {
+			continue
+		}
+		last1 = it1.pos
+		run1++
+		count1 += w
+	}
+	return hist, count1, count2
+}
+
+func readRefs(ctx context.Context,
 	db1, db2 *sqlx.DB, decors1, decors2 []BuilderDecorator) ([]feat.Feature, error) {
 
 	var refs []feat.Feature
 
 	// select reference features
 	refsB1 := DecorateBuilder(htsdb.ReferenceBuilder, decors1...)
-	refs1, err := htsdb.SelectReferences(db1, refsB1)
+	refs1, err := htsdb.SelectReferencesContext(ctx, db1, refsB1)
 	if err != nil {
 		log.Fatal(err)
 	}
 	refsB2 := DecorateBuilder(htsdb.ReferenceBuilder, decors2...)
-	refs2, err := htsdb.SelectReferences(db2, refsB2)
+	refs2, err := htsdb.SelectReferencesContext(ctx, db2, refsB2)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -253,17 +912,55 @@ func readRefs(
 }
 
 type job struct {
+	ctx              context.Context
 	opts             Opts
 	ref              feat.Feature
 	decors1, decors2 []BuilderDecorator
 	db1, db2         *sqlx.DB
+	coords1, coords2 htsdb.Coords
+	cp               *htsdb.Checkpoint
+	budget           *htsdb.MemBudget
+	checker          *htsdb.RangeChecker
+	weight1, weight2 htsdb.Weighting
+}
+
+// weight returns the weight with weighting w of a read with copies copy
+// number at a position with n previously counted reads, or 0 if the read is
+// not counted e.g. beyond --max-per-pos.
+func (j job) weight(w htsdb.Weighting, n, copies int) int {
+	if n > 0 && j.opts.capped(n) {
+		return 0
+	}
+	return w.Weight(n, copies)
+}
+
+// pairs returns the pairs of db2 reads of weight w with db1 reads of weight n
+// at relative position relPos. With --self the pair of each read with itself
+// is excluded.
+func (j job) pairs(relPos int, n uint, w int) uint {
+	p := n * uint(w)
+	if j.opts.Self && relPos == 0 && p >= uint(w) {
+		p -= uint(w)
+	}
+	return p
 }
 
+// result is the histogram and read counts of a reference. It holds the
+// reference name rather than the job so that in-flight results do not retain
+// the job state.
 type result struct {
-	hist   map[int]uint
-	count1 int
-	count2 int
-	job    job
+	hist aggregate.Histogram
+	// fwd and rev are the parts of hist from forward and reverse db2 reads.
+	fwd, rev aggregate.Histogram
+	count1   int
+	count2   int
+	ref      string
+}
+
+// savedResult is the checkpointed form of result.
+type savedResult struct {
+	Hist, Fwd, Rev aggregate.Histogram
+	Count1, Count2 int
 }
 
 // A BuilderDecorator wraps a squirrel.SelectBuilder with extra behaviour.
@@ -289,8 +986,19 @@ func Where(clause string) BuilderDecorator {
 	}
 }
 
-//DecorateBuilder decorates a squirrel.SelectBuilder with all the given
-//BuilderDecorators, in order.
+// WhereNot returns a BuilderDecorator that extends a squirrel.SelectBuilder
+// with a where clause that excludes the records matching clause, including
+// those for which it is NULL. Returns the builder itself if the clause is the
+// empty string.
+func WhereNot(clause string) BuilderDecorator {
+	if clause == "" {
+		return Where("")
+	}
+	return Where(htsdb.NotClause(clause))
+}
+
+// DecorateBuilder decorates a squirrel.SelectBuilder with all the given
+// BuilderDecorators, in order.
 func DecorateBuilder(b squirrel.SelectBuilder, ds ...BuilderDecorator) squirrel.SelectBuilder {
 	decorated := b
 	for _, decorate := range ds {
@@ -304,9 +1012,24 @@ func prepareStmt(b squirrel.SelectBuilder, db *sqlx.DB) (*sqlx.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	stmt, err := db.Preparex(q)
+	stmt, err := db.Preparex(db.Rebind(q))
 	if err != nil {
 		return nil, err
 	}
 	return stmt, nil
 }
+
+// openDB opens dsn with driver allowing up to conns connections. SQLite
+// databases are opened read-only.
+func openDB(driver, dsn string, conns int) (*sqlx.DB, error) {
+	if driver == htsdb.SQLite {
+		return htsdb.OpenReadOnly(dsn, conns)
+	}
+	db, err := htsdb.Connect(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
+	return db, nil
+}
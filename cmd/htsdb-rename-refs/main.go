@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-rename-refs"
+const version = "0.5"
+const descr = `Rename the references of database records in place. References
+are renamed either to UCSC (chr1, chrM) or Ensembl (1, MT) style or according
+to a two-column mapping file with old and new names. The rnext column is
+renamed as well if it exists. Renaming fails if two references would have the
+same name.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	refMap = app.Flag("ref-map", "Rename to ucsc or ensembl style or use mapping file.").
+		PlaceHolder("<ucsc|ensembl|file>").Required().String()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	rename, err := htsdb.RefRenamer(*refMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CheckUnlocked(db, *force); err != nil {
+		log.Fatal(err)
+	}
+
+	// select distinct reference names.
+	var rnames []string
+	if err = db.SelectContext(ctx, &rnames, "SELECT DISTINCT rname FROM "+*tab+
+		" WHERE rname IS NOT NULL"); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "no changes were made")
+		}
+		log.Fatal(err)
+	}
+
+	// check if rnext exists.
+	var cols []string
+	rows, err := db.Queryx("SELECT * FROM " + *tab + " LIMIT 0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cols, err = rows.Columns(); err != nil {
+		log.Fatal(err)
+	}
+	rows.Close()
+	renameCols := []string{"rname"}
+	for _, c := range cols {
+		if c == "rnext" {
+			renameCols = append(renameCols, c)
+		}
+	}
+
+	m, err := renames(rnames, rename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		for _, old := range rnames {
+			if name, ok := m[old]; ok {
+				log.Printf("%s -> %s\n", old, name)
+			}
+		}
+	}
+
+	// rename in a single transaction.
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = renameRefs(ctx, tx, *tab, renameCols, m); err != nil {
+		tx.Rollback()
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "no changes were made")
+		}
+		log.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
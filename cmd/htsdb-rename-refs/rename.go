@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// renameBatch is the maximum number of names renamed by a single UPDATE so
+// that its arguments stay within the limits of the databases.
+var renameBatch = 5000
+
+// renames returns the new name of each of rnames that rename changes. It fails
+// if two references would have the same name after renaming.
+func renames(rnames []string, rename func(string) string) (map[string]string, error) {
+	sorted := append([]string(nil), rnames...)
+	sort.Strings(sorted)
+	m := make(map[string]string)
+	seen := make(map[string]string)
+	for _, old := range sorted {
+		name := rename(old)
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("references %s and %s are both renamed to %s",
+				prev, old, name)
+		}
+		seen[name] = old
+		if name != old {
+			m[old] = name
+		}
+	}
+	return m, nil
+}
+
+// renameRefs renames the references of columns cols of table according to m.
+// Each UPDATE matches the names that the values had before it ran, so that
+// swapped or chained names e.g. 1 to chr1 and chr1 to 1 are renamed once.
+func renameRefs(ctx context.Context, tx *sqlx.Tx, table string, cols []string, m map[string]string) error {
+	steps := []map[string]string{m}
+	if len(m) > renameBatch {
+		// a later batch could match the new names of an earlier one, so
+		// rename through temporary names that no reference has; SAM
+		// reference names have no spaces.
+		tmp, final := make(map[string]string), make(map[string]string)
+		for old, name := range m {
+			t := "htsdb rename " + old
+			tmp[old], final[t] = t, name
+		}
+		steps = []map[string]string{tmp, final}
+	}
+	for _, step := range steps {
+		olds := make([]string, 0, len(step))
+		for old := range step {
+			olds = append(olds, old)
+		}
+		sort.Strings(olds)
+		for len(olds) > 0 {
+			n := len(olds)
+			if n > renameBatch {
+				n = renameBatch
+			}
+			for _, c := range cols {
+				if err := renameBatchCol(ctx, tx, table, c, olds[:n], step); err != nil {
+					return err
+				}
+			}
+			olds = olds[n:]
+		}
+	}
+	return nil
+}
+
+// renameBatchCol renames olds in column c of table in a single UPDATE.
+func renameBatchCol(ctx context.Context, tx *sqlx.Tx, table, c string, olds []string, m map[string]string) error {
+	args := make([]interface{}, 0, 3*len(olds))
+	var b strings.Builder
+	b.WriteString("UPDATE " + table + " SET " + c + " = CASE " + c)
+	for _, old := range olds {
+		b.WriteString(" WHEN ? THEN ?")
+		args = append(args, old, m[old])
+	}
+	b.WriteString(" END WHERE " + c + " IN (?" + strings.Repeat(", ?", len(olds)-1) + ")")
+	for _, old := range olds {
+		args = append(args, old)
+	}
+	_, err := tx.ExecContext(ctx, tx.Rebind(b.String()), args...)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRenameSwapAndChain(t *testing.T) {
+	defer func(n int) { renameBatch = n }(renameBatch)
+	for _, batch := range []int{renameBatch, 1} {
+		db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("CREATE TABLE sample (rname, rnext)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("INSERT INTO sample VALUES ('1', 'chr1'), ('chr1', '1')," +
+			" ('a', 'b'), ('b', 'a'), ('x', '=')")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapping := map[string]string{"1": "chr1", "chr1": "1", "a": "b", "b": "c"}
+		m, err := renames([]string{"1", "chr1", "a", "b", "x"}, func(s string) string {
+			if n, ok := mapping[s]; ok {
+				return n
+			}
+			return s
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		renameBatch = batch
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = renameRefs(context.Background(), tx, "sample", []string{"rname", "rnext"}, m); err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		var rows [][2]string
+		r, err := db.Query("SELECT rname, rnext FROM sample ORDER BY rowid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r.Next() {
+			var row [2]string
+			if err = r.Scan(&row[0], &row[1]); err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		db.Close()
+		exp := [][2]string{{"chr1", "1"}, {"1", "chr1"}, {"b", "c"}, {"c", "b"}, {"x", "="}}
+		if !reflect.DeepEqual(rows, exp) {
+			t.Errorf("batch %d: expected %v, actual %v", batch, exp, rows)
+		}
+	}
+}
+
+func TestRenamesCollision(t *testing.T) {
+	_, err := renames([]string{"1", "chr1"}, func(s string) string { return "chr1" })
+	if err == nil {
+		t.Error("expected error for two references renamed to chr1")
+	}
+}
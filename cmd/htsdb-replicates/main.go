@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/normalize"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-replicates"
+const version = "0.8"
+const descr = `Measure the concordance of replicate databases. Reads are counted
+in the features of a BED or GTF file or, if none is given, in the
+non-overlapping tiles of a BED file (--tiles) or in fixed size bins of the
+genome. For each pair of databases the Pearson correlation of log2
+normalized counts (CPM by default), the Spearman correlation of raw counts and
+the fraction of shared top ranked features (an IDR-style rank concordance)
+are printed. A principal component analysis of the samples can be written as
+TSV and as an SVG plot. Provided SQL filter will apply to all databases.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFiles = app.Flag("db", "File to SQLite database; repeat for each replicate.").
+		PlaceHolder("<file>").Required().Strings()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql each --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	names = app.Flag("name", "Sample name for each database; defaults to the file name.").
+		PlaceHolder("<name>").Strings()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	featsFile = app.Flag("feats", "BED or GTF (.gtf) file with features to count reads in.").
+			PlaceHolder("<file>").String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. exon.").
+			PlaceHolder("<type>").String()
+	binSize = app.Flag("bin-size", "Size of genomic bins if no features are given.").
+		Default("10000").Int()
+	tilesFile = app.Flag("tiles", "BED file with non-overlapping tiles to count reads starting in if no features are given.").
+			PlaceHolder("<file>").String()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	norm = app.Flag("normalize", "Normalization of counts before log transformation.").
+		Default("cpm").Enum(normalize.Methods...)
+	scaleFactors = app.Flag("scale-factors", "Per sample scale factors e.g. rep1=1.2,rep2=0.9.").
+			PlaceHolder("<name=factor,...>").String()
+	spikeWhere = app.Flag("spike-where", "SQL filter that selects spike-in reads for spike normalization.").
+			PlaceHolder("<SQL>").String()
+	genomeSize = app.Flag("effective-genome-size", "Effective genome size for rpgc normalization; defaults to the mappable or total reference length.").
+			Default("0").Int()
+	mappability = app.Flag("mappability", "BED file with mappable regions whose total length is the effective genome size.").
+			PlaceHolder("<file>").String()
+	top = app.Flag("top", "Fraction of top ranked features compared for rank concordance.").
+		Default("0.1").Float64()
+	pcaFile = app.Flag("pca", "File to write the principal components of samples.").
+		PlaceHolder("<file>").String()
+	plotFile = app.Flag("plot", "File to write an SVG plot of the first two principal components.").
+			PlaceHolder("<file>").String()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("4").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("fixed").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if len(*dbFiles) < 2 {
+		kingpin.Fatalf("at least two databases are required")
+	}
+	if len(*names) == 0 {
+		for _, f := range *dbFiles {
+			*names = append(*names, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)))
+		}
+	}
+	if len(*names) != len(*dbFiles) {
+		kingpin.Fatalf("expected %d names, got %d", len(*dbFiles), len(*names))
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	table := cols.Table(*tab)
+
+	// read features or tiles.
+	var regions []htsdb.Region
+	if *featsFile != "" {
+		if regions, err = htsdb.ReadFeatureRegions(*featsFile, *featType); err != nil {
+			log.Fatal(err)
+		}
+	}
+	tiles, err := htsdb.ReadTilesFile(*tilesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	method, err := normalize.Parse(*norm)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	factors, err := normalize.ParseFactors(*scaleFactors)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	mapp, err := htsdb.ReadMappabilityFile(*mappability)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// count reads of each database.
+	var counts []map[htsdb.Region]float64
+	var norms []normalize.Normalizer
+	for i, f := range *dbFiles {
+		if *verbose == true {
+			log.Printf("db:%s\n", f)
+		}
+		db, err := htsdb.Connect(*driver, f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var c map[htsdb.Region]float64
+		if regions != nil {
+			c, err = countFeats(ctx, db, table, regions)
+		} else {
+			c, err = countBins(ctx, db, table, tiles)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "nothing was printed")
+			}
+			log.Fatalf("%s: %s", (*names)[i], err)
+		}
+		factor, ok := factors[(*names)[i]]
+		if !ok {
+			factor = 1
+		}
+		n, err := normalize.New(db, method, table, *where, *spikeWhere, *copyNum, factor)
+		if err != nil {
+			log.Fatalf("%s: %s", (*names)[i], err)
+		}
+		if method == normalize.RPGC {
+			if n.GenomeSize, err = genomeSizeOf(db, table, mapp); err != nil {
+				log.Fatalf("%s: %s", (*names)[i], err)
+			}
+		}
+		norms = append(norms, n)
+		db.Close()
+		counts = append(counts, c)
+	}
+
+	// assemble the feature by sample matrix.
+	keys := regions
+	if keys == nil && tiles != nil {
+		keys = tiles.Regions()
+	}
+	if keys == nil {
+		set := make(map[htsdb.Region]bool)
+		for _, c := range counts {
+			for k := range c {
+				set[k] = true
+			}
+		}
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Rname != keys[j].Rname {
+				return keys[i].Rname < keys[j].Rname
+			}
+			return keys[i].Start < keys[j].Start
+		})
+	}
+	raw := make([][]float64, len(counts))
+	logNorm := make([][]float64, len(counts))
+	for s, c := range counts {
+		raw[s] = make([]float64, len(keys))
+		logNorm[s] = make([]float64, len(keys))
+		for i, k := range keys {
+			raw[s][i] = c[k]
+			length := k.Stop - k.Start + 1
+			if len(mapp) > 0 {
+				length = mapp.Bases(k)
+			}
+			logNorm[s][i] = math.Log2(norms[s].Value(c[k], length) + 1)
+		}
+	}
+
+	// print pairwise concordance.
+	fmt.Printf("sample1\tsample2\tpearson\tspearman\ttop_overlap\n")
+	for i := 0; i < len(raw); i++ {
+		for j := i + 1; j < len(raw); j++ {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", (*names)[i], (*names)[j],
+				ff.Format(htsdb.Pearson(logNorm[i], logNorm[j])),
+				ff.Format(htsdb.Spearman(raw[i], raw[j])),
+				ff.Format(topOverlap(raw[i], raw[j], *top)))
+		}
+	}
+
+	// principal component analysis of samples.
+	if *pcaFile == "" && *plotFile == "" {
+		return
+	}
+	scores, explained := pca(logNorm)
+	if *pcaFile != "" {
+		f, err := os.Create(*pcaFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(f, "sample")
+		for k := range explained {
+			fmt.Fprintf(f, "\tPC%d", k+1)
+		}
+		fmt.Fprintf(f, "\n")
+		for s, name := range *names {
+			fmt.Fprintf(f, "%s", name)
+			for k := range explained {
+				fmt.Fprintf(f, "\t%s", ff.Format(scores[s][k]))
+			}
+			fmt.Fprintf(f, "\n")
+		}
+		fmt.Fprintf(f, "explained_variance")
+		for _, e := range explained {
+			fmt.Fprintf(f, "\t%s", ff.Format(e))
+		}
+		fmt.Fprintf(f, "\n")
+		if err = f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *plotFile != "" {
+		if err = writePlot(*plotFile, *names, scores, explained); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// countFeats returns the number of reads contained in each region.
+// genomeSizeOf returns the effective genome size for db; the size given on the
+// command line takes precedence over the mappable and total reference length.
+func genomeSizeOf(db *sqlx.DB, table string, mapp htsdb.Mappability) (float64, error) {
+	if *genomeSize > 0 {
+		return float64(*genomeSize), nil
+	}
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		return 0, err
+	}
+	lens, err := htsdb.ReferenceLengths(db, table, coords)
+	if err != nil {
+		return 0, err
+	}
+	return float64(htsdb.EffectiveGenomeSize(lens, mapp)), nil
+}
+
+func countFeats(ctx context.Context, db *sqlx.DB, table string, regions []htsdb.Region) (map[htsdb.Region]float64, error) {
+	coords, err := htsdb.SelectCoordsContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	b := squirrel.Select().Column(countExpr()).From(table).
+		Where("rname = ? AND start BETWEEN ? AND ? AND stop BETWEEN ? AND ?")
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		return nil, err
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.PreparexContext(ctx, db.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	counts := make(map[htsdb.Region]float64)
+	for _, r := range regions {
+		var c float64
+		start, stop := coords.FromHtsdb(r.Start, r.Stop)
+		if err = stmt.GetContext(ctx, &c, r.Rname, start, stop, start, stop); err != nil {
+			return nil, err
+		}
+		counts[r] += c
+	}
+	return counts, nil
+}
+
+// countBins returns the number of reads starting in each genomic bin or, if
+// tiles is not nil, in each tile.
+func countBins(ctx context.Context, db *sqlx.DB, table string, tiles htsdb.Tiles) (map[htsdb.Region]float64, error) {
+	coords, err := htsdb.SelectCoordsContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	b := htsdb.FeatureBuilder.From(table)
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		return nil, err
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[htsdb.Region]float64)
+	var r htsdb.Feature
+	for rows.Next() {
+		if err = rows.StructScan(&r); err != nil {
+			return nil, err
+		}
+		coords.Normalize(&r.Range)
+		var k htsdb.Region
+		if tiles != nil {
+			var ok bool
+			if k, ok = tiles.Find(r.Rname, r.Start()); !ok {
+				continue
+			}
+		} else {
+			bin := r.Start() / *binSize
+			k = htsdb.Region{Rname: r.Rname, Start: bin * *binSize, Stop: (bin+1)**binSize - 1}
+		}
+		if *copyNum == true {
+			counts[k] += float64(r.CopyNumber)
+		} else {
+			counts[k]++
+		}
+	}
+	return counts, rows.Err()
+}
+
+func countExpr() string {
+	if *copyNum == true {
+		return htsdb.CopyNumberTotal
+	}
+	return htsdb.RecordCount
+}
+
+// topOverlap returns the fraction of the top ranked features of x that are
+// also top ranked in y. Features with zero counts are never top ranked.
+func topOverlap(x, y []float64, frac float64) float64 {
+	k := int(math.Ceil(frac * float64(len(x))))
+	if k == 0 {
+		return math.NaN()
+	}
+	topX, topY := topSet(x, k), topSet(y, k)
+	if len(topX) == 0 {
+		return math.NaN()
+	}
+	shared := 0
+	for i := range topX {
+		if topY[i] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(topX))
+}
+
+func topSet(x []float64, k int) map[int]bool {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return x[idx[i]] > x[idx[j]] })
+	set := make(map[int]bool)
+	for _, i := range idx[:k] {
+		if x[i] > 0 {
+			set[i] = true
+		}
+	}
+	return set
+}
+
+// pca returns the principal component scores of the samples (rows) of x and
+// the fraction of variance explained by each component.
+func pca(x [][]float64) ([][]float64, []float64) {
+	n := len(x)
+	m := len(x[0])
+
+	// center each feature.
+	centered := make([][]float64, n)
+	for s := range centered {
+		centered[s] = make([]float64, m)
+	}
+	for f := 0; f < m; f++ {
+		var mean float64
+		for s := 0; s < n; s++ {
+			mean += x[s][f]
+		}
+		mean /= float64(n)
+		for s := 0; s < n; s++ {
+			centered[s][f] = x[s][f] - mean
+		}
+	}
+
+	// eigen decomposition of the sample Gram matrix.
+	g := make([][]float64, n)
+	for i := range g {
+		g[i] = make([]float64, n)
+		for j := range g[i] {
+			for f := 0; f < m; f++ {
+				g[i][j] += centered[i][f] * centered[j][f]
+			}
+		}
+	}
+	vals, vecs := jacobi(g)
+
+	var total float64
+	for _, v := range vals {
+		if v > 0 {
+			total += v
+		}
+	}
+	scores := make([][]float64, n)
+	explained := make([]float64, n)
+	for s := range scores {
+		scores[s] = make([]float64, n)
+		for k := range vals {
+			if vals[k] > 0 {
+				scores[s][k] = vecs[s][k] * math.Sqrt(vals[k])
+			}
+		}
+	}
+	for k, v := range vals {
+		if total > 0 && v > 0 {
+			explained[k] = v / total
+		}
+	}
+	return scores, explained
+}
+
+// jacobi returns the eigenvalues of the symmetric matrix a in decreasing
+// order and the corresponding eigenvectors as columns.
+func jacobi(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+	v := make([][]float64, n)
+	for i := range v {
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		var off float64
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-20 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return a[order[i]][order[i]] > a[order[j]][order[j]] })
+	vals := make([]float64, n)
+	vecs := make([][]float64, n)
+	for i := range vecs {
+		vecs[i] = make([]float64, n)
+	}
+	for k, o := range order {
+		vals[k] = a[o][o]
+		for i := 0; i < n; i++ {
+			vecs[i][k] = v[i][o]
+		}
+	}
+	return vals, vecs
+}
+
+// writePlot writes an SVG scatter plot of the first two principal components
+// to file f.
+func writePlot(f string, names []string, scores [][]float64, explained []float64) error {
+	const size, margin = 500.0, 60.0
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, s := range scores {
+		minX, maxX = math.Min(minX, s[0]), math.Max(maxX, s[0])
+		minY, maxY = math.Min(minY, s[1]), math.Max(maxY, s[1])
+	}
+	scale := func(v, min, max float64) float64 {
+		if max == min {
+			return 0.5
+		}
+		return (v - min) / (max - min)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%g\" height=\"%g\">\n",
+		size+2*margin, size+2*margin)
+	fmt.Fprintf(&b, "<rect x=\"%g\" y=\"%g\" width=\"%g\" height=\"%g\" fill=\"none\" stroke=\"black\"/>\n",
+		margin, margin, size, size)
+	fmt.Fprintf(&b, "<text x=\"%g\" y=\"%g\" text-anchor=\"middle\">PC1 (%.1f%%)</text>\n",
+		margin+size/2, size+1.6*margin, explained[0]*100)
+	fmt.Fprintf(&b, "<text x=\"%g\" y=\"%g\" text-anchor=\"middle\" transform=\"rotate(-90 %g %g)\">PC2 (%.1f%%)</text>\n",
+		margin/2, margin+size/2, margin/2, margin+size/2, explained[1]*100)
+	for i, s := range scores {
+		x := margin + 10 + scale(s[0], minX, maxX)*(size-20)
+		y := margin + size - 10 - scale(s[1], minY, maxY)*(size-20)
+		fmt.Fprintf(&b, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"5\"/>\n", x, y)
+		fmt.Fprintf(&b, "<text x=\"%.1f\" y=\"%.1f\" font-size=\"12\">%s</text>\n", x+7, y-7, names[i])
+	}
+	b.WriteString("</svg>\n")
+	return os.WriteFile(f, []byte(b.String()), 0644)
+}
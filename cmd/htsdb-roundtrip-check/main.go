@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-roundtrip-check"
+const version = "0.7"
+const descr = `Verify that a database reproduces the alignments of the BAM file
+it was imported from. All SAM fields, including tags and quality strings, are
+compared regardless of record order so that silent field truncation during
+import or export is detected. The references of the BAM header are compared
+against the reference table of the database if present. Unmapped reads are
+not compared. Exits with status 1 if the alignments differ.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	bamFile = app.Flag("bam", "Original BAM file.").
+		PlaceHolder("<file>").Required().String()
+	dbFile = app.Flag("db", "File to SQLite database imported from the BAM file.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	where = app.Flag("where", "SQL filter injected in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	examples = app.Flag("examples", "Maximum number of differing database records to print.").
+			Default("10").Int()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open BAM file and database.
+	orig, err := htsdb.NewBAMReader(*bamFile, &htsdb.SamRecord{}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer orig.Close()
+
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// compare header references.
+	ok := true
+	seqs, err := htsdb.SelectRefSeqs(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if seqs == nil {
+		log.Printf("warning: no reference table; header not compared\n")
+	} else {
+		refs := make([]htsdb.Reference, len(seqs))
+		for i, s := range seqs {
+			refs[i] = htsdb.Reference{Chrom: s.Name, Length: s.Length}
+		}
+		bamRefs, err := orig.References()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range htsdb.CompareReferences(bamRefs, refs) {
+			fmt.Printf("header\t%s\n", p)
+			ok = false
+		}
+	}
+
+	// compare alignments.
+	readsB := htsdb.SamRecordBuilder.From(*tab)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+	query, _, err := readsB.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := htsdb.NewReaderContext(ctx, db.DB, db.DriverName(), &htsdb.SamRecord{}, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	rt, err := htsdb.CompareAlignments(orig, out, *examples)
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "comparison is incomplete")
+		}
+		log.Fatal(err)
+	}
+	fmt.Printf("records\tbam:%d\tdb:%d\n", rt.Records[0], rt.Records[1])
+	fmt.Printf("missing\t%d\n", rt.Missing)
+	fmt.Printf("extra\t%d\n", rt.Extra)
+	for _, l := range rt.Examples {
+		fmt.Printf("differs\t%s\n", l)
+	}
+	if !ok || !rt.OK() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/pipeline"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-run"
+const version = "0.3"
+const descr = `Run the analysis steps of a YAML pipeline file on a database table
+in a single scan. The file names the database, the table and a list of steps
+that are applied in order to the reads of each reference: filter (where,
+min-len, max-len, min-copies), collapse (anchor), coverage (out, strand) and
+metagene (features, type, bins, flank, anchor, stranded, out). SQL filters must
+precede the other steps. Each analysis writes its own output file, e.g.
+
+db: sample.db
+table: sample
+steps:
+  - filter: {where: "mapq >= 10"}
+  - collapse: {anchor: 5p}
+  - coverage: {out: sample.bedgraph}
+  - metagene: {features: genes.bed, bins: 50, flank: 500, out: metagene.tsv}`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	specFile = app.Arg("pipeline", "YAML file with the pipeline.").
+			Required().String()
+	dbFile = app.Flag("db", "Override the database of the pipeline.").
+		PlaceHolder("<file>").String()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	spec, err := pipeline.ReadSpec(*specFile)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *dbFile != "" {
+		spec.DB = *dbFile
+	}
+	if err = spec.Validate(); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(spec.Driver, spec.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// restrict the scan to the regions.
+	coords, err := htsdb.SelectCoordsContext(ctx, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// run all steps in a single scan and write their results.
+	p, err := pipeline.New(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	if err = p.RunContext(ctx, db, regsFilter); err != nil {
+		if ctx.Err() != nil {
+			p.Close()
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("steps:%d, elapsed:%s\n", len(spec.Steps), time.Since(start))
+	}
+}
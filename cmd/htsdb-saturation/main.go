@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-saturation"
+const version = "0.11"
+const descr = `Subsample the reads of a database at multiple fractions and print
+the number of features with at least a minimum number of reads at each
+depth, producing sequencing saturation curves. Features are read from a GTF
+file and intervals sharing an attribute (e.g. the exons of a gene) form a
+single feature; a read is assigned to a feature if it is contained in any of
+its intervals. Subsamples are nested: each read of a subsample is also part of
+all larger subsamples. Provided SQL filter will apply to all reads. Reads
+can also be read directly from a BAM file without importing it. Reads whose
+strand is not 1 or -1 are kept with unknown strand, which matches no feature
+with --use-ori, unless --invalid-strand skips them or stops with an error.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	bamFile = app.Flag("bam", "BAM file to read instead of a database.").
+		PlaceHolder("<file>").String()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	gtfFile = app.Flag("gtf", "GTF file with features.").
+		PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "GTF feature type to use.").
+			Default("exon").String()
+	groupAttr = app.Flag("group-attr", "GTF attribute that groups intervals into features.").
+			Default("gene_id").String()
+	fractions = app.Flag("fractions", "Comma separated subsampling fractions.").
+			Default("0.1,0.2,0.3,0.4,0.5,0.6,0.7,0.8,0.9,1").String()
+	minCount = app.Flag("min-count", "Minimum number of reads for a feature to be detected.").
+			Default("10").Int()
+	copyNum = app.Flag("copy-number", "Subsample read copies instead of records.").
+		Bool()
+	useOri = app.Flag("use-ori", "Only assign reads on the orientation of the feature.").
+		Bool()
+	strandPolicy = app.Flag("invalid-strand", "Handling of reads whose strand is not 1 or -1.").
+			Default("unknown").Enum(htsdb.StrandPolicies...)
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
+	seed = app.Flag("seed", "Seed for the random subsampling.").
+		Default("1").Int64()
+	as = app.Flag("as", "Name to print describing the sample.").
+		Default("all").String()
+	header = app.Flag("header", "Print header line.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+// interval is a GTF interval that belongs to feature feat.
+type interval struct {
+	start, stop int
+	strand      int
+	feat        int
+}
+
+// index holds the intervals of each reference sorted by start.
+type index struct {
+	refs   map[string][]interval
+	maxLen int
+}
+
+// containing calls fn for each feature with an interval that contains
+// start-stop. A feature may be reported more than once.
+func (idx *index) containing(rname string, start, stop, strand int, fn func(int)) {
+	ivs := idx.refs[rname]
+	i := sort.Search(len(ivs), func(i int) bool { return ivs[i].start > start })
+	for i--; i >= 0 && ivs[i].start > stop-idx.maxLen; i-- {
+		iv := ivs[i]
+		if iv.stop >= stop && (strand == 0 || iv.strand == strand) {
+			fn(iv.feat)
+		}
+	}
+}
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// parse subsampling fractions in increasing order.
+	var fracs []float64
+	for _, s := range strings.Split(*fractions, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || f <= 0 || f > 1 {
+			kingpin.Fatalf("invalid fraction %q", s)
+		}
+		fracs = append(fracs, f)
+	}
+	sort.Float64s(fracs)
+	if (*dbFile == "") == (*bamFile == "") {
+		kingpin.Fatalf("exactly one of --db and --bam is required")
+	}
+	policy, err := htsdb.ParseStrandPolicy(*strandPolicy)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read features.
+	idx, nFeats, err := readIndex(*gtfFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open reads of the database or BAM file.
+	var reads htsdb.RecordSource
+	var r htsdb.OrientedFeature
+	if *bamFile != "" {
+		if *where != "" || *colMap != "" || common.Regions != "" || common.Blacklist != "" {
+			kingpin.Fatalf("--where, --col-map, --regions and --blacklist cannot be used with --bam")
+		}
+		br, err := htsdb.NewBAMReader(*bamFile, &r, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer br.Close()
+		br.SetStrandPolicy(policy)
+		reads = br
+	} else {
+		db, err := htsdb.Connect(*driver, *dbFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+			log.Fatal(err)
+		}
+		table := cols.Table(*tab)
+		coords, err := htsdb.SelectCoords(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// assemble sqlx select builders
+		readsB := htsdb.OrientedFeatureBuilder.From(table)
+		if *where != "" {
+			readsB = readsB.Where(*where)
+		}
+
+		// keep reads in the regions and exclude blacklisted regions.
+		bl, err := common.ReadBlacklist()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(bl) > 0 {
+			n, err := bl.Count(db, table, *where, coords)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("blacklist: excluded %d records\n", n)
+		}
+		regsFilter, err := common.Filter(db, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if regsFilter != "" {
+			readsB = readsB.Where(regsFilter)
+		}
+
+		// exclude low-complexity reads.
+		if *maskLowComplexity == true {
+			f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+			if err != nil {
+				log.Fatal(err)
+			}
+			readsB = readsB.Where(f)
+		}
+		query, _, err := readsB.ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dr, err := htsdb.NewReaderContext(ctx, db.DB, db.DriverName(), &r, query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dr.SetStrandPolicy(policy)
+		reads = dr
+	}
+
+	// assign each read, or read copy, a random number and count it in all
+	// subsamples with a larger fraction.
+	rnd := rand.New(rand.NewSource(*seed))
+	counts := make([][]int, len(fracs))
+	for i := range counts {
+		counts[i] = make([]int, nFeats)
+	}
+	totals := make([]int, len(fracs))
+	var feats []int
+	seen := make(map[int]bool)
+	for reads.Next() {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		strand := 0
+		if *useOri == true {
+			strand = int(r.Orient)
+		}
+		feats = feats[:0]
+		for k := range seen {
+			delete(seen, k)
+		}
+		// reads of unknown strand match no feature when orientation is used.
+		if *useOri == false || r.Strand().Known() {
+			idx.containing(r.Rname, r.Start(), r.End()-1, strand, func(f int) {
+				if !seen[f] {
+					seen[f] = true
+					feats = append(feats, f)
+				}
+			})
+		}
+		units := 1
+		if *copyNum == true {
+			units = r.CopyNumber
+		}
+		for u := 0; u < units; u++ {
+			x := rnd.Float64()
+			for i := len(fracs) - 1; i >= 0 && x < fracs[i]; i-- {
+				totals[i]++
+				for _, f := range feats {
+					counts[i][f]++
+				}
+			}
+		}
+	}
+	if err = reads.Err(); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		log.Fatal(err)
+	}
+	if s, ok := reads.(interface{ Skipped() int }); ok && s.Skipped() > 0 {
+		log.Printf("warning: skipped %d reads with invalid strand\n", s.Skipped())
+	}
+
+	// print results.
+	if *header == true {
+		fmt.Printf("category\tfraction\treads\tfeatures\n")
+	}
+	for i, frac := range fracs {
+		detected := 0
+		for _, c := range counts[i] {
+			if c >= *minCount {
+				detected++
+			}
+		}
+		fmt.Printf("%s\t%g\t%d\t%d\n", *as, frac, totals[i], detected)
+	}
+}
+
+// readIndex reads the GTF file f and returns an index of its intervals and the
+// number of features.
+func readIndex(f string) (*index, int, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fh.Close()
+	idx := &index{refs: make(map[string][]interval)}
+	ids := make(map[string]int)
+	err = htsdb.ReadGTF(fh, func(g *htsdb.GTFRecord) error {
+		if g.Feature != *featType {
+			return nil
+		}
+		name, ok := g.Attributes[*groupAttr]
+		if !ok {
+			return fmt.Errorf("GTF record at %s:%d has no %s attribute",
+				g.Seqname, g.Start, *groupAttr)
+		}
+		id, ok := ids[name]
+		if !ok {
+			id = len(ids)
+			ids[name] = id
+		}
+		idx.refs[g.Seqname] = append(idx.refs[g.Seqname],
+			interval{start: g.Start, stop: g.End, strand: int(g.Strand), feat: id})
+		if l := g.End - g.Start + 1; l > idx.maxLen {
+			idx.maxLen = l
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, ivs := range idx.refs {
+		sort.Slice(ivs, func(i, j int) bool { return ivs[i].start < ivs[j].start })
+	}
+	if *verbose == true {
+		log.Printf("features:%d\n", len(ids))
+	}
+	return idx, len(ids), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-site-counts"
+const version = "0.7"
+const descr = `Print the site by sample count matrix for positions of interest,
+e.g. crosslink sites, across several databases. For each site of a BED or GTF
+file and each database, the reads whose anchor position (5' end by default)
+falls in the site extended by the flanks are counted, weighted by copy number
+unless disabled. With --stranded only reads on the strand of the site are
+counted; sites without strand count reads on both strands. Reads of unknown
+strand are not counted. Sites are printed in BED coordinates without the
+flanks. Provided SQL filter will apply to all databases.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFiles = app.Flag("db", "File to SQLite database; repeat for each sample.").
+		PlaceHolder("<file>").Required().Strings()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql each --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	names = app.Flag("name", "Sample name for each database; defaults to the file name.").
+		PlaceHolder("<name>").Strings()
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	sitesFile = app.Flag("sites", "BED or GTF (.gtf) file with the sites.").
+			PlaceHolder("<file>").Required().String()
+	flank = app.Flag("flank", "Number of bases added on each side of sites.").
+		Default("0").Int()
+	anchor = app.Flag("anchor", "Read position that must fall in the site.").
+		Default("5p").Enum("5p", "3p", "mid")
+	stranded = app.Flag("stranded", "Only count reads on the strand of the site.").
+			Bool()
+	copyNum = app.Flag("copy-number", "Weight counts by read copy number; --no-copy-number counts records.").
+		Default("true").Bool()
+	long = app.Flag("long", "Print one row per site and sample with sample and count columns (long format).").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if len(*names) == 0 {
+		for _, f := range *dbFiles {
+			*names = append(*names, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)))
+		}
+	}
+	if len(*names) != len(*dbFiles) {
+		kingpin.Fatalf("expected %d names, got %d", len(*dbFiles), len(*names))
+	}
+	if *flank < 0 {
+		kingpin.Fatalf("--flank must not be negative")
+	}
+	anc, err := htsdb.ParseAnchor(*anchor)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read sites.
+	sites, err := htsdb.ReadAnnotations(*sitesFile, "", "gene_name")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// count reads at the sites of each database.
+	counts := make([][]int, len(*dbFiles))
+	for i, f := range *dbFiles {
+		if *verbose == true {
+			log.Printf("db:%s\n", f)
+		}
+		if counts[i], err = countSites(ctx, f, cols, sites, anc); err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "nothing was printed")
+			}
+			log.Fatalf("%s: %s", (*names)[i], err)
+		}
+	}
+
+	// print the site by sample matrix.
+	w := htsdb.NewResultWriter(os.Stdout, []string{"site", "rname", "start", "end", "strand"},
+		*names, *long)
+	w.Variable, w.Value = "sample", "count"
+	defer w.Flush()
+	if err = w.WriteHeader(); err != nil {
+		log.Fatal(err)
+	}
+	for j, s := range sites {
+		start, end := htsdb.BEDCoords.FromHtsdb(s.Start, s.Stop)
+		name := s.Name
+		if name == "" {
+			name = s.Rname + ":" + strconv.Itoa(start) + "-" + strconv.Itoa(end)
+		}
+		row := []interface{}{name, s.Rname, start, end, htsdb.Orientation(s.Strand).String()}
+		for i := range counts {
+			row = append(row, counts[i][j])
+		}
+		if err = w.WriteRow(row...); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// countSites returns the number of reads of database f whose anchor falls in
+// each of sites extended by the flanks.
+func countSites(ctx context.Context, f string, colMap htsdb.ColumnMap, sites []htsdb.Annotation, anc htsdb.Anchor) ([]int, error) {
+	db, err := htsdb.Connect(*driver, f)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	coords, err := htsdb.SelectCoordsContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve copy numbers on a copy of the column map as databases may
+	// differ.
+	cols := colMap.Clone()
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		log.Printf("warning: %s: table %s has no copy_number column; each record counts once\n", f, *tab)
+	}
+
+	// prepare the query for the reads overlapping a site.
+	overlap := "rname = ? AND start <= ? AND stop >= ?"
+	if coords.HalfOpen {
+		overlap = "rname = ? AND start < ? AND stop > ?"
+	}
+	b := htsdb.OrientedFeatureBuilder.From(cols.Table(*tab)).Where(overlap)
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		return nil, err
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.PreparexContext(ctx, db.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	counts := make([]int, len(sites))
+	var r htsdb.OrientedFeature
+	for j, s := range sites {
+		from, to := s.Start-*flank, s.Stop+*flank
+		if from < 0 {
+			from = 0
+		}
+		start, stop := coords.FromHtsdb(from, to)
+		rows, err := stmt.QueryxContext(ctx, s.Rname, stop, start)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			if err = rows.StructScan(&r); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !r.Strand().Known() || (*stranded && s.Strand != 0 && int(r.Orient) != s.Strand) {
+				continue
+			}
+			coords.Normalize(&r.Range)
+			if pos, ok := htsdb.PosAtOK(&r.Range, r.Strand().Feat(), anc, 0); !ok || pos < from || pos > to {
+				continue
+			}
+			if *copyNum == true {
+				counts[j] += r.CopyNumber
+			} else {
+				counts[j]++
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
@@ -2,29 +2,36 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"sort"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 // CountBuilder is a squirrel select builder whose columns match Count fields.
 var CountBuilder = squirrel.Select().
-	Column(squirrel.Alias(squirrel.Expr("LENGTH(sequence)"), "len")).
+	Column(squirrel.Alias(squirrel.Expr("COALESCE(LENGTH(sequence), 0)"), "len")).
 	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
-	Column(squirrel.Alias(squirrel.Expr("SUM(copy_number)"), "copyNum")).
+	Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum")).
 	GroupBy("len").OrderBy("len")
 
-// AlignLenBuilder is a squirrel select builder that describes a query for the
-// alignment length and whose columns match the fields of Count.
-var AlignLenBuilder = squirrel.Select().
-	Column(squirrel.Alias(squirrel.Expr("stop - start + 1"), "len")).
-	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
-	Column(squirrel.Alias(squirrel.Expr("SUM(copy_number)"), "copyNum")).
-	GroupBy("len").OrderBy("len")
+// AlignLenBuilder returns a squirrel select builder that describes a query for
+// the alignment length under coordinate convention c and whose columns match
+// the fields of Count.
+func AlignLenBuilder(c htsdb.Coords) squirrel.SelectBuilder {
+	return squirrel.Select().
+		Column(squirrel.Alias(squirrel.Expr(c.LenExpr()), "len")).
+		Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
+		Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum")).
+		GroupBy("len").OrderBy("len")
+}
 
 // Count is a databases row with record count information.
 type Count struct {
@@ -34,74 +41,235 @@ type Count struct {
 }
 
 const prog = "htsdb-size-distro"
-const version = "0.2"
+const version = "0.11"
 const descr = `Print the number of reads and read copies for each read/alignment size.
-Provided SQL filter will apply to all counts.`
+Provided SQL filter will apply to all counts.
+With --watch, the command keeps running while the database is being filled
+e.g. by an importer during a sequencing run; only the appended rows are counted
+and the updated distribution is printed after a "# rows:<n>" line whenever rows
+are appended. Rows must only be appended, never deleted or updated.`
 
 var (
 	app = kingpin.New(prog, descr)
 
 	dbFile = app.Flag("db", "File to SQLite database.").
 		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
 	tab = app.Flag("table", "Database table name.").
 		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Count read copies by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
 	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
 		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
 	as = app.Flag("as", "Name to print describing the count/s.").
 		Default("all").String()
 	header = app.Flag("header", "Print header line.").
 		Bool()
+	long = app.Flag("long", "Print one value per row after the group columns (long format).").
+		Bool()
 	alignLen = app.Flag("align-len", "Use alignment length instead of read length.").
 			Bool()
+	watch = app.Flag("watch", "Keep running and print the updated distribution as rows are appended.").
+		Bool()
+	interval = app.Flag("interval", "Polling interval for --watch.").
+			Default("5s").Duration()
 )
 
+var common cli.Options
+
 func main() {
 	// read command line args and options
 	app.HelpFlag.Short('h')
 	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		kingpin.Fatalf("%s", err)
 	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *watch == true && *driver != htsdb.SQLite {
+		kingpin.Fatalf("--watch requires the sqlite3 driver")
+	}
 
-	// assemble sqlx select builders
-	countBuilder := CountBuilder.From(*tab)
-	if *alignLen == true {
-		countBuilder = AlignLenBuilder.From(*tab)
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if *where != "" {
-		countBuilder = countBuilder.Where(*where)
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
 	}
 
 	// open database connections.
 	var db *sqlx.DB
-	if db, err = sqlx.Connect("sqlite3", *dbFile); err != nil {
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		panic(err)
+	}
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
 		panic(err)
 	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
 
-	// prepare statements.
-	query, _, err := countBuilder.ToSql()
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		panic(err)
+	}
+
+	// assemble sqlx select builders; the table is added per query.
+	countBuilder := CountBuilder
+	if *alignLen == true {
+		countBuilder = AlignLenBuilder(coords)
+	}
+	if *where != "" {
+		countBuilder = countBuilder.Where(*where)
+	}
+	regs, err := common.ReadRegions()
 	if err != nil {
 		panic(err)
 	}
+	regsFilter, err := htsdb.RegionsClause(db, regs, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		countBuilder = countBuilder.Where(regsFilter)
+	}
 
-	// get the count
-	var counts []Count
-	if err = db.Select(&counts, query); err != nil {
+	// exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
 		panic(err)
 	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", n)
+		countBuilder = bl.Apply(countBuilder, coords)
+	}
+
+	// exclude low-complexity reads.
+	if *maskLowComplexity == true {
+		f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+		if err != nil {
+			panic(err)
+		}
+		countBuilder = countBuilder.Where(f)
+	}
+
+	if *watch == false {
+		// get the count
+		query, _, err := countBuilder.From(table).ToSql()
+		if err != nil {
+			panic(err)
+		}
+		var counts []Count
+		if err = db.SelectContext(ctx, &counts, query); err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "output is partial")
+			}
+			panic(err)
+		}
+		printCounts(counts)
+		return
+	}
+
+	// count appended rows and print the updated distribution until
+	// interrupted.
+	w := htsdb.NewWatcher(db, *tab, *interval)
+	totals := make(map[int]*Count)
+	for {
+		from, to, err := w.Next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+		query, _, err := countBuilder.From(cols.TableRows(*tab, from, to)).ToSql()
+		if err != nil {
+			panic(err)
+		}
+		var counts []Count
+		if err = db.Select(&counts, query); err != nil {
+			panic(err)
+		}
+		for _, c := range counts {
+			t, ok := totals[c.SeqLen]
+			if !ok {
+				t = &Count{SeqLen: c.SeqLen}
+				totals[c.SeqLen] = t
+			}
+			t.Count += c.Count
+			t.CopyNum += c.CopyNum
+		}
+		merged := make([]Count, 0, len(totals))
+		for _, t := range totals {
+			merged = append(merged, *t)
+		}
+		sort.Slice(merged, func(i, j int) bool { return merged[i].SeqLen < merged[j].SeqLen })
+		fmt.Printf("# rows:%d\n", to)
+		printCounts(merged)
+	}
+}
 
-	// print results.
+// printCounts prints counts sorted by length, adding zero counts for missing
+// lengths.
+func printCounts(counts []Count) {
+	w := htsdb.NewResultWriter(os.Stdout, []string{"category", "len"},
+		[]string{"count", "copyNumber"}, *long)
 	if *header == true {
-		fmt.Printf("category\tlen\tcount\tcopyNumber\n")
+		if err := w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
 	}
 	idx := 0
 	for _, c := range counts {
 		for c.SeqLen > idx {
-			fmt.Printf("%s\t%d\t%d\t%d\n", *as, idx, 0, 0)
+			if err := w.WriteRow(*as, idx, 0, 0); err != nil {
+				log.Fatal(err)
+			}
 			idx++
 		}
 		idx = c.SeqLen + 1
-		fmt.Printf("%s\t%d\t%d\t%d\n", *as, c.SeqLen, c.Count, c.CopyNum)
+		if err := w.WriteRow(*as, c.SeqLen, c.Count, c.CopyNum); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Record is a database record with the alignment of a read and its strand.
+type Record struct {
+	Pos     int               `db:"pos"`
+	Cigar   string            `db:"cigar"`
+	Seq     string            `db:"seq"`
+	Strand  htsdb.Orientation `db:"strand"`
+	CopyNum float64           `db:"copy_number"`
+}
+
+// RecordBuilder is a squirrel select builder whose columns match Record
+// fields.
+var RecordBuilder = squirrel.Select("pos", "cigar", "seq", "strand", "copy_number")
+
+const prog = "htsdb-snv-tally"
+const version = "0.7"
+const descr = `Print the non-reference bases at each position of the given
+regions e.g. targeted amplicons in a minimal VCF for quick mutation screening.
+Bases are piled up from the sequences and CIGARs of the reads, weighted by copy
+number, and compared to the reference sequence from --fasta or, if not given,
+from the reference sequences stored in the database. INFO holds the depth (DP),
+the count (AC) and fraction (AF) of the alternative base and its counts on the
+forward (FWD) and reverse (REV) strands. Deletions, insertions and N bases are
+not reported. Provided SQL filter will apply to all reads.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. seq=sequence.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Weigh reads by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	regions = app.Flag("regions", "BED file with the regions to screen.").
+		PlaceHolder("<file>").Required().String()
+	fasta = app.Flag("fasta", "FASTA file with the reference sequences.").
+		PlaceHolder("<file>").String()
+	minCount = app.Flag("min-count", "Minimum count of an alternative base.").
+			Default("2").Float64()
+	minFrac = app.Flag("min-frac", "Minimum fraction of the depth of an alternative base.").
+		Default("0.01").Float64()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterBlacklist(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	regs, err := htsdb.ReadRegionsFile(*regions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// merge regions so that overlapping ones do not report positions twice.
+	regs = htsdb.MergeRegions(regs)
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// read the references of the regions from the FASTA file.
+	var refs map[string]string
+	if *fasta != "" {
+		if refs, err = readRefs(*fasta, regs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// weigh each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readsB := RecordBuilder.From(table)
+	if *where != "" {
+		readsB = readsB.Where(*where)
+	}
+	if blf := bl.Filter(coords); blf != "" {
+		readsB = readsB.Where(blf)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "##fileformat=VCFv4.2\n"+
+		"##source=%s-%s\n"+
+		"##INFO=<ID=DP,Number=1,Type=Float,Description=\"Depth\">\n"+
+		"##INFO=<ID=AC,Number=1,Type=Float,Description=\"Count of the alternative base\">\n"+
+		"##INFO=<ID=AF,Number=1,Type=Float,Description=\"Fraction of the depth of the alternative base\">\n"+
+		"##INFO=<ID=FWD,Number=1,Type=Float,Description=\"Count of the alternative base on the forward strand\">\n"+
+		"##INFO=<ID=REV,Number=1,Type=Float,Description=\"Count of the alternative base on the reverse strand\">\n"+
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n", prog, version)
+
+	// pile up the reads of each region per strand and tally the SNVs.
+	for _, r := range regs {
+		var ref string
+		if refs != nil {
+			seq, ok := refs[r.Rname]
+			if !ok || r.Stop >= len(seq) {
+				log.Fatalf("region %s:%d-%d is not in %s", r.Rname, r.Start+1, r.Stop+1, *fasta)
+			}
+			ref = seq[r.Start : r.Stop+1]
+		} else if ref, err = htsdb.RefSubseq(db, r.Rname, r.Start, r.Stop); err != nil {
+			log.Fatal(err)
+		}
+
+		query, _, err := readsB.
+			Where(htsdb.RegionsFilter([]htsdb.Region{r}, coords)).ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows, err := db.QueryxContext(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				w.Flush()
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
+		}
+		fwd, rev := htsdb.NewPileup(r), htsdb.NewPileup(r)
+		var rec Record
+		for rows.Next() {
+			if err = rows.StructScan(&rec); err != nil {
+				log.Fatal(err)
+			}
+			p := fwd
+			if rec.Strand == htsdb.Reverse {
+				p = rev
+			}
+			if err = p.Add(rec.Pos, rec.Cigar, rec.Seq, rec.CopyNum); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			if ctx.Err() != nil {
+				w.Flush()
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
+		}
+		rows.Close()
+
+		snvs, err := htsdb.TallySNVs(fwd, rev, ref, *minCount, *minFrac)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range snvs {
+			fmt.Fprintf(w, "%s\t%d\t.\t%c\t%c\t.\t.\tDP=%s;AC=%s;AF=%s;FWD=%s;REV=%s\n",
+				s.Rname, s.Pos+1, s.Ref, s.Alt, ff.Format(s.Depth),
+				ff.Format(s.Count()), ff.Format(s.Fraction()), ff.Format(s.Fwd),
+				ff.Format(s.Rev))
+		}
+	}
+}
+
+// readRefs returns the sequences of the references of regions in the FASTA
+// file f. Other sequences are not kept in memory.
+func readRefs(f string, regions []htsdb.Region) (map[string]string, error) {
+	want := make(map[string]bool)
+	for _, r := range regions {
+		want[r.Rname] = true
+	}
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	refs := make(map[string]string)
+	err = htsdb.ReadFasta(fh, func(name string, seq []byte) error {
+		if want[name] {
+			refs[name] = strings.ToUpper(string(seq))
+		}
+		return nil
+	})
+	return refs, err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-strand-crosstalk"
+const version = "0.2"
+const descr = `Estimate the strand cross-talk of stranded libraries i.e. the
+fraction of reads on the unexpected strand. Reads are counted on the features
+of a BED or GTF file that overlap no feature on the opposite strand, e.g.
+single-isoform genes, as sense or antisense. For each sample the number of
+unambiguous features, of sense and of antisense reads and the cross-talk rate,
+antisense over all counted reads, are printed. The rate can be used to
+correct antisense analyses. Provided SQL filter will apply to all samples.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	samples = app.Flag("sample", "Sample of the samples registry to read instead of --table. Can be repeated.").
+		PlaceHolder("<name>").Strings()
+	allSamples = app.Flag("all-samples", "Read all samples of the samples registry.").
+			Bool()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	featsFile = app.Flag("feats", "BED or GTF (.gtf) file with stranded features.").
+			PlaceHolder("<file>").Required().String()
+	featType = app.Flag("feat-type", "Only use GTF features of this type e.g. gene.").
+			PlaceHolder("<type>").String()
+	copyNum = app.Flag("copy-number", "Count read copies instead of records.").
+		Bool()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *allSamples == true && len(*samples) > 0 {
+		kingpin.Fatalf("--all-samples cannot be used with --sample")
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// read the features whose strand is unambiguous.
+	anns, err := htsdb.ReadAnnotations(*featsFile, *featType, "gene_name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	anns = htsdb.Unambiguous(anns)
+	if len(anns) == 0 {
+		log.Fatal("no unambiguous stranded features")
+	}
+	idx := htsdb.NewAnnotationIndex(anns)
+	var refs []string
+	seen := make(map[string]bool)
+	for _, a := range anns {
+		if !seen[a.Rname] {
+			seen[a.Rname] = true
+			refs = append(refs, a.Rname)
+		}
+	}
+	sort.Strings(refs)
+	if *verbose == true {
+		log.Printf("unambiguous features:%d\n", len(anns))
+	}
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// get the samples and their tables.
+	names, tables := []string{*tab}, []string{*tab}
+	switch {
+	case *allSamples == true:
+		ss, err := htsdb.SelectSamples(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(ss) == 0 {
+			log.Fatal("no registered samples")
+		}
+		names, tables = nil, nil
+		for _, s := range ss {
+			names, tables = append(names, s.Name), append(tables, s.Table())
+		}
+	case len(*samples) > 0:
+		names, tables = *samples, nil
+		for _, s := range *samples {
+			t, err := htsdb.SampleTable(db, s, *tab)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tables = append(tables, t)
+		}
+	}
+
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tsv := htsdb.NewTSVWriter(os.Stdout)
+	tsv.Format = ff
+	if err = tsv.WriteStrings([]string{"sample", "features", "sense", "antisense", "crosstalk"}); err != nil {
+		log.Fatal(err)
+	}
+	var checker htsdb.RangeChecker
+	for i, tab := range tables {
+		missing, err := cols.ResolveCopyNumber(db, tab, *copyNum)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if missing {
+			log.Printf("warning: table %s has no copy_number column; each record counts once\n", tab)
+		}
+		table := cols.Table(tab)
+		b := htsdb.OrientedFeatureBuilder.From(table).Where("rname = ?")
+		if *where != "" {
+			b = b.Where(*where)
+		}
+		if regsFilter != "" {
+			b = b.Where(regsFilter)
+		}
+		q, _, err := b.ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		stmt, err := db.Preparex(db.Rebind(q))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// count reads on the expected and on the opposite strand.
+		var sense, antisense float64
+		var r htsdb.OrientedFeature
+		for _, ref := range refs {
+			if ctx.Err() != nil {
+				break
+			}
+			rows, err := stmt.QueryxContext(ctx, ref)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for rows.Next() {
+				if err = rows.StructScan(&r); err != nil {
+					log.Fatal(err)
+				}
+				coords.Normalize(&r.Range)
+				if !checker.Check(&r.Range) || r.Strand() == htsdb.Unknown {
+					continue
+				}
+				found := idx.Overlapping(ref, r.StartPos, r.StopPos, 0)
+				if len(found) == 0 {
+					continue
+				}
+				w := 1.0
+				if *copyNum == true {
+					w = float64(r.CopyNumber)
+				}
+				if int(r.Orient) == found[0].Strand {
+					sense += w
+				} else {
+					antisense += w
+				}
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil && ctx.Err() == nil {
+				log.Fatal(err)
+			}
+		}
+		stmt.Close()
+		if ctx.Err() != nil {
+			break
+		}
+		rate := math.NaN()
+		if sense+antisense > 0 {
+			rate = antisense / (sense + antisense)
+		}
+		if err = tsv.Write(names[i], len(anns), sense, antisense, rate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = tsv.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	// report malformed records.
+	if msg := checker.Report(); msg != "" {
+		log.Printf("warning: %s\n", msg)
+	}
+	if ctx.Err() != nil {
+		db.Close()
+		cli.Interrupted(stop, "output is partial")
+	}
+}
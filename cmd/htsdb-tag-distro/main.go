@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Count is a databases row with tag value count information.
+type Count struct {
+	Rname   string `db:"rname"`
+	Len     int    `db:"len"`
+	Value   int    `db:"value"`
+	Count   int    `db:"count"`
+	CopyNum int    `db:"copyNum"`
+}
+
+const prog = "htsdb-tag-distro"
+const version = "0.8"
+const descr = `Print the number of reads and read copies for each value of
+integer SAM tags e.g. the edit distance (NM) and the alignment score (AS) for
+alignment quality control. Tags are read from the column named after the lower
+case tag e.g. nm if present and parsed from the tags column otherwise. Records
+without a tag are not counted for it. Counts can be grouped by reference and
+by read or alignment length. Provided SQL filter will apply to all counts.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Count read copies by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
+	tags = app.Flag("tag", "Integer SAM tag to count; may be repeated.").
+		Default("NM", "AS").Strings()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	byRef = app.Flag("by-ref", "Group counts by reference.").
+		Bool()
+	byLen = app.Flag("by-len", "Group counts by read length.").
+		Bool()
+	alignLen = app.Flag("align-len", "Use alignment length instead of read length for --by-len.").
+			Bool()
+	as = app.Flag("as", "Name to print describing the count/s.").
+		Default("all").String()
+	header = app.Flag("header", "Print header line.").
+		Bool()
+	long = app.Flag("long", "Print one value per row after the group columns (long format).").
+		Bool()
+)
+
+var common cli.Options
+
+func main() {
+	// read command line args and options
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	var db *sqlx.DB
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble the grouping columns shared by all tags.
+	groupB := squirrel.Select().From(table).
+		Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
+		Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum"))
+	group := []string{}
+	if *byRef == true {
+		groupB = groupB.Column("rname")
+		group = append(group, "rname")
+	}
+	if *byLen == true {
+		lenExpr := "COALESCE(LENGTH(sequence), 0)"
+		if *alignLen == true {
+			lenExpr = coords.LenExpr()
+		}
+		groupB = groupB.Column(squirrel.Alias(squirrel.Expr(lenExpr), "len"))
+		group = append(group, "len")
+	}
+	if *where != "" {
+		groupB = groupB.Where(*where)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		groupB = groupB.Where(regsFilter)
+	}
+
+	// print header; in long format the counts are in column n as the tag
+	// values are in column value.
+	names := []string{"category", "tag"}
+	if *byRef == true {
+		names = append(names, "rname")
+	}
+	if *byLen == true {
+		names = append(names, "len")
+	}
+	w := htsdb.NewResultWriter(os.Stdout, append(names, "value"),
+		[]string{"count", "copyNumber"}, *long)
+	w.Value = "n"
+	if *header == true {
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// count and print the values of each tag.
+	for _, tag := range *tags {
+		expr, err := htsdb.TagExpr(db, table, tag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys := append(append([]string{}, group...), "value")
+		query, _, err := groupB.
+			Column(squirrel.Alias(squirrel.Expr(expr), "value")).
+			Where(expr + " IS NOT NULL").
+			GroupBy(keys...).OrderBy(keys...).ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var counts []Count
+		if err = db.SelectContext(ctx, &counts, query); err != nil {
+			if ctx.Err() != nil {
+				w.Flush()
+				cli.Interrupted(stop, "output is partial")
+			}
+			log.Fatal(err)
+		}
+		for _, c := range counts {
+			row := []interface{}{*as, strings.ToUpper(tag)}
+			if *byRef == true {
+				row = append(row, c.Rname)
+			}
+			if *byLen == true {
+				row = append(row, c.Len)
+			}
+			row = append(row, c.Value, c.Count, c.CopyNum)
+			if err = w.WriteRow(row...); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
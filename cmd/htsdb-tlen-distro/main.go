@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// CountBuilder is a squirrel select builder whose columns match Count fields.
+// Only the leftmost mate of each pair has a positive TLEN so each fragment is
+// counted once.
+var CountBuilder = squirrel.Select().
+	Column(squirrel.Alias(squirrel.Expr("tlen"), "tlen")).
+	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count")).
+	Column(squirrel.Alias(squirrel.Expr(htsdb.CopyNumberSum), "copyNum")).
+	Where("tlen > 0").
+	GroupBy("tlen").OrderBy("tlen")
+
+// Count is a databases row with template length count information.
+type Count struct {
+	Tlen    int `db:"tlen"`
+	Count   int `db:"count"`
+	CopyNum int `db:"copyNum"`
+}
+
+const prog = "htsdb-tlen-distro"
+const version = "0.10"
+const descr = `Print the number of read pairs and pair copies for each template
+length (insert size) of paired-end data. Each pair is counted once through the
+mate with positive TLEN. Provided SQL filter will apply to all counts.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	copyNum = app.Flag("copy-number", "Count read copies by copy number; --no-copy-number counts each record once.").
+		Default("true").Bool()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
+	proper = app.Flag("proper-pairs", "Only count pairs flagged as properly aligned (0x2).").
+		Bool()
+	maxTlen = app.Flag("max-tlen", "Ignore template lengths larger than this; 0 for no limit.").
+		Default("0").Int()
+	as = app.Flag("as", "Name to print describing the count/s.").
+		Default("all").String()
+	header = app.Flag("header", "Print header line.").
+		Bool()
+	long = app.Flag("long", "Print one value per row after the group columns (long format).").
+		Bool()
+	stats = app.Flag("stats", "Print summary statistics instead of the distribution.").
+		Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("2").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("fixed").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	// read command line args and options
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// open database connections.
+	var db *sqlx.DB
+	if db, err = htsdb.Connect(*driver, *dbFile); err != nil {
+		panic(err)
+	}
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		panic(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		panic(err)
+	}
+
+	// assemble sqlx select builders
+	countBuilder := CountBuilder.From(table)
+	if *proper == true {
+		countBuilder = countBuilder.Where("(flag & 2) != 0")
+	}
+	if *maxTlen > 0 {
+		countBuilder = countBuilder.Where(fmt.Sprintf("tlen <= %d", *maxTlen))
+	}
+	if *where != "" {
+		countBuilder = countBuilder.Where(*where)
+	}
+
+	// keep reads in the regions and exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		panic(err)
+	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", n)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		countBuilder = countBuilder.Where(regsFilter)
+	}
+
+	// exclude low-complexity reads.
+	if *maskLowComplexity == true {
+		f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+		if err != nil {
+			panic(err)
+		}
+		countBuilder = countBuilder.Where(f)
+	}
+
+	// prepare statements.
+	query, _, err := countBuilder.ToSql()
+	if err != nil {
+		panic(err)
+	}
+
+	// get the count
+	var counts []Count
+	if err = db.SelectContext(ctx, &counts, query); err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		panic(err)
+	}
+
+	// print results.
+	if *stats == true {
+		s := summarize(counts)
+		fmt.Printf("pairs:%d\ncopies:%d\nmin:%d\nmax:%d\nmean:%s\nmedian:%d\nsd:%s\n",
+			s.pairs, s.copies, s.min, s.max, ff.Format(s.mean), s.median, ff.Format(s.sd))
+		return
+	}
+	w := htsdb.NewResultWriter(os.Stdout, []string{"category", "tlen"},
+		[]string{"count", "copyNumber"}, *long)
+	if *header == true {
+		if err = w.WriteHeader(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	idx := 1
+	for _, c := range counts {
+		for c.Tlen > idx {
+			if err = w.WriteRow(*as, idx, 0, 0); err != nil {
+				log.Fatal(err)
+			}
+			idx++
+		}
+		idx = c.Tlen + 1
+		if err = w.WriteRow(*as, c.Tlen, c.Count, c.CopyNum); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type summary struct {
+	pairs, copies, min, max, median int
+	mean, sd                        float64
+}
+
+// summarize returns summary statistics of the template lengths in counts
+// which must be sorted by template length.
+func summarize(counts []Count) summary {
+	var s summary
+	if len(counts) == 0 {
+		return s
+	}
+	var sum, sumSq float64
+	for _, c := range counts {
+		s.pairs += c.Count
+		s.copies += c.CopyNum
+		sum += float64(c.Tlen) * float64(c.Count)
+		sumSq += float64(c.Tlen) * float64(c.Tlen) * float64(c.Count)
+	}
+	s.min, s.max = counts[0].Tlen, counts[len(counts)-1].Tlen
+	s.mean = sum / float64(s.pairs)
+	s.sd = math.Sqrt(math.Max(sumSq/float64(s.pairs)-s.mean*s.mean, 0))
+	seen := 0
+	for _, c := range counts {
+		seen += c.Count
+		if seen*2 >= s.pairs {
+			s.median = c.Tlen
+			break
+		}
+	}
+	return s
+}
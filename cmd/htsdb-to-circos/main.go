@@ -0,0 +1,245 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/normalize"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const prog = "htsdb-to-circos"
+const version = "0.9"
+const descr = `Print the number of reads starting in fixed size genomic bins as
+a circos plot data file. Counts are weighted by read copy number unless
+disabled. Optionally, a matching karyotype file is written so that genome-wide
+figures can be built directly from the database. With --tiles, reads are
+counted in the non-overlapping tiles of a BED file e.g. recombination blocks
+instead of fixed size bins. Provided SQL filter will apply to all counts.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter to inject in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	strand = app.Flag("strand", "Strand of reads to count.").
+		Default("both").Enum("both", "+", "-")
+	maskLowComplexity = app.Flag("mask-low-complexity", "Exclude low-complexity reads; requires the dust column of htsdb-annotate-seq.").
+				Bool()
+	dustThreshold = app.Flag("dust-threshold", "DUST score above which reads are low-complexity.").
+			Default("2").Float64()
+	binSize = app.Flag("bin-size", "Size of genomic bins.").
+		Default("1000000").Int()
+	tilesFile = app.Flag("tiles", "BED file with non-overlapping tiles to count reads in instead of fixed size bins.").
+			PlaceHolder("<file>").String()
+	copyNum = app.Flag("copy-number", "Weight counts by read copy number; --no-copy-number counts records.").
+		Default("true").Bool()
+	norm = app.Flag("normalize", "Normalization of counts.").
+		Default("raw").Enum("raw", "cpm")
+	karyotype = app.Flag("karyotype", "File to write the circos karyotype of the references.").
+			PlaceHolder("<file>").String()
+	verbose   = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+	precision = app.Flag("precision", "Digits of real numbers; -1 for the fewest that represent them exactly.").
+			Default("-1").Int()
+	notation = app.Flag("notation", "Notation of real numbers.").
+			Default("auto").Enum(htsdb.Notations...)
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	ff, err := htsdb.ParseFloatFormat(*notation, *precision)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *binSize < 1 {
+		kingpin.Fatalf("--bin-size must be positive")
+	}
+	tiles, err := htsdb.ReadTilesFile(*tilesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	size := *binSize
+	if tiles != nil {
+		size = 1
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// count each record once if copy numbers are ignored or missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, *copyNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	table := cols.Table(*tab)
+
+	// read coordinate convention.
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble sqlx select builders.
+	binsB := htsdb.BinCountBuilder(coords, size, *copyNum).From(table)
+	if *where != "" {
+		binsB = binsB.Where(*where)
+	}
+	switch *strand {
+	case "+":
+		binsB = binsB.Where("strand = 1")
+	case "-":
+		binsB = binsB.Where("strand = -1")
+	}
+
+	// restrict to regions and exclude blacklisted regions.
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bl) > 0 {
+		n, err := bl.Count(db, table, *where, coords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("blacklist: excluded %d records\n", n)
+	}
+	regsFilter, err := common.Filter(db, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		binsB = binsB.Where(regsFilter)
+	}
+
+	// exclude low-complexity reads.
+	if *maskLowComplexity == true {
+		f, err := htsdb.LowComplexityFilter(db, table, *dustThreshold)
+		if err != nil {
+			log.Fatal(err)
+		}
+		binsB = binsB.Where(f)
+	}
+
+	// prepare normalization of counts.
+	method, err := normalize.Parse(*norm)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	n, err := normalize.New(db, method, table, *where, "", *copyNum, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lens, err := htsdb.ReferenceLengths(db, table, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// count reads in tiles or in bins clipped at the reference lengths.
+	if tiles != nil {
+		values, err := tiles.CountContext(ctx, db, binsB)
+		if err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "nothing was printed")
+			}
+			log.Fatal(err)
+		}
+		regs := tiles.Regions()
+		for i, r := range regs {
+			values[i] = n.Value(values[i], r.Stop-r.Start+1)
+		}
+		if *verbose == true {
+			log.Printf("tiles:%d\n", len(regs))
+		}
+		if err = htsdb.WriteCircosRegions(os.Stdout, regs, values, ff); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		query, _, err := binsB.ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var bins []htsdb.Bin
+		if err = db.SelectContext(ctx, &bins, query); err != nil {
+			if ctx.Err() != nil {
+				cli.Interrupted(stop, "nothing was printed")
+			}
+			log.Fatal(err)
+		}
+		if *verbose == true {
+			log.Printf("bins:%d\n", len(bins))
+		}
+		for i := range bins {
+			bins[i].Value = n.Value(bins[i].Value, *binSize)
+		}
+		if err = htsdb.WriteCircosPlot(os.Stdout, bins, *binSize, lens, ff); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *karyotype != "" {
+		f, err := os.Create(*karyotype)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = htsdb.WriteKaryotype(f, htsdb.SortedReferences(lens), ""); err != nil {
+			log.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/export"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Record is a read with its sequence and qualities.
+type Record struct {
+	Qname      string            `db:"qname"`
+	Seq        string            `db:"seq"`
+	Qual       string            `db:"qual"`
+	Orient     htsdb.Orientation `db:"strand"`
+	CopyNumber int               `db:"copy_number"`
+	Flag       int               `db:"flag"`
+}
+
+const prog = "htsdb-to-fastq"
+const version = "0.3"
+const descr = `Print the sequences and qualities of database records in FASTQ
+or, with --format fasta, FASTA format e.g. to realign reads to another genome.
+Reads aligned on the reverse strand are restored to their sequenced
+orientation unless --as-stored is given. If the table has a flag column only
+primary alignments are printed, so that each read is printed once, and mates
+get /1 and /2 suffixes. Missing qualities are printed as I. With --expand each
+record is printed copy number times; with --collapse each unique sequence is
+printed once with its total copy number in the name (seq<n>_x<count>) and the
+qualities of one of its reads. Output is gzip compressed with --gzip or if
+--out ends in .gz. Provided SQL filters will apply to output.`
+
+var (
+	app = kingpin.New(prog, descr)
+
+	dbFile = app.Flag("db", "File to SQLite database.").
+		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
+	tab = app.Flag("table", "Database table name.").
+		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. seq=sequence.").
+		PlaceHolder("<col=col,...>").String()
+	where = app.Flag("where", "SQL filter injected in WHERE clause.").
+		PlaceHolder("<SQL>").String()
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	regionStrs = app.Flag("region", "Region to restrict the output to e.g. chr1:1,000-2,000 (1-based, inclusive). Can be repeated.").
+			PlaceHolder("<rname:start-end>").Strings()
+	format = app.Flag("format", "Output format.").
+		Default("fastq").Enum("fastq", "fasta")
+	expand = app.Flag("expand", "Print each record copy number times.").
+		Bool()
+	collapse = app.Flag("collapse", "Print each unique sequence once with its total copy number.").
+			Bool()
+	asStored = app.Flag("as-stored", "Print sequences as stored instead of in sequenced orientation.").
+			Bool()
+	allAlns = app.Flag("all-alignments", "Also print secondary and supplementary alignments.").
+		Bool()
+	outFile = app.Flag("out", "Output file; - for stdout.").
+		Default("-").PlaceHolder("<file>").String()
+	gz = app.Flag("gzip", "Compress output with gzip.").
+		Bool()
+	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
+)
+
+var common cli.Options
+
+func main() {
+	app.HelpFlag.Short('h')
+	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *expand == true && *collapse == true {
+		kingpin.Fatalf("--expand cannot be used with --collapse")
+	}
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// open database connections.
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+
+	// each record is a single copy if copy numbers are missing.
+	missing, err := cols.ResolveCopyNumber(db, *tab, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing && (*expand == true || *collapse == true) {
+		log.Printf("warning: table %s has no copy_number column; each record counts once\n", *tab)
+	}
+	hasQual, err := htsdb.HasColumn(db, *tab, "qual")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hasFlag, err := htsdb.HasColumn(db, *tab, "flag")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hasQual = hasQual || cols.Mapped("qual")
+	hasFlag = hasFlag || cols.Mapped("flag")
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, []string{"qname", "seq"}); err != nil {
+		log.Fatal(err)
+	}
+
+	// assemble the query.
+	qualCol, flagCol := "qual", "flag"
+	if !hasQual {
+		qualCol = "'*' AS qual"
+	}
+	if !hasFlag {
+		flagCol = "0 AS flag"
+	}
+	b := squirrel.Select("qname", "seq", qualCol, "strand", "copy_number", flagCol).From(table)
+	if *collapse == true {
+		b = squirrel.Select("'' AS qname", "seq", "MIN("+strings.TrimSuffix(qualCol, " AS qual")+") AS qual",
+			"strand", "SUM(copy_number) AS copy_number", "0 AS flag").From(table).
+			GroupBy("seq", "strand")
+	}
+	if *where != "" {
+		b = b.Where(*where)
+	}
+	if hasFlag && *allAlns == false {
+		b = b.Where("(flag & 2304) = 0")
+	}
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, rs := range *regionStrs {
+		reg, err := htsdb.ParseRegion(rs)
+		if err != nil {
+			kingpin.Fatalf("%s", err)
+		}
+		regs = append(regs, reg)
+	}
+	regsFilter, err := htsdb.RegionsClause(db, regs, coords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if regsFilter != "" {
+		b = b.Where(regsFilter)
+	}
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = bl.Apply(b, coords)
+	query, _, err := b.ToSql()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// open output.
+	var out io.Writer = os.Stdout
+	if *outFile != "-" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	out = bw
+	var zw *gzip.Writer
+	if *gz == true || strings.HasSuffix(*outFile, ".gz") {
+		zw = gzip.NewWriter(bw)
+		out = zw
+	}
+	w := export.NewFastxWriter(out, *format == "fasta")
+
+	// print reads until exhausted or interrupted.
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		if ctx.Err() != nil {
+			cli.Interrupted(stop, "nothing was printed")
+		}
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	type unique struct {
+		qual   string
+		copies int
+	}
+	uniques := make(map[string]*unique)
+	n := 0
+	var r Record
+	for rows.Next() {
+		if err = rows.StructScan(&r); err != nil {
+			log.Fatal(err)
+		}
+		seq, qual := r.Seq, r.Qual
+		if *asStored == false {
+			seq, qual = export.OriginalRead(seq, qual, r.Orient)
+		}
+		if *collapse == true {
+			if u, ok := uniques[seq]; ok {
+				u.copies += r.CopyNumber
+			} else {
+				uniques[seq] = &unique{qual, r.CopyNumber}
+			}
+			continue
+		}
+		name := r.Qname
+		if r.Flag&1 != 0 && r.Flag&64 != 0 {
+			name += "/1"
+		} else if r.Flag&1 != 0 && r.Flag&128 != 0 {
+			name += "/2"
+		}
+		copies := 1
+		if *expand == true {
+			copies = r.CopyNumber
+		}
+		for i := 0; i < copies; i++ {
+			cname := name
+			if *expand == true && copies > 1 {
+				cname = r.Qname + "_" + strconv.Itoa(i+1) + strings.TrimPrefix(name, r.Qname)
+			}
+			if err = w.Write(cname, seq, qual); err != nil {
+				log.Fatal(err)
+			}
+			n++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			if zw != nil {
+				zw.Close()
+			}
+			bw.Flush()
+			cli.Interrupted(stop, "output is partial")
+		}
+		log.Fatal(err)
+	}
+
+	// print unique sequences by decreasing copy number.
+	if *collapse == true {
+		seqs := make([]string, 0, len(uniques))
+		for s := range uniques {
+			seqs = append(seqs, s)
+		}
+		sort.Slice(seqs, func(i, j int) bool {
+			ci, cj := uniques[seqs[i]].copies, uniques[seqs[j]].copies
+			return ci > cj || (ci == cj && seqs[i] < seqs[j])
+		})
+		for i, s := range seqs {
+			u := uniques[s]
+			name := "seq" + strconv.Itoa(i+1) + "_x" + strconv.Itoa(u.copies)
+			if err = w.Write(name, s, u.qual); err != nil {
+				log.Fatal(err)
+			}
+			n++
+		}
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose == true {
+		log.Printf("reads:%d\n", n)
+	}
+}
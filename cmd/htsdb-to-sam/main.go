@@ -1,150 +1,330 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"io"
 	"log"
 	"os"
-	"strconv"
+	"sort"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
-	"github.com/mnsmar/htsdb"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/cmd/internal/cli"
+	"github.com/mnsmar/htsdb/v2/export"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 const prog = "htsdb-to-sam"
-const version = "0.1"
+const version = "0.15"
 const descr = `Print database records in SAM format. Provided SQL filters will
-apply to output.`
+apply to output. With --columns only the given SAM fields are read from the
+database and the others are printed as unavailable e.g. * for seq and qual,
+which is faster for wide tables. --flag-from-strand sets the reverse
+complemented bit of FLAG from the strand column e.g. for databases built from
+BED-like sources; use it with --columns without flag if the table has no flag
+column. --check-flags only prints the records whose flag and strand columns
+disagree. --bam writes compressed BAM instead of SAM; it always includes the
+header, since BAM records refer to its references. --sorted streams the
+records ordered by rname and pos, in the order of the header references with
+unmapped records last, and marks the output as coordinate sorted e.g. for
+samtools index; --index creates the index on rname and pos that lets the
+database stream them without sorting the table. The --header starts with an @HD line with
+the sort order and ends with a @PG line with the command line. It has an @RG
+line for the sample of --sample, or for the table if the metadata set read
+group fields, with the fields stored in the metadata under rg.<TAG> keys e.g.
+rg.PL=ILLUMINA or rg.LB=lib1; SM is the sample unless set.`
 
 var (
 	app = kingpin.New(prog, descr)
 
 	dbFile = app.Flag("db", "SQLite file.").
 		PlaceHolder("<file>").Required().String()
+	driver = app.Flag("driver", "Database driver; for postgres and mysql --db is a connection string.").
+		Default("sqlite3").Enum(htsdb.Drivers...)
 	tab = app.Flag("table", "Database table name.").
 		Default("sample").String()
+	sample = app.Flag("sample", "Sample of the samples registry to read instead of --table.").
+		PlaceHolder("<name>").String()
+	colMap = app.Flag("col-map", "Map canonical to foreign column names e.g. rname=chrom,copy_number=score.").
+		PlaceHolder("<col=col,...>").String()
+	columns = app.Flag("columns", "Comma separated SAM fields to read e.g. qname,rname,pos,cigar.").
+		PlaceHolder("<col,...>").String()
 	where = app.Flag("where", "SQL filter injected in WHERE clause.").
 		PlaceHolder("<SQL>").String()
-	header = app.Flag("header", "build and print SAM header.").
+	whereNot = app.Flag("where-not", "SQL filter whose matching records are excluded.").
+			PlaceHolder("<SQL>").String()
+	filterDefs = app.Flag("filter", "Define a named SQL filter for --filters. Can be repeated.").
+			PlaceHolder("<name=SQL>").Strings()
+	filterExpr = app.Flag("filters", "Combine named filters with AND, OR, NOT and parentheses e.g. \"uniq AND NOT rrna\".").
+			PlaceHolder("<expr>").String()
+	regionStrs = app.Flag("region", "Region to restrict the output to e.g. chr1:1,000-2,000 (1-based, inclusive). Can be repeated.").
+			PlaceHolder("<rname:start-end>").Strings()
+	header = app.Flag("header", "build and print SAM header; uses the reference table if present.").
 		Bool()
+	bamOut = app.Flag("bam", "Write BAM instead of SAM.").
+		Bool()
+	sorted = app.Flag("sorted", "Print records sorted by coordinate.").
+		Bool()
+	index = app.Flag("index", "Create the rname and pos index for --sorted if missing.").
+		Bool()
+	force = app.Flag("force", "Modify the database even if it is locked.").
+		Bool()
+	refMap = app.Flag("ref-map", "Rename references to ucsc or ensembl style or use mapping file.").
+		PlaceHolder("<ucsc|ensembl|file>").String()
+	flagFromStrand = app.Flag("flag-from-strand", "Set the reverse complemented bit of FLAG from the strand column.").
+			Bool()
+	checkFlags = app.Flag("check-flags", "Only print records whose flag and strand columns disagree.").
+			Bool()
 	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
 )
 
-// Reader encapsulates a connection to a database and implements io.Reader.
-type Reader struct {
-	db   *sqlx.DB
-	dest *htsdb.SamRecord
-	rows *sqlx.Rows
-	err  error
+var common cli.Options
+
+// Record is a database record with the SAM fields and, optionally, the strand.
+type Record struct {
+	htsdb.SamRecord
+	Strand htsdb.Orientation `db:"strand"`
 }
 
-// NewReader returns a new Reader that reads from db using the given query.
-func NewReader(db *sqlx.DB, query string) (*Reader, error) {
-	rows, err := db.Queryx(query)
-	if err != nil {
-		return nil, err
+// SAMRecord returns the SAM record of r with the reverse complemented bit of
+// FLAG set from the strand if --flag-from-strand is given.
+func (r *Record) SAMRecord() *htsdb.SamRecord {
+	if *flagFromStrand == true {
+		r.Flag = htsdb.StrandFlag(r.Flag, r.Strand)
 	}
+	return &r.SamRecord
+}
 
-	return &Reader{
-		db:   db,
-		dest: &htsdb.SamRecord{},
-		rows: rows,
-	}, nil
+// countingSource is a RecordSource that counts the records read.
+type countingSource struct {
+	htsdb.RecordSource
+	n int
 }
 
-// Read reads the next record from r into p. It returns the number of bytes
-// read (0 <= n <= len(p)) and any error encountered. Even if Read returns n <
-// len(p), it may use all of p as scratch space during the call. If some data
-// is available but not len(p) bytes, Read conventionally returns what is
-// available instead of waiting for more. It will return n = 0 and io.EOF when
-// r is exhausted. It will return n = 0 and an error if it encounters one.
-func (r *Reader) Read(p []byte) (n int, err error) {
-	if len(p) == 0 {
-		return 0, nil
-	}
-
-	ok := r.rows.Next()
-	if !ok {
-		if r.rows.Err() == nil {
-			return 0, io.EOF
-		}
-		return 0, r.rows.Err()
+func (c *countingSource) Next() bool {
+	ok := c.RecordSource.Next()
+	if ok {
+		c.n++
 	}
-	err = r.rows.StructScan(r.dest)
-	if err != nil {
-		return 0, err
-	}
-	s := r.dest.Qname + "\t" +
-		strconv.Itoa(r.dest.Flag) + "\t" +
-		r.dest.Rname + "\t" +
-		strconv.Itoa(r.dest.Pos) + "\t" +
-		strconv.Itoa(r.dest.Mapq) + "\t" +
-		r.dest.Cigar + "\t" +
-		r.dest.Rnext + "\t" +
-		strconv.Itoa(r.dest.Pnext) + "\t" +
-		strconv.Itoa(r.dest.Tlen) + "\t" +
-		r.dest.Seq + "\t" +
-		r.dest.Qual + "\t" +
-		r.dest.Tags + "\n"
-
-	n = copy(p, s[0:])
-
-	return
+	return ok
 }
 
 func main() {
 	app.HelpFlag.Short('h')
 	app.Version(version)
+	common.Register(app)
+	common.RegisterRegions(app)
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		kingpin.Fatalf("%s", err)
 	}
+	if *where, err = htsdb.CombineFilters(*where, *whereNot, *filterDefs, *filterExpr); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	// profile and cancel work on SIGINT/SIGTERM.
+	ctx, stop, err := common.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	// map foreign column names.
+	cols, err := htsdb.ParseColumnMap(*colMap)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	selCols, err := htsdb.ParseColumns(*columns)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	readsB, err := htsdb.SamRecordColumnsBuilder(selCols)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *sorted == true && len(selCols) > 0 && !(contains(selCols, "rname") && contains(selCols, "pos")) {
+		kingpin.Fatalf("--sorted needs rname and pos in --columns")
+	}
 
-	db, err := sqlx.Connect("sqlite3", *dbFile)
+	rename, err := htsdb.RefRenamer(*refMap)
 	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	db, err := htsdb.Connect(*driver, *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if *tab, err = htsdb.SampleTable(db, *sample, *tab); err != nil {
+		log.Fatal(err)
+	}
+	table := cols.Table(*tab)
+	if err = htsdb.CheckColumns(db, table, selCols); err != nil {
 		log.Fatal(err)
 	}
 
-	readsB := htsdb.SamRecordBuilder.From(*tab)
-	refsB := htsdb.ReferenceBuilder.From(*tab)
+	readsB = readsB.From(table)
+	refsB := htsdb.ReferenceBuilder.From(table)
 	if *where != "" {
 		readsB = readsB.Where(*where)
 		refsB = refsB.Where(*where)
 	}
-
-	query, _, err := readsB.ToSql()
+	if *flagFromStrand == true {
+		readsB = readsB.Column("strand")
+	}
+	if *checkFlags == true {
+		readsB = readsB.Where(htsdb.FlagStrandMismatch)
+	}
+	// restrict to regions and exclude blacklisted regions.
+	coords, err := htsdb.SelectCoords(db)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	if *header == true {
-		refs, err := htsdb.SelectReferences(db, refsB)
+	regs, err := common.ReadRegions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, rs := range *regionStrs {
+		reg, err := htsdb.ParseRegion(rs)
 		if err != nil {
+			kingpin.Fatalf("%s", err)
+		}
+		regs = append(regs, reg)
+	}
+	// regions are inlined rather than looked up in a TEMP table as the
+	// queries of sorted output are open at the same time.
+	if f := htsdb.RegionsFilter(regs, coords); f != "" {
+		readsB = readsB.Where(f)
+	}
+	bl, err := common.ReadBlacklist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	readsB = bl.Apply(readsB, coords)
+
+	// sorted records without a reference go last.
+	queries := []squirrel.SelectBuilder{readsB}
+	if *sorted == true {
+		queries = []squirrel.SelectBuilder{
+			readsB.Where("rname <> '*'").OrderBy("rname", "pos"),
+			readsB.Where("rname = '*'"),
+		}
+
+		// create index or warn about sorting the table.
+		if *index == true {
+			if err = htsdb.CheckUnlocked(db, *force); err != nil {
+				log.Fatal(err)
+			}
+			if err = htsdb.CreatePosIndex(db, *tab, cols.Column("rname"), cols.Column("pos")); err != nil {
+				log.Fatal(err)
+			}
+		} else if ok, err := htsdb.IndexExists(db, htsdb.PosIndexName(*tab)); err != nil {
 			log.Fatal(err)
+		} else if !ok {
+			log.Printf("warning: rname and pos are not indexed; use --index to avoid sorting the table\n")
 		}
-		for _, r := range refs {
-			fmt.Printf("@SQ\tSN:%s\tLN:%d\n", r.Name(), r.Len())
+	}
+
+	var hdr export.HeaderOpts
+	hdr.Rename = rename
+	hdr.Coordinate = *sorted
+	if *header == true || *bamOut == true {
+		if hdr.Refs, err = htsdb.SelectReferences(db, refsB); err != nil {
+			log.Fatal(err)
+		}
+		if hdr.Seqs, err = htsdb.SelectRefSeqs(db); err != nil {
+			log.Fatal(err)
+		}
+		if hdr.Seqs != nil {
+			for _, p := range htsdb.CheckRefSeqs(hdr.Refs, hdr.Seqs) {
+				log.Printf("warning: %s\n", p)
+			}
+		}
+
+		if rg, ok, err := readGroup(db, *sample, *tab); err != nil {
+			log.Fatal(err)
+		} else if ok {
+			hdr.ReadGroups = []export.ReadGroup{rg}
+		}
+		hdr.Program = &export.Program{ID: prog, Name: prog, Version: version,
+			CommandLine: strings.Join(os.Args, " ")}
+
+		// list references in the order of the sorted records.
+		if *sorted == true {
+			sort.Slice(hdr.Refs, func(i, j int) bool { return hdr.Refs[i].Chrom < hdr.Refs[j].Chrom })
+			sort.Slice(hdr.Seqs, func(i, j int) bool { return hdr.Seqs[i].Name < hdr.Seqs[j].Name })
 		}
 	}
 
-	r, err := NewReader(db, query)
-	if err != nil {
+	// write records until exhausted or interrupted.
+	var srcs []htsdb.RecordSource
+	for _, b := range queries {
+		query, _, err := b.ToSql()
+		if err != nil {
+			log.Fatal(err)
+		}
+		r, err := htsdb.NewReaderContext(ctx, db.DB, db.DriverName(), &Record{}, query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srcs = append(srcs, r)
+	}
+	src := &countingSource{RecordSource: htsdb.NewMultiSource(srcs...)}
+	write := export.WriteSAM
+	if *bamOut == true {
+		write = export.WriteBAM
+	}
+	if err = write(os.Stdout, src, hdr); err != nil {
+		if ctx.Err() != nil {
+			db.Close()
+			cli.Interrupted(stop, "output is partial")
+		}
 		log.Fatal(err)
 	}
+	if *checkFlags == true {
+		log.Printf("flag/strand mismatches: %d\n", src.n)
+	}
+}
 
-	sc := bufio.NewScanner(r)
-	for {
-		ok := sc.Scan()
-		if ok == false {
-			break
+// readGroup returns the read group of sample, or of table if sample is empty,
+// with the fields stored in the metadata of db under rg.<TAG> keys. The
+// boolean is false if sample is empty and no fields are stored.
+func readGroup(db *sqlx.DB, sample, table string) (export.ReadGroup, bool, error) {
+	meta, err := htsdb.Meta(db)
+	if err != nil {
+		return export.ReadGroup{}, false, err
+	}
+	var tags []string
+	for k := range meta {
+		if tag := strings.TrimPrefix(k, "rg."); tag != k && tag != "ID" {
+			tags = append(tags, tag)
 		}
-		fmt.Printf("%s\n", sc.Text())
 	}
-	if sc.Err() != nil {
-		log.Fatal(err)
+	if sample == "" && len(tags) == 0 {
+		return export.ReadGroup{}, false, nil
+	}
+	if sample == "" {
+		sample = table
+	}
+	sort.Strings(tags)
+	rg := export.ReadGroup{ID: sample}
+	if _, ok := meta["rg.SM"]; !ok {
+		rg.Fields = append(rg.Fields, "SM:"+sample)
+	}
+	for _, tag := range tags {
+		rg.Fields = append(rg.Fields, tag+":"+meta["rg."+tag])
+	}
+	return rg, true, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,146 @@
+// Package cli implements the options shared by the htsdb commands, so that
+// profiling, interruption and the --regions and --blacklist flags work the
+// same way in every command.
+package cli
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Options holds the options shared by the htsdb commands. Commands that use
+// go-arg embed it in their options struct; commands that use kingpin
+// register its flags with Register and RegisterRegions.
+type Options struct {
+	CPUProfile string `arg:"--cpuprofile" help:"write CPU profile to file"`
+	MemProfile string `arg:"--memprofile" help:"write memory profile to file"`
+	Trace      string `arg:"--trace" help:"write execution trace to file"`
+	Regions    string `help:"BED file with regions to restrict the analysis to"`
+	Blacklist  string `help:"BED file with blacklisted regions whose reads are excluded"`
+}
+
+// Register registers the profiling flags of o with app.
+func (o *Options) Register(app *kingpin.Application) {
+	app.Flag("cpuprofile", "Write CPU profile to file.").
+		PlaceHolder("<file>").StringVar(&o.CPUProfile)
+	app.Flag("memprofile", "Write memory profile to file.").
+		PlaceHolder("<file>").StringVar(&o.MemProfile)
+	app.Flag("trace", "Write execution trace to file.").
+		PlaceHolder("<file>").StringVar(&o.Trace)
+}
+
+// RegisterRegions registers the --regions and --blacklist flags of o with
+// app, for commands that read records.
+func (o *Options) RegisterRegions(app *kingpin.Application) {
+	app.Flag("regions", "BED file with regions to restrict the analysis to.").
+		PlaceHolder("<file>").StringVar(&o.Regions)
+	o.RegisterBlacklist(app)
+}
+
+// RegisterBlacklist registers only the --blacklist flag of o with app, for
+// commands whose --regions flag has a meaning of their own.
+func (o *Options) RegisterBlacklist(app *kingpin.Application) {
+	app.Flag("blacklist", "BED file with blacklisted regions whose reads are excluded.").
+		PlaceHolder("<file>").StringVar(&o.Blacklist)
+}
+
+// Start starts the profiling requested by o and returns a context that is
+// cancelled when the process receives SIGINT or SIGTERM. The returned
+// function stops profiling and signal handling; it should be deferred and
+// also called before os.Exit. It can be called more than once.
+func (o *Options) Start() (context.Context, func(), error) {
+	stopProfiling, err := htsdb.StartProfiling(o.CPUProfile, o.MemProfile, o.Trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, stopSignals := htsdb.SignalContext()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			stopSignals()
+			if err := stopProfiling(); err != nil {
+				log.Print(err)
+			}
+		})
+	}, nil
+}
+
+// ReadRegions reads the regions of the --regions file. It returns no regions
+// if the flag is not given.
+func (o *Options) ReadRegions() ([]htsdb.Region, error) {
+	return htsdb.ReadRegionsFile(o.Regions)
+}
+
+// ReadBlacklist reads the blacklist of the --blacklist file. It returns an
+// empty blacklist if the flag is not given.
+func (o *Options) ReadBlacklist() (htsdb.Blacklist, error) {
+	return htsdb.ReadBlacklistFile(o.Blacklist)
+}
+
+// Filter returns an SQL clause that selects the records of db that overlap
+// the --regions and do not overlap the --blacklist, under the coordinate
+// convention c of db. Many regions are looked up in a TEMP table as
+// RegionsClause does. It returns the empty string if neither flag is given.
+func (o *Options) Filter(db *sqlx.DB, c htsdb.Coords) (string, error) {
+	regs, err := o.ReadRegions()
+	if err != nil {
+		return "", err
+	}
+	bl, err := o.ReadBlacklist()
+	if err != nil {
+		return "", err
+	}
+	f, err := htsdb.RegionsClause(db, regs, c)
+	if err != nil {
+		return "", err
+	}
+	if blf := bl.Filter(c); blf != "" {
+		if f != "" {
+			return f + " AND " + blf, nil
+		}
+		return blf, nil
+	}
+	return f, nil
+}
+
+// ChimeraFilter returns an SQL clause like Filter for the chimera table. It
+// selects the chimeras with an arm that overlaps the --regions and no arm
+// that overlaps the --blacklist.
+func (o *Options) ChimeraFilter(c htsdb.Coords) (string, error) {
+	regs, err := o.ReadRegions()
+	if err != nil {
+		return "", err
+	}
+	bl, err := o.ReadBlacklist()
+	if err != nil {
+		return "", err
+	}
+	var preds []string
+	if len(regs) > 0 {
+		preds = append(preds, "("+
+			htsdb.RegionsFilterColumns(regs, c, "rname1", "start1", "stop1")+" OR "+
+			htsdb.RegionsFilterColumns(regs, c, "rname2", "start2", "stop2")+")")
+	}
+	if len(bl) > 0 {
+		preds = append(preds,
+			"NOT "+htsdb.RegionsFilterColumns(bl, c, "rname1", "start1", "stop1"),
+			"NOT "+htsdb.RegionsFilterColumns(bl, c, "rname2", "start2", "stop2"))
+	}
+	return strings.Join(preds, " AND "), nil
+}
+
+// Interrupted logs that the command was interrupted and the consequence
+// e.g. "output is partial", calls stop and exits with status 130 as for
+// SIGINT.
+func Interrupted(stop func(), consequence string) {
+	log.Print("interrupted; " + consequence)
+	stop()
+	os.Exit(130)
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestRegister(t *testing.T) {
+	var o Options
+	app := kingpin.New("test", "")
+	o.Register(app)
+	o.RegisterRegions(app)
+	_, err := app.Parse([]string{"--cpuprofile", "cpu.out", "--trace", "trace.out",
+		"--regions", "regs.bed", "--blacklist", "bl.bed"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := Options{CPUProfile: "cpu.out", Trace: "trace.out",
+		Regions: "regs.bed", Blacklist: "bl.bed"}
+	if o != expected {
+		t.Errorf("expected %+v, actual %+v", expected, o)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE sample (rname, start, stop)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO sample VALUES ('chr1', 5, 8), ('chr1', 15, 18), ('chr1', 25, 28), ('chr2', 5, 8)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs := filepath.Join(dir, "regions.bed")
+	if err = os.WriteFile(regs, []byte("chr1\t0\t20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bl := filepath.Join(dir, "blacklist.bed")
+	if err = os.WriteFile(bl, []byte("chr1\t10\t20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     Options
+		expected int
+	}{
+		{Options{}, 4},
+		{Options{Regions: regs}, 2},
+		{Options{Blacklist: bl}, 3},
+		{Options{Regions: regs, Blacklist: bl}, 1},
+	}
+	for _, tt := range tests {
+		f, err := tt.opts.Filter(db, htsdb.HtsdbCoords)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		q := "SELECT COUNT(*) FROM sample"
+		if f != "" {
+			q += " WHERE " + f
+		}
+		var n int
+		if err = db.Get(&n, q); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.expected {
+			t.Errorf("%+v: expected %d records, actual %d", tt.opts, tt.expected, n)
+		}
+	}
+}
+
+func TestChimeraFilter(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = htsdb.CreateChimeraTable(db); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO " + htsdb.ChimeraTable +
+		" (rname1, start1, stop1, rname2, start2, stop2) VALUES" +
+		" ('chr1', 5, 8, 'chr2', 5, 8), ('chr2', 5, 8, 'chr1', 15, 18), ('chr2', 5, 8, 'chr2', 25, 28)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	regs := filepath.Join(dir, "regions.bed")
+	if err = os.WriteFile(regs, []byte("chr1\t0\t20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bl := filepath.Join(dir, "blacklist.bed")
+	if err = os.WriteFile(bl, []byte("chr1\t10\t20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     Options
+		expected int
+	}{
+		{Options{}, 3},
+		{Options{Regions: regs}, 2},
+		{Options{Blacklist: bl}, 2},
+		{Options{Regions: regs, Blacklist: bl}, 1},
+	}
+	for _, tt := range tests {
+		f, err := tt.opts.ChimeraFilter(htsdb.HtsdbCoords)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		q := "SELECT COUNT(*) FROM " + htsdb.ChimeraTable
+		if f != "" {
+			q += " WHERE " + f
+		}
+		var n int
+		if err = db.Get(&n, q); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.expected {
+			t.Errorf("%+v: expected %d chimeras, actual %d", tt.opts, tt.expected, n)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package htsdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ColumnMap maps canonical htsdb column names (e.g. rname, copy_number) to the
+// column names used by a foreign schema (e.g. chrom, score). It allows the
+// builders and the Reader to work on tables with a different naming without
+// altering them. The zero value is an empty map.
+type ColumnMap struct {
+	names map[string]string // canonical to foreign names.
+	cols  []string          // columns of the table, if known.
+}
+
+// ParseColumnMap parses a comma separated list of canonical=foreign column
+// pairs e.g. "rname=chrom,copy_number=score". The empty string results in an
+// empty map.
+func ParseColumnMap(s string) (ColumnMap, error) {
+	m := ColumnMap{names: make(map[string]string)}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return ColumnMap{}, fmt.Errorf("htsdb: invalid column mapping %q", pair)
+		}
+		canon, foreign := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if canon == "" || foreign == "" {
+			return ColumnMap{}, fmt.Errorf("htsdb: invalid column mapping %q", pair)
+		}
+		if _, ok := m.names[canon]; ok {
+			return ColumnMap{}, fmt.Errorf("htsdb: column %s mapped twice", canon)
+		}
+		m.names[canon] = foreign
+	}
+	return m, nil
+}
+
+// ParseColumns parses a comma separated list of column names e.g.
+// "qname,rname,start,stop". The empty string results in an empty list.
+func ParseColumns(s string) ([]string, error) {
+	var cols []string
+	seen := make(map[string]bool)
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("htsdb: column %s given twice", c)
+		}
+		seen[c] = true
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// Column returns the foreign name of the canonical column name. It returns
+// name itself if the column is not mapped.
+func (m ColumnMap) Column(name string) string {
+	if c, ok := m.names[name]; ok {
+		return c
+	}
+	return name
+}
+
+// Mapped returns true if the canonical column name is mapped.
+func (m ColumnMap) Mapped(name string) bool {
+	_, ok := m.names[name]
+	return ok
+}
+
+// Clone returns a copy of m that can be resolved independently of m e.g.
+// against the tables of several databases.
+func (m ColumnMap) Clone() ColumnMap {
+	c := ColumnMap{names: make(map[string]string, len(m.names))}
+	for k, v := range m.names {
+		c.names[k] = v
+	}
+	c.cols = append(c.cols, m.cols...)
+	return c
+}
+
+// ResolveCopyNumber maps copy_number to the constant 1, so that each record
+// counts once, if copyNum is false or if table in db has no copy_number column
+// and the column is not mapped to a foreign one. Otherwise aggregates such as
+// SUM(copy_number) would silently return NULL. If table has a copy_number
+// column that the constant overrides, the columns of table are stored so that
+// Table lists them explicitly without it. It returns true if the column was
+// missing so that the caller can warn about the fallback. It must be called
+// before Table.
+func (m *ColumnMap) ResolveCopyNumber(db *sqlx.DB, table string, copyNum bool) (bool, error) {
+	if _, ok := m.names["copy_number"]; ok && copyNum {
+		return false, nil
+	}
+	cols, err := TableColumns(db, table)
+	if err != nil {
+		return false, err
+	}
+	has := false
+	for _, c := range cols {
+		if c == "copy_number" {
+			has = true
+		}
+	}
+	if copyNum && has {
+		return false, nil
+	}
+	if m.names == nil {
+		m.names = make(map[string]string)
+	}
+	m.names["copy_number"] = "1"
+	if has {
+		m.cols = cols
+	}
+	return copyNum, nil
+}
+
+// Table returns a table expression for table that exposes the mapped foreign
+// columns under their canonical names. The result can be passed directly to
+// the From method of the builders. Table is returned unchanged if m is empty.
+func (m ColumnMap) Table(table string) string {
+	if len(m.names) == 0 {
+		return table
+	}
+	return "(SELECT " + m.selectList() + " FROM " + table + ") AS " + table
+}
+
+// TableRows is like Table but the table expression only contains the rows of
+// table whose rowid is in (from, to] e.g. the rows appended since a previous
+// query. Like Watcher, it only applies to SQLite tables.
+func (m ColumnMap) TableRows(table string, from, to int64) string {
+	return fmt.Sprintf("(SELECT %s FROM %s WHERE rowid > %d AND rowid <= %d) AS %s",
+		m.selectList(), table, from, to, table)
+}
+
+// selectList returns the result columns of the table expressions of m. The
+// columns of the table are listed explicitly, without those that m
+// overrides, if they are known; otherwise they are selected with *.
+func (m ColumnMap) selectList() string {
+	canon := make([]string, 0, len(m.names))
+	for k := range m.names {
+		canon = append(canon, k)
+	}
+	sort.Strings(canon)
+
+	cols := make([]string, 0, len(m.cols)+len(m.names)+1)
+	if m.cols == nil {
+		cols = append(cols, "*")
+	}
+	for _, c := range m.cols {
+		if _, ok := m.names[c]; !ok {
+			cols = append(cols, c)
+		}
+	}
+	for _, k := range canon {
+		cols = append(cols, m.names[k]+" AS "+k)
+	}
+	return strings.Join(cols, ", ")
+}
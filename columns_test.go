@@ -0,0 +1,147 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var parseColumnMapTests = []struct {
+	Name, In, Table, Error string
+}{
+	{
+		Name:  "empty",
+		In:    "",
+		Table: "foo",
+	},
+	{
+		Name:  "two columns",
+		In:    "rname=chrom, copy_number=score",
+		Table: "(SELECT *, score AS copy_number, chrom AS rname FROM foo) AS foo",
+	},
+	{
+		Name:  "missing separator",
+		In:    "rname",
+		Error: "invalid column mapping",
+	},
+	{
+		Name:  "duplicate column",
+		In:    "rname=chrom,rname=seqid",
+		Error: "mapped twice",
+	},
+}
+
+func TestParseColumnMap(t *testing.T) {
+	for _, tt := range parseColumnMapTests {
+		m, err := ParseColumnMap(tt.In)
+		if err != nil {
+			if tt.Error == "" || !strings.Contains(err.Error(), tt.Error) {
+				t.Errorf("%s:unexpected error:%v", tt.Name, err)
+			}
+			continue
+		}
+		if tt.Error != "" {
+			t.Errorf("%s:expected error %q", tt.Name, tt.Error)
+		}
+		if got := m.Table("foo"); got != tt.Table {
+			t.Errorf("%s:wrong table: expected %q, actual %q", tt.Name, tt.Table,
+				got)
+		}
+	}
+}
+
+func TestColumnMapReader(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE bar (s, e)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	if _, err := db.Exec("INSERT INTO bar VALUES(1, 2)"); err != nil {
+		t.Fatal("Failed insert:", err)
+	}
+
+	m, err := ParseColumnMap("start=s,stop=e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(db, "sqlite3", &Record{},
+		"SELECT start, stop FROM "+m.Table("bar"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !r.Next() {
+		t.Fatal("expected a record:", r.Error())
+	}
+	rec := r.Record().(*Record)
+	if rec.Start != 1 || rec.End != 2 {
+		t.Errorf("wrong record: %v", rec)
+	}
+}
+
+var resolveCopyNumberTests = []struct {
+	Name, ColMap string
+	CopyNum      bool
+	Sum          int
+}{
+	{Name: "copy numbers", CopyNum: true, Sum: 5},
+	{Name: "override", CopyNum: false, Sum: 2},
+	{Name: "mapped", ColMap: "copy_number=score", CopyNum: true, Sum: 30},
+	{Name: "mapped override", ColMap: "copy_number=score", CopyNum: false, Sum: 2},
+}
+
+func TestResolveCopyNumber(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE bar (rname, copy_number, score)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO bar VALUES ('chr1', 2, 10), ('chr1', 3, 20)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range resolveCopyNumberTests {
+		m, err := ParseColumnMap(tt.ColMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if missing, err := m.ResolveCopyNumber(db, "bar", tt.CopyNum); err != nil || missing {
+			t.Fatalf("%s: unexpected result: %v, %v", tt.Name, missing, err)
+		}
+		var sum int
+		if err := db.Get(&sum, "SELECT SUM(copy_number) FROM "+m.Table("bar")); err != nil {
+			t.Fatalf("%s: %v", tt.Name, err)
+		}
+		if sum != tt.Sum {
+			t.Errorf("%s: expected sum %d, actual %d", tt.Name, tt.Sum, sum)
+		}
+	}
+}
+
+func TestResolveCopyNumberMissing(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+
+	m := ColumnMap{}
+	missing, err := m.ResolveCopyNumber(db, "foo", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !missing || m.Column("copy_number") != "1" {
+		t.Errorf("expected fallback to 1, actual %v, %q", missing, m.Column("copy_number"))
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	cols, err := ParseColumns(" qname, rname,,start ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cols, "|") != "qname|rname|start" {
+		t.Errorf("unexpected columns: %q", cols)
+	}
+	if _, err := ParseColumns("qname,qname"); err == nil {
+		t.Error("expected error for duplicate column")
+	}
+}
@@ -0,0 +1,35 @@
+package htsdb
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// uriEscaper escapes the characters of a file name that are special in SQLite
+// URI file names.
+var uriEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// ReadOnlyDSN returns the data source name that opens the SQLite database
+// file path read-only.
+func ReadOnlyDSN(path string) string {
+	return "file:" + uriEscaper.Replace(path) + "?mode=ro"
+}
+
+// OpenReadOnly opens the SQLite database file path read-only with a pool of
+// up to conns connections that are kept open, so that each of conns
+// concurrent workers reads through its own connection instead of waiting for
+// a shared one. Read-only connections do not take write locks and are thus
+// not serialized by SQLite.
+func OpenReadOnly(path string, conns int) (*sqlx.DB, error) {
+	if conns < 1 {
+		conns = 1
+	}
+	db, err := sqlx.Connect("sqlite3", ReadOnlyDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
+	return db, nil
+}
@@ -0,0 +1,93 @@
+package htsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestReadOnlyDSN(t *testing.T) {
+	tests := []struct{ path, expected string }{
+		{"test.db", "file:test.db?mode=ro"},
+		{"/data/run#1?.db", "file:/data/run%231%3f.db?mode=ro"},
+	}
+	for _, tt := range tests {
+		if got := ReadOnlyDSN(tt.path); got != tt.expected {
+			t.Errorf("ReadOnlyDSN(%q): expected %q, actual %q", tt.path, tt.expected, got)
+		}
+	}
+}
+
+// benchDB creates a database with n records per reference on 8 references.
+func benchDB(b *testing.B, n int) (string, func()) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(dir, "bench.db")
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	db.MustExec("CREATE TABLE sample (rname TEXT, start INTEGER, stop INTEGER, copy_number INTEGER)")
+	tx := db.MustBegin()
+	for r := 0; r < 8; r++ {
+		for i := 0; i < n; i++ {
+			tx.MustExec("INSERT INTO sample VALUES (?, ?, ?, 1)", string(rune('a'+r)), i, i+30)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	db.MustExec("CREATE INDEX sample_rname ON sample (rname)")
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// benchWorkers reads all references of db with 8 concurrent workers.
+func benchWorkers(b *testing.B, db *sqlx.DB) {
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for r := 0; r < 8; r++ {
+			wg.Add(1)
+			go func(rname string) {
+				defer wg.Done()
+				var recs []Feature
+				err := db.Select(&recs, "SELECT rname, start, stop, copy_number FROM sample WHERE rname = ?", rname)
+				if err != nil {
+					b.Error(err)
+				}
+			}(string(rune('a' + r)))
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkSharedConnection(b *testing.B) {
+	path, cleanup := benchDB(b, 20000)
+	defer cleanup()
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	b.ResetTimer()
+	benchWorkers(b, db)
+}
+
+func BenchmarkReadOnlyPool(b *testing.B) {
+	path, cleanup := benchDB(b, 20000)
+	defer cleanup()
+	db, err := OpenReadOnly(path, 8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	b.ResetTimer()
+	benchWorkers(b, db)
+}
@@ -0,0 +1,139 @@
+package htsdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CoordsMetaKey is the metadata key that stores the coordinate convention of
+// the start and stop columns.
+const CoordsMetaKey = "coords"
+
+// Coords describes the coordinate convention used for the start and stop
+// columns of a database. Base is the position of the first base of a
+// reference (0 or 1) and HalfOpen is true if stop is not part of the interval.
+type Coords struct {
+	Base     int
+	HalfOpen bool
+}
+
+// Common coordinate conventions. HtsdbCoords is the convention assumed by
+// Range: 0-based with an inclusive stop.
+var (
+	HtsdbCoords = Coords{Base: 0, HalfOpen: false}
+	BEDCoords   = Coords{Base: 0, HalfOpen: true}
+	SAMCoords   = Coords{Base: 1, HalfOpen: false}
+)
+
+// ParseCoords parses a coordinate convention. Valid values are "bed", "sam",
+// "htsdb" or "<base>-<closed|halfopen>" e.g. "1-closed".
+func ParseCoords(s string) (Coords, error) {
+	switch strings.ToLower(s) {
+	case "htsdb", "0-closed":
+		return HtsdbCoords, nil
+	case "bed", "0-halfopen":
+		return BEDCoords, nil
+	case "sam", "1-closed":
+		return SAMCoords, nil
+	case "1-halfopen":
+		return Coords{Base: 1, HalfOpen: true}, nil
+	}
+	return Coords{}, fmt.Errorf("htsdb: invalid coordinate convention %q", s)
+}
+
+// String returns the "<base>-<closed|halfopen>" representation of c.
+func (c Coords) String() string {
+	if c.HalfOpen {
+		return fmt.Sprintf("%d-halfopen", c.Base)
+	}
+	return fmt.Sprintf("%d-closed", c.Base)
+}
+
+// ToHtsdb converts start and stop from c to HtsdbCoords.
+func (c Coords) ToHtsdb(start, stop int) (int, int) {
+	start -= c.Base
+	stop -= c.Base
+	if c.HalfOpen {
+		stop--
+	}
+	return start, stop
+}
+
+// FromHtsdb converts start and stop from HtsdbCoords to c.
+func (c Coords) FromHtsdb(start, stop int) (int, int) {
+	start += c.Base
+	stop += c.Base
+	if c.HalfOpen {
+		stop++
+	}
+	return start, stop
+}
+
+// Normalize converts the coordinates of r in place from c to HtsdbCoords so
+// that End, Head and Tail return correct positions.
+func (c Coords) Normalize(r *Range) {
+	r.StartPos, r.StopPos = c.ToHtsdb(r.StartPos, r.StopPos)
+}
+
+// LenExpr returns an SQL expression that calculates the alignment length from
+// the start and stop columns under c.
+func (c Coords) LenExpr() string {
+	if c.HalfOpen {
+		return "stop - start"
+	}
+	return "stop - start + 1"
+}
+
+// FragmentStopExpr returns an SQL expression that calculates the stop of the
+// sequenced fragment of a paired-end record from the start and tlen columns
+// under c. It is only meaningful for the leftmost mate (positive tlen).
+func (c Coords) FragmentStopExpr() string {
+	if c.HalfOpen {
+		return "start + tlen"
+	}
+	return "start + tlen - 1"
+}
+
+// SelectCoords returns the coordinate convention stored in the metadata of
+// db. It returns HtsdbCoords if no convention is stored.
+func SelectCoords(db *sqlx.DB) (Coords, error) {
+	return SelectCoordsContext(context.Background(), db)
+}
+
+// SelectCoordsContext is like SelectCoords but the queries run with ctx.
+func SelectCoordsContext(ctx context.Context, db *sqlx.DB) (Coords, error) {
+	v, ok, err := GetMetaContext(ctx, db, CoordsMetaKey)
+	if err != nil || !ok {
+		return HtsdbCoords, err
+	}
+	return ParseCoords(v)
+}
+
+// SetCoords stores the coordinate convention c in the metadata of db.
+func SetCoords(db *sqlx.DB, c Coords) error {
+	return SetMeta(db, CoordsMetaKey, c.String())
+}
+
+// InitCoords stores the coordinate convention c in the metadata of db before
+// records are added to it. The convention applies to every table of db, so it
+// fails if db already has record tables that use another convention; call it
+// before the new table is created.
+func InitCoords(db *sqlx.DB, c Coords) error {
+	tables, err := RecordTables(db)
+	if err != nil {
+		return err
+	}
+	if len(tables) > 0 {
+		cur, err := SelectCoords(db)
+		if err != nil {
+			return err
+		}
+		if cur != c {
+			return fmt.Errorf("htsdb: database stores %s coordinates, not %s", cur, c)
+		}
+	}
+	return SetCoords(db, c)
+}
@@ -0,0 +1,77 @@
+package htsdb
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var coordsTests = []struct {
+	Name                  string
+	Coords                Coords
+	Start, Stop           int
+	HtsdbStart, HtsdbStop int
+}{
+	{Name: "htsdb", Coords: HtsdbCoords, Start: 10, Stop: 19, HtsdbStart: 10, HtsdbStop: 19},
+	{Name: "bed", Coords: BEDCoords, Start: 10, Stop: 20, HtsdbStart: 10, HtsdbStop: 19},
+	{Name: "sam", Coords: SAMCoords, Start: 11, Stop: 20, HtsdbStart: 10, HtsdbStop: 19},
+}
+
+func TestCoords(t *testing.T) {
+	for _, tt := range coordsTests {
+		start, stop := tt.Coords.ToHtsdb(tt.Start, tt.Stop)
+		if start != tt.HtsdbStart || stop != tt.HtsdbStop {
+			t.Errorf("%s:wrong ToHtsdb: expected %d-%d, actual %d-%d", tt.Name,
+				tt.HtsdbStart, tt.HtsdbStop, start, stop)
+		}
+		start, stop = tt.Coords.FromHtsdb(start, stop)
+		if start != tt.Start || stop != tt.Stop {
+			t.Errorf("%s:wrong FromHtsdb: expected %d-%d, actual %d-%d", tt.Name,
+				tt.Start, tt.Stop, start, stop)
+		}
+		c, err := ParseCoords(tt.Coords.String())
+		if err != nil || c != tt.Coords {
+			t.Errorf("%s:failed to parse %s:%v", tt.Name, tt.Coords, err)
+		}
+	}
+}
+
+func TestSelectCoords(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+
+	c, err := SelectCoords(db)
+	if err != nil || c != HtsdbCoords {
+		t.Errorf("expected default coords, actual %s:%v", c, err)
+	}
+	if err = SetCoords(db, BEDCoords); err != nil {
+		t.Fatal("failed to set coords:", err)
+	}
+	c, err = SelectCoords(db)
+	if err != nil || c != BEDCoords {
+		t.Errorf("expected bed coords, actual %s:%v", c, err)
+	}
+}
+
+func TestInitCoords(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+
+	if err := InitCoords(db, BEDCoords); err != nil {
+		t.Fatal("failed to init coords:", err)
+	}
+	if err := InitCoords(db, SAMCoords); err != nil {
+		t.Errorf("expected no error without record tables, actual %v", err)
+	}
+	db.MustExec("CREATE TABLE sample (rname TEXT, start INTEGER, stop INTEGER)")
+	if err := InitCoords(db, BEDCoords); err == nil {
+		t.Error("expected error for different coords")
+	}
+	if err := InitCoords(db, SAMCoords); err != nil {
+		t.Errorf("expected no error for same coords, actual %v", err)
+	}
+	c, err := SelectCoords(db)
+	if err != nil || c != SAMCoords {
+		t.Errorf("expected sam coords, actual %s:%v", c, err)
+	}
+}
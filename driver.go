@@ -0,0 +1,129 @@
+package htsdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// Supported database drivers. The commands register all of them by importing
+// github.com/mnsmar/htsdb/v2/drivers; otherwise the driver of a PostgreSQL
+// database must be registered by importing github.com/lib/pq, that of a MySQL
+// or MariaDB database by importing github.com/go-sql-driver/mysql and that of
+// a DuckDB database by importing github.com/marcboeker/go-duckdb.
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+	MySQL    = "mysql"
+	DuckDB   = "duckdb"
+)
+
+// Drivers are the names of the supported database drivers. DuckDB needs cgo
+// and is not listed when building with the purego tag.
+var Drivers = append([]string{SQLite, Postgres, MySQL}, cgoDrivers...)
+
+// Connect opens and pings the database dsn with driver, one of Drivers. For
+// SQLite and DuckDB dsn is the database file, for PostgreSQL a connection string e.g.
+// "postgres://user@host/lab?sslmode=disable" and for MySQL a DSN e.g.
+// "user:pass@tcp(host:3306)/lab". MySQL sessions run in ANSI mode so that
+// double quoted identifiers and || behave as in the other databases. Queries
+// with positional arguments must be rebound with the Rebind method of the
+// database and squirrel builders can use Placeholder.
+func Connect(driver, dsn string) (*sqlx.DB, error) {
+	switch driver {
+	case SQLite, Postgres:
+	case DuckDB:
+		if len(cgoDrivers) == 0 {
+			return nil, fmt.Errorf("htsdb: database driver %q needs cgo and is not available with the purego tag", driver)
+		}
+	case MySQL:
+		dsn = mysqlANSI(dsn)
+	default:
+		return nil, fmt.Errorf("htsdb: unsupported database driver %q", driver)
+	}
+	db, err := sqlx.Connect(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == Postgres {
+		// PostgreSQL folds unquoted column aliases e.g. copyNum to lower case.
+		db.Mapper = reflectx.NewMapperTagFunc("db", strings.ToLower, strings.ToLower)
+	}
+	return db, nil
+}
+
+// Placeholder returns the squirrel placeholder format of driver e.g. to
+// build queries with squirrel.StatementBuilder.PlaceholderFormat.
+func Placeholder(driver string) squirrel.PlaceholderFormat {
+	if sqlx.BindType(driver) == sqlx.DOLLAR {
+		return squirrel.Dollar
+	}
+	return squirrel.Question
+}
+
+// mysqlANSI returns the MySQL dsn with the ANSI SQL mode unless it already
+// sets the SQL mode.
+func mysqlANSI(dsn string) string {
+	if strings.Contains(dsn, "sql_mode=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&sql_mode=%27ANSI%27"
+	}
+	return dsn + "?sql_mode=%27ANSI%27"
+}
+
+// isPostgres returns true if db is a PostgreSQL database.
+func isPostgres(db interface{ DriverName() string }) bool {
+	return db.DriverName() == Postgres
+}
+
+// isMySQL returns true if db is a MySQL or MariaDB database.
+func isMySQL(db interface{ DriverName() string }) bool {
+	return db.DriverName() == MySQL
+}
+
+// isDuckDB returns true if db is a DuckDB database.
+func isDuckDB(db interface{ DriverName() string }) bool {
+	return db.DriverName() == DuckDB
+}
+
+// requireSQLite returns an error if db is not a SQLite database e.g. for
+// statements that identify rows by rowid, which the other databases lack.
+func requireSQLite(db interface{ DriverName() string }, what string) error {
+	if d := db.DriverName(); d != SQLite {
+		return fmt.Errorf("htsdb: %s requires a %s database, not %s", what, SQLite, d)
+	}
+	return nil
+}
+
+// longText returns the column type of db for text that can be longer than
+// 64 kB e.g. reference sequences.
+func longText(db interface{ DriverName() string }) string {
+	if isMySQL(db) {
+		return "LONGTEXT"
+	}
+	return "TEXT"
+}
+
+// upsertSQL returns a statement that inserts a row of cols into table or, if
+// a row with the same primary key, the first of cols, exists, replaces its
+// other columns. It is not rebound.
+func upsertSQL(db interface{ DriverName() string }, table string, cols ...string) string {
+	q := "INSERT INTO " + table + " (" + strings.Join(cols, ", ") + ") VALUES (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+	set := make([]string, len(cols)-1)
+	if isMySQL(db) {
+		for i, c := range cols[1:] {
+			set[i] = c + " = VALUES(" + c + ")"
+		}
+		return q + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	}
+	for i, c := range cols[1:] {
+		set[i] = c + " = excluded." + c
+	}
+	return q + " ON CONFLICT (" + cols[0] + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
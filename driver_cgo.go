@@ -0,0 +1,6 @@
+//go:build !purego
+
+package htsdb
+
+// cgoDrivers are the supported database drivers that need cgo.
+var cgoDrivers = []string{DuckDB}
@@ -0,0 +1,7 @@
+//go:build purego
+
+package htsdb
+
+// cgoDrivers are the supported database drivers that need cgo; none are
+// available with the purego tag.
+var cgoDrivers []string
@@ -0,0 +1,16 @@
+//go:build purego
+
+package htsdb
+
+import "testing"
+
+func TestPuregoDrivers(t *testing.T) {
+	for _, d := range Drivers {
+		if d == DuckDB {
+			t.Errorf("%s should not be listed with the purego tag", DuckDB)
+		}
+	}
+	if _, err := Connect(DuckDB, "lab.duckdb"); err == nil {
+		t.Errorf("expected error for %s with the purego tag", DuckDB)
+	}
+}
@@ -0,0 +1,49 @@
+package htsdb
+
+import (
+	"testing"
+)
+
+type driverName string
+
+func (d driverName) DriverName() string { return string(d) }
+
+func TestUpsertSQL(t *testing.T) {
+	tests := []struct {
+		Driver string
+		Out    string
+	}{
+		{SQLite, "INSERT INTO t (k, a, b) VALUES (?, ?, ?)" +
+			" ON CONFLICT (k) DO UPDATE SET a = excluded.a, b = excluded.b"},
+		{DuckDB, "INSERT INTO t (k, a, b) VALUES (?, ?, ?)" +
+			" ON CONFLICT (k) DO UPDATE SET a = excluded.a, b = excluded.b"},
+		{MySQL, "INSERT INTO t (k, a, b) VALUES (?, ?, ?)" +
+			" ON DUPLICATE KEY UPDATE a = VALUES(a), b = VALUES(b)"},
+	}
+	for _, tt := range tests {
+		if got := upsertSQL(driverName(tt.Driver), "t", "k", "a", "b"); got != tt.Out {
+			t.Errorf("%s: expected %q, actual %q", tt.Driver, tt.Out, got)
+		}
+	}
+}
+
+func TestMySQLANSI(t *testing.T) {
+	tests := []struct {
+		In, Out string
+	}{
+		{"u@tcp(h)/lab", "u@tcp(h)/lab?sql_mode=%27ANSI%27"},
+		{"u@tcp(h)/lab?tls=true", "u@tcp(h)/lab?tls=true&sql_mode=%27ANSI%27"},
+		{"u@tcp(h)/lab?sql_mode=%27TRADITIONAL%27", "u@tcp(h)/lab?sql_mode=%27TRADITIONAL%27"},
+	}
+	for _, tt := range tests {
+		if got := mysqlANSI(tt.In); got != tt.Out {
+			t.Errorf("expected %q, actual %q", tt.Out, got)
+		}
+	}
+}
+
+func TestConnectDriver(t *testing.T) {
+	if _, err := Connect("oracle", "lab"); err == nil {
+		t.Error("expected error for unsupported driver")
+	}
+}
@@ -0,0 +1,18 @@
+// Package drivers registers the database drivers of htsdb.Drivers so that
+// commands can connect to any supported database with a single import:
+//
+//	import _ "github.com/mnsmar/htsdb/v2/drivers"
+//
+// SQLite is provided by github.com/mattn/go-sqlite3, which needs cgo. Building
+// with the purego tag, e.g. CGO_ENABLED=0 go build -tags purego, replaces it
+// with the cgo-free modernc.org/sqlite for static binaries that cross
+// compile without a C toolchain. DuckDB needs cgo and is neither registered
+// nor listed in htsdb.Drivers with the purego tag.
+package drivers
+
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+)
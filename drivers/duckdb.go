@@ -0,0 +1,5 @@
+//go:build !purego
+
+package drivers
+
+import _ "github.com/marcboeker/go-duckdb"
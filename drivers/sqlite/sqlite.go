@@ -0,0 +1,8 @@
+//go:build !purego
+
+// Package sqlite registers an SQLite driver under the name sqlite3 of
+// htsdb.SQLite: github.com/mattn/go-sqlite3 by default or, with the purego
+// build tag, the cgo-free modernc.org/sqlite.
+package sqlite
+
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,18 @@
+//go:build purego
+
+// Package sqlite registers an SQLite driver under the name sqlite3 of
+// htsdb.SQLite: github.com/mattn/go-sqlite3 by default or, with the purego
+// build tag, the cgo-free modernc.org/sqlite.
+package sqlite
+
+import (
+	"database/sql"
+
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// modernc.org/sqlite registers itself as sqlite; htsdb connects to
+	// sqlite3.
+	sql.Register("sqlite3", &sqlite.Driver{})
+}
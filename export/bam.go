@@ -0,0 +1,136 @@
+package export
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// WriteBAM writes the header described by hdr and the records of src to w in
+// BAM format. Records must be *record.SamRecord or implement SAMRecorder and
+// their references must be in hdr; unlike WriteSAM the header is required. If
+// hdr.Coordinate is set, records must be in the order of the header
+// references and by position, with records without a reference last. It
+// stops at the first error of src or w and returns it.
+func WriteBAM(w io.Writer, src query.RecordSource, hdr HeaderOpts) error {
+	rename := hdr.Rename
+	if rename == nil {
+		rename = func(s string) string { return s }
+	}
+	var refs []*sam.Reference
+	if hdr.Seqs != nil {
+		for _, s := range hdr.Seqs {
+			md5, err := hex.DecodeString(s.MD5)
+			if err != nil {
+				return fmt.Errorf("export: invalid MD5 of reference %s: %v", s.Name, err)
+			}
+			ref, err := sam.NewReference(rename(s.Name), "", "", s.Length, md5, nil)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+	} else {
+		for _, r := range hdr.Refs {
+			ref, err := sam.NewReference(rename(r.Chrom), "", "", r.Length, nil, nil)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("export: BAM output needs the references for the header")
+	}
+	h, err := sam.NewHeader([]byte(headerText(hdr, rename, false)), refs)
+	if err != nil {
+		return err
+	}
+	bw, err := bam.NewWriter(w, h, 1)
+	if err != nil {
+		return err
+	}
+
+	var rec sam.Record
+	var line []byte
+	lastRef, lastPos := 0, -1
+	for src.Next() {
+		var s *record.SamRecord
+		switch r := src.Record().(type) {
+		case *record.SamRecord:
+			s = r
+		case SAMRecorder:
+			s = r.SAMRecord()
+		default:
+			bw.Close()
+			return fmt.Errorf("export: cannot write record of type %T as BAM", r)
+		}
+		line = appendSAMLine(line[:0], s, rename)
+		rec = sam.Record{}
+		if err = rec.UnmarshalSAM(h, line); err != nil {
+			bw.Close()
+			return fmt.Errorf("export: record %s: %v", s.Qname, err)
+		}
+		if hdr.Coordinate {
+			ref := len(refs)
+			if rec.Ref != nil {
+				ref = rec.Ref.ID()
+			}
+			if ref < lastRef || (ref == lastRef && rec.Pos < lastPos) {
+				bw.Close()
+				return fmt.Errorf("export: record %s is not sorted by coordinate", s.Qname)
+			}
+			lastRef, lastPos = ref, rec.Pos
+		}
+		if err = bw.Write(&rec); err != nil {
+			bw.Close()
+			return err
+		}
+	}
+	if err = src.Err(); err != nil {
+		bw.Close()
+		return err
+	}
+	return bw.Close()
+}
+
+// appendSAMLine appends s as a SAM line without the trailing newline to b
+// with its references renamed by rename. Empty tags add no field.
+func appendSAMLine(b []byte, s *record.SamRecord, rename func(string) string) []byte {
+	rnext := s.Rnext
+	if rnext != "=" && rnext != "*" {
+		rnext = rename(rnext)
+	}
+	b = append(b, s.Qname...)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, int64(s.Flag), 10)
+	b = append(b, '\t')
+	b = append(b, rename(s.Rname)...)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, int64(s.Pos), 10)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, int64(s.Mapq), 10)
+	b = append(b, '\t')
+	b = append(b, s.Cigar...)
+	b = append(b, '\t')
+	b = append(b, rnext...)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, int64(s.Pnext), 10)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, int64(s.Tlen), 10)
+	b = append(b, '\t')
+	b = append(b, s.Seq...)
+	b = append(b, '\t')
+	b = append(b, s.Qual...)
+	if s.Tags != "" {
+		b = append(b, '\t')
+		b = append(b, s.Tags...)
+	}
+	return b
+}
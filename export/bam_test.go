@@ -0,0 +1,76 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+func TestWriteBAM(t *testing.T) {
+	recs := []interface{}{
+		&record.SamRecord{Qname: "r1", Rname: "1", Pos: 10, Mapq: 30, Cigar: "5M",
+			Rnext: "*", Seq: "ACGTA", Qual: "IIIII", Tags: "NM:i:0"},
+		&record.SamRecord{Qname: "r2", Flag: 16, Rname: "2", Pos: 1, Cigar: "4M",
+			Rnext: "1", Pnext: 10, Seq: "TTGA", Qual: "*"},
+	}
+	hdr := HeaderOpts{
+		Refs:       []record.Reference{{Chrom: "1", Length: 100}, {Chrom: "2", Length: 200}},
+		Rename:     htsdb.EnsemblToUCSC,
+		Coordinate: true,
+	}
+	var buf bytes.Buffer
+	if err := WriteBAM(&buf, query.NewSliceSource(recs, nil), hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := bam.NewReader(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if refs := r.Header().Refs(); len(refs) != 2 || refs[1].Name() != "chr2" || refs[1].Len() != 200 {
+		t.Fatalf("unexpected header references %v", refs)
+	}
+	for _, exp := range recs {
+		s := exp.(*record.SamRecord)
+		rec, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Name != s.Qname || rec.Pos != s.Pos-1 || rec.Ref.Name() != "chr"+s.Rname ||
+			string(rec.Seq.Expand()) != s.Seq || int(rec.Flags) != s.Flag {
+			t.Errorf("record %s was not written intact: %v", s.Qname, rec)
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, actual %v", err)
+	}
+}
+
+func TestWriteBAMErrors(t *testing.T) {
+	refs := []record.Reference{{Chrom: "1", Length: 100}}
+	rec := &record.SamRecord{Qname: "r1", Rname: "1", Pos: 10, Cigar: "4M",
+		Rnext: "*", Seq: "ACGT", Qual: "*"}
+	var buf bytes.Buffer
+	src := query.NewSliceSource([]interface{}{rec}, nil)
+	if err := WriteBAM(&buf, src, HeaderOpts{}); err == nil {
+		t.Error("expected error for missing references")
+	}
+	unknown := *rec
+	unknown.Rname = "2"
+	src = query.NewSliceSource([]interface{}{&unknown}, nil)
+	if err := WriteBAM(&buf, src, HeaderOpts{Refs: refs}); err == nil {
+		t.Error("expected error for record on unknown reference")
+	}
+	earlier := *rec
+	earlier.Pos = 5
+	src = query.NewSliceSource([]interface{}{rec, &earlier}, nil)
+	if err := WriteBAM(&buf, src, HeaderOpts{Refs: refs, Coordinate: true}); err == nil {
+		t.Error("expected error for unsorted records")
+	}
+}
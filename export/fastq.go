@@ -0,0 +1,66 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mnsmar/htsdb/v2/analysis"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// FastxWriter writes reads in FASTQ format or, for FASTA, without their
+// qualities in FASTA format.
+type FastxWriter struct {
+	w     *bufio.Writer
+	fasta bool
+}
+
+// NewFastxWriter returns a FastxWriter that writes to w in FASTA format if
+// fasta is true and in FASTQ format otherwise.
+func NewFastxWriter(w io.Writer, fasta bool) *FastxWriter {
+	return &FastxWriter{w: bufio.NewWriter(w), fasta: fasta}
+}
+
+// Write writes the read name with sequence seq and qualities qual. Missing
+// qualities i.e. "" or "*" are written as I (Phred 40) for each base in
+// FASTQ format.
+func (f *FastxWriter) Write(name, seq, qual string) error {
+	if f.fasta {
+		_, err := f.w.WriteString(">" + name + "\n" + seq + "\n")
+		return err
+	}
+	if qual == "" || qual == "*" {
+		qual = strings.Repeat("I", len(seq))
+	}
+	if len(qual) != len(seq) {
+		return fmt.Errorf("export: read %s has %d bases but %d qualities", name, len(seq), len(qual))
+	}
+	_, err := f.w.WriteString("@" + name + "\n" + seq + "\n+\n" + qual + "\n")
+	return err
+}
+
+// Flush flushes the underlying writer.
+func (f *FastxWriter) Flush() error {
+	return f.w.Flush()
+}
+
+// OriginalRead returns the sequence and qualities of a read as sequenced
+// given those of its alignment on strand ori, which are reverse complemented
+// and reversed for reads aligned on the reverse strand. Missing qualities are
+// returned unchanged.
+func OriginalRead(seq, qual string, ori record.Orientation) (string, string) {
+	if ori != record.Reverse || seq == "*" {
+		return seq, qual
+	}
+	seq = analysis.ReverseComplement(seq)
+	if qual != "" && qual != "*" {
+		b := []byte(qual)
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		qual = string(b)
+	}
+	return seq, qual
+}
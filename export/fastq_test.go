@@ -0,0 +1,44 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+func TestFastxWriter(t *testing.T) {
+	var fq, fa bytes.Buffer
+	wq, wa := NewFastxWriter(&fq, false), NewFastxWriter(&fa, true)
+	for _, w := range []*FastxWriter{wq, wa} {
+		if err := w.Write("r1", "ACGT", "ABCD"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write("r2", "AC", "*"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := "@r1\nACGT\n+\nABCD\n@r2\nAC\n+\nII\n"; fq.String() != exp {
+		t.Errorf("unexpected FASTQ output: %q", fq.String())
+	}
+	if exp := ">r1\nACGT\n>r2\nAC\n"; fa.String() != exp {
+		t.Errorf("unexpected FASTA output: %q", fa.String())
+	}
+	if err := wq.Write("r3", "ACG", "II"); err == nil {
+		t.Error("expected error for quality length mismatch")
+	}
+}
+
+func TestOriginalRead(t *testing.T) {
+	seq, qual := OriginalRead("AACG", "ABCD", record.Reverse)
+	if seq != "CGTT" || qual != "DCBA" {
+		t.Errorf("unexpected reverse read %s %s", seq, qual)
+	}
+	seq, qual = OriginalRead("AACG", "*", record.Forward)
+	if seq != "AACG" || qual != "*" {
+		t.Errorf("unexpected forward read %s %s", seq, qual)
+	}
+}
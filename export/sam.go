@@ -0,0 +1,189 @@
+// Package export writes htsdb records in the formats of other tools so that
+// commands and library users share a single, tested implementation of each
+// format. It is part of the stable API of htsdb together with packages
+// record, query, analysis and importer and also exports the writers of
+// tracks, tables and plots of package htsdb: their types are aliases of those
+// of package htsdb and their functions call those of package htsdb. It is
+// kept compatible across minor versions of module github.com/mnsmar/htsdb/v2.
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// HeaderOpts controls the SAM header and the reference names written by
+// WriteSAM.
+type HeaderOpts struct {
+	// Refs are written as @SQ lines. No header is written if Refs, Seqs,
+	// ReadGroups and Program are all nil and Coordinate is false; otherwise
+	// the header starts with an @HD line.
+	Refs []record.Reference
+	// Seqs are written as @SQ lines with their MD5 checksums instead of Refs
+	// if not nil.
+	Seqs []record.RefSeq
+	// Rename renames references in the header and in the rname and rnext
+	// fields of records. Names are kept if Rename is nil.
+	Rename func(string) string
+	// Coordinate marks the records as sorted by coordinate in the @HD line;
+	// the sort order is unknown otherwise.
+	Coordinate bool
+	// ReadGroups are written as @RG lines.
+	ReadGroups []ReadGroup
+	// Program is written as a @PG line if not nil.
+	Program *Program
+}
+
+// ReadGroup is an @RG line of a SAM header.
+type ReadGroup struct {
+	ID string
+	// Fields are the other fields of the line in order e.g. SM:liver.
+	Fields []string
+}
+
+// Program is a @PG line of a SAM header that describes the program that
+// wrote the records.
+type Program struct {
+	ID, Name, Version string
+	// CommandLine is the command line of the program. Tabs and newlines are
+	// written as spaces.
+	CommandLine string
+}
+
+// headerText returns the lines of the SAM header described by hdr with
+// references renamed by rename. @SQ lines are left out unless sq is true
+// e.g. for BAM headers whose references are binary.
+func headerText(hdr HeaderOpts, rename func(string) string, sq bool) string {
+	if hdr.Refs == nil && hdr.Seqs == nil && hdr.ReadGroups == nil &&
+		hdr.Program == nil && !hdr.Coordinate {
+		return ""
+	}
+	var b strings.Builder
+	so := "unknown"
+	if hdr.Coordinate {
+		so = "coordinate"
+	}
+	fmt.Fprintf(&b, "@HD\tVN:1.6\tSO:%s\n", so)
+	if sq && hdr.Seqs != nil {
+		for _, s := range hdr.Seqs {
+			fmt.Fprintf(&b, "@SQ\tSN:%s\tLN:%d\tM5:%s\n", rename(s.Name), s.Length, s.MD5)
+		}
+	} else if sq {
+		for _, r := range hdr.Refs {
+			fmt.Fprintf(&b, "@SQ\tSN:%s\tLN:%d\n", rename(r.Chrom), r.Length)
+		}
+	}
+	for _, rg := range hdr.ReadGroups {
+		b.WriteString("@RG\tID:" + rg.ID)
+		for _, f := range rg.Fields {
+			b.WriteString("\t" + f)
+		}
+		b.WriteByte('\n')
+	}
+	if p := hdr.Program; p != nil {
+		b.WriteString("@PG\tID:" + p.ID)
+		if p.Name != "" {
+			b.WriteString("\tPN:" + p.Name)
+		}
+		if p.Version != "" {
+			b.WriteString("\tVN:" + p.Version)
+		}
+		if p.CommandLine != "" {
+			b.WriteString("\tCL:" + strings.Map(func(r rune) rune {
+				if r == '\t' || r == '\n' {
+					return ' '
+				}
+				return r
+			}, p.CommandLine))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// SAMRecorder is implemented by records that are written as SAM records e.g.
+// records that embed record.SamRecord with additional columns that modify it.
+type SAMRecorder interface {
+	SAMRecord() *record.SamRecord
+}
+
+// WriteSAM writes the header described by hdr and the records of src to w in
+// SAM format. Records must be *record.SamRecord or implement SAMRecorder.
+// Records are streamed field by field, so there is no limit on their length.
+// It stops at the first error of src or w and returns it.
+func WriteSAM(w io.Writer, src query.RecordSource, hdr HeaderOpts) error {
+	rename := hdr.Rename
+	if rename == nil {
+		rename = func(s string) string { return s }
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(headerText(hdr, rename, true))
+	sw := &samWriter{w: bw, rename: rename}
+	for src.Next() {
+		var s *record.SamRecord
+		switch rec := src.Record().(type) {
+		case *record.SamRecord:
+			s = rec
+		case SAMRecorder:
+			s = rec.SAMRecord()
+		default:
+			return fmt.Errorf("export: cannot write record of type %T as SAM", rec)
+		}
+		if err := sw.write(s); err != nil {
+			return err
+		}
+	}
+	if err := src.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// samWriter writes SAM records field by field to a buffered writer so that
+// records of any length are streamed without being copied into a line first.
+type samWriter struct {
+	w      *bufio.Writer
+	rename func(string) string
+	num    []byte
+}
+
+// write writes s followed by a newline.
+func (sw *samWriter) write(s *record.SamRecord) error {
+	rnext := s.Rnext
+	if rnext != "=" && rnext != "*" {
+		rnext = sw.rename(rnext)
+	}
+	sw.text(s.Qname)
+	sw.int(s.Flag)
+	sw.text(sw.rename(s.Rname))
+	sw.int(s.Pos)
+	sw.int(s.Mapq)
+	sw.text(s.Cigar)
+	sw.text(rnext)
+	sw.int(s.Pnext)
+	sw.int(s.Tlen)
+	sw.text(s.Seq)
+	sw.text(s.Qual)
+	sw.w.WriteString(s.Tags)
+	return sw.w.WriteByte('\n')
+}
+
+// text writes field v and a tab. Errors are sticky in bufio.Writer and are
+// reported by the final write of the record.
+func (sw *samWriter) text(v string) {
+	sw.w.WriteString(v)
+	sw.w.WriteByte('\t')
+}
+
+// int writes integer field v and a tab.
+func (sw *samWriter) int(v int) {
+	sw.num = strconv.AppendInt(sw.num[:0], int64(v), 10)
+	sw.w.Write(sw.num)
+	sw.w.WriteByte('\t')
+}
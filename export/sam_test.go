@@ -0,0 +1,120 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// flagged is a record that sets the reverse complemented bit from its strand.
+type flagged struct {
+	record.SamRecord
+	Strand record.Orientation
+}
+
+func (f *flagged) SAMRecord() *record.SamRecord {
+	s := f.SamRecord
+	s.Flag = record.StrandFlag(s.Flag, f.Strand)
+	return &s
+}
+
+func TestWriteSAM(t *testing.T) {
+	long := strings.Repeat("A", 100000)
+	recs := []interface{}{
+		&record.SamRecord{Qname: "r1", Rname: "1", Pos: 10, Mapq: 30, Cigar: "5M",
+			Rnext: "=", Seq: "ACGTA", Qual: "IIIII", Tags: "NM:i:0"},
+		&record.SamRecord{Qname: "r2", Rname: "2", Pos: 1, Cigar: "100000M",
+			Rnext: "1", Pnext: 5, Seq: long, Qual: "*"},
+		&flagged{SamRecord: record.SamRecord{Qname: "r3", Rname: "1", Pos: 1,
+			Cigar: "*", Rnext: "*", Seq: "*", Qual: "*"}, Strand: record.Reverse},
+	}
+	hdr := HeaderOpts{
+		Refs:   []record.Reference{{Chrom: "1", Length: 100}, {Chrom: "2", Length: 200000}},
+		Rename: htsdb.EnsemblToUCSC,
+	}
+	var buf bytes.Buffer
+	if err := WriteSAM(&buf, query.NewSliceSource(recs, nil), hdr); err != nil {
+		t.Fatal(err)
+	}
+	exp := "@HD\tVN:1.6\tSO:unknown\n" +
+		"@SQ\tSN:chr1\tLN:100\n" +
+		"@SQ\tSN:chr2\tLN:200000\n" +
+		"r1\t0\tchr1\t10\t30\t5M\t=\t0\t0\tACGTA\tIIIII\tNM:i:0\n" +
+		"r2\t0\tchr2\t1\t0\t100000M\tchr1\t5\t0\t" + long + "\t*\t\n" +
+		"r3\t16\tchr1\t1\t0\t*\t*\t0\t0\t*\t*\t\n"
+	if got := buf.String(); got != exp {
+		t.Errorf("unexpected SAM output:\n%.300s", got)
+	}
+}
+
+func TestWriteSAMErrors(t *testing.T) {
+	var buf bytes.Buffer
+	src := query.NewSliceSource([]interface{}{&record.Feature{}}, nil)
+	if err := WriteSAM(&buf, src, HeaderOpts{}); err == nil {
+		t.Error("expected error for record that is not a SAM record")
+	}
+	recs := []interface{}{&record.SamRecord{Qname: "r1"}}
+	if err := WriteSAM(failWriter{}, query.NewSliceSource(recs, nil), HeaderOpts{}); err == nil {
+		t.Error("expected write error")
+	}
+}
+
+// failWriter is an io.Writer that always fails.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestWriteSAMLongRecords(t *testing.T) {
+	seq := strings.Repeat("ACGT", 1<<19)
+	qual := strings.Repeat("I", len(seq))
+	recs := []interface{}{
+		&record.SamRecord{Qname: "long1", Rname: "1", Pos: 1, Cigar: "2097152M",
+			Rnext: "*", Seq: seq, Qual: qual},
+		&record.SamRecord{Qname: "long2", Rname: "1", Pos: 2, Cigar: "2097152M",
+			Rnext: "*", Seq: seq, Qual: qual, Tags: "NM:i:0"},
+	}
+	var buf bytes.Buffer
+	if err := WriteSAM(&buf, query.NewSliceSource(recs, nil), HeaderOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(recs) {
+		t.Fatalf("expected %d lines, actual %d", len(recs), len(lines))
+	}
+	for i, line := range lines {
+		r := recs[i].(*record.SamRecord)
+		fields := strings.Split(line, "\t")
+		if len(fields) != 12 || fields[0] != r.Qname || fields[9] != seq ||
+			fields[10] != qual || fields[11] != r.Tags {
+			t.Errorf("record %s was not written intact", r.Qname)
+		}
+	}
+}
+
+func TestHeaderText(t *testing.T) {
+	hdr := HeaderOpts{
+		Refs:       []record.Reference{{Chrom: "1", Length: 100}},
+		Coordinate: true,
+		ReadGroups: []ReadGroup{{ID: "liver", Fields: []string{"SM:liver", "PL:ILLUMINA"}}},
+		Program: &Program{ID: "htsdb-to-sam", Name: "htsdb-to-sam", Version: "1.0",
+			CommandLine: "htsdb-to-sam --where\tstrand = 1"},
+	}
+	exp := "@HD\tVN:1.6\tSO:coordinate\n" +
+		"@SQ\tSN:chr1\tLN:100\n" +
+		"@RG\tID:liver\tSM:liver\tPL:ILLUMINA\n" +
+		"@PG\tID:htsdb-to-sam\tPN:htsdb-to-sam\tVN:1.0\tCL:htsdb-to-sam --where strand = 1\n"
+	if got := headerText(hdr, htsdb.EnsemblToUCSC, true); got != exp {
+		t.Errorf("expected\n%s\nactual\n%s", exp, got)
+	}
+	if got := headerText(hdr, htsdb.EnsemblToUCSC, false); strings.Contains(got, "@SQ") {
+		t.Errorf("expected no @SQ lines, actual\n%s", got)
+	}
+	if got := headerText(HeaderOpts{}, htsdb.EnsemblToUCSC, true); got != "" {
+		t.Errorf("expected no header, actual\n%s", got)
+	}
+}
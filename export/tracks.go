@@ -0,0 +1,132 @@
+package export
+
+import (
+	"io"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/query"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// Writers of tracks, tables and plots.
+type (
+	Track          = htsdb.Track
+	Coverage       = htsdb.Coverage
+	TrackWriter    = htsdb.TrackWriter
+	CapWriter      = htsdb.CapWriter
+	BedGraphWriter = htsdb.BedGraphWriter
+	BinWriter      = htsdb.BinWriter
+	BigWigWriter   = htsdb.BigWigWriter
+	TSVWriter      = htsdb.TSVWriter
+	ResultWriter   = htsdb.ResultWriter
+	FloatFormat    = htsdb.FloatFormat
+	Bin            = htsdb.Bin
+)
+
+// BinAgg is the aggregation of the values of the bins of a BinWriter.
+type BinAgg = htsdb.BinAgg
+
+// Aggregations of the values of bins.
+const (
+	BinSum  = htsdb.BinSum
+	BinMean = htsdb.BinMean
+	BinMax  = htsdb.BinMax
+)
+
+// NewBedGraphWriter returns a new BedGraphWriter that writes to w.
+func NewBedGraphWriter(w io.Writer) *BedGraphWriter {
+	return htsdb.NewBedGraphWriter(w)
+}
+
+// NewBinWriter returns a new BinWriter that writes bins of size bases to w.
+// Output is fixedStep wiggle if wig is true and bedGraph otherwise.
+func NewBinWriter(w io.Writer, size int, agg BinAgg, wig bool) *BinWriter {
+	return htsdb.NewBinWriter(w, size, agg, wig)
+}
+
+// ParseBinAgg parses a bin aggregation function. Valid values are "sum",
+// "mean" and "max".
+func ParseBinAgg(s string) (BinAgg, error) {
+	return htsdb.ParseBinAgg(s)
+}
+
+// NewBigWigWriter returns a BigWigWriter that writes to w the values of the
+// references with the given lengths. The header is rewritten by Flush, so w
+// must be seekable e.g. an *os.File.
+func NewBigWigWriter(w io.WriteSeeker, lengths map[string]int) (*BigWigWriter, error) {
+	return htsdb.NewBigWigWriter(w, lengths)
+}
+
+// NewTSVWriter returns a TSVWriter that writes to w and prints NULL values as
+// NA.
+func NewTSVWriter(w io.Writer) *TSVWriter {
+	return htsdb.NewTSVWriter(w)
+}
+
+// NewResultWriter returns a ResultWriter that writes rows with keys and values
+// columns to w, in long format if long is true.
+func NewResultWriter(w io.Writer, keys, values []string, long bool) *ResultWriter {
+	return htsdb.NewResultWriter(w, keys, values, long)
+}
+
+// ParseFloatFormat returns the FloatFormat for notation "fixed", "sci" or
+// "auto" and precision. It is meant for the --notation and --precision flags
+// of the tools.
+func ParseFloatFormat(notation string, precision int) (FloatFormat, error) {
+	return htsdb.ParseFloatFormat(notation, precision)
+}
+
+// Notations returns the names of the notations accepted by ParseFloatFormat.
+func Notations() []string {
+	return append([]string(nil), htsdb.Notations...)
+}
+
+// WriteBedGraph writes the positions of t with non-zero values to w in
+// bedGraph format. Consecutive positions with equal values are merged into a
+// single interval.
+func WriteBedGraph(w io.Writer, rname string, t Track) error {
+	return htsdb.WriteBedGraph(w, rname, t)
+}
+
+// WriteChromSizes writes refs to w in the two column chrom.sizes format used
+// by the UCSC genome browser tools.
+func WriteChromSizes(w io.Writer, refs []record.Reference) error {
+	return htsdb.WriteChromSizes(w, refs)
+}
+
+// WriteKaryotype writes refs to w as a circos karyotype file. The label of a
+// reference is its name without a "chr" prefix. The color of a reference is
+// color or, if empty, its name so that the circos chromosome colors apply.
+func WriteKaryotype(w io.Writer, refs []record.Reference, color string) error {
+	return htsdb.WriteKaryotype(w, refs, color)
+}
+
+// SortedReferences returns the references of lens in natural order i.e. with
+// numbers in names compared by value so that chr2 comes before chr10.
+func SortedReferences(lens map[string]int) []record.Reference {
+	return htsdb.SortedReferences(lens)
+}
+
+// WriteCircosPlot writes bins of size bases to w as a circos plot data file
+// with one "chr start end value" line per bin. Coordinates are 0-based and
+// inclusive and values are formatted with f. The last bin of a reference is
+// clipped at its length in lens, if present.
+func WriteCircosPlot(w io.Writer, bins []Bin, size int, lens map[string]int, f FloatFormat) error {
+	return htsdb.WriteCircosPlot(w, bins, size, lens, f)
+}
+
+// WriteCircosRegions is like WriteCircosPlot for regions of arbitrary size,
+// e.g. Tiles, with values in the same order.
+func WriteCircosRegions(w io.Writer, regions []query.Region, values []float64, f FloatFormat) error {
+	return htsdb.WriteCircosRegions(w, regions, values, f)
+}
+
+// BinCountBuilder returns a squirrel select builder whose structure matches
+// that of Bin and that counts the records starting in each bin of size bases.
+// Records are weighted by their copy number if copies is true. c is the
+// coordinate convention of the database. Bins are sorted by reference and
+// index.
+func BinCountBuilder(c record.Coords, size int, copies bool) squirrel.SelectBuilder {
+	return htsdb.BinCountBuilder(c, size, copies)
+}
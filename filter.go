@@ -0,0 +1,206 @@
+package htsdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NotClause returns an SQL condition that is true for the records for which
+// clause is not true, including those for which clause is NULL e.g. because
+// of a NULL column. Plain NOT would exclude the latter from both a selection
+// and its inverse.
+func NotClause(clause string) string {
+	return "(" + clause + ") IS NOT TRUE"
+}
+
+// Filters maps names to SQL conditions so that they can be combined with
+// Expr.
+type Filters map[string]string
+
+// ParseFilters parses filter definitions of the form name=SQL. Names consist
+// of letters, digits, underscores and dashes and are case sensitive.
+func ParseFilters(defs []string) (Filters, error) {
+	fs := make(Filters)
+	for _, d := range defs {
+		kv := strings.SplitN(d, "=", 2)
+		if len(kv) != 2 || !isFilterName(kv[0]) || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("htsdb: invalid filter definition %q", d)
+		}
+		if isFilterKeyword(kv[0]) {
+			return nil, fmt.Errorf("htsdb: filter name %q is a keyword", kv[0])
+		}
+		if _, ok := fs[kv[0]]; ok {
+			return nil, fmt.Errorf("htsdb: filter %q defined twice", kv[0])
+		}
+		fs[kv[0]] = kv[1]
+	}
+	return fs, nil
+}
+
+// Expr returns the SQL condition of expr, an expression of filter names
+// combined with AND, OR, NOT and parentheses e.g. "unique AND NOT (rrna OR
+// trna)". Keywords are case insensitive, AND binds tighter than OR and NOT
+// is true when the negated filter is not true as for NotClause.
+func (fs Filters) Expr(expr string) (string, error) {
+	p := &filterParser{fs: fs, toks: tokenizeFilter(expr)}
+	if len(p.toks) == 0 {
+		return "", fmt.Errorf("htsdb: empty filter expression")
+	}
+	out, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	if err != nil {
+		return "", fmt.Errorf("htsdb: filter expression %q: %v", expr, err)
+	}
+	return out, nil
+}
+
+// CombineFilters returns the SQL condition of the records that match where,
+// do not match whereNot and match the expression expr of the named filters
+// defined in defs, as ParseFilters and Filters.Expr. Empty arguments are
+// ignored and where is returned unchanged if it is the only condition.
+func CombineFilters(where, whereNot string, defs []string, expr string) (string, error) {
+	fs, err := ParseFilters(defs)
+	if err != nil {
+		return "", err
+	}
+	if expr == "" && len(fs) > 0 {
+		return "", fmt.Errorf("htsdb: named filters without a filter expression")
+	}
+	if whereNot == "" && expr == "" {
+		return where, nil
+	}
+	var conds []string
+	if where != "" {
+		conds = append(conds, "("+where+")")
+	}
+	if whereNot != "" {
+		conds = append(conds, NotClause(whereNot))
+	}
+	if expr != "" {
+		e, err := fs.Expr(expr)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, "("+e+")")
+	}
+	return strings.Join(conds, " AND "), nil
+}
+
+// filterParser is a recursive descent parser of filter expressions.
+type filterParser struct {
+	fs   Filters
+	toks []string
+	pos  int
+}
+
+// peek returns the upper case next token or "" at the end.
+func (p *filterParser) peek() string {
+	if p.pos < len(p.toks) {
+		return strings.ToUpper(p.toks[p.pos])
+	}
+	return ""
+}
+
+func (p *filterParser) or() (string, error) {
+	return p.binary("OR", p.and)
+}
+
+func (p *filterParser) and() (string, error) {
+	return p.binary("AND", p.not)
+}
+
+// binary parses operands of next separated by the operator op.
+func (p *filterParser) binary(op string, next func() (string, error)) (string, error) {
+	out, err := next()
+	for err == nil && p.peek() == op {
+		p.pos++
+		var rhs string
+		if rhs, err = next(); err == nil {
+			out += " " + op + " " + rhs
+		}
+	}
+	return out, err
+}
+
+// not parses a negation, a parenthesized expression or a filter name. The
+// result is always parenthesized so that it can be an operand of IS.
+func (p *filterParser) not() (string, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return "", fmt.Errorf("unexpected end")
+	case "NOT":
+		p.pos++
+		f, err := p.not()
+		if err != nil {
+			return "", err
+		}
+		return "(" + f + " IS NOT TRUE)", nil
+	case "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return "", err
+		}
+		if p.peek() != ")" {
+			return "", fmt.Errorf("missing )")
+		}
+		p.pos++
+		return "(" + e + ")", nil
+	case ")", "AND", "OR":
+		return "", fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	name := p.toks[p.pos]
+	sql, ok := p.fs[name]
+	if !ok {
+		return "", fmt.Errorf("undefined filter %q", name)
+	}
+	p.pos++
+	return "(" + sql + ")", nil
+}
+
+// tokenizeFilter splits a filter expression into parentheses and words.
+func tokenizeFilter(expr string) []string {
+	var toks []string
+	start := -1
+	for i, c := range expr {
+		if c == '(' || c == ')' || c == ' ' || c == '\t' || c == '\n' {
+			if start >= 0 {
+				toks = append(toks, expr[start:i])
+				start = -1
+			}
+			if c == '(' || c == ')' {
+				toks = append(toks, string(c))
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, expr[start:])
+	}
+	return toks
+}
+
+// isFilterName returns true if s is a valid filter name.
+func isFilterName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(isAlpha(c) || c == '_' || c == '-' || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isFilterKeyword returns true if s is an operator of filter expressions.
+func isFilterKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "AND", "OR", "NOT":
+		return true
+	}
+	return false
+}
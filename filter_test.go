@@ -0,0 +1,70 @@
+package htsdb
+
+import "testing"
+
+func TestFiltersExpr(t *testing.T) {
+	fs, err := ParseFilters([]string{"uniq=mapq >= 10", "rrna=rname = 'rRNA'", "short=stop - start < 18"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Expr, Out string
+		OK        bool
+	}{
+		{"uniq", "(mapq >= 10)", true},
+		{"uniq and not rrna", "(mapq >= 10) AND ((rname = 'rRNA') IS NOT TRUE)", true},
+		{"uniq OR rrna AND short", "(mapq >= 10) OR (rname = 'rRNA') AND (stop - start < 18)", true},
+		{"NOT (rrna OR short)", "(((rname = 'rRNA') OR (stop - start < 18)) IS NOT TRUE)", true},
+		{"", "", false},
+		{"uniq AND", "", false},
+		{"(uniq", "", false},
+		{"uniq)", "", false},
+		{"uniq trna", "", false},
+		{"trna", "", false},
+	}
+	for _, tt := range tests {
+		out, err := fs.Expr(tt.Expr)
+		if (err == nil) != tt.OK {
+			t.Errorf("%q: expected ok %t, actual error %v", tt.Expr, tt.OK, err)
+			continue
+		}
+		if out != tt.Out {
+			t.Errorf("%q: expected %q, actual %q", tt.Expr, tt.Out, out)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	for _, defs := range [][]string{{"uniq"}, {"=mapq > 1"}, {"a b=mapq > 1"}, {"not=mapq > 1"},
+		{"a=mapq > 1", "a=mapq > 2"}, {"a= "}} {
+		if _, err := ParseFilters(defs); err == nil {
+			t.Errorf("%q: expected error", defs)
+		}
+	}
+}
+
+func TestCombineFilters(t *testing.T) {
+	tests := []struct {
+		Where, WhereNot string
+		Defs            []string
+		Expr, Out       string
+	}{
+		{"mapq > 1", "", nil, "", "mapq > 1"},
+		{"", "", nil, "", ""},
+		{"", "rname = 'chrM'", nil, "", "(rname = 'chrM') IS NOT TRUE"},
+		{"mapq > 1", "rname = 'chrM'", []string{"u=nh = 1"}, "u",
+			"(mapq > 1) AND (rname = 'chrM') IS NOT TRUE AND ((nh = 1))"},
+	}
+	for _, tt := range tests {
+		out, err := CombineFilters(tt.Where, tt.WhereNot, tt.Defs, tt.Expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tt.Out {
+			t.Errorf("expected %q, actual %q", tt.Out, out)
+		}
+	}
+	if _, err := CombineFilters("", "", []string{"u=nh = 1"}, ""); err == nil {
+		t.Error("expected error for named filters without expression")
+	}
+}
@@ -0,0 +1,50 @@
+package htsdb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FloatFormat formats the real numbers printed by the tools e.g. normalized
+// counts and statistics. Numbers always use '.' as the decimal separator,
+// regardless of locale, so that outputs can be read by downstream tools. The
+// zero value uses the fewest digits that represent each number exactly.
+type FloatFormat struct {
+	// Notation is 'f' for fixed point, 'e' for scientific notation and 'g'
+	// for scientific notation for large exponents and fixed point otherwise.
+	Notation byte
+	// Precision is the number of digits after the decimal point for 'f' and
+	// 'e' and the number of significant digits for 'g'. -1 uses the fewest
+	// digits that represent the number exactly.
+	Precision int
+}
+
+// Notations are the valid notations of ParseFloatFormat.
+var Notations = []string{"fixed", "sci", "auto"}
+
+// ParseFloatFormat returns the FloatFormat for notation "fixed", "sci" or
+// "auto" and precision. It is meant for the --notation and --precision flags
+// of the tools.
+func ParseFloatFormat(notation string, precision int) (FloatFormat, error) {
+	if precision < -1 {
+		return FloatFormat{}, fmt.Errorf("htsdb: invalid precision %d", precision)
+	}
+	switch notation {
+	case "fixed":
+		return FloatFormat{Notation: 'f', Precision: precision}, nil
+	case "sci":
+		return FloatFormat{Notation: 'e', Precision: precision}, nil
+	case "auto":
+		return FloatFormat{Notation: 'g', Precision: precision}, nil
+	}
+	return FloatFormat{}, fmt.Errorf("htsdb: invalid notation %q", notation)
+}
+
+// Format returns v formatted according to f. NaN and infinite values are
+// formatted as NaN, +Inf and -Inf.
+func (f FloatFormat) Format(v float64) string {
+	if f.Notation == 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, f.Notation, f.Precision, 64)
+}
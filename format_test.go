@@ -0,0 +1,41 @@
+package htsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloatFormat(t *testing.T) {
+	tests := []struct {
+		Notation  string
+		Precision int
+		In        float64
+		Out       string
+	}{
+		{"fixed", 4, 1.0 / 3, "0.3333"},
+		{"fixed", 0, 2.5e6, "2500000"},
+		{"fixed", -1, 0.125, "0.125"},
+		{"sci", 2, 12345, "1.23e+04"},
+		{"auto", 3, 0.00001234, "1.23e-05"},
+		{"auto", -1, 1234.5, "1234.5"},
+		{"fixed", 2, math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		f, err := ParseFloatFormat(tt.Notation, tt.Precision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Format(tt.In); got != tt.Out {
+			t.Errorf("%s/%d: expected %q, actual %q", tt.Notation, tt.Precision, tt.Out, got)
+		}
+	}
+	if got := (FloatFormat{}).Format(0.1); got != "0.1" {
+		t.Errorf("zero value: expected %q, actual %q", "0.1", got)
+	}
+	if _, err := ParseFloatFormat("engineering", 2); err == nil {
+		t.Error("expected error for invalid notation")
+	}
+	if _, err := ParseFloatFormat("fixed", -2); err == nil {
+		t.Error("expected error for invalid precision")
+	}
+}
@@ -0,0 +1,134 @@
+package htsdb
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ReferenceLengths returns the reference lengths in HtsdbCoords. Lengths are
+// read from the reference sequence table if present and estimated as the
+// largest stop of the records of table otherwise.
+func ReferenceLengths(db *sqlx.DB, table string, c Coords) (map[string]int, error) {
+	return ReferenceLengthsContext(context.Background(), db, table, c)
+}
+
+// ReferenceLengthsContext is like ReferenceLengths but the scan of table runs
+// with ctx.
+func ReferenceLengthsContext(ctx context.Context, db *sqlx.DB, table string, c Coords) (map[string]int, error) {
+	lens := make(map[string]int)
+	seqs, err := SelectRefSeqs(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range seqs {
+		lens[s.Name] = s.Length
+	}
+	if len(lens) > 0 {
+		return lens, nil
+	}
+	refs, err := SelectReferencesContext(ctx, db, ReferenceBuilder.From(table))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		_, stop := c.ToHtsdb(0, r.Length-1)
+		lens[r.Chrom] = stop + 1
+	}
+	return lens, nil
+}
+
+// Mappability holds the mappable regions of each reference, sorted and
+// merged. Positions outside them cannot be covered by uniquely aligned reads
+// and are excluded from the effective genome size.
+type Mappability map[string][]Region
+
+// NewMappability returns the Mappability made of regions.
+func NewMappability(regions []Region) Mappability {
+	m := make(Mappability)
+	for _, r := range MergeRegions(regions) {
+		m[r.Rname] = append(m[r.Rname], r)
+	}
+	return m
+}
+
+// ReadMappabilityFile reads the mappable regions from the BED or bedGraph file
+// f. The empty string returns an empty Mappability.
+func ReadMappabilityFile(f string) (Mappability, error) {
+	regions, err := ReadRegionsFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewMappability(regions), nil
+}
+
+// Size returns the number of mappable bases i.e. the effective genome size.
+func (m Mappability) Size() int {
+	size := 0
+	for _, regions := range m {
+		for _, r := range regions {
+			size += r.Stop - r.Start + 1
+		}
+	}
+	return size
+}
+
+// Bases returns the number of mappable bases within r.
+func (m Mappability) Bases(r Region) int {
+	regions := m[r.Rname]
+	i := sort.Search(len(regions), func(i int) bool { return regions[i].Stop >= r.Start })
+	n := 0
+	for ; i < len(regions) && regions[i].Start <= r.Stop; i++ {
+		start, stop := regions[i].Start, regions[i].Stop
+		if start < r.Start {
+			start = r.Start
+		}
+		if stop > r.Stop {
+			stop = r.Stop
+		}
+		n += stop - start + 1
+	}
+	return n
+}
+
+// RandomStart returns a random start for a window of width bases on reference
+// rname that lies entirely within a mappable region. All such windows are
+// equally likely. It returns false if no mappable region fits the window.
+func (m Mappability) RandomStart(rnd *rand.Rand, rname string, width int) (int, bool) {
+	total := 0
+	for _, r := range m[rname] {
+		if n := r.Stop - r.Start + 2 - width; n > 0 {
+			total += n
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	k := rnd.Intn(total)
+	for _, r := range m[rname] {
+		n := r.Stop - r.Start + 2 - width
+		if n <= 0 {
+			continue
+		}
+		if k < n {
+			return r.Start + k, true
+		}
+		k -= n
+	}
+	return 0, false
+}
+
+// EffectiveGenomeSize returns the number of mappable bases of m or, if m is
+// empty, the total length of the references in lens.
+func EffectiveGenomeSize(lens map[string]int, m Mappability) int {
+	if len(m) > 0 {
+		return m.Size()
+	}
+	size := 0
+	for _, l := range lens {
+		size += l
+	}
+	return size
+}
@@ -0,0 +1,57 @@
+package htsdb
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMappability(t *testing.T) {
+	m := NewMappability([]Region{{"chr1", 10, 19}, {"chr1", 15, 29},
+		{"chr1", 50, 54}, {"chr2", 0, 9}})
+	if got := m.Size(); got != 35 {
+		t.Errorf("Size: expected 35, actual %d", got)
+	}
+	tests := []struct {
+		r        Region
+		expected int
+	}{
+		{Region{"chr1", 0, 9}, 0},
+		{Region{"chr1", 0, 100}, 25},
+		{Region{"chr1", 25, 52}, 8},
+		{Region{"chr2", 5, 5}, 1},
+		{Region{"chr3", 0, 100}, 0},
+	}
+	for _, tt := range tests {
+		if got := m.Bases(tt.r); got != tt.expected {
+			t.Errorf("Bases(%v): expected %d, actual %d", tt.r, tt.expected, got)
+		}
+	}
+}
+
+func TestMappabilityRandomStart(t *testing.T) {
+	m := NewMappability([]Region{{"chr1", 10, 19}, {"chr1", 50, 54}})
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		s, ok := m.RandomStart(rnd, "chr1", 5)
+		if !ok {
+			t.Fatal("expected a window")
+		}
+		if !(s >= 10 && s+4 <= 19) && s != 50 {
+			t.Fatalf("window at %d is not mappable", s)
+		}
+	}
+	if _, ok := m.RandomStart(rnd, "chr1", 11); ok {
+		t.Error("expected no window wider than the mappable regions")
+	}
+}
+
+func TestEffectiveGenomeSize(t *testing.T) {
+	lens := map[string]int{"chr1": 100, "chr2": 50}
+	if got := EffectiveGenomeSize(lens, nil); got != 150 {
+		t.Errorf("expected 150, actual %d", got)
+	}
+	m := NewMappability([]Region{{"chr1", 0, 9}})
+	if got := EffectiveGenomeSize(lens, m); got != 10 {
+		t.Errorf("expected 10, actual %d", got)
+	}
+}
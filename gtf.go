@@ -0,0 +1,101 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/biogo/feat"
+)
+
+// GTFRecord is a line of a GTF file. Start and End follow HtsdbCoords.
+type GTFRecord struct {
+	Seqname    string
+	Source     string
+	Feature    string
+	Start, End int
+	Score      string
+	Strand     feat.Orientation
+	Frame      string
+	Attributes map[string]string
+}
+
+// Region returns the interval of g.
+func (g *GTFRecord) Region() Region {
+	return Region{Rname: g.Seqname, Start: g.Start, Stop: g.End}
+}
+
+// ReadGTF reads GTF records from r and calls fn for each one. Empty and
+// comment lines are ignored. Reading stops at the first error returned by fn.
+func ReadGTF(r io.Reader, fn func(*GTFRecord) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 8 {
+			return fmt.Errorf("htsdb: GTF line %d: expected at least 8 columns", line)
+		}
+		start, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("htsdb: GTF line %d: %v", line, err)
+		}
+		end, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return fmt.Errorf("htsdb: GTF line %d: %v", line, err)
+		}
+		g := &GTFRecord{Seqname: fields[0], Source: fields[1], Feature: fields[2],
+			Score: fields[5], Frame: fields[7], Attributes: make(map[string]string)}
+		g.Start, g.End = SAMCoords.ToHtsdb(start, end)
+		switch fields[6] {
+		case "+":
+			g.Strand = feat.Forward
+		case "-":
+			g.Strand = feat.Reverse
+		}
+		if len(fields) > 8 {
+			for _, attr := range strings.Split(fields[8], ";") {
+				attr = strings.TrimSpace(attr)
+				if attr == "" {
+					continue
+				}
+				kv := strings.SplitN(attr, " ", 2)
+				if len(kv) == 2 {
+					g.Attributes[kv[0]] = strings.Trim(kv[1], "\"")
+				}
+			}
+		}
+		if err = fn(g); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// ReadFeatureRegions reads the regions of the features in file f. GTF files
+// are recognised by the .gtf extension and all other files are read as BED.
+// For GTF files only features of type featType are read unless it is empty.
+func ReadFeatureRegions(f, featType string) ([]Region, error) {
+	if !strings.HasSuffix(f, ".gtf") {
+		return ReadRegionsFile(f)
+	}
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var regions []Region
+	err = ReadGTF(fh, func(g *GTFRecord) error {
+		if featType == "" || g.Feature == featType {
+			regions = append(regions, g.Region())
+		}
+		return nil
+	})
+	return regions, err
+}
@@ -0,0 +1,38 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/feat"
+)
+
+func TestReadGTF(t *testing.T) {
+	in := "#!genome-build GRCh38\n" +
+		"1\thavana\tgene\t11869\t14409\t.\t+\t.\tgene_id \"ENSG1\"; gene_name \"DDX11L1\";\n" +
+		"1\thavana\texon\t11869\t12227\t.\t-\t.\tgene_id \"ENSG1\"; exon_number \"1\";\n"
+	var recs []*GTFRecord
+	err := ReadGTF(strings.NewReader(in), func(g *GTFRecord) error {
+		recs = append(recs, g)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	g := recs[0]
+	if g.Region() != (Region{"1", 11868, 14408}) || g.Strand != feat.Forward ||
+		g.Feature != "gene" || g.Attributes["gene_name"] != "DDX11L1" {
+		t.Errorf("wrong record: %+v", g)
+	}
+	if recs[1].Strand != feat.Reverse || recs[1].Attributes["exon_number"] != "1" {
+		t.Errorf("wrong record: %+v", recs[1])
+	}
+
+	err = ReadGTF(strings.NewReader("1\thavana\tgene\n"), func(*GTFRecord) error { return nil })
+	if err == nil {
+		t.Error("expected error for short line")
+	}
+}
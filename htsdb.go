@@ -18,37 +18,88 @@
 // if r.Error() !=  nil {
 // 	panic(r.Error)
 // }
+//
+// Besides the reader, the package holds the implementation shared by the htsdb
+// tools and its API follows their needs. Packages record, query, analysis,
+// importer and export select the part of it that library users can depend on,
+// grouped by purpose, and keep it compatible across minor versions of module
+// github.com/mnsmar/htsdb/v2.
 package htsdb
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/jmoiron/sqlx"
 )
 
 // Reader encapsulates a connection to a database and acts as an iterator for
-// the records. Internally the reader maps each database row to dest.
+// the records. Internally the reader maps each database row to dest. If dest
+// implements Normalizer e.g. embeds Range, its coordinates are converted to
+// HtsdbCoords from the convention stored in the database so that End, Head
+// and Tail return correct positions. If dest implements Stranded, records
+// whose strand is not 1 or -1 are handled according to the strand policy of
+// the reader, StrandUnknown by default.
 type Reader struct {
-	db    *sqlx.DB
-	dest  interface{}
-	query string
-	rows  *sqlx.Rows
-	err   error
+	ctx     context.Context
+	db      *sqlx.DB
+	dest    interface{}
+	query   string
+	rows    *sqlx.Rows
+	err     error
+	coords  Coords
+	policy  StrandPolicy
+	skipped int
 }
 
 // NewReader returns a new reader that reads from db by runs the given query
 // and maps rows into dest.
 func NewReader(db *sql.DB, driverName string, dest interface{}, query string,
 ) (*Reader, error) {
+	return NewReaderContext(context.Background(), db, driverName, dest, query)
+}
+
+// NewReaderContext is like NewReader but the query runs with ctx. Once ctx
+// is cancelled or its deadline passes, Next returns false and Error returns
+// the error of ctx.
+func NewReaderContext(ctx context.Context, db *sql.DB, driverName string,
+	dest interface{}, query string) (*Reader, error) {
 
 	sqlxDB := sqlx.NewDb(db, driverName)
 
-	rows, err := sqlxDB.Queryx(query)
+	coords, err := SelectCoordsContext(ctx, sqlxDB)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sqlxDB.QueryxContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Reader{db: sqlxDB, dest: dest, query: query, rows: rows}, nil
+	return &Reader{ctx: ctx, db: sqlxDB, dest: dest, query: query, rows: rows,
+		coords: coords}, nil
+}
+
+// OpenReader is like NewReader but connects to the database dsn with driver,
+// one of Drivers, as Connect does. Closing the reader closes the connection.
+func OpenReader(driver, dsn string, dest interface{}, query string) (*Reader, error) {
+	db, err := Connect(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	coords, err := SelectCoords(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	rows, err := db.Queryx(query)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Reader{ctx: context.Background(), db: db, dest: dest, query: query,
+		rows: rows, coords: coords}, nil
 }
 
 // Next advances the iterator past the next record, which will then be
@@ -60,13 +111,52 @@ func (r *Reader) Next() bool {
 	if r.err != nil {
 		return false
 	}
-	ok := r.rows.Next()
-	if !ok {
-		r.err = r.rows.Err()
-		return false
+	for {
+		if r.err = r.ctx.Err(); r.err != nil {
+			return false
+		}
+		ok := r.rows.Next()
+		if !ok {
+			r.err = r.rows.Err()
+			return false
+		}
+		if r.err = r.rows.StructScan(r.dest); r.err != nil {
+			return false
+		}
+		if n, ok := r.dest.(Normalizer); ok && r.coords != HtsdbCoords {
+			n.Normalize(r.coords)
+		}
+		s, ok := r.dest.(Stranded)
+		if !ok {
+			return true
+		}
+		keep, err := r.policy.Check(s)
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if keep {
+			return true
+		}
+		r.skipped++
 	}
-	r.err = r.rows.StructScan(r.dest)
-	return r.err == nil
+}
+
+// SetCoords sets the coordinate convention of the records read by r, which is
+// otherwise read from the metadata of the database e.g. HtsdbCoords for
+// queries whose coordinates are already converted.
+func (r *Reader) SetCoords(c Coords) {
+	r.coords = c
+}
+
+// SetStrandPolicy sets the policy for records whose strand is not 1 or -1.
+func (r *Reader) SetStrandPolicy(p StrandPolicy) {
+	r.policy = p
+}
+
+// Skipped returns the number of records skipped because of their strand.
+func (r *Reader) Skipped() int {
+	return r.skipped
 }
 
 // Error returns the error that was encountered by the iterator.
@@ -77,6 +167,27 @@ func (r *Reader) Error() error {
 // Record returns the most recent record read by a call to Next.
 func (r *Reader) Record() interface{} { return r.dest }
 
+// Err returns the error that was encountered by the iterator. It is the same
+// as Error.
+func (r *Reader) Err() error { return r.err }
+
+// References returns the references of the records selected by the query of
+// r. The query must select the rname and stop columns; the length of a
+// reference is estimated from the largest stop on it.
+func (r *Reader) References() ([]Reference, error) {
+	refs := []Reference{}
+	err := r.db.Select(&refs, "SELECT rname, MAX(stop)+1 AS length FROM ("+
+		r.query+") AS q GROUP BY rname")
+	if err != nil {
+		return refs, err
+	}
+	for i := range refs {
+		_, last := r.coords.ToHtsdb(0, refs[i].Length-1)
+		refs[i].Length = last + 1
+	}
+	return refs, nil
+}
+
 // Close closes the database connection.
 func (r *Reader) Close() {
 	r.db.Close()
@@ -1,10 +1,14 @@
 package htsdb
 
 import (
+	"context"
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
 	"strings"
 	"testing"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
 )
 
 type Record struct {
@@ -113,3 +117,70 @@ func TestReader(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderContext(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("INSERT INTO foo(start, stop) VALUES(1, 2)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := NewReaderContext(ctx, db, "sqlite3", &Record{}, "SELECT * FROM foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !r.Next() {
+		t.Fatal(r.Error())
+	}
+	cancel()
+	if r.Next() {
+		t.Error("expected Next to stop after cancel")
+	}
+	if r.Error() != context.Canceled {
+		t.Errorf("expected %v, actual %v", context.Canceled, r.Error())
+	}
+
+	if _, err = NewReaderContext(ctx, db, "sqlite3", &Record{}, "SELECT * FROM foo"); err != context.Canceled {
+		t.Errorf("expected %v, actual %v", context.Canceled, err)
+	}
+}
+
+func TestReaderCoords(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO foo(start, stop) VALUES(10, 20)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCoords(sqlx.NewDb(db, "sqlite3"), BEDCoords); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(db, "sqlite3", &Range{}, "SELECT start, stop FROM foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Next() {
+		t.Fatal(r.Error())
+	}
+	rec := r.Record().(*Range)
+	if rec.Start() != 10 || rec.End() != 20 || Head(rec, feat.Reverse) != 19 {
+		t.Errorf("expected BED record 10-20 with reverse head 19, actual %d-%d",
+			rec.Start(), rec.End())
+	}
+	for r.Next() {
+	}
+
+	r, err = NewReader(db, "sqlite3", &Range{}, "SELECT start, stop FROM foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetCoords(HtsdbCoords)
+	if !r.Next() || r.Record().(*Range).StopPos != 20 {
+		t.Errorf("expected unconverted stop 20, actual %v", r.Record())
+	}
+}
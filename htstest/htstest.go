@@ -0,0 +1,243 @@
+// Package htstest generates small deterministic databases of htsdb records
+// with known distributions for the tests of the htsdb packages and commands,
+// and provides assertions for the accumulators of package aggregate.
+//
+// e.g.
+// db, recs, cleanup := htstest.NewDB(t, htstest.DefaultOptions)
+// defer cleanup()
+// // run the analysis on db and compare with the distribution of recs.
+// htstest.EqualHistograms(t, got, htstest.LengthHistogram(recs, true))
+package htstest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/aggregate"
+)
+
+// MaxRecords is the largest number of records of a fixture. It keeps the time
+// and memory of tests that use fixtures bounded.
+const MaxRecords = 100000
+
+// Options control the records of a generated fixture. Fixtures generated with
+// equal options are identical.
+type Options struct {
+	// Seed is the seed of the random generator.
+	Seed int64
+	// Records is the number of records, at most MaxRecords.
+	Records int
+	// Refs are the references on which records align.
+	Refs []htsdb.Reference
+	// MinLen and MaxLen are the range of read lengths.
+	MinLen, MaxLen int
+	// MaxCopyNumber is the largest copy number; copy numbers are uniform in
+	// 1 to MaxCopyNumber.
+	MaxCopyNumber int
+}
+
+// DefaultOptions generate a thousand short reads on two references.
+var DefaultOptions = Options{
+	Seed:          1,
+	Records:       1000,
+	Refs:          []htsdb.Reference{{Chrom: "chr1", Length: 5000}, {Chrom: "chr2", Length: 2000}},
+	MinLen:        18,
+	MaxLen:        30,
+	MaxCopyNumber: 5,
+}
+
+// Record is a generated record. Start and Stop follow htsdb.HtsdbCoords and
+// Pos is the corresponding 1-based SAM position.
+type Record struct {
+	Qname      string
+	Flag       int
+	Rname      string
+	Pos        int
+	Strand     int
+	Start      int
+	Stop       int
+	CopyNumber int
+	Cigar      string
+	Seq        string
+}
+
+// Columns are the columns of the tables created by Write, in the order of the
+// Record fields.
+var Columns = []string{"qname", "flag", "rname", "pos", "strand", "start",
+	"stop", "copy_number", "cigar", "seq"}
+
+// Generate returns the records described by opts sorted by reference and
+// start. Reads align without gaps, uniformly on the references and strands.
+func Generate(opts Options) ([]Record, error) {
+	if opts.Records < 0 || opts.Records > MaxRecords {
+		return nil, fmt.Errorf("htstest: number of records must be 0 to %d", MaxRecords)
+	}
+	if len(opts.Refs) == 0 || opts.MinLen < 1 || opts.MaxLen < opts.MinLen ||
+		opts.MaxCopyNumber < 1 {
+		return nil, fmt.Errorf("htstest: invalid options %+v", opts)
+	}
+	for _, ref := range opts.Refs {
+		if ref.Length < opts.MaxLen {
+			return nil, fmt.Errorf("htstest: reference %s is shorter than reads", ref.Chrom)
+		}
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	recs := make([]Record, opts.Records)
+	for i := range recs {
+		ref := opts.Refs[rnd.Intn(len(opts.Refs))]
+		l := opts.MinLen + rnd.Intn(opts.MaxLen-opts.MinLen+1)
+		start := rnd.Intn(ref.Length - l + 1)
+		r := Record{
+			Qname:      fmt.Sprintf("r%06d", i),
+			Rname:      ref.Chrom,
+			Pos:        start + 1,
+			Strand:     1,
+			Start:      start,
+			Stop:       start + l - 1,
+			CopyNumber: 1 + rnd.Intn(opts.MaxCopyNumber),
+			Cigar:      strconv.Itoa(l) + "M",
+			Seq:        randomSeq(rnd, l),
+		}
+		if rnd.Intn(2) == 1 {
+			r.Strand, r.Flag = -1, 16
+		}
+		recs[i] = r
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Rname != recs[j].Rname {
+			return recs[i].Rname < recs[j].Rname
+		}
+		return recs[i].Start < recs[j].Start
+	})
+	return recs, nil
+}
+
+// randomSeq returns a random DNA sequence of length l.
+func randomSeq(rnd *rand.Rand, l int) string {
+	seq := make([]byte, l)
+	for i := range seq {
+		seq[i] = "ACGT"[rnd.Intn(4)]
+	}
+	return string(seq)
+}
+
+// Write creates table in db with Columns and loads recs into it. The
+// coordinate convention of db is set to htsdb.HtsdbCoords.
+func Write(db *sqlx.DB, table string, recs []Record) error {
+	_, err := db.Exec("CREATE TABLE " + table + " (qname TEXT, flag INTEGER," +
+		" rname TEXT, pos INTEGER, strand INTEGER, start INTEGER, stop INTEGER," +
+		" copy_number INTEGER, cigar TEXT, seq TEXT)")
+	if err != nil {
+		return err
+	}
+	if err = htsdb.SetCoords(db, htsdb.HtsdbCoords); err != nil {
+		return err
+	}
+	l, err := htsdb.NewLoader(db, table, Columns, htsdb.DefaultLoadOptions)
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		err = l.Add(r.Qname, r.Flag, r.Rname, r.Pos, r.Strand, r.Start, r.Stop,
+			r.CopyNumber, r.Cigar, r.Seq)
+		if err != nil {
+			l.Close()
+			return err
+		}
+	}
+	return l.Close()
+}
+
+// NewDB returns a database in a new temporary directory with table sample
+// holding the records generated for opts, the records and a function that
+// closes the database and removes the directory. It fails tb on error.
+func NewDB(tb testing.TB, opts Options) (*sqlx.DB, []Record, func()) {
+	tb.Helper()
+	recs, err := Generate(opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "htstest")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "fixture.db"))
+	if err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+	cleanup = func() { db.Close(); os.RemoveAll(dir) }
+	if err = Write(db, "sample", recs); err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+	return db, recs, cleanup
+}
+
+// LengthHistogram returns the histogram of the read lengths of recs. Each
+// record counts by its copy number if copies is true and once otherwise.
+func LengthHistogram(recs []Record, copies bool) aggregate.Histogram {
+	h := aggregate.NewHistogram()
+	for _, r := range recs {
+		h.Add(r.Stop-r.Start+1, weight(r, copies))
+	}
+	return h
+}
+
+// PosHistogram returns the histogram of the 0-based positions of recs on
+// reference rname and strand at anchor. Records of unknown strand have no
+// anchor and are not counted. Each record counts by its copy number
+// if copies is true and once otherwise.
+func PosHistogram(recs []Record, rname string, strand int, anchor htsdb.Anchor, copies bool) aggregate.Histogram {
+	h := aggregate.NewHistogram()
+	for _, r := range recs {
+		if r.Rname != rname || r.Strand != strand {
+			continue
+		}
+		rng := &htsdb.Range{StartPos: r.Start, StopPos: r.Stop}
+		if pos, ok := htsdb.PosAtOK(rng, htsdb.Orientation(r.Strand).Feat(), anchor, 0); ok {
+			h.Add(pos, weight(r, copies))
+		}
+	}
+	return h
+}
+
+// weight returns the count of r.
+func weight(r Record, copies bool) uint {
+	if copies {
+		return uint(r.CopyNumber)
+	}
+	return 1
+}
+
+// EqualHistograms reports an error to tb for each value whose count differs
+// between got and want. Values with zero counts are ignored.
+func EqualHistograms(tb testing.TB, got, want aggregate.Histogram) {
+	tb.Helper()
+	keys := make(map[int]bool)
+	for _, k := range got.Keys() {
+		keys[k] = true
+	}
+	for _, k := range want.Keys() {
+		keys[k] = true
+	}
+	sorted := make([]int, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Ints(sorted)
+	for _, k := range sorted {
+		if got[k] != want[k] {
+			tb.Errorf("histogram value %d: expected count %d, actual %d", k, want[k], got[k])
+		}
+	}
+}
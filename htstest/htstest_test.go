@@ -0,0 +1,103 @@
+package htstest
+
+import (
+	"reflect"
+	"testing"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/aggregate"
+)
+
+func TestGenerate(t *testing.T) {
+	a, err := Generate(DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate(DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != DefaultOptions.Records || !reflect.DeepEqual(a, b) {
+		t.Fatal("expected identical fixtures for equal options")
+	}
+	for _, r := range a {
+		l := r.Stop - r.Start + 1
+		if l < DefaultOptions.MinLen || l > DefaultOptions.MaxLen || len(r.Seq) != l {
+			t.Errorf("%s: unexpected length %d", r.Qname, l)
+		}
+		if r.Pos != r.Start+1 || (r.Strand == -1) != (r.Flag == 16) {
+			t.Errorf("%s: inconsistent record %+v", r.Qname, r)
+		}
+	}
+	opts := DefaultOptions
+	opts.Seed = 2
+	if c, _ := Generate(opts); reflect.DeepEqual(a, c) {
+		t.Error("expected different fixtures for different seeds")
+	}
+	opts.Records = MaxRecords + 1
+	if _, err := Generate(opts); err == nil {
+		t.Error("expected error for too many records")
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	recs := []Record{
+		{Rname: "chr1", Strand: 1, Start: 10, Stop: 19, CopyNumber: 2},
+		{Rname: "chr1", Strand: -1, Start: 10, Stop: 14, CopyNumber: 3},
+		{Rname: "chr1", Strand: 1, Start: 10, Stop: 14, CopyNumber: 1},
+	}
+	EqualHistograms(t, LengthHistogram(recs, true), aggregate.Histogram{10: 2, 5: 4})
+	EqualHistograms(t, LengthHistogram(recs, false), aggregate.Histogram{10: 1, 5: 2})
+	EqualHistograms(t, PosHistogram(recs, "chr1", 1, htsdb.AnchorTail, true),
+		aggregate.Histogram{19: 2, 14: 1})
+	EqualHistograms(t, PosHistogram(recs, "chr1", -1, htsdb.AnchorHead, false),
+		aggregate.Histogram{14: 1})
+}
+
+// recorder is a testing.TB that records errors instead of failing.
+type recorder struct {
+	testing.TB
+	errors int
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) { r.errors++ }
+
+func TestEqualHistograms(t *testing.T) {
+	r := &recorder{TB: t}
+	EqualHistograms(r, aggregate.Histogram{1: 2, 3: 0}, aggregate.Histogram{1: 2})
+	if r.errors != 0 {
+		t.Errorf("expected equal histograms, got %d errors", r.errors)
+	}
+	EqualHistograms(r, aggregate.Histogram{1: 2, 2: 1}, aggregate.Histogram{1: 3, 4: 1})
+	if r.errors != 3 {
+		t.Errorf("expected 3 errors, actual %d", r.errors)
+	}
+}
+
+func TestNewDB(t *testing.T) {
+	db, recs, cleanup := NewDB(t, DefaultOptions)
+	defer cleanup()
+
+	var got []struct {
+		Len    int `db:"len"`
+		Copies int `db:"copies"`
+	}
+	err := db.Select(&got, "SELECT stop - start + 1 AS len, SUM(copy_number) AS copies"+
+		" FROM sample GROUP BY len")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := aggregate.NewHistogram()
+	for _, g := range got {
+		h.Add(g.Len, uint(g.Copies))
+	}
+	EqualHistograms(t, h, LengthHistogram(recs, true))
+	coords, err := htsdb.SelectCoords(db)
+	if err != nil || coords != htsdb.HtsdbCoords {
+		t.Errorf("unexpected coordinates: %v, %v", coords, err)
+	}
+}
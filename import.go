@@ -0,0 +1,154 @@
+package htsdb
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/biogo/hts/sam"
+	"github.com/jmoiron/sqlx"
+)
+
+// ImportColumns are the columns of the tables created by CreateImportTable:
+// the SAM fields followed by the canonical htsdb columns.
+var ImportColumns = append(append([]string(nil), samColumns...),
+	"start", "stop", "strand", "copy_number")
+
+// CreateImportTable creates table in db with ImportColumns. It fails if the
+// table exists.
+func CreateImportTable(db *sqlx.DB, table string) error {
+	_, err := db.Exec("CREATE TABLE " + table + " (qname TEXT, flag INTEGER," +
+		" rname TEXT, pos INTEGER, mapq INTEGER, cigar TEXT, rnext TEXT," +
+		" pnext INTEGER, tlen INTEGER, seq TEXT, qual TEXT, tags TEXT," +
+		" start INTEGER, stop INTEGER, strand INTEGER, copy_number INTEGER)")
+	return err
+}
+
+// ImportRecord is a row of the tables created by CreateImportTable. The
+// columns of a Writer of ImportRecord are ImportColumns.
+type ImportRecord struct {
+	SamRecord
+	Start      int `db:"start"`
+	Stop       int `db:"stop"`
+	Strand     int `db:"strand"`
+	CopyNumber int `db:"copy_number"`
+}
+
+// NewImportRecord returns the ImportRecord of the mapped alignment rec with
+// start and stop in c.
+func NewImportRecord(rec *sam.Record, c Coords) ImportRecord {
+	str := func(col string) string { return bamValue(rec, col).(string) }
+	num := func(col string) int { return int(bamValue(rec, col).(int64)) }
+	start, stop := c.FromHtsdb(rec.Pos, rec.End()-1)
+	return ImportRecord{
+		SamRecord: SamRecord{Qname: str("qname"), Flag: num("flag"),
+			Rname: str("rname"), Pos: num("pos"), Mapq: num("mapq"),
+			Cigar: str("cigar"), Rnext: str("rnext"), Pnext: num("pnext"),
+			Tlen: num("tlen"), Seq: str("seq"), Qual: str("qual"), Tags: str("tags")},
+		Start: start, Stop: stop, Strand: num("strand"), CopyNumber: 1,
+	}
+}
+
+// Normalize converts the start and stop of r in place from c to HtsdbCoords.
+func (r *ImportRecord) Normalize(c Coords) { r.Start, r.Stop = c.ToHtsdb(r.Start, r.Stop) }
+
+// AnnotatedImportRecord is an ImportRecord with the sequence annotations of
+// htsdb-annotate-seq. The annotations are NULL for records without sequence.
+// The columns of a Writer of AnnotatedImportRecord are ImportColumns followed
+// by SeqAnnotationColumns.
+type AnnotatedImportRecord struct {
+	ImportRecord
+	GC             *float64 `db:"gc"`
+	MaxHomopolymer *int     `db:"max_homopolymer"`
+	Dust           *float64 `db:"dust"`
+}
+
+// SeqAnnotationColumns are the columns of the sequence annotations of
+// AnnotatedImportRecord.
+var SeqAnnotationColumns = []string{GCColumn, HomopolymerColumn, DustColumn}
+
+// Annotated returns r annotated with the GCFraction, MaxHomopolymer and
+// DustScore of its sequence.
+func (r ImportRecord) Annotated() AnnotatedImportRecord {
+	a := AnnotatedImportRecord{ImportRecord: r}
+	if r.Seq != "" && r.Seq != "*" {
+		gc, homo, dust := GCFraction(r.Seq), MaxHomopolymer(r.Seq), DustScore(r.Seq)
+		a.GC, a.MaxHomopolymer, a.Dust = &gc, &homo, &dust
+	}
+	return a
+}
+
+// AddSeqAnnotationColumns adds SeqAnnotationColumns to a table created by
+// CreateImportTable so that it can be written by a Writer of
+// AnnotatedImportRecord.
+func AddSeqAnnotationColumns(db *sqlx.DB, table string) error {
+	types := []string{"REAL", "INTEGER", "REAL"}
+	for i, col := range SeqAnnotationColumns {
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + col + " " + types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SAMSource is implemented by the SAM and BAM readers of biogo/hts.
+type SAMSource interface {
+	Read() (*sam.Record, error)
+}
+
+// ImportAlignments writes the mapped alignments read from r to w, a Writer of
+// ImportRecord or AnnotatedImportRecord, which converts their start and stop
+// to the convention of the database. It returns the number of imported and of
+// skipped unmapped alignments.
+func ImportAlignments(w *Writer, r SAMSource) (imported, unmapped int, err error) {
+	return ImportAlignmentsContext(context.Background(), w, r)
+}
+
+// ImportAlignmentsContext is like ImportAlignments but stops with the error
+// of ctx once ctx is cancelled. The alignments written until then are left in
+// w.
+func ImportAlignmentsContext(ctx context.Context, w *Writer, r SAMSource) (imported, unmapped int, err error) {
+	annotate := w.typ == reflect.TypeOf(AnnotatedImportRecord{})
+	for {
+		if err := ctx.Err(); err != nil {
+			return imported, unmapped, err
+		}
+		rec, err := r.Read()
+		if err == io.EOF {
+			return imported, unmapped, nil
+		}
+		if err != nil {
+			return imported, unmapped, err
+		}
+		if rec.Flags&sam.Unmapped != 0 || rec.Ref == nil {
+			unmapped++
+			continue
+		}
+		var v interface{} = NewImportRecord(rec, HtsdbCoords)
+		if annotate {
+			v = v.(ImportRecord).Annotated()
+		}
+		if err = w.Write(v); err != nil {
+			return imported, unmapped, err
+		}
+		imported++
+	}
+}
+
+// HeaderRefSeqs returns the references of a SAM header as rows of the
+// reference sequence table. The checksum is taken from the M5 tag if present.
+func HeaderRefSeqs(h *sam.Header) ([]RefSeq, error) {
+	seqs := make([]RefSeq, len(h.Refs()))
+	for i, ref := range h.Refs() {
+		if ref.Len() < 0 {
+			return nil, fmt.Errorf("htsdb: reference %s has no length", ref.Name())
+		}
+		seqs[i] = RefSeq{Name: ref.Name(), Length: ref.Len()}
+		if md5 := ref.MD5(); md5 != nil {
+			seqs[i].MD5 = hex.EncodeToString(md5)
+		}
+	}
+	return seqs, nil
+}
@@ -0,0 +1,146 @@
+package htsdb
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+// samSlice is a SAMSource that reads records from a slice.
+type samSlice []*sam.Record
+
+func (s *samSlice) Read() (*sam.Record, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	rec := (*s)[0]
+	*s = (*s)[1:]
+	return rec, nil
+}
+
+func TestImportAlignments(t *testing.T) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	mapped, err := sam.NewRecord("r1", ref, nil, 9, -1, 0, 30, cigar,
+		[]byte("ACGT"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped.Flags = sam.Reverse
+	unmapped, err := sam.NewRecord("r2", nil, nil, -1, -1, 0, 0, nil,
+		[]byte("ACGT"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmapped.Flags = sam.Unmapped
+
+	rec := NewImportRecord(mapped, BEDCoords)
+	expected := ImportRecord{
+		SamRecord: SamRecord{Qname: "r1", Flag: 16, Rname: "chr1", Pos: 10,
+			Mapq: 30, Cigar: "4M", Rnext: "*", Seq: "ACGT", Qual: rec.Qual, Tags: rec.Tags},
+		Start: 9, Stop: 13, Strand: -1, CopyNumber: 1,
+	}
+	if rec != expected {
+		t.Errorf("expected %+v, actual %+v", expected, rec)
+	}
+
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	if err = CreateImportTable(db, "sample"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(db, "sample", ImportRecord{}, DefaultLoadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w.Columns(), ImportColumns) {
+		t.Errorf("expected columns %v, actual %v", ImportColumns, w.Columns())
+	}
+	src := samSlice{mapped, unmapped}
+	n, skipped, err := ImportAlignments(w, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || skipped != 1 {
+		t.Errorf("expected 1 imported and 1 unmapped, actual %d and %d", n, skipped)
+	}
+	var stop int
+	if err = db.Get(&stop, "SELECT stop FROM sample"); err != nil || stop != 12 {
+		t.Errorf("expected stop 12, actual %d, %v", stop, err)
+	}
+}
+
+func TestImportAnnotatedAlignments(t *testing.T) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	withSeq, err := sam.NewRecord("r1", ref, nil, 9, -1, 0, 30, cigar,
+		[]byte("AAGC"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSeq, err := sam.NewRecord("r2", ref, nil, 19, -1, 0, 30, cigar,
+		[]byte("ACGT"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSeq.Seq = sam.Seq{}
+
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	if err = CreateImportTable(db, "sample"); err != nil {
+		t.Fatal(err)
+	}
+	if err = AddSeqAnnotationColumns(db, "sample"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(db, "sample", AnnotatedImportRecord{}, DefaultLoadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expCols := append(append([]string(nil), ImportColumns...), SeqAnnotationColumns...)
+	if !reflect.DeepEqual(w.Columns(), expCols) {
+		t.Errorf("expected columns %v, actual %v", expCols, w.Columns())
+	}
+	src := samSlice{withSeq, noSeq}
+	if _, _, err = ImportAlignments(w, &src); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []struct {
+		GC   *float64 `db:"gc"`
+		Homo *int     `db:"max_homopolymer"`
+		Dust *float64 `db:"dust"`
+	}
+	if err = db.Select(&rows, "SELECT gc, max_homopolymer, dust FROM sample ORDER BY qname"); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 records, actual %d", len(rows))
+	}
+	if r := rows[0]; r.GC == nil || *r.GC != 0.5 || r.Homo == nil || *r.Homo != 2 || r.Dust == nil {
+		t.Errorf("expected gc 0.5 and max_homopolymer 2, actual %v and %v", r.GC, r.Homo)
+	}
+	if r := rows[1]; r.GC != nil || r.Homo != nil || r.Dust != nil {
+		t.Errorf("expected NULL annotations without sequence, actual %v", r)
+	}
+}
@@ -0,0 +1,269 @@
+// Package importer exports the loading of records into htsdb databases: the
+// writers of record structs, the import of SAM, BAM, BED and chimeric
+// alignments and the creation of the tables, metadata and references of a
+// database. It is part of the stable API of htsdb together with packages
+// record, query, analysis and export: its types and constants are aliases of
+// those of package htsdb and its functions call those of package htsdb. They
+// are kept compatible across minor versions of module
+// github.com/mnsmar/htsdb/v2. It is named importer because import is a Go
+// keyword.
+package importer
+
+import (
+	"context"
+	"io"
+
+	"github.com/biogo/hts/sam"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// Writers of records.
+type (
+	Writer      = htsdb.Writer
+	Loader      = htsdb.Loader
+	LoadOptions = htsdb.LoadOptions
+)
+
+// DefaultLoadOptions returns the options of the htsdb import tools.
+func DefaultLoadOptions() LoadOptions {
+	return htsdb.DefaultLoadOptions
+}
+
+// NewWriter returns a Writer that inserts records of the struct type of rec,
+// a struct or a pointer to one, into table in db.
+func NewWriter(db *sqlx.DB, table string, rec interface{}, opts LoadOptions) (*Writer, error) {
+	return htsdb.NewWriter(db, table, rec, opts)
+}
+
+// NewLoader returns a Loader that inserts rows into cols of table in db.
+func NewLoader(db *sqlx.DB, table string, cols []string, opts LoadOptions) (*Loader, error) {
+	return htsdb.NewLoader(db, table, cols, opts)
+}
+
+// BulkInsert inserts records, a slice of structs or of pointers to structs,
+// into table of db in transactions of batchSize rows and returns the number of
+// inserted rows. Fields are mapped to columns as by Writer. If batchSize is
+// not positive, all records are inserted in a single transaction. On error
+// the transaction of the failed record is rolled back and only the rows of
+// the transactions committed before it are kept and counted.
+func BulkInsert(db *sqlx.DB, table string, records interface{}, batchSize int) (int64, error) {
+	return htsdb.BulkInsert(db, table, records, batchSize)
+}
+
+// BulkInsertOptions is like BulkInsert but loads with opts e.g. to disable
+// synchronous writes during the load with LoadOptions.Unsafe.
+func BulkInsertOptions(db *sqlx.DB, table string, records interface{}, opts LoadOptions) (int64, error) {
+	return htsdb.BulkInsertOptions(db, table, records, opts)
+}
+
+// Imported records and their sources.
+type (
+	ImportRecord          = htsdb.ImportRecord
+	AnnotatedImportRecord = htsdb.AnnotatedImportRecord
+	SAMSource             = htsdb.SAMSource
+	BEDRecord             = htsdb.BEDRecord
+)
+
+// ImportColumns returns the columns of the tables created by
+// CreateImportTable: the SAM fields followed by the canonical htsdb columns.
+func ImportColumns() []string {
+	return append([]string(nil), htsdb.ImportColumns...)
+}
+
+// CreateImportTable creates table in db with ImportColumns. It fails if the
+// table exists.
+func CreateImportTable(db *sqlx.DB, table string) error {
+	return htsdb.CreateImportTable(db, table)
+}
+
+// NewImportRecord returns the ImportRecord of the mapped alignment rec with
+// start and stop in c.
+func NewImportRecord(rec *sam.Record, c record.Coords) ImportRecord {
+	return htsdb.NewImportRecord(rec, c)
+}
+
+// SeqAnnotationColumns returns the columns of the sequence annotations of
+// AnnotatedImportRecord.
+func SeqAnnotationColumns() []string {
+	return append([]string(nil), htsdb.SeqAnnotationColumns...)
+}
+
+// AddSeqAnnotationColumns adds SeqAnnotationColumns to a table created by
+// CreateImportTable so that it can be written by a Writer of
+// AnnotatedImportRecord.
+func AddSeqAnnotationColumns(db *sqlx.DB, table string) error {
+	return htsdb.AddSeqAnnotationColumns(db, table)
+}
+
+// ImportAlignments writes the mapped alignments read from r to w, a Writer of
+// ImportRecord or AnnotatedImportRecord, which converts their start and stop
+// to the convention of the database. It returns the number of imported and of
+// skipped unmapped alignments.
+func ImportAlignments(w *Writer, r SAMSource) (imported, unmapped int, err error) {
+	return htsdb.ImportAlignments(w, r)
+}
+
+// ImportAlignmentsContext is like ImportAlignments but stops with the error
+// of ctx once ctx is cancelled. The alignments written until then are left in
+// w.
+func ImportAlignmentsContext(ctx context.Context, w *Writer, r SAMSource) (imported, unmapped int, err error) {
+	return htsdb.ImportAlignmentsContext(ctx, w, r)
+}
+
+// ScanBED reads the records of a BED file with 3 to 12 columns from r and
+// calls fn for each one. Empty lines and track, browser or comment lines are
+// ignored. Reading stops at the first error returned by fn.
+func ScanBED(r io.Reader, fn func(BEDRecord) error) error {
+	return htsdb.ScanBED(r, fn)
+}
+
+// ChimeraInserter inserts chimeras into the chimera table.
+type ChimeraInserter = htsdb.ChimeraInserter
+
+// DupPolicy determines how chimeras identical to a stored one are inserted.
+type DupPolicy = htsdb.DupPolicy
+
+// Policies for duplicate chimeras.
+const (
+	DupKeep  = htsdb.DupKeep
+	DupSkip  = htsdb.DupSkip
+	DupSum   = htsdb.DupSum
+	DupError = htsdb.DupError
+)
+
+// DupPolicies returns the names of the policies accepted by ParseDupPolicy.
+func DupPolicies() []string {
+	return append([]string(nil), htsdb.DupPolicies...)
+}
+
+// ParseDupPolicy parses a duplicate policy name.
+func ParseDupPolicy(s string) (DupPolicy, error) {
+	return htsdb.ParseDupPolicy(s)
+}
+
+// CreateChimeraTable creates the chimera table in db if it does not exist.
+func CreateChimeraTable(db *sqlx.DB) error {
+	return htsdb.CreateChimeraTable(db)
+}
+
+// NewChimeras returns the chimeras formed by consecutive segments of the read
+// qname, ordered from the 5' end of the read.
+func NewChimeras(qname string, segs []record.Segment, copies int) []record.Chimera {
+	return htsdb.NewChimeras(qname, segs, copies)
+}
+
+// ReadSAMChimeras reads SAM records from r and calls fn for each chimera
+// formed by a primary alignment and the supplementary alignments listed in
+// its SA tag. Header lines, unmapped, secondary and supplementary records are
+// skipped. Reading stops at the first error returned by fn.
+func ReadSAMChimeras(r io.Reader, fn func(record.Chimera) error) error {
+	return htsdb.ReadSAMChimeras(r, fn)
+}
+
+// ScanSAMChimeras is like ReadSAMChimeras but skips the first since SAM
+// records, header lines excluded, without parsing them and also passes to fn
+// the 1-based number of the record each chimera comes from. When fn is called
+// for record n, all records before n have been processed; since can thus be
+// used to resume an interrupted import. It returns the number of records read.
+func ScanSAMChimeras(r io.Reader, since int, fn func(n int, c record.Chimera) error) (int, error) {
+	return htsdb.ScanSAMChimeras(r, since, fn)
+}
+
+// Migrate upgrades the layout of db to SchemaVersion and returns the version
+// it started from. The version is stored after each step so that a failed
+// migration resumes from the last completed one. Databases newer than
+// SchemaVersion are an error.
+func Migrate(db *sqlx.DB) (int, error) {
+	return htsdb.Migrate(db)
+}
+
+// CreateSchema creates the canonical record table with ImportColumns and its
+// indexes in db and stores SchemaVersion. It fails if the table exists or if
+// db has record tables with a layout older than SchemaVersion; use Migrate
+// first.
+func CreateSchema(db *sqlx.DB, table string) error {
+	return htsdb.CreateSchema(db, table)
+}
+
+// SampleIndexes returns the CREATE INDEX statements of the canonical indexes
+// of a record table.
+func SampleIndexes(table string) []string {
+	return htsdb.SampleIndexes(table)
+}
+
+// CreatePosIndex creates an index on the columns rname and pos of table, which
+// hold the reference names and positions, if it does not exist. It lets
+// queries ordered by reference and position stream records without sorting
+// the table.
+func CreatePosIndex(db *sqlx.DB, table, rname, pos string) error {
+	return htsdb.CreatePosIndex(db, table, rname, pos)
+}
+
+// CreateQnameIndex creates an index on column col of table, which holds the
+// read names, if it does not exist. It makes SelectByName fast on large
+// tables.
+func CreateQnameIndex(db *sqlx.DB, table, col string) error {
+	return htsdb.CreateQnameIndex(db, table, col)
+}
+
+// InitCoords stores the coordinate convention c in the metadata of db before
+// records are added to it. The convention applies to every table of db, so it
+// fails if db already has record tables that use another convention; call it
+// before the new table is created.
+func InitCoords(db *sqlx.DB, c record.Coords) error {
+	return htsdb.InitCoords(db, c)
+}
+
+// SetMeta stores value for key in the metadata table of db, replacing any
+// previous value. The metadata table is created if it does not exist. db can
+// be a transaction so that the value is stored atomically with other changes.
+func SetMeta(db sqlx.Ext, key, value string) error {
+	return htsdb.SetMeta(db, key, value)
+}
+
+// CheckUnlocked returns ErrLocked if db is locked and force is false. It is
+// called by commands before they modify a database.
+func CheckUnlocked(db *sqlx.DB, force bool) error {
+	return htsdb.CheckUnlocked(db, force)
+}
+
+// CreateSamplesTable creates the samples registry in db if it does not
+// exist.
+func CreateSamplesTable(db *sqlx.DB) error {
+	return htsdb.CreateSamplesTable(db)
+}
+
+// AddSample registers s in db, creating the registry if needed. If the ID of
+// s is valid, RecordTable must have a sample_id column and a view named after
+// the sample that selects its records is created. The sample name must be a
+// valid identifier.
+func AddSample(db *sqlx.DB, s record.Sample) error {
+	return htsdb.AddSample(db, s)
+}
+
+// HeaderRefSeqs returns the references of a SAM header as rows of the
+// reference sequence table. The checksum is taken from the M5 tag if present.
+func HeaderRefSeqs(h *sam.Header) ([]record.RefSeq, error) {
+	return htsdb.HeaderRefSeqs(h)
+}
+
+// CreateRefSeqTable creates the reference sequence table in db if it does
+// not exist.
+func CreateRefSeqTable(db *sqlx.DB) error {
+	return htsdb.CreateRefSeqTable(db)
+}
+
+// InsertRefSeq stores s in the reference sequence table of db, replacing any
+// sequence with the same name.
+func InsertRefSeq(db *sqlx.DB, s record.RefSeq) error {
+	return htsdb.InsertRefSeq(db, s)
+}
+
+// ReadFasta reads FASTA formatted sequences from r and calls fn for each one.
+// The name of a sequence is the first word of its header line. Reading stops
+// at the first error returned by fn.
+func ReadFasta(r io.Reader, fn func(name string, seq []byte) error) error {
+	return htsdb.ReadFasta(r, fn)
+}
@@ -0,0 +1,196 @@
+package htsdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsomiRClasses are the classes of IsomiR.Classes in output order.
+var IsomiRClasses = []string{"canonical", "5p_ext", "5p_trim", "3p_ext", "3p_trim", "nta"}
+
+// IsomiR describes how a small RNA read differs from the annotated mature
+// sequence it is assigned to. Shifts are the offsets of the templated read
+// ends from the annotation ends as returned by Annotation.EndOffsets, so a
+// negative Shift5 is a 5' extension and a positive Shift3 a 3' extension.
+type IsomiR struct {
+	Shift5, Shift3 int
+	// Added holds the non-templated bases added to the 3' end of the read,
+	// in read orientation.
+	Added string
+}
+
+// Classes returns "canonical" if i matches its annotation and otherwise one
+// or more of 5p_ext, 5p_trim, 3p_ext, 3p_trim and nta (non-templated
+// addition).
+func (i IsomiR) Classes() []string {
+	var cls []string
+	switch {
+	case i.Shift5 < 0:
+		cls = append(cls, "5p_ext")
+	case i.Shift5 > 0:
+		cls = append(cls, "5p_trim")
+	}
+	switch {
+	case i.Shift3 > 0:
+		cls = append(cls, "3p_ext")
+	case i.Shift3 < 0:
+		cls = append(cls, "3p_trim")
+	}
+	if i.Added != "" {
+		cls = append(cls, "nta")
+	}
+	if len(cls) == 0 {
+		cls = append(cls, "canonical")
+	}
+	return cls
+}
+
+// ClassifyIsomiR classifies the read with sequence seq aligned with cigar at
+// the 1-based position pos on strand ori against annotation a. ref is the
+// reference sequence from the 0-based position refStart. The 3' end of the
+// read is measured without its non-templated additions, as returned by
+// NonTemplated.
+func ClassifyIsomiR(a Annotation, pos int, cigar, seq string, ori Orientation,
+	ref string, refStart int) (IsomiR, error) {
+	added, start, stop, err := NonTemplated(pos, cigar, seq, ori, ref, refStart)
+	if err != nil {
+		return IsomiR{}, err
+	}
+	i := IsomiR{Added: added}
+	i.Shift5, i.Shift3 = a.EndOffsets(start, stop)
+	return i, nil
+}
+
+// NonTemplated returns the non-templated bases added to the 3' end of the
+// read with sequence seq aligned with cigar at the 1-based position pos on
+// strand ori, in read orientation, and the start and stop in HtsdbCoords of
+// the templated part of the alignment. ref is the reference sequence from the
+// 0-based position refStart. The additions are the bases soft clipped from
+// the 3' end of the read and the consecutive bases at the 3' end of the
+// alignment that mismatch ref, keeping at least one aligned base. Alignment
+// bases outside ref are taken to match.
+func NonTemplated(pos int, cigar, seq string, ori Orientation, ref string,
+	refStart int) (added string, start, stop int, err error) {
+	if seq == "" || seq == "*" {
+		return "", 0, 0, fmt.Errorf("htsdb: read without sequence")
+	}
+	ops, err := parseCigarOps(cigar)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// strip hard clips and find soft clips and the reference span.
+	for len(ops) > 0 && ops[0].op == 'H' {
+		ops = ops[1:]
+	}
+	for len(ops) > 0 && ops[len(ops)-1].op == 'H' {
+		ops = ops[:len(ops)-1]
+	}
+	lead, trail := 0, 0
+	if len(ops) > 0 && ops[0].op == 'S' {
+		lead, ops = ops[0].n, ops[1:]
+	}
+	if len(ops) > 0 && ops[len(ops)-1].op == 'S' {
+		trail, ops = ops[len(ops)-1].n, ops[:len(ops)-1]
+	}
+	if len(ops) == 0 {
+		return "", 0, 0, fmt.Errorf("htsdb: CIGAR %q has no aligned bases", cigar)
+	}
+	start, end, qlen := pos-1, pos-1, lead+trail
+	for _, o := range ops {
+		switch o.op {
+		case 'M', '=', 'X':
+			end, qlen = end+o.n, qlen+o.n
+		case 'D', 'N':
+			end += o.n
+		case 'I':
+			qlen += o.n
+		}
+	}
+	if qlen != len(seq) {
+		return "", 0, 0, fmt.Errorf("htsdb: CIGAR %q does not match sequence length %d", cigar, len(seq))
+	}
+	mismatch := func(q, r int) bool {
+		r -= refStart
+		if r < 0 || r >= len(ref) {
+			return false
+		}
+		return upper(seq[q]) != upper(ref[r])
+	}
+
+	// extend the additions over the mismatches of the 3' aligned block.
+	if ori == Reverse {
+		q, r, n := lead, start, 0
+		if o := ops[0]; o.op == 'M' || o.op == '=' || o.op == 'X' {
+			for n < o.n-1 && mismatch(q, r) {
+				q, r, n = q+1, r+1, n+1
+			}
+		}
+		return ReverseComplement(seq[:q]), r, end - 1, nil
+	}
+	q, r, n := len(seq)-trail-1, end-1, 0
+	if o := ops[len(ops)-1]; o.op == 'M' || o.op == '=' || o.op == 'X' {
+		for n < o.n-1 && mismatch(q, r) {
+			q, r, n = q-1, r-1, n+1
+		}
+	}
+	return strings.ToUpper(seq[q+1:]), start, r, nil
+}
+
+// NTAClass returns the class of the non-templated addition added: "none",
+// "mono-" or "oligo-" followed by the base for additions of one or more
+// copies of a single base, with U for T e.g. mono-U, and "mixed" otherwise.
+func NTAClass(added string) string {
+	if added == "" {
+		return "none"
+	}
+	for i := 1; i < len(added); i++ {
+		if upper(added[i]) != upper(added[0]) {
+			return "mixed"
+		}
+	}
+	b := string(upper(added[0]))
+	if b == "T" {
+		b = "U"
+	}
+	if len(added) == 1 {
+		return "mono-" + b
+	}
+	return "oligo-" + b
+}
+
+// cigarOp is an operation of a CIGAR string.
+type cigarOp struct {
+	op byte
+	n  int
+}
+
+// parseCigarOps returns the operations of cigar.
+func parseCigarOps(cigar string) ([]cigarOp, error) {
+	var ops []cigarOp
+	n, digits := 0, false
+	for i := 0; i < len(cigar); i++ {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			n, digits = n*10+int(c-'0'), true
+			continue
+		}
+		if !digits || strings.IndexByte("MIDNSHP=X", c) < 0 {
+			return nil, fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+		}
+		ops = append(ops, cigarOp{c, n})
+		n, digits = 0, false
+	}
+	if digits || len(ops) == 0 {
+		return nil, fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+	}
+	return ops, nil
+}
+
+// upper returns the upper case of the ASCII letter c.
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
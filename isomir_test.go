@@ -0,0 +1,63 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassifyIsomiR(t *testing.T) {
+	ref := "ACGTTGCAGGCTAACGTAGCTAGGATCCATGCAAGTCGTA"
+	fw := Annotation{Region{"chr1", 10, 31}, "mir-fw", 1}
+	rv := Annotation{Region{"chr1", 10, 31}, "mir-rv", -1}
+	mature := ref[10:32]
+	tests := []struct {
+		a       Annotation
+		pos     int
+		cigar   string
+		seq     string
+		ori     Orientation
+		classes string
+		added   string
+	}{
+		{fw, 11, "22M", mature, Forward, "canonical", ""},
+		{fw, 11, "22M2S", mature + "TT", Forward, "nta", "TT"},
+		{fw, 11, "23M", mature + "T", Forward, "nta", "T"},
+		{fw, 11, "23M", mature + "A", Forward, "3p_ext", ""},
+		{fw, 11, "20M", ref[10:30], Forward, "3p_trim", ""},
+		{fw, 10, "23M", ref[9:32], Forward, "5p_ext", ""},
+		{fw, 12, "19M2S", ref[11:30] + "TT", Forward, "5p_trim 3p_trim nta", "TT"},
+		{rv, 11, "22M", mature, Reverse, "canonical", ""},
+		{rv, 10, "23M", "A" + mature, Reverse, "nta", "T"},
+		{rv, 11, "1S22M", "C" + mature, Reverse, "nta", "G"},
+		{rv, 11, "23M", ref[10:33], Reverse, "5p_ext", ""},
+	}
+	for _, tt := range tests {
+		i, err := ClassifyIsomiR(tt.a, tt.pos, tt.cigar, tt.seq, tt.ori, ref, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(i.Classes(), " "); got != tt.classes || i.Added != tt.added {
+			t.Errorf("%s %d %s: expected %q %q, actual %q %q", tt.a.Name, tt.pos,
+				tt.cigar, tt.classes, tt.added, got, i.Added)
+		}
+	}
+
+	if _, err := ClassifyIsomiR(fw, 11, "20M", mature, Forward, ref, 0); err == nil {
+		t.Error("expected error for CIGAR and sequence length mismatch")
+	}
+	if _, err := ClassifyIsomiR(fw, 11, "22M", "*", Forward, ref, 0); err == nil {
+		t.Error("expected error for missing sequence")
+	}
+}
+
+func TestNTAClass(t *testing.T) {
+	tests := map[string]string{
+		"": "none", "T": "mono-U", "a": "mono-A", "TTT": "oligo-U", "AA": "oligo-A",
+		"AT": "mixed",
+	}
+	for added, expected := range tests {
+		if got := NTAClass(added); got != expected {
+			t.Errorf("%q: expected %s, actual %s", added, expected, got)
+		}
+	}
+}
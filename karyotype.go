@@ -0,0 +1,85 @@
+package htsdb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SortedReferences returns the references of lens in natural order i.e. with
+// numbers in names compared by value so that chr2 comes before chr10.
+func SortedReferences(lens map[string]int) []Reference {
+	refs := make([]Reference, 0, len(lens))
+	for name, l := range lens {
+		refs = append(refs, Reference{Chrom: name, Length: l})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return NaturalLess(refs[i].Chrom, refs[j].Chrom)
+	})
+	return refs
+}
+
+// NaturalLess returns true if a sorts before b when runs of digits are
+// compared by their numeric value.
+func NaturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// WriteChromSizes writes refs to w in the two column chrom.sizes format used
+// by the UCSC genome browser tools.
+func WriteChromSizes(w io.Writer, refs []Reference) error {
+	for _, r := range refs {
+		if _, err := fmt.Fprintf(w, "%s\t%d\n", r.Chrom, r.Length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteKaryotype writes refs to w as a circos karyotype file. The label of a
+// reference is its name without a "chr" prefix. The color of a reference is
+// color or, if empty, its name so that the circos chromosome colors apply.
+func WriteKaryotype(w io.Writer, refs []Reference, color string) error {
+	for _, r := range refs {
+		c := color
+		if c == "" {
+			c = strings.ToLower(r.Chrom)
+		}
+		label := strings.TrimPrefix(r.Chrom, "chr")
+		if label == "" {
+			label = r.Chrom
+		}
+		if _, err := fmt.Fprintf(w, "chr - %s %s 0 %d %s\n", r.Chrom, label,
+			r.Length, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
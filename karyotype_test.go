@@ -0,0 +1,43 @@
+package htsdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"chr2", "chr10", true},
+		{"chr10", "chr2", false},
+		{"chr1", "chr1_random", true},
+		{"chr9", "chrX", true},
+		{"chr02", "chr1", false},
+		{"chr1", "chr1", false},
+	}
+	for _, tt := range tests {
+		if got := NaturalLess(tt.a, tt.b); got != tt.expected {
+			t.Errorf("NaturalLess(%q, %q): expected %v, actual %v", tt.a, tt.b,
+				tt.expected, got)
+		}
+	}
+}
+
+func TestWriteKaryotype(t *testing.T) {
+	refs := SortedReferences(map[string]int{"chr10": 20, "chr2": 30, "chrX": 10})
+	var chromSizes, karyotype bytes.Buffer
+	if err := WriteChromSizes(&chromSizes, refs); err != nil {
+		t.Fatal(err)
+	}
+	if got := chromSizes.String(); got != "chr2\t30\nchr10\t20\nchrX\t10\n" {
+		t.Errorf("unexpected chrom.sizes: %q", got)
+	}
+	if err := WriteKaryotype(&karyotype, refs[:1], ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := karyotype.String(); got != "chr - chr2 2 0 30 chr2\n" {
+		t.Errorf("unexpected karyotype: %q", got)
+	}
+}
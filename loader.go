@@ -0,0 +1,242 @@
+package htsdb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sqliteMaxVariables is the default maximum number of host parameters in a
+// single SQLite statement.
+const sqliteMaxVariables = 999
+
+// LoadOptions tunes the throughput of a Loader.
+type LoadOptions struct {
+	// BatchRows is the number of rows inserted by a single multi-row INSERT.
+	// It is reduced to fit the SQLite limit on statement parameters.
+	BatchRows int
+	// TxRows is the number of rows per transaction. Zero commits only on
+	// Flush and Close.
+	TxRows int
+	// Unsafe enables WAL journaling and disables synchronous writes during
+	// the load; both are restored by Close. A crash during the load may corrupt the database; use it for
+	// databases that can be recreated e.g. new imports. It only applies to
+	// SQLite databases.
+	Unsafe bool
+}
+
+// DefaultLoadOptions are the load options suitable for most imports.
+var DefaultLoadOptions = LoadOptions{BatchRows: 500, TxRows: 1000000}
+
+// Loader inserts rows into the columns of a table at high throughput. Rows
+// are buffered and inserted with multi-row INSERTs in transactions of
+// LoadOptions.TxRows rows, and index creation can be deferred until all rows
+// are loaded. A Loader uses a single connection of the pool and is not safe
+// for concurrent use.
+type Loader struct {
+	conn    *sqlx.Conn
+	driver  string
+	tx      *sqlx.Tx
+	stmt    *sqlx.Stmt
+	table   string
+	cols    []string
+	opts    LoadOptions
+	buf     []interface{}
+	inTx    int
+	rows    int64
+	indexes []string
+	sync    string
+	journal string
+}
+
+// NewLoader returns a Loader that inserts rows into cols of table in db.
+func NewLoader(db *sqlx.DB, table string, cols []string, opts LoadOptions) (*Loader, error) {
+	if opts.BatchRows < 1 {
+		opts.BatchRows = 1
+	}
+	if max := sqliteMaxVariables / len(cols); opts.BatchRows > max {
+		opts.BatchRows = max
+	}
+	conn, err := db.Connx(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	l := &Loader{conn: conn, driver: db.DriverName(), table: table, cols: cols, opts: opts,
+		buf: make([]interface{}, 0, opts.BatchRows*len(cols))}
+	if opts.Unsafe && db.DriverName() == SQLite {
+		err = conn.QueryRowxContext(context.Background(), "PRAGMA synchronous").Scan(&l.sync)
+		if err == nil {
+			err = conn.QueryRowxContext(context.Background(), "PRAGMA journal_mode").Scan(&l.journal)
+		}
+		if err == nil {
+			_, err = conn.ExecContext(context.Background(), "PRAGMA journal_mode = WAL")
+		}
+		if err == nil {
+			_, err = conn.ExecContext(context.Background(), "PRAGMA synchronous = OFF")
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// insertSQL returns the INSERT statement for n rows.
+func (l *Loader) insertSQL(n int) string {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(l.cols)), ", ") + ")"
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = row
+	}
+	return sqlx.Rebind(sqlx.BindType(l.driver), "INSERT INTO "+l.table+
+		" ("+strings.Join(l.cols, ", ")+") VALUES "+strings.Join(rows, ", "))
+}
+
+// begin starts a transaction and prepares the full batch statement.
+func (l *Loader) begin() error {
+	var err error
+	if l.tx, err = l.conn.BeginTxx(context.Background(), nil); err != nil {
+		return err
+	}
+	if l.stmt, err = l.tx.Preparex(l.insertSQL(l.opts.BatchRows)); err != nil {
+		l.tx.Rollback()
+		l.tx = nil
+	}
+	return err
+}
+
+// Add buffers a row with one value per column.
+func (l *Loader) Add(vals ...interface{}) error {
+	l.buf = append(l.buf, vals...)
+	if len(l.buf) < l.opts.BatchRows*len(l.cols) {
+		return nil
+	}
+	if l.tx == nil {
+		if err := l.begin(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.stmt.Exec(l.buf...); err != nil {
+		return err
+	}
+	l.rows += int64(l.opts.BatchRows)
+	l.inTx += l.opts.BatchRows
+	l.buf = l.buf[:0]
+	if l.opts.TxRows > 0 && l.inTx >= l.opts.TxRows {
+		return l.commit()
+	}
+	return nil
+}
+
+// commit commits the current transaction. If the commit fails, the rows of
+// the transaction are no longer counted.
+func (l *Loader) commit() error {
+	if l.tx == nil {
+		return nil
+	}
+	l.stmt.Close()
+	err := l.tx.Commit()
+	if err != nil {
+		l.rows -= int64(l.inTx)
+	}
+	l.tx, l.stmt, l.inTx = nil, nil, 0
+	return err
+}
+
+// rollback rolls back the current transaction and stops counting its rows.
+func (l *Loader) rollback() error {
+	if l.tx == nil {
+		return nil
+	}
+	l.stmt.Close()
+	err := l.tx.Rollback()
+	l.rows -= int64(l.inTx)
+	l.tx, l.stmt, l.inTx = nil, nil, 0
+	return err
+}
+
+// Flush inserts the buffered rows and commits them.
+func (l *Loader) Flush() error {
+	if n := len(l.buf) / len(l.cols); n > 0 {
+		if l.tx == nil {
+			if err := l.begin(); err != nil {
+				return err
+			}
+		}
+		if _, err := l.tx.Exec(l.insertSQL(n), l.buf...); err != nil {
+			return err
+		}
+		l.rows += int64(n)
+		l.inTx += n
+		l.buf = l.buf[:0]
+	}
+	return l.commit()
+}
+
+// DeferIndex registers a CREATE INDEX statement that is executed by Close,
+// after all rows are loaded, which is faster than updating the index for
+// each inserted row.
+func (l *Loader) DeferIndex(stmt string) {
+	l.indexes = append(l.indexes, stmt)
+}
+
+// Rows returns the number of rows inserted so far, excluding buffered rows.
+func (l *Loader) Rows() int64 {
+	return l.rows
+}
+
+// Close flushes the buffered rows, creates the deferred indexes, restores
+// the journal mode and synchronous writes and releases the connection. On
+// error the uncommitted rows are discarded and Rows only counts the rows of
+// the committed transactions.
+func (l *Loader) Close() error {
+	err := l.Flush()
+	if err != nil {
+		l.rollback()
+		l.buf = l.buf[:0]
+	}
+	for _, stmt := range l.indexes {
+		if err != nil {
+			break
+		}
+		_, err = l.conn.ExecContext(context.Background(), stmt)
+	}
+	if rerr := l.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Abort discards the buffered rows, rolls back the open transaction,
+// restores the journal mode and synchronous writes and releases the
+// connection. Rows committed by earlier transactions are kept and the
+// deferred indexes are not created.
+func (l *Loader) Abort() error {
+	err := l.rollback()
+	l.buf = l.buf[:0]
+	if rerr := l.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// release restores the pragmas changed by NewLoader and releases the
+// connection.
+func (l *Loader) release() error {
+	var err error
+	if l.journal != "" {
+		_, err = l.conn.ExecContext(context.Background(), "PRAGMA journal_mode = "+l.journal)
+	}
+	if l.sync != "" {
+		_, serr := l.conn.ExecContext(context.Background(), "PRAGMA synchronous = "+l.sync)
+		if err == nil {
+			err = serr
+		}
+	}
+	if cerr := l.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
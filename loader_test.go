@@ -0,0 +1,138 @@
+package htsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestLoaderInsertSQL(t *testing.T) {
+	l := &Loader{table: "t", cols: []string{"a", "b"}}
+	expected := "INSERT INTO t (a, b) VALUES (?, ?), (?, ?)"
+	if got := l.insertSQL(2); got != expected {
+		t.Errorf("expected %q, actual %q", expected, got)
+	}
+}
+
+// loaderDB creates a database with an empty table t.
+func loaderDB(tb testing.TB) (*sqlx.DB, func()) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "load.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db.MustExec("CREATE TABLE t (rname TEXT, start INTEGER, stop INTEGER)")
+	return db, func() { db.Close(); os.RemoveAll(dir) }
+}
+
+func TestLoader(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+
+	opts := LoadOptions{BatchRows: 3, TxRows: 6, Unsafe: true}
+	l, err := NewLoader(db, "t", []string{"rname", "start", "stop"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.DeferIndex("CREATE INDEX t_idx ON t (rname, start)")
+	for i := 0; i < 10; i++ {
+		if err := l.Add("chr1", i, i+10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.Rows() != 9 {
+		t.Errorf("expected 9 inserted rows before close, actual %d", l.Rows())
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Rows() != 10 {
+		t.Errorf("expected 10 inserted rows, actual %d", l.Rows())
+	}
+
+	var cnt, sum int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&sum, "SELECT SUM(start) FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 10 || sum != 45 {
+		t.Errorf("expected 10 rows with start sum 45, actual %d, %d", cnt, sum)
+	}
+	idx, err := IndexSQL(db, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx) != 1 {
+		t.Errorf("expected 1 index, actual %d", len(idx))
+	}
+	var journal string
+	if err := db.Get(&journal, "PRAGMA journal_mode"); err != nil {
+		t.Fatal(err)
+	}
+	if journal != "delete" {
+		t.Errorf("expected delete journal mode after close, actual %s", journal)
+	}
+}
+
+func TestLoaderCloseFailedCommit(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+
+	// rows of c must reference a row of p, which is only checked on commit.
+	db.SetMaxOpenConns(1)
+	db.MustExec("PRAGMA foreign_keys = ON")
+	db.MustExec("CREATE TABLE p (id INTEGER PRIMARY KEY)")
+	db.MustExec("CREATE TABLE c (pid INTEGER REFERENCES p (id) DEFERRABLE INITIALLY DEFERRED)")
+	db.MustExec("INSERT INTO p VALUES (1)")
+
+	opts := LoadOptions{BatchRows: 2, TxRows: 2}
+	l, err := NewLoader(db, "c", []string{"pid"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []int{1, 1, 2} {
+		if err := l.Add(pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err == nil {
+		t.Fatal("expected error for the final commit")
+	}
+	if l.Rows() != 2 {
+		t.Errorf("expected 2 committed rows, actual %d", l.Rows())
+	}
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM c"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 2 {
+		t.Errorf("expected 2 stored rows, actual %d", cnt)
+	}
+}
+
+func BenchmarkLoader(b *testing.B) {
+	db, cleanup := loaderDB(b)
+	defer cleanup()
+
+	b.ResetTimer()
+	l, err := NewLoader(db, "t", []string{"rname", "start", "stop"}, DefaultLoadOptions)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if err := l.Add("chr1", i, i+10); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package htsdb
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/biogo/biogo/feat"
+)
+
+// RangeChecker detects malformed ranges i.e. ranges of zero length and ranges
+// whose stop precedes their start. Head, Tail and Mid are meaningless for
+// such ranges, so analyses skip them and report how many were seen instead
+// of computing nonsense positions. The zero value is ready to use and it is
+// safe for concurrent use.
+type RangeChecker struct {
+	empty, inverted int64
+}
+
+// Check returns true if r has positive length. Otherwise it counts r as
+// malformed and returns false. r must be in HtsdbCoords.
+func (c *RangeChecker) Check(r feat.Range) bool {
+	switch n := r.Len(); {
+	case n > 0:
+		return true
+	case n == 0:
+		atomic.AddInt64(&c.empty, 1)
+	default:
+		atomic.AddInt64(&c.inverted, 1)
+	}
+	return false
+}
+
+// Empty returns the number of zero length ranges seen.
+func (c *RangeChecker) Empty() int { return int(atomic.LoadInt64(&c.empty)) }
+
+// Inverted returns the number of ranges seen whose stop precedes their start.
+func (c *RangeChecker) Inverted() int { return int(atomic.LoadInt64(&c.inverted)) }
+
+// Merge adds the malformed ranges counted by o to c.
+func (c *RangeChecker) Merge(o *RangeChecker) {
+	atomic.AddInt64(&c.empty, int64(o.Empty()))
+	atomic.AddInt64(&c.inverted, int64(o.Inverted()))
+}
+
+// Report returns a one line summary of the malformed ranges seen or the empty
+// string if there were none.
+func (c *RangeChecker) Report() string {
+	empty, inverted := c.Empty(), c.Inverted()
+	if empty == 0 && inverted == 0 {
+		return ""
+	}
+	return fmt.Sprintf("skipped %d malformed records: %d zero length, %d with stop before start",
+		empty+inverted, empty, inverted)
+}
@@ -0,0 +1,37 @@
+package htsdb
+
+import "testing"
+
+func TestRangeChecker(t *testing.T) {
+	var c RangeChecker
+	if c.Report() != "" {
+		t.Error("expected empty report")
+	}
+	ranges := []Range{{StartPos: 10, StopPos: 19}, {StartPos: 10, StopPos: 9},
+		{StartPos: 10, StopPos: 5}, {StartPos: 3, StopPos: 3}}
+	var valid int
+	for i := range ranges {
+		if c.Check(&ranges[i]) {
+			valid++
+		}
+	}
+	if valid != 2 || c.Empty() != 1 || c.Inverted() != 1 {
+		t.Errorf("unexpected counts: valid %d, empty %d, inverted %d", valid,
+			c.Empty(), c.Inverted())
+	}
+	expected := "skipped 2 malformed records: 1 zero length, 1 with stop before start"
+	if got := c.Report(); got != expected {
+		t.Errorf("expected %q, actual %q", expected, got)
+	}
+}
+
+func TestRangeCheckerMerge(t *testing.T) {
+	var a, b RangeChecker
+	a.Check(&Range{StartPos: 5, StopPos: 4})
+	b.Check(&Range{StartPos: 5, StopPos: 4})
+	b.Check(&Range{StartPos: 9, StopPos: 2})
+	a.Merge(&b)
+	if a.Empty() != 2 || a.Inverted() != 1 {
+		t.Errorf("expected 2 empty and 1 inverted, actual %d and %d", a.Empty(), a.Inverted())
+	}
+}
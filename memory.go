@@ -0,0 +1,83 @@
+package htsdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MapEntrySize is the approximate number of bytes used by an entry of a Go
+// map with integer keys and values, including bucket overhead.
+const MapEntrySize = 48
+
+// MemBudget tracks an approximate memory budget that is shared by the
+// in-memory structures of concurrent workers. When a reservation fails,
+// callers are expected to release what they hold and switch to a streaming
+// algorithm. It is safe for concurrent use.
+//
+// The budget covers the per-reference structures whose size grows with the
+// number of reads: the coverage and counts of htsdb-coverage and
+// htsdb-ends-to-bedgraph and the positions of htsdb-relative-pos-distro.
+// Region indexes, which grow with the size of user-supplied BED files, are not
+// budgeted.
+type MemBudget struct {
+	limit int64
+	used  int64
+}
+
+// NewMemBudget returns a new budget of limit bytes. A limit of zero or less
+// means unlimited.
+func NewMemBudget(limit int64) *MemBudget {
+	return &MemBudget{limit: limit}
+}
+
+// Reserve reserves n bytes. It returns false and reserves nothing if the
+// reservation would exceed the budget.
+func (b *MemBudget) Reserve(n int64) bool {
+	if b == nil || b.limit <= 0 {
+		return true
+	}
+	if atomic.AddInt64(&b.used, n) > b.limit {
+		atomic.AddInt64(&b.used, -n)
+		return false
+	}
+	return true
+}
+
+// Release returns n previously reserved bytes to the budget.
+func (b *MemBudget) Release(n int64) {
+	if b == nil || b.limit <= 0 {
+		return
+	}
+	atomic.AddInt64(&b.used, -n)
+}
+
+// ParseBytes parses a size in bytes with an optional K, M, G or T suffix
+// (powers of 1024) e.g. "512M". The empty string returns 0.
+func ParseBytes(s string) (int64, error) {
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	case 'T':
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("htsdb: invalid size %q", orig)
+	}
+	return int64(v * float64(mult)), nil
+}
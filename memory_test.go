@@ -0,0 +1,42 @@
+package htsdb
+
+import "testing"
+
+var parseBytesTests = []struct {
+	In    string
+	Bytes int64
+	Error bool
+}{
+	{In: "", Bytes: 0},
+	{In: "100", Bytes: 100},
+	{In: "2k", Bytes: 2048},
+	{In: "1.5G", Bytes: 3 << 29},
+	{In: "foo", Error: true},
+	{In: "-1M", Error: true},
+}
+
+func TestParseBytes(t *testing.T) {
+	for _, tt := range parseBytesTests {
+		b, err := ParseBytes(tt.In)
+		if (err != nil) != tt.Error || b != tt.Bytes {
+			t.Errorf("%q:expected %d, actual %d:%v", tt.In, tt.Bytes, b, err)
+		}
+	}
+}
+
+func TestMemBudget(t *testing.T) {
+	b := NewMemBudget(100)
+	if !b.Reserve(60) {
+		t.Error("reservation within budget failed")
+	}
+	if b.Reserve(60) {
+		t.Error("reservation beyond budget succeeded")
+	}
+	b.Release(60)
+	if !b.Reserve(100) {
+		t.Error("reservation after release failed")
+	}
+	if !NewMemBudget(0).Reserve(1 << 40) {
+		t.Error("unlimited budget rejected reservation")
+	}
+}
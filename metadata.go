@@ -0,0 +1,113 @@
+package htsdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MetadataTable is the name of the table that stores database wide key/value
+// settings such as the coordinate convention.
+const MetadataTable = "metadata"
+
+// GetMeta returns the metadata value stored in db for key. The boolean is
+// false if the key or the metadata table do not exist.
+func GetMeta(db *sqlx.DB, key string) (string, bool, error) {
+	return GetMetaContext(context.Background(), db, key)
+}
+
+// GetMetaContext is like GetMeta but the queries run with ctx.
+func GetMetaContext(ctx context.Context, db *sqlx.DB, key string) (string, bool, error) {
+	ok, err := TableExistsContext(ctx, db, MetadataTable)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	var value string
+	err = db.GetContext(ctx, &value, db.Rebind(
+		"SELECT value FROM "+MetadataTable+` WHERE "key" = ?`), key)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// SetMeta stores value for key in the metadata table of db, replacing any
+// previous value. The metadata table is created if it does not exist. db can
+// be a transaction so that the value is stored atomically with other changes.
+func SetMeta(db sqlx.Ext, key, value string) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + MetadataTable +
+		` ("key" VARCHAR(255) PRIMARY KEY, value TEXT)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(db.Rebind(upsertSQL(db, MetadataTable, `"key"`, "value")), key, value)
+	return err
+}
+
+// Meta returns all metadata key/value pairs stored in db. It returns an empty
+// map if the metadata table does not exist.
+func Meta(db *sqlx.DB) (map[string]string, error) {
+	meta := make(map[string]string)
+	ok, err := TableExists(db, MetadataTable)
+	if err != nil || !ok {
+		return meta, err
+	}
+	rows, err := db.Queryx(`SELECT "key", value FROM ` + MetadataTable)
+	if err != nil {
+		return meta, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			return meta, err
+		}
+		meta[k] = v
+	}
+	return meta, rows.Err()
+}
+
+// LockedMetaKey is the metadata key that marks a database as locked e.g. a
+// canonical project database on a shared filesystem. Commands that modify
+// databases refuse to modify locked ones unless forced.
+const LockedMetaKey = "locked"
+
+// ErrLocked is returned by CheckUnlocked for locked databases.
+var ErrLocked = errors.New("htsdb: database is locked; use --force to modify it")
+
+// Locked returns true if db is locked i.e. its locked metadata value is true
+// as parsed by strconv.ParseBool.
+func Locked(db *sqlx.DB) (bool, error) {
+	v, ok, err := GetMeta(db, LockedMetaKey)
+	if err != nil || !ok {
+		return false, err
+	}
+	locked, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("htsdb: invalid %s metadata value %q", LockedMetaKey, v)
+	}
+	return locked, nil
+}
+
+// CheckUnlocked returns ErrLocked if db is locked and force is false. It is
+// called by commands before they modify a database.
+func CheckUnlocked(db *sqlx.DB, force bool) error {
+	if force {
+		return nil
+	}
+	locked, err := Locked(db)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return ErrLocked
+	}
+	return nil
+}
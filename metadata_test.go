@@ -0,0 +1,40 @@
+package htsdb
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestCheckUnlocked(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+
+	if err := CheckUnlocked(db, false); err != nil {
+		t.Errorf("expected unlocked database without metadata, actual %v", err)
+	}
+	tests := []struct {
+		Value string
+		Force bool
+		Err   error
+	}{
+		{"false", false, nil},
+		{"true", false, ErrLocked},
+		{"1", false, ErrLocked},
+		{"true", true, nil},
+	}
+	for _, tt := range tests {
+		if err := SetMeta(db, LockedMetaKey, tt.Value); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckUnlocked(db, tt.Force); err != tt.Err {
+			t.Errorf("%s, force %t: expected %v, actual %v", tt.Value, tt.Force, tt.Err, err)
+		}
+	}
+	if err := SetMeta(db, LockedMetaKey, "maybe"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Locked(db); err == nil {
+		t.Error("expected error for invalid locked value")
+	}
+}
@@ -0,0 +1,166 @@
+// Package normalize implements the normalization methods shared by the htsdb
+// tools that export counts or coverage, so that the same --normalize flag
+// means the same thing in every command.
+package normalize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// Method is a normalization method.
+type Method int
+
+// Valid normalization methods.
+const (
+	// Raw leaves values unchanged.
+	Raw Method = iota
+	// CPM scales values to counts per million library reads.
+	CPM
+	// RPKM scales values to reads per kilobase of feature per million library
+	// reads.
+	RPKM
+	// Spike scales values to counts per million spike-in reads.
+	Spike
+	// Scale multiplies values by a custom factor.
+	Scale
+	// RPGC scales values to reads per genomic content i.e. to the ratio of
+	// the observed to the expected value if library reads were spread
+	// uniformly over the effective genome size.
+	RPGC
+)
+
+// Methods lists the command line names of the methods in order.
+var Methods = []string{"raw", "cpm", "rpkm", "spike", "scale", "rpgc"}
+
+// Parse parses a normalization method name.
+func Parse(s string) (Method, error) {
+	for i, m := range Methods {
+		if strings.ToLower(s) == m {
+			return Method(i), nil
+		}
+	}
+	return Raw, fmt.Errorf("normalize: invalid method %q", s)
+}
+
+// String returns the command line name of m.
+func (m Method) String() string {
+	if m >= 0 && int(m) < len(Methods) {
+		return Methods[m]
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// Normalizer normalizes the values of a single database.
+type Normalizer struct {
+	Method Method
+	// Total is the library size for CPM and RPKM or the number of spike-in
+	// reads for Spike.
+	Total float64
+	// Factor is an additional scale applied by all methods; it is the custom
+	// factor for Scale. Zero is treated as 1.
+	Factor float64
+	// GenomeSize is the effective genome size for RPGC.
+	GenomeSize float64
+}
+
+// Value returns the normalized v for a feature of length bases. length is
+// only used by RPKM and RPGC.
+func (n Normalizer) Value(v float64, length int) float64 {
+	f := n.Factor
+	if f == 0 {
+		f = 1
+	}
+	switch n.Method {
+	case CPM, Spike:
+		if n.Total == 0 {
+			return 0
+		}
+		return v * f * 1e6 / n.Total
+	case RPKM:
+		if n.Total == 0 || length <= 0 {
+			return 0
+		}
+		return v * f * 1e9 / (n.Total * float64(length))
+	case RPGC:
+		if n.Total == 0 || n.GenomeSize == 0 || length <= 0 {
+			return 0
+		}
+		return v * f * n.GenomeSize / (n.Total * float64(length))
+	}
+	return v * f
+}
+
+// NeedsTotal returns true if m requires the library or spike-in size.
+func (m Method) NeedsTotal() bool {
+	return m == CPM || m == RPKM || m == Spike || m == RPGC
+}
+
+// ParseFactors parses per-database scale factors given as a comma separated
+// list of name=factor pairs e.g. "ctrl.db=1.2,treat.db=0.8".
+func ParseFactors(s string) (map[string]float64, error) {
+	factors := make(map[string]float64)
+	if s == "" {
+		return factors, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("normalize: invalid scale factor %q", pair)
+		}
+		f, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("normalize: invalid scale factor %q", pair)
+		}
+		factors[kv[0]] = f
+	}
+	return factors, nil
+}
+
+// LibrarySize returns the number of records in table that satisfy the SQL
+// filter where, or the sum of their copy numbers if copies is true. An empty
+// filter selects all records.
+func LibrarySize(db *sqlx.DB, table, where string, copies bool) (float64, error) {
+	expr := "COUNT(*) * 1e0"
+	if copies {
+		expr = "COALESCE(SUM(copy_number), 0) * 1e0"
+	}
+	b := squirrel.Select().Column(expr).From(table)
+	if where != "" {
+		b = b.Where(where)
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	err = db.Get(&total, query)
+	return total, err
+}
+
+// New returns the Normalizer for method m on the records of table in db.
+// where restricts the records that make up the library; spikeWhere selects the
+// spike-in records for Spike. factor is the scale factor. The effective genome
+// size for RPGC is left for the caller to set.
+func New(db *sqlx.DB, m Method, table, where, spikeWhere string, copies bool,
+	factor float64) (Normalizer, error) {
+
+	n := Normalizer{Method: m, Factor: factor}
+	if !m.NeedsTotal() {
+		return n, nil
+	}
+	var err error
+	if m == Spike {
+		if spikeWhere == "" {
+			return n, fmt.Errorf("normalize: spike-in filter is required")
+		}
+		n.Total, err = LibrarySize(db, table, spikeWhere, copies)
+		return n, err
+	}
+	n.Total, err = LibrarySize(db, table, where, copies)
+	return n, err
+}
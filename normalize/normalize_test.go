@@ -0,0 +1,59 @@
+package normalize
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for i, s := range Methods {
+		m, err := Parse(s)
+		if err != nil || m != Method(i) || m.String() != s {
+			t.Errorf("Parse(%q): got %v, %v", s, m, err)
+		}
+	}
+	if _, err := Parse("tpm"); err == nil {
+		t.Error("expected error for invalid method")
+	}
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		n        Normalizer
+		v        float64
+		length   int
+		expected float64
+	}{
+		{Normalizer{Method: Raw}, 5, 0, 5},
+		{Normalizer{Method: CPM, Total: 2e6}, 10, 0, 5},
+		{Normalizer{Method: RPKM, Total: 2e6}, 10, 500, 10},
+		{Normalizer{Method: RPKM, Total: 2e6}, 10, 0, 0},
+		{Normalizer{Method: Spike, Total: 1e4}, 10, 0, 1000},
+		{Normalizer{Method: Scale, Factor: 0.5}, 10, 0, 5},
+		{Normalizer{Method: CPM, Total: 1e6, Factor: 2}, 10, 0, 20},
+		{Normalizer{Method: CPM}, 10, 0, 0},
+		{Normalizer{Method: RPGC, Total: 1e3, GenomeSize: 1e4}, 10, 10, 10},
+		{Normalizer{Method: RPGC, Total: 1e3}, 10, 10, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.n.Value(tt.v, tt.length); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("%+v.Value(%v, %d): expected %v, actual %v", tt.n, tt.v,
+				tt.length, tt.expected, got)
+		}
+	}
+}
+
+func TestParseFactors(t *testing.T) {
+	f, err := ParseFactors("a.db=1.5,b=0.5")
+	if err != nil || len(f) != 2 || f["a.db"] != 1.5 || f["b"] != 0.5 {
+		t.Errorf("unexpected factors: %v, %v", f, err)
+	}
+	if f, err = ParseFactors(""); err != nil || len(f) != 0 {
+		t.Errorf("unexpected factors: %v, %v", f, err)
+	}
+	for _, s := range []string{"a", "=1", "a=x"} {
+		if _, err = ParseFactors(s); err == nil {
+			t.Errorf("ParseFactors(%q): expected error", s)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package htsdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+)
+
+// Orientation is the strand of a record. It extends feat.Orientation with
+// explicit support for records of unknown strand and for validating the
+// values read from the strand column.
+type Orientation int8
+
+// Orientations of records. Unknown is used for records without strand e.g.
+// unstranded libraries.
+const (
+	Reverse Orientation = -1
+	Unknown Orientation = 0
+	Forward Orientation = 1
+)
+
+// Scan implements sql.Scanner. Integers are stored unchanged so that invalid
+// values can be detected with Valid; "+", "-" and "." are also accepted. NULL
+// is Unknown.
+func (o *Orientation) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*o = Unknown
+	case int64:
+		if v < -128 || v > 127 {
+			return fmt.Errorf("htsdb: invalid strand %d", v)
+		}
+		*o = Orientation(v)
+	case []byte:
+		return o.Scan(string(v))
+	case string:
+		switch strings.TrimSpace(v) {
+		case "+":
+			*o = Forward
+		case "-":
+			*o = Reverse
+		case ".", "":
+			*o = Unknown
+		default:
+			i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 8)
+			if err != nil {
+				return fmt.Errorf("htsdb: invalid strand %q", v)
+			}
+			*o = Orientation(i)
+		}
+	default:
+		return fmt.Errorf("htsdb: invalid strand of type %T", src)
+	}
+	return nil
+}
+
+// Valid returns true if o is Forward, Reverse or Unknown.
+func (o Orientation) Valid() bool {
+	return o >= Reverse && o <= Forward
+}
+
+// Known returns true if o is Forward or Reverse. Only ranges of known
+// orientation have a head and a tail.
+func (o Orientation) Known() bool {
+	return o == Forward || o == Reverse
+}
+
+// Feat returns o as a feat.Orientation. Invalid orientations become
+// feat.NotOriented.
+func (o Orientation) Feat() feat.Orientation {
+	if !o.Known() {
+		return feat.NotOriented
+	}
+	return feat.Orientation(o)
+}
+
+// String returns "+", "-" or "." for known, unknown and invalid orientations.
+func (o Orientation) String() string {
+	switch o {
+	case Forward:
+		return "+"
+	case Reverse:
+		return "-"
+	}
+	return "."
+}
+
+// SAM FLAG bits that determine the strand of a record.
+const (
+	flagUnmapped = 0x4
+	flagReverse  = 0x10
+)
+
+// FlagStrand returns the orientation of a record with the given SAM FLAG:
+// Reverse if the read is reverse complemented, Forward otherwise and Unknown
+// if the read is unmapped.
+func FlagStrand(flag int) Orientation {
+	switch {
+	case flag&flagUnmapped != 0:
+		return Unknown
+	case flag&flagReverse != 0:
+		return Reverse
+	}
+	return Forward
+}
+
+// StrandFlag returns flag with the reverse complemented bit (0x10) set
+// according to o e.g. to rebuild the FLAG of records imported from BED-like
+// sources. flag is returned unchanged if o is not known.
+func StrandFlag(flag int, o Orientation) int {
+	switch o {
+	case Forward:
+		return flag &^ flagReverse
+	case Reverse:
+		return flag | flagReverse
+	}
+	return flag
+}
+
+// FlagStrandMismatch is an SQL filter that selects the mapped records whose
+// strand column is known and disagrees with the reverse complemented bit of
+// their flag column.
+const FlagStrandMismatch = "(flag & 4) = 0 AND strand IN (1, -1) AND " +
+	"((flag & 16) != 0) != (strand = -1)"
+
+// Stranded is implemented by records whose strand can be validated.
+type Stranded interface {
+	Strand() Orientation
+	SetStrand(Orientation)
+}
+
+// StrandPolicy determines how records whose strand is not 1 or -1 are
+// handled when they are read.
+type StrandPolicy int
+
+// Valid strand policies. StrandUnknown is the default so that records of
+// unstranded tables are read as before policies existed.
+const (
+	// StrandUnknown keeps the record with Unknown orientation.
+	StrandUnknown StrandPolicy = iota
+	// StrandError stops reading with an error.
+	StrandError
+	// StrandSkip skips the record.
+	StrandSkip
+)
+
+// StrandPolicies lists the command line names of the policies.
+var StrandPolicies = []string{"unknown", "error", "skip"}
+
+// ParseStrandPolicy parses a strand policy. Valid values are "error", "skip"
+// and "unknown".
+func ParseStrandPolicy(s string) (StrandPolicy, error) {
+	switch s {
+	case "error":
+		return StrandError, nil
+	case "skip":
+		return StrandSkip, nil
+	case "unknown":
+		return StrandUnknown, nil
+	}
+	return 0, fmt.Errorf("htsdb: invalid strand policy %q", s)
+}
+
+// Check applies p to rec. It returns false if rec must be skipped and an
+// error if the policy is StrandError and the strand of rec is not known.
+func (p StrandPolicy) Check(rec Stranded) (bool, error) {
+	o := rec.Strand()
+	if o.Known() {
+		return true, nil
+	}
+	switch p {
+	case StrandSkip:
+		return false, nil
+	case StrandUnknown:
+		rec.SetStrand(Unknown)
+		return true, nil
+	}
+	return false, fmt.Errorf("htsdb: record with strand %d; expected 1 or -1", int(o))
+}
+
+// InvalidStrandFilter is an SQL clause that selects the records whose strand
+// is not 1 or -1.
+const InvalidStrandFilter = "(strand IS NULL OR strand NOT IN (1, -1))"
+
+// Filter applies p to the records of table that satisfy the SQL filter where,
+// for commands that select records by strand in SQL instead of reading it. It
+// returns where, restricted to the records of strand 1 or -1 if p is
+// StrandSkip, and the number of records with another strand, which are only
+// counted if p is not StrandUnknown. It returns an error if p is StrandError
+// and there are such records.
+func (p StrandPolicy) Filter(db *sqlx.DB, table, where string) (string, int, error) {
+	if p == StrandUnknown {
+		return where, 0, nil
+	}
+	cb := CountBuilder.From(table).Where(InvalidStrandFilter)
+	if where != "" {
+		cb = cb.Where(where)
+	}
+	query, _, err := cb.ToSql()
+	if err != nil {
+		return "", 0, err
+	}
+	var n int
+	if err = db.Get(&n, query); err != nil {
+		return "", 0, err
+	}
+	if p == StrandError && n > 0 {
+		return "", n, fmt.Errorf("htsdb: %d records with strand other than 1 or -1", n)
+	}
+	if p == StrandSkip {
+		where, err = CombineFilters(where, InvalidStrandFilter, nil, "")
+	}
+	return where, n, err
+}
@@ -0,0 +1,160 @@
+package htsdb
+
+import (
+	"testing"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestOrientationScan(t *testing.T) {
+	tests := []struct {
+		src      interface{}
+		expected Orientation
+		valid    bool
+	}{
+		{int64(1), Forward, true},
+		{int64(-1), Reverse, true},
+		{int64(0), Unknown, true},
+		{nil, Unknown, true},
+		{int64(2), Orientation(2), false},
+		{[]byte("-"), Reverse, true},
+		{"+", Forward, true},
+		{".", Unknown, true},
+	}
+	for _, tt := range tests {
+		var o Orientation
+		if err := o.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v): %v", tt.src, err)
+			continue
+		}
+		if o != tt.expected || o.Valid() != tt.valid {
+			t.Errorf("Scan(%v): expected %d, actual %d", tt.src, tt.expected, o)
+		}
+	}
+	var o Orientation
+	if err := o.Scan("x"); err == nil {
+		t.Error("expected error for invalid strand")
+	}
+	if Orientation(2).Feat() != feat.NotOriented || Reverse.Feat() != feat.Reverse {
+		t.Error("unexpected feat orientation")
+	}
+}
+
+func TestOrientedFeatureScan(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	db.MustExec("CREATE TABLE s (rname TEXT, start INTEGER, stop INTEGER, " +
+		"copy_number INTEGER, strand TEXT)")
+	db.MustExec("INSERT INTO s VALUES ('chr1', 1, 2, 1, '+'), " +
+		"('chr1', 3, 4, 1, '-'), ('chr1', 5, 6, 1, '.')")
+	var recs []OrientedFeature
+	if err := db.Select(&recs, "SELECT * FROM s ORDER BY start"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Orientation{Forward, Reverse, Unknown}
+	if len(recs) != len(expected) {
+		t.Fatalf("expected %d records, actual %d", len(expected), len(recs))
+	}
+	for i, r := range recs {
+		if r.Strand() != expected[i] {
+			t.Errorf("record %d: expected strand %s, actual %s", i, expected[i], r.Strand())
+		}
+	}
+}
+
+func TestStrandPolicy(t *testing.T) {
+	for _, s := range []string{"error", "skip", "unknown"} {
+		p, err := ParseStrandPolicy(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &OrientedFeature{Orient: Orientation(2)}
+		keep, err := p.Check(r)
+		switch p {
+		case StrandError:
+			if err == nil {
+				t.Error("expected error")
+			}
+		case StrandSkip:
+			if keep || err != nil {
+				t.Errorf("expected skip, got %v, %v", keep, err)
+			}
+		case StrandUnknown:
+			if !keep || err != nil || r.Strand() != Unknown {
+				t.Errorf("expected coercion to unknown, got %v, %v, %d", keep, err, r.Orient)
+			}
+		}
+		r.Orient = Reverse
+		if keep, err := p.Check(r); !keep || err != nil {
+			t.Errorf("%s: expected valid strand to be kept", s)
+		}
+	}
+}
+
+func TestStrandPolicyFilter(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	db.MustExec("CREATE TABLE s (start INTEGER, strand INTEGER)")
+	db.MustExec("INSERT INTO s VALUES (1, 1), (2, -1), (3, 0), (4, NULL), (5, 2)")
+
+	if f, n, err := StrandUnknown.Filter(db, "s", "start > 1"); f != "start > 1" || n != 0 || err != nil {
+		t.Errorf("unknown: expected unchanged filter and no count, actual %q, %d, %v", f, n, err)
+	}
+	if _, n, err := StrandError.Filter(db, "s", ""); err == nil || n != 3 {
+		t.Errorf("error: expected error for 3 records, actual %d, %v", n, err)
+	}
+	if _, _, err := StrandError.Filter(db, "s", "start < 3"); err != nil {
+		t.Errorf("error: expected no error for valid records, actual %v", err)
+	}
+	f, n, err := StrandSkip.Filter(db, "s", "start > 1")
+	if err != nil || n != 3 {
+		t.Fatalf("skip: expected 3 records, actual %d, %v", n, err)
+	}
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM s WHERE "+f); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 1 {
+		t.Errorf("skip: expected 1 record after filter, actual %d", cnt)
+	}
+}
+
+func TestFlagStrand(t *testing.T) {
+	tests := []struct {
+		Flag   int
+		Strand Orientation
+	}{
+		{Flag: 0, Strand: Forward},
+		{Flag: 16, Strand: Reverse},
+		{Flag: 99, Strand: Forward},
+		{Flag: 83, Strand: Reverse},
+		{Flag: 4, Strand: Unknown},
+	}
+	for _, tt := range tests {
+		if got := FlagStrand(tt.Flag); got != tt.Strand {
+			t.Errorf("FlagStrand(%d): expected %d, actual %d", tt.Flag, tt.Strand, got)
+		}
+	}
+}
+
+func TestStrandFlag(t *testing.T) {
+	tests := []struct {
+		Flag   int
+		Strand Orientation
+		Out    int
+	}{
+		{Flag: 0, Strand: Reverse, Out: 16},
+		{Flag: 83, Strand: Forward, Out: 67},
+		{Flag: 99, Strand: Forward, Out: 99},
+		{Flag: 16, Strand: Unknown, Out: 16},
+	}
+	for _, tt := range tests {
+		if got := StrandFlag(tt.Flag, tt.Strand); got != tt.Out {
+			t.Errorf("StrandFlag(%d, %d): expected %d, actual %d", tt.Flag, tt.Strand, tt.Out, got)
+		}
+		if tt.Strand.Known() && FlagStrand(StrandFlag(tt.Flag, tt.Strand)) != tt.Strand {
+			t.Errorf("StrandFlag(%d, %d): strand not preserved", tt.Flag, tt.Strand)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package htsdb
+
+import (
+	"fmt"
+)
+
+// PileupSymbols are the symbols counted by Pileup in the order of
+// PileupColumn.Counts: the four bases, any other base and a deletion.
+const PileupSymbols = "ACGTN-"
+
+// Pileup counts the bases that aligned reads place on each position of a
+// region e.g. an amplicon. Insertions and clipped bases are ignored since they
+// have no reference position.
+type Pileup struct {
+	region Region
+	counts [][len(PileupSymbols)]float64
+}
+
+// NewPileup returns an empty Pileup for region r.
+func NewPileup(r Region) *Pileup {
+	return &Pileup{region: r, counts: make([][len(PileupSymbols)]float64, r.Stop-r.Start+1)}
+}
+
+// Add adds the bases of a read with sequence seq aligned with cigar at the
+// 1-based position pos of the reference of the pileup, weighted by n e.g. the
+// copy number. Bases outside the region are ignored, as are reads without a
+// sequence i.e. "*".
+func (p *Pileup) Add(pos int, cigar, seq string, n float64) error {
+	if seq == "*" || seq == "" {
+		return nil
+	}
+	ref, q, num, digits := pos-1, 0, 0, false
+	for i := 0; i < len(cigar); i++ {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			num, digits = num*10+int(c-'0'), true
+			continue
+		}
+		if !digits {
+			return fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+		}
+		switch c {
+		case 'M', '=', 'X':
+			if q+num > len(seq) {
+				return fmt.Errorf("htsdb: CIGAR %q is longer than sequence", cigar)
+			}
+			for j := 0; j < num; j++ {
+				p.add(ref+j, symbolIndex(seq[q+j]), n)
+			}
+			ref, q = ref+num, q+num
+		case 'D':
+			for j := 0; j < num; j++ {
+				p.add(ref+j, len(PileupSymbols)-1, n)
+			}
+			ref += num
+		case 'N':
+			ref += num
+		case 'I', 'S':
+			q += num
+		case 'H', 'P':
+		default:
+			return fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+		}
+		num, digits = 0, false
+	}
+	if digits {
+		return fmt.Errorf("htsdb: invalid CIGAR %q", cigar)
+	}
+	return nil
+}
+
+// add adds n to symbol sym at the 0-based reference position pos.
+func (p *Pileup) add(pos, sym int, n float64) {
+	if pos < p.region.Start || pos > p.region.Stop {
+		return
+	}
+	p.counts[pos-p.region.Start][sym] += n
+}
+
+// symbolIndex returns the index of base b in PileupSymbols.
+func symbolIndex(b byte) int {
+	switch b {
+	case 'A', 'a':
+		return 0
+	case 'C', 'c':
+		return 1
+	case 'G', 'g':
+		return 2
+	case 'T', 't':
+		return 3
+	}
+	return 4
+}
+
+// Columns returns the columns of the pileup, one per position of the region.
+func (p *Pileup) Columns() []PileupColumn {
+	cols := make([]PileupColumn, len(p.counts))
+	for i, c := range p.counts {
+		cols[i] = PileupColumn{Rname: p.region.Rname, Pos: p.region.Start + i, Counts: c}
+	}
+	return cols
+}
+
+// PileupColumn holds the counts of the symbols at a 0-based reference
+// position in the order of PileupSymbols.
+type PileupColumn struct {
+	Rname  string
+	Pos    int
+	Counts [len(PileupSymbols)]float64
+}
+
+// Depth returns the total count of the column, including deletions.
+func (c PileupColumn) Depth() float64 {
+	var d float64
+	for _, v := range c.Counts {
+		d += v
+	}
+	return d
+}
+
+// Fraction returns the fraction of the depth of the column contributed by
+// the symbol at index i of PileupSymbols, or 0 if the depth is 0.
+func (c PileupColumn) Fraction(i int) float64 {
+	d := c.Depth()
+	if d == 0 {
+		return 0
+	}
+	return c.Counts[i] / d
+}
+
+// Consensus returns the most frequent symbol of the column and its fraction.
+// Ties are resolved in the order of PileupSymbols. It returns 'N' if the
+// depth is below minDepth or the fraction of the most frequent symbol is
+// below minFrac.
+func (c PileupColumn) Consensus(minDepth, minFrac float64) (byte, float64) {
+	best := 0
+	for i, v := range c.Counts {
+		if v > c.Counts[best] {
+			best = i
+		}
+	}
+	d := c.Depth()
+	if d == 0 || d < minDepth {
+		return 'N', 0
+	}
+	frac := c.Counts[best] / d
+	if frac < minFrac {
+		return 'N', frac
+	}
+	return PileupSymbols[best], frac
+}
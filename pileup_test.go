@@ -0,0 +1,63 @@
+package htsdb
+
+import (
+	"testing"
+)
+
+func TestPileup(t *testing.T) {
+	p := NewPileup(Region{Rname: "chr1", Start: 10, Stop: 15})
+	reads := []struct {
+		Pos   int
+		Cigar string
+		Seq   string
+		N     float64
+	}{
+		{11, "6M", "ACGTAC", 2},
+		{11, "2M1D3M", "ACTAC", 1},
+		{9, "2S3M1I2M", "GGACGCTA", 1},
+		{13, "4M", "*", 5},
+	}
+	for _, r := range reads {
+		if err := p.Add(r.Pos, r.Cigar, r.Seq, r.N); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cols := p.Columns()
+	if len(cols) != 6 {
+		t.Fatalf("expected 6 columns, actual %d", len(cols))
+	}
+	expected := []struct {
+		Depth float64
+		Base  byte
+	}{{4, 'A'}, {4, 'C'}, {4, 'G'}, {3, 'T'}, {3, 'A'}, {3, 'C'}}
+	for i, e := range expected {
+		c := cols[i]
+		if c.Pos != 10+i || c.Rname != "chr1" {
+			t.Errorf("column %d: unexpected position %s:%d", i, c.Rname, c.Pos)
+		}
+		if c.Depth() != e.Depth {
+			t.Errorf("column %d: expected depth %v, actual %v", i, e.Depth, c.Depth())
+		}
+		if b, _ := c.Consensus(0, 0); b != e.Base {
+			t.Errorf("column %d: expected consensus %c, actual %c", i, e.Base, b)
+		}
+	}
+	if f := cols[2].Fraction(5); f != 0.25 {
+		t.Errorf("expected deletion fraction 0.25, actual %v", f)
+	}
+	if b, frac := cols[2].Consensus(0, 0.8); b != 'N' || frac != 0.5 {
+		t.Errorf("expected N below fraction threshold, actual %c %v", b, frac)
+	}
+	if b, _ := cols[0].Consensus(5, 0); b != 'N' {
+		t.Errorf("expected N below depth threshold, actual %c", b)
+	}
+}
+
+func TestPileupErrors(t *testing.T) {
+	p := NewPileup(Region{Rname: "chr1", Start: 0, Stop: 9})
+	for _, cigar := range []string{"5Q", "M", "5", "10M"} {
+		if err := p.Add(1, cigar, "ACGTA", 1); err == nil {
+			t.Errorf("%s: expected error", cigar)
+		}
+	}
+}
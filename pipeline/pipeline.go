@@ -0,0 +1,270 @@
+// Package pipeline runs a declarative list of analysis steps on the records of
+// a database table in a single scan e.g. to compute several QC results of a
+// sample without reading the table once per analysis. Records are read
+// sorted by reference and the records of each reference are held in memory
+// and passed through the steps in order. Filter and collapse steps change the
+// records that later steps see; coverage and metagene steps accumulate their
+// results and write them when the pipeline is closed.
+//
+// Pipelines are described in YAML e.g.
+//
+//	db: sample.db
+//	table: sample
+//	steps:
+//	  - filter: {where: "mapq >= 10", min-len: 18}
+//	  - collapse: {anchor: 5p}
+//	  - coverage: {out: sample.bedgraph}
+//	  - metagene: {features: genes.bed, bins: 50, flank: 500, out: metagene.tsv}
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Spec is the description of a pipeline.
+type Spec struct {
+	// DB is the database file or, for drivers other than SQLite, the
+	// connection string.
+	DB string `yaml:"db"`
+	// Driver is the database driver; sqlite3 if empty.
+	Driver string `yaml:"driver"`
+	// Table is the record table; sample if empty.
+	Table string `yaml:"table"`
+	// ColMap maps canonical to foreign column names as htsdb.ParseColumnMap.
+	ColMap string `yaml:"col-map"`
+	// CopyNumber weights records by copy number; true if unset.
+	CopyNumber *bool `yaml:"copy-number"`
+	// Steps are the steps of the pipeline in order.
+	Steps []StepSpec `yaml:"steps"`
+}
+
+// StepSpec is a single step of a pipeline. Exactly one field must be set.
+type StepSpec struct {
+	Filter   *FilterSpec   `yaml:"filter"`
+	Collapse *CollapseSpec `yaml:"collapse"`
+	Coverage *CoverageSpec `yaml:"coverage"`
+	Metagene *MetageneSpec `yaml:"metagene"`
+}
+
+// ReadSpec reads the pipeline description in the YAML file f. Unknown keys are
+// an error so that misspelled options are not ignored. The description is
+// validated by New.
+func ReadSpec(f string) (Spec, error) {
+	var s Spec
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return s, err
+	}
+	if err = yaml.UnmarshalStrict(data, &s); err != nil {
+		return s, fmt.Errorf("pipeline: %s: %v", f, err)
+	}
+	if s.Driver == "" {
+		s.Driver = htsdb.SQLite
+	}
+	if s.Table == "" {
+		s.Table = "sample"
+	}
+	return s, nil
+}
+
+// Validate returns an error if s is not a valid pipeline. SQL filters must
+// precede all other steps as they are applied by the database query.
+func (s Spec) Validate() error {
+	if s.DB == "" {
+		return fmt.Errorf("pipeline: no database")
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("pipeline: no steps")
+	}
+	leading := true
+	for i, st := range s.Steps {
+		name, err := st.name()
+		if err != nil {
+			return fmt.Errorf("pipeline: step %d: %v", i+1, err)
+		}
+		if name != "filter" {
+			leading = false
+			continue
+		}
+		if st.Filter.Where != "" && !leading {
+			return fmt.Errorf("pipeline: step %d: SQL filters must precede other steps", i+1)
+		}
+	}
+	return nil
+}
+
+// name returns the name of the single step set in s.
+func (s StepSpec) name() (string, error) {
+	var names []string
+	if s.Filter != nil {
+		names = append(names, "filter")
+	}
+	if s.Collapse != nil {
+		names = append(names, "collapse")
+	}
+	if s.Coverage != nil {
+		names = append(names, "coverage")
+	}
+	if s.Metagene != nil {
+		names = append(names, "metagene")
+	}
+	if len(names) != 1 {
+		return "", fmt.Errorf("expected one of filter, collapse, coverage or metagene, found %q",
+			strings.Join(names, ", "))
+	}
+	return names[0], nil
+}
+
+// Read is a record held in memory by a pipeline. Start and Stop are in
+// htsdb.HtsdbCoords.
+type Read struct {
+	Rname      string            `db:"rname"`
+	Start      int               `db:"start"`
+	Stop       int               `db:"stop"`
+	Strand     htsdb.Orientation `db:"strand"`
+	CopyNumber int               `db:"copy_number"`
+}
+
+// Len returns the length of r.
+func (r Read) Len() int { return r.Stop - r.Start + 1 }
+
+// Step is a step of a pipeline. Process is called once for each reference
+// with its reads sorted by start and returns the reads seen by the next step.
+// Close is called once all references are processed.
+type Step interface {
+	Process(rname string, reads []Read) ([]Read, error)
+	Close() error
+}
+
+// Pipeline is a runnable pipeline.
+type Pipeline struct {
+	spec  Spec
+	where []string
+	steps []Step
+}
+
+// New returns the pipeline described by s. Steps that write files create
+// them; Close the pipeline to release them.
+func New(s Spec) (*Pipeline, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{spec: s}
+	for i, st := range s.Steps {
+		var step Step
+		var err error
+		switch {
+		case st.Filter != nil:
+			if st.Filter.Where != "" {
+				p.where = append(p.where, st.Filter.Where)
+			}
+			step = st.Filter
+		case st.Collapse != nil:
+			step, err = newCollapse(*st.Collapse)
+		case st.Coverage != nil:
+			step, err = newCoverage(*st.Coverage)
+		case st.Metagene != nil:
+			step, err = newMetagene(*st.Metagene)
+		}
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("pipeline: step %d: %v", i+1, err)
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// Run scans the table of the pipeline in db once and passes the reads of each
+// reference through the steps. It does not close the pipeline.
+func (p *Pipeline) Run(db *sqlx.DB) error {
+	return p.RunContext(context.Background(), db)
+}
+
+// RunContext is like Run but the scan runs with ctx. The SQL filters are
+// applied in addition to those of the filter steps e.g. to restrict the scan
+// to regions.
+func (p *Pipeline) RunContext(ctx context.Context, db *sqlx.DB, filters ...string) error {
+	cols, err := htsdb.ParseColumnMap(p.spec.ColMap)
+	if err != nil {
+		return err
+	}
+	copyNum := p.spec.CopyNumber == nil || *p.spec.CopyNumber
+	if _, err = cols.ResolveCopyNumber(db, p.spec.Table, copyNum); err != nil {
+		return err
+	}
+	coords, err := htsdb.SelectCoordsContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	b := htsdb.OrientedFeatureBuilder.From(cols.Table(p.spec.Table)).
+		OrderBy("rname", "start")
+	for _, w := range append(p.where, filters...) {
+		if w != "" {
+			b = b.Where(w)
+		}
+	}
+	query, _, err := b.ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var reads []Read
+	for rows.Next() {
+		var r Read
+		if err = rows.StructScan(&r); err != nil {
+			return err
+		}
+		r.Start, r.Stop = coords.ToHtsdb(r.Start, r.Stop)
+		if len(reads) > 0 && reads[0].Rname != r.Rname {
+			if err = p.process(reads); err != nil {
+				return err
+			}
+			reads = reads[:0]
+		}
+		reads = append(reads, r)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(reads) > 0 {
+		return p.process(reads)
+	}
+	return nil
+}
+
+// process passes the reads of a single reference through the steps.
+func (p *Pipeline) process(reads []Read) error {
+	rname := reads[0].Rname
+	var err error
+	for _, s := range p.steps {
+		if reads, err = s.Process(rname, reads); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the steps of the pipeline, writing their results. It returns
+// the first error.
+func (p *Pipeline) Close() error {
+	var first error
+	for _, s := range p.steps {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mnsmar/htsdb/v2"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Steps []StepSpec
+		OK    bool
+	}{
+		{[]StepSpec{{Filter: &FilterSpec{Where: "mapq > 10"}}, {Collapse: &CollapseSpec{}}}, true},
+		{[]StepSpec{{Collapse: &CollapseSpec{}}, {Filter: &FilterSpec{MinLen: 18}}}, true},
+		{[]StepSpec{{Collapse: &CollapseSpec{}}, {Filter: &FilterSpec{Where: "mapq > 10"}}}, false},
+		{[]StepSpec{{Collapse: &CollapseSpec{}, Coverage: &CoverageSpec{}}}, false},
+		{[]StepSpec{{}}, false},
+		{nil, false},
+	}
+	for i, tt := range tests {
+		err := Spec{DB: "sample.db", Steps: tt.Steps}.Validate()
+		if (err == nil) != tt.OK {
+			t.Errorf("test %d: expected ok %t, actual error %v", i, tt.OK, err)
+		}
+	}
+}
+
+func TestFilterCollapse(t *testing.T) {
+	reads := []Read{
+		{"chr1", 10, 29, htsdb.Forward, 1},
+		{"chr1", 10, 29, htsdb.Forward, 2},
+		{"chr1", 10, 25, htsdb.Forward, 1},
+		{"chr1", 12, 29, htsdb.Reverse, 4},
+		{"chr1", 14, 29, htsdb.Reverse, 1},
+		{"chr1", 40, 45, htsdb.Forward, 1},
+		{"chr1", 50, 55, htsdb.Unknown, 1},
+		{"chr1", 50, 55, htsdb.Unknown, 2},
+	}
+	f := &FilterSpec{MinLen: 10}
+	got, _ := f.Process("chr1", append([]Read{}, reads...))
+	if len(got) != 5 {
+		t.Errorf("expected 5 reads after filter, actual %d", len(got))
+	}
+
+	c, _ := newCollapse(CollapseSpec{})
+	got, _ = c.Process("chr1", append([]Read{}, reads...))
+	if len(got) != 6 || got[0].CopyNumber != 3 {
+		t.Errorf("expected 6 reads with first copy number 3, actual %v", got)
+	}
+
+	c, _ = newCollapse(CollapseSpec{Anchor: "3p"})
+	got, _ = c.Process("chr1", append([]Read{}, reads...))
+	expected := []Read{
+		{"chr1", 10, 29, htsdb.Forward, 3},
+		{"chr1", 10, 25, htsdb.Forward, 1},
+		{"chr1", 12, 29, htsdb.Reverse, 4},
+		{"chr1", 14, 29, htsdb.Reverse, 1},
+		{"chr1", 40, 45, htsdb.Forward, 1},
+		{"chr1", 50, 55, htsdb.Unknown, 3},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, actual %v", expected, got)
+	}
+	if _, err := newCollapse(CollapseSpec{Anchor: "5'"}); err == nil {
+		t.Error("expected error for invalid anchor")
+	}
+}
+
+func TestCoverageMetagene(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bed := filepath.Join(dir, "feats.bed")
+	err = ioutil.WriteFile(bed, []byte("chr1\t10\t20\tf1\t0\t+\nchr1\t30\t40\tf2\t0\t-\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cov, err := newCoverage(CoverageSpec{Out: filepath.Join(dir, "cov.bedgraph")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg, err := newMetagene(MetageneSpec{Features: bed, Bins: 2, Flank: 2,
+		Out: filepath.Join(dir, "mg.tsv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads := []Read{
+		{"chr1", 9, 12, htsdb.Forward, 1},
+		{"chr1", 10, 12, htsdb.Forward, 2},
+		{"chr1", 35, 41, htsdb.Reverse, 1},
+	}
+	for _, s := range []Step{cov, mg} {
+		if _, err = s.Process("chr1", reads); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "cov.bedgraph"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "chr1\t9\t10\t1\nchr1\t10\t13\t3\nchr1\t35\t42\t1\n"
+	if string(out) != expected {
+		t.Errorf("expected coverage %q, actual %q", expected, out)
+	}
+	// the 5' end of the reverse read is upstream of the reverse feature.
+	if !reflect.DeepEqual(mg.up, []float64{1, 1}) || !reflect.DeepEqual(mg.body, []float64{2, 0}) ||
+		!reflect.DeepEqual(mg.down, []float64{0, 0}) {
+		t.Errorf("unexpected profile up:%v body:%v down:%v", mg.up, mg.body, mg.down)
+	}
+}
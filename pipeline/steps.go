@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/mnsmar/htsdb/v2"
+)
+
+// FilterSpec selects the reads seen by later steps. Where is an SQL filter
+// applied by the database query; the other conditions are applied in memory
+// and are ignored if 0.
+type FilterSpec struct {
+	Where     string `yaml:"where"`
+	MinLen    int    `yaml:"min-len"`
+	MaxLen    int    `yaml:"max-len"`
+	MinCopies int    `yaml:"min-copies"`
+}
+
+// Process returns the reads that pass the in-memory conditions of f.
+func (f *FilterSpec) Process(rname string, reads []Read) ([]Read, error) {
+	kept := reads[:0]
+	for _, r := range reads {
+		l := r.Len()
+		if (f.MinLen > 0 && l < f.MinLen) || (f.MaxLen > 0 && l > f.MaxLen) ||
+			(f.MinCopies > 0 && r.CopyNumber < f.MinCopies) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+// Close does nothing.
+func (f *FilterSpec) Close() error { return nil }
+
+// CollapseSpec merges the reads on the same strand that have the same
+// coordinates or, if Anchor is set, the same anchor position ("5p", "3p" or
+// "mid") into the first of them with the sum of their copy numbers. Reads of
+// unknown strand are only merged with identical reads.
+type CollapseSpec struct {
+	Anchor string `yaml:"anchor"`
+}
+
+type collapse struct {
+	anchor htsdb.Anchor
+	byPos  bool
+}
+
+// collapseKey identifies the reads merged by a collapse step.
+type collapseKey struct {
+	pos, stop int
+	strand    htsdb.Orientation
+}
+
+func newCollapse(s CollapseSpec) (*collapse, error) {
+	if s.Anchor == "" {
+		return &collapse{}, nil
+	}
+	a, err := htsdb.ParseAnchor(s.Anchor)
+	if err != nil {
+		return nil, err
+	}
+	return &collapse{anchor: a, byPos: true}, nil
+}
+
+// Process returns the collapsed reads in order of their first read.
+func (c *collapse) Process(rname string, reads []Read) ([]Read, error) {
+	index := make(map[collapseKey]int, len(reads))
+	kept := reads[:0]
+	for _, r := range reads {
+		k := collapseKey{pos: r.Start, stop: r.Stop, strand: r.Strand}
+		if c.byPos {
+			rng := htsdb.Range{StartPos: r.Start, StopPos: r.Stop}
+			if pos, ok := htsdb.PosAtOK(&rng, r.Strand.Feat(), c.anchor, 0); ok {
+				k = collapseKey{pos: pos, strand: r.Strand}
+			}
+		}
+		if i, ok := index[k]; ok {
+			kept[i].CopyNumber += r.CopyNumber
+			continue
+		}
+		index[k] = len(kept)
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+// Close does nothing.
+func (c *collapse) Close() error { return nil }
+
+// CoverageSpec writes the per-base coverage of the reads, weighted by copy
+// number, to Out in bedGraph format. Strand, "+" or "-", restricts the
+// coverage to the reads on that strand.
+type CoverageSpec struct {
+	Out    string `yaml:"out"`
+	Strand string `yaml:"strand"`
+}
+
+type coverage struct {
+	strand htsdb.Orientation
+	f      *os.File
+	w      *htsdb.BedGraphWriter
+}
+
+func newCoverage(s CoverageSpec) (*coverage, error) {
+	c := &coverage{}
+	switch s.Strand {
+	case "":
+	case "+":
+		c.strand = htsdb.Forward
+	case "-":
+		c.strand = htsdb.Reverse
+	default:
+		return nil, fmt.Errorf("invalid strand %q", s.Strand)
+	}
+	if s.Out == "" {
+		return nil, fmt.Errorf("coverage requires out")
+	}
+	f, err := os.Create(s.Out)
+	if err != nil {
+		return nil, err
+	}
+	c.f, c.w = f, htsdb.NewBedGraphWriter(f)
+	return c, nil
+}
+
+// Process adds the coverage of reads on rname to the output and returns
+// reads unchanged.
+func (c *coverage) Process(rname string, reads []Read) ([]Read, error) {
+	if len(reads) == 0 {
+		return reads, nil
+	}
+	from, to := reads[0].Start, reads[0].Stop
+	for _, r := range reads {
+		if r.Stop > to {
+			to = r.Stop
+		}
+	}
+	// depth changes at the start and after the stop of each read.
+	delta := make([]float64, to-from+2)
+	for _, r := range reads {
+		if c.strand != 0 && r.Strand != c.strand {
+			continue
+		}
+		delta[r.Start-from] += float64(r.CopyNumber)
+		delta[r.Stop-from+1] -= float64(r.CopyNumber)
+	}
+	var depth float64
+	for i := 0; i <= to-from; i++ {
+		depth += delta[i]
+		if err := c.w.Add(rname, from+i, depth); err != nil {
+			return nil, err
+		}
+	}
+	return reads, nil
+}
+
+// Close flushes and closes the output.
+func (c *coverage) Close() error {
+	err := c.w.Flush()
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MetageneSpec writes the profile of read anchors ("5p" by default) along
+// the features of a BED or GTF file to Out. Feature bodies are scaled to Bins
+// bins (100 by default) and Flank bases upstream and downstream are profiled
+// per base, all relative to the feature orientation. Type selects the
+// features of GTF files e.g. "gene". With Stranded only reads on the strand of
+// oriented features are counted.
+type MetageneSpec struct {
+	Features string `yaml:"features"`
+	Type     string `yaml:"type"`
+	Bins     int    `yaml:"bins"`
+	Flank    int    `yaml:"flank"`
+	Anchor   string `yaml:"anchor"`
+	Stranded bool   `yaml:"stranded"`
+	Out      string `yaml:"out"`
+}
+
+type metagene struct {
+	spec     MetageneSpec
+	anchor   htsdb.Anchor
+	idx      htsdb.AnnotationIndex
+	features int
+	up, down []float64
+	body     []float64
+}
+
+func newMetagene(s MetageneSpec) (*metagene, error) {
+	if s.Features == "" || s.Out == "" {
+		return nil, fmt.Errorf("metagene requires features and out")
+	}
+	if s.Bins == 0 {
+		s.Bins = 100
+	}
+	if s.Bins < 0 || s.Flank < 0 {
+		return nil, fmt.Errorf("bins and flank must not be negative")
+	}
+	if s.Anchor == "" {
+		s.Anchor = "5p"
+	}
+	a, err := htsdb.ParseAnchor(s.Anchor)
+	if err != nil {
+		return nil, err
+	}
+	anns, err := htsdb.ReadAnnotations(s.Features, s.Type, "gene_name")
+	if err != nil {
+		return nil, err
+	}
+	return &metagene{spec: s, anchor: a, idx: htsdb.NewAnnotationIndex(anns),
+		features: len(anns), up: make([]float64, s.Flank),
+		down: make([]float64, s.Flank), body: make([]float64, s.Bins)}, nil
+}
+
+// Process adds the anchors of reads on rname to the profile and returns reads
+// unchanged. Reads of unknown strand are anchored as forward reads.
+func (m *metagene) Process(rname string, reads []Read) ([]Read, error) {
+	flank := m.spec.Flank
+	for _, r := range reads {
+		o := feat.Forward
+		if r.Strand.Known() {
+			o = r.Strand.Feat()
+		}
+		rng := htsdb.Range{StartPos: r.Start, StopPos: r.Stop}
+		pos := htsdb.PosAt(&rng, o, m.anchor, 0)
+		strand := 0
+		if m.spec.Stranded {
+			strand = int(r.Strand)
+		}
+		for _, a := range m.idx.Overlapping(rname, pos-flank, pos+flank, strand) {
+			if m.spec.Stranded && a.Strand == 0 {
+				continue
+			}
+			// offset of pos from the feature head along the feature.
+			off, length := pos-a.Start, a.Stop-a.Start+1
+			if a.Strand == -1 {
+				off = a.Stop - pos
+			}
+			v := float64(r.CopyNumber)
+			switch {
+			case off < 0:
+				m.up[flank+off] += v
+			case off >= length:
+				m.down[off-length] += v
+			default:
+				m.body[off*m.spec.Bins/length] += v
+			}
+		}
+	}
+	return reads, nil
+}
+
+// Close writes the profile with the total and the mean count per feature at
+// each upstream base, body bin and downstream base.
+func (m *metagene) Close() error {
+	f, err := os.Create(m.spec.Out)
+	if err != nil {
+		return err
+	}
+	w := htsdb.NewResultWriter(f, []string{"section", "bin"}, []string{"count", "mean"}, false)
+	write := func(section string, vals []float64, first int) error {
+		for i, v := range vals {
+			mean := 0.0
+			if m.features > 0 {
+				mean = v / float64(m.features)
+			}
+			if err := w.WriteRow(section, first+i, v, mean); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = w.WriteHeader()
+	if err == nil {
+		err = write("upstream", m.up, -m.spec.Flank)
+	}
+	if err == nil {
+		err = write("body", m.body, 0)
+	}
+	if err == nil {
+		err = write("downstream", m.down, 1)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package htsdb
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// StartProfiling starts CPU profiling and execution tracing to the files cpu
+// and trc respectively. Empty file names disable the corresponding output.
+// The returned function stops profiling and writes a heap profile to mem if
+// it is not empty; it should be deferred by the caller.
+func StartProfiling(cpu, mem, trc string) (func() error, error) {
+	var cpuF, trcF *os.File
+	closeAll := func() {
+		if cpuF != nil {
+			pprof.StopCPUProfile()
+			cpuF.Close()
+		}
+		if trcF != nil {
+			trace.Stop()
+			trcF.Close()
+		}
+	}
+
+	var err error
+	if cpu != "" {
+		if cpuF, err = os.Create(cpu); err != nil {
+			return nil, err
+		}
+		if err = pprof.StartCPUProfile(cpuF); err != nil {
+			cpuF.Close()
+			return nil, err
+		}
+	}
+	if trc != "" {
+		if trcF, err = os.Create(trc); err != nil {
+			closeAll()
+			return nil, err
+		}
+		if err = trace.Start(trcF); err != nil {
+			trcF.Close()
+			trcF = nil
+			closeAll()
+			return nil, err
+		}
+	}
+
+	return func() error {
+		closeAll()
+		if mem == "" {
+			return nil
+		}
+		f, err := os.Create(mem)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		runtime.GC()
+		return pprof.WriteHeapProfile(f)
+	}, nil
+}
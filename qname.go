@@ -0,0 +1,35 @@
+package htsdb
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// QnameIndexName returns the name of the index on the read names of table.
+func QnameIndexName(table string) string {
+	return table + "_qname_idx"
+}
+
+// CreateQnameIndex creates an index on column col of table, which holds the
+// read names, if it does not exist. It makes SelectByName fast on large
+// tables.
+func CreateQnameIndex(db *sqlx.DB, table, col string) error {
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS " + QnameIndexName(table) +
+		" ON " + table + " (" + col + ")")
+	return err
+}
+
+// SelectByName selects into dest, a pointer to a slice, all records of b with
+// read name qname e.g. all alignments of a read. b must select from the table
+// and the read name column must be exposed as qname.
+//
+// e.g.
+// var recs []SamRecord
+// err := SelectByName(db, SamRecordBuilder.From("sample"), "read1", &recs)
+func SelectByName(db *sqlx.DB, b squirrel.SelectBuilder, qname string, dest interface{}) error {
+	query, args, err := b.Where("qname = ?", qname).ToSql()
+	if err != nil {
+		return err
+	}
+	return db.Select(dest, db.Rebind(query), args...)
+}
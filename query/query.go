@@ -0,0 +1,405 @@
+// Package query exports the database access of htsdb: connections, readers of
+// records, the SQL builders of the record types and the filters shared by the
+// htsdb tools. It is part of the stable API of htsdb together with packages
+// record, analysis, importer and export: its types and constants are aliases
+// of those of package htsdb and its functions call those of package htsdb.
+// They are kept compatible across minor versions of module
+// github.com/mnsmar/htsdb/v2.
+package query
+
+import (
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/mnsmar/htsdb/v2"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+// Database drivers.
+const (
+	SQLite   = htsdb.SQLite
+	Postgres = htsdb.Postgres
+	MySQL    = htsdb.MySQL
+	DuckDB   = htsdb.DuckDB
+)
+
+// Drivers returns the names of the supported database drivers. DuckDB needs
+// cgo and is not listed when building with the purego tag.
+func Drivers() []string {
+	return append([]string(nil), htsdb.Drivers...)
+}
+
+// Connect opens and pings the database dsn with driver, one of Drivers. For
+// SQLite and DuckDB dsn is the database file, for PostgreSQL a connection string e.g.
+// "postgres://user@host/lab?sslmode=disable" and for MySQL a DSN e.g.
+// "user:pass@tcp(host:3306)/lab". MySQL sessions run in ANSI mode so that
+// double quoted identifiers and || behave as in the other databases. Queries
+// with positional arguments must be rebound with the Rebind method of the
+// database and squirrel builders can use Placeholder.
+func Connect(driver, dsn string) (*sqlx.DB, error) {
+	return htsdb.Connect(driver, dsn)
+}
+
+// Placeholder returns the squirrel placeholder format of driver e.g. to
+// build queries with squirrel.StatementBuilder.PlaceholderFormat.
+func Placeholder(driver string) squirrel.PlaceholderFormat {
+	return htsdb.Placeholder(driver)
+}
+
+// OpenReadOnly opens the SQLite database file path read-only with a pool of
+// up to conns connections that are kept open, so that each of conns
+// concurrent workers reads through its own connection instead of waiting for
+// a shared one. Read-only connections do not take write locks and are thus
+// not serialized by SQLite.
+func OpenReadOnly(path string, conns int) (*sqlx.DB, error) {
+	return htsdb.OpenReadOnly(path, conns)
+}
+
+// ReadOnlyDSN returns the data source name that opens the SQLite database
+// file path read-only.
+func ReadOnlyDSN(path string) string {
+	return htsdb.ReadOnlyDSN(path)
+}
+
+// Sources of records.
+type (
+	Reader       = htsdb.Reader
+	RecordSource = htsdb.RecordSource
+	MultiSource  = htsdb.MultiSource
+	SliceSource  = htsdb.SliceSource
+	BAMReader    = htsdb.BAMReader
+)
+
+// NewReader returns a new reader that reads from db by runs the given query
+// and maps rows into dest.
+func NewReader(db *sql.DB, driverName string, dest interface{}, query string) (*Reader, error) {
+	return htsdb.NewReader(db, driverName, dest, query)
+}
+
+// NewReaderContext is like NewReader but the query runs with ctx. Once ctx
+// is cancelled or its deadline passes, Next returns false and Error returns
+// the error of ctx.
+func NewReaderContext(ctx context.Context, db *sql.DB, driverName string, dest interface{}, query string) (*Reader, error) {
+	return htsdb.NewReaderContext(ctx, db, driverName, dest, query)
+}
+
+// OpenReader is like NewReader but connects to the database dsn with driver,
+// one of Drivers, as Connect does. Closing the reader closes the connection.
+func OpenReader(driver, dsn string, dest interface{}, query string) (*Reader, error) {
+	return htsdb.OpenReader(driver, dsn, dest, query)
+}
+
+// NewMultiSource returns a MultiSource that reads srcs in order.
+func NewMultiSource(srcs ...RecordSource) *MultiSource {
+	return htsdb.NewMultiSource(srcs...)
+}
+
+// NewSliceSource returns a SliceSource for recs that align on refs. If refs
+// is nil, References derives them from the records, which must then be
+// *Feature or *OrientedFeature.
+func NewSliceSource(recs []interface{}, refs []record.Reference) *SliceSource {
+	return htsdb.NewSliceSource(recs, refs)
+}
+
+// NewBAMReader returns a BAMReader that reads into dest, a pointer to a
+// struct, the alignments of the BAM file path that overlap regions. Regions
+// are merged and read sorted by reference name and start, and each alignment
+// is read once even if it overlaps several of them. If regions is empty, all
+// alignments are read in file order. Regions require an index in
+// path.bai or, for files ending in .bam, in the same path with extension
+// .bai.
+func NewBAMReader(path string, dest interface{}, regions []Region) (*BAMReader, error) {
+	return htsdb.NewBAMReader(path, dest, regions)
+}
+
+// CountBuilder returns the squirrel select builder of the columns of Count.
+func CountBuilder() squirrel.SelectBuilder {
+	return htsdb.CountBuilder
+}
+
+// RangeBuilder returns the squirrel select builder of the columns of Range.
+func RangeBuilder() squirrel.SelectBuilder {
+	return htsdb.RangeBuilder
+}
+
+// FeatureBuilder returns the squirrel select builder of the columns of
+// Feature.
+func FeatureBuilder() squirrel.SelectBuilder {
+	return htsdb.FeatureBuilder
+}
+
+// OrientedFeatureBuilder returns the squirrel select builder of the columns of
+// OrientedFeature.
+func OrientedFeatureBuilder() squirrel.SelectBuilder {
+	return htsdb.OrientedFeatureBuilder
+}
+
+// SamRecordBuilder returns the squirrel select builder of the columns of
+// SamRecord.
+func SamRecordBuilder() squirrel.SelectBuilder {
+	return htsdb.SamRecordBuilder
+}
+
+// SamRecordColumnsBuilder returns a squirrel select builder like
+// SamRecordBuilder that only reads cols from the database, which avoids
+// reading large columns such as seq and qual when they are not needed. The
+// other SamRecord fields get the SAM value for unavailable information e.g. *
+// for seq or 255 for mapq. An empty cols reads all columns.
+func SamRecordColumnsBuilder(cols []string) (squirrel.SelectBuilder, error) {
+	return htsdb.SamRecordColumnsBuilder(cols)
+}
+
+// FragmentBuilder returns a squirrel select builder whose columns match Range
+// fields but that spans the whole sequenced fragment of paired-end records
+// instead of the alignment of each mate. Each fragment is selected once,
+// through the leftmost mate with positive TLEN. Coordinates follow convention
+// c.
+func FragmentBuilder(c record.Coords) squirrel.SelectBuilder {
+	return htsdb.FragmentBuilder(c)
+}
+
+// ReferenceBuilder returns the squirrel select builder of the columns of
+// Reference.
+func ReferenceBuilder() squirrel.SelectBuilder {
+	return htsdb.ReferenceBuilder
+}
+
+// SQL expressions of the copy number and count of records.
+const (
+	CopyNumberSum   = htsdb.CopyNumberSum
+	CopyNumberTotal = htsdb.CopyNumberTotal
+	RecordCount     = htsdb.RecordCount
+)
+
+// SelectCoords returns the coordinate convention stored in the metadata of
+// db. It returns HtsdbCoords if no convention is stored.
+func SelectCoords(db *sqlx.DB) (record.Coords, error) {
+	return htsdb.SelectCoords(db)
+}
+
+// SelectCoordsContext is like SelectCoords but the queries run with ctx.
+func SelectCoordsContext(ctx context.Context, db *sqlx.DB) (record.Coords, error) {
+	return htsdb.SelectCoordsContext(ctx, db)
+}
+
+// SelectReferences selects the reference sequences from db using
+// squirrel.SelectBuilder. It will return an error if it encounters one.
+//
+// e.g.
+// refs, err := SelectReferences(db, ReferenceBuilder)
+func SelectReferences(db *sqlx.DB, b squirrel.SelectBuilder) ([]record.Reference, error) {
+	return htsdb.SelectReferences(db, b)
+}
+
+// SelectReferencesContext is like SelectReferences but the query runs with
+// ctx so that it can be cancelled.
+func SelectReferencesContext(ctx context.Context, db *sqlx.DB, b squirrel.SelectBuilder) ([]record.Reference, error) {
+	return htsdb.SelectReferencesContext(ctx, db, b)
+}
+
+// SelectRefSeqs returns the names, lengths and checksums of the reference
+// sequences stored in db, sorted by name. Sequences are not loaded. It returns
+// nil if the reference sequence table does not exist.
+func SelectRefSeqs(db *sqlx.DB) ([]record.RefSeq, error) {
+	return htsdb.SelectRefSeqs(db)
+}
+
+// SelectSamples returns the samples registered in db sorted by name. It
+// returns nil if db has no samples registry.
+func SelectSamples(db *sqlx.DB) ([]record.Sample, error) {
+	return htsdb.SelectSamples(db)
+}
+
+// SampleTable returns the table or view with the records of the registered
+// sample name in db. It returns table if name is empty so that commands can
+// select either a sample or a table.
+func SampleTable(db *sqlx.DB, name, table string) (string, error) {
+	return htsdb.SampleTable(db, name, table)
+}
+
+// SampleNames returns the names of the samples of db that share table,
+// keyed by sample ID, e.g. to label results grouped by sample_id.
+func SampleNames(db *sqlx.DB, table string) (map[int64]string, error) {
+	return htsdb.SampleNames(db, table)
+}
+
+// SelectByName selects into dest, a pointer to a slice, all records of b with
+// read name qname e.g. all alignments of a read. b must select from the table
+// and the read name column must be exposed as qname.
+//
+// e.g.
+// var recs []SamRecord
+// err := SelectByName(db, SamRecordBuilder.From("sample"), "read1", &recs)
+func SelectByName(db *sqlx.DB, b squirrel.SelectBuilder, qname string, dest interface{}) error {
+	return htsdb.SelectByName(db, b, qname, dest)
+}
+
+// GetMeta returns the metadata value stored in db for key. The boolean is
+// false if the key or the metadata table do not exist.
+func GetMeta(db *sqlx.DB, key string) (string, bool, error) {
+	return htsdb.GetMeta(db, key)
+}
+
+// GetMetaContext is like GetMeta but the queries run with ctx.
+func GetMetaContext(ctx context.Context, db *sqlx.DB, key string) (string, bool, error) {
+	return htsdb.GetMetaContext(ctx, db, key)
+}
+
+// Meta returns all metadata key/value pairs stored in db. It returns an empty
+// map if the metadata table does not exist.
+func Meta(db *sqlx.DB) (map[string]string, error) {
+	return htsdb.Meta(db)
+}
+
+// TableColumns returns the column names of table in db.
+func TableColumns(db *sqlx.DB, table string) ([]string, error) {
+	return htsdb.TableColumns(db, table)
+}
+
+// HasColumn returns true if table in db has a column with the given name.
+func HasColumn(db *sqlx.DB, table, col string) (bool, error) {
+	return htsdb.HasColumn(db, table, col)
+}
+
+// RecordTables returns the tables of db with the rname, start and stop
+// columns of records, sorted by name.
+func RecordTables(db *sqlx.DB) ([]string, error) {
+	return htsdb.RecordTables(db)
+}
+
+// Filters is a set of named SQL filters.
+type Filters = htsdb.Filters
+
+// ParseFilters parses filter definitions of the form name=SQL. Names consist
+// of letters, digits, underscores and dashes and are case sensitive.
+func ParseFilters(defs []string) (Filters, error) {
+	return htsdb.ParseFilters(defs)
+}
+
+// CombineFilters returns the SQL condition of the records that match where,
+// do not match whereNot and match the expression expr of the named filters
+// defined in defs, as ParseFilters and Filters.Expr. Empty arguments are
+// ignored and where is returned unchanged if it is the only condition.
+func CombineFilters(where, whereNot string, defs []string, expr string) (string, error) {
+	return htsdb.CombineFilters(where, whereNot, defs, expr)
+}
+
+// NotClause returns an SQL condition that is true for the records for which
+// clause is not true, including those for which clause is NULL e.g. because
+// of a NULL column. Plain NOT would exclude the latter from both a selection
+// and its inverse.
+func NotClause(clause string) string {
+	return htsdb.NotClause(clause)
+}
+
+// TagExpr returns an SQL expression for the value of the integer SAM tag tag
+// e.g. NM or AS of the records of table in db. If table has a column named
+// after the lower case tag e.g. nm, the column is used; otherwise the value is
+// parsed from the tab separated tags column, which is slower. Records without
+// the tag have NULL value.
+func TagExpr(db *sqlx.DB, table, tag string) (string, error) {
+	return htsdb.TagExpr(db, table, tag)
+}
+
+// RegionsFilter returns an SQL clause that selects records overlapping any of
+// regions. The region coordinates are converted to c, the convention of the
+// database. Values are inlined in the clause so that it can be combined with
+// prepared statements that take positional arguments. It returns the empty
+// string if regions is empty.
+func RegionsFilter(regions []Region, c record.Coords) string {
+	return htsdb.RegionsFilter(regions, c)
+}
+
+// RegionsClause returns an SQL clause like RegionsFilter for queries on db.
+// Above TempRegionsThreshold merged regions, which would make statements long
+// and slow to parse and evaluate, the regions are loaded into an indexed TEMP
+// table and the clause looks up the single candidate region of each record
+// in it. TEMP tables are private to a connection so db is then limited to a
+// single open connection; callers must not run concurrent queries on db. The
+// table lasts as long as the connection. It returns the empty string if
+// regions is empty.
+func RegionsClause(db *sqlx.DB, regions []Region, c record.Coords) (string, error) {
+	return htsdb.RegionsClause(db, regions, c)
+}
+
+// ContainedFilter returns an SQL clause that selects records contained in any
+// of regions. Regions are merged first so that records contained in the union
+// of overlapping or adjacent regions are selected once. Coordinates are
+// converted to c and inlined as for RegionsFilter. It returns the empty string
+// if regions is empty.
+func ContainedFilter(regions []Region, c record.Coords) string {
+	return htsdb.ContainedFilter(regions, c)
+}
+
+// LowComplexityFilter returns an SQL clause that excludes the records of
+// table whose DustScore, stored in DustColumn by htsdb-annotate-seq, is above
+// threshold. Records without a score e.g. without sequence are kept. It
+// returns an error if table has no DustColumn.
+func LowComplexityFilter(db *sqlx.DB, table string, threshold float64) (string, error) {
+	return htsdb.LowComplexityFilter(db, table, threshold)
+}
+
+// Genomic regions that restrict queries.
+type (
+	Region      = htsdb.Region
+	RegionIndex = htsdb.RegionIndex
+)
+
+// ReadRegions reads regions from r in BED format. Only the first three
+// columns are used. Empty lines and track, browser or comment lines are
+// ignored.
+func ReadRegions(r io.Reader) ([]Region, error) {
+	return htsdb.ReadRegions(r)
+}
+
+// ReadRegionsFile reads regions from the BED file f. The empty string returns
+// no regions.
+func ReadRegionsFile(f string) ([]Region, error) {
+	return htsdb.ReadRegionsFile(f)
+}
+
+// ParseRegion parses a region in samtools syntax: rname, rname:start or
+// rname:start-end with 1-based inclusive positions and optional thousands
+// separators e.g. chr1:1,000-2,000. Without start or end the region extends to
+// the start or end of the reference. Reference names that contain colons are
+// taken whole unless the part after the last colon is a valid range.
+func ParseRegion(s string) (Region, error) {
+	return htsdb.ParseRegion(s)
+}
+
+// MergeRegions returns the regions sorted by reference and start with
+// overlapping and adjacent regions merged.
+func MergeRegions(regions []Region) []Region {
+	return htsdb.MergeRegions(regions)
+}
+
+// NewRegionIndex returns a RegionIndex of regions. Overlapping and adjacent
+// regions are merged.
+func NewRegionIndex(regions []Region) RegionIndex {
+	return htsdb.NewRegionIndex(regions)
+}
+
+// StrandPolicy determines how records with an invalid strand are read.
+type StrandPolicy = htsdb.StrandPolicy
+
+// Policies for records with an invalid strand.
+const (
+	StrandUnknown = htsdb.StrandUnknown
+	StrandError   = htsdb.StrandError
+	StrandSkip    = htsdb.StrandSkip
+)
+
+// StrandPolicies returns the names of the policies accepted by
+// ParseStrandPolicy.
+func StrandPolicies() []string {
+	return append([]string(nil), htsdb.StrandPolicies...)
+}
+
+// ParseStrandPolicy parses a strand policy. Valid values are "error", "skip"
+// and "unknown".
+func ParseStrandPolicy(s string) (StrandPolicy, error) {
+	return htsdb.ParseStrandPolicy(s)
+}
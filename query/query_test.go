@@ -0,0 +1,66 @@
+package query
+
+import (
+	"testing"
+
+	_ "github.com/mnsmar/htsdb/v2/drivers/sqlite"
+
+	"github.com/mnsmar/htsdb/v2/htstest"
+	"github.com/mnsmar/htsdb/v2/importer"
+	"github.com/mnsmar/htsdb/v2/record"
+)
+
+func TestReadWrittenRecords(t *testing.T) {
+	db, recs, cleanup := htstest.NewDB(t, htstest.DefaultOptions)
+	defer cleanup()
+
+	// copy the records to a new table through the importer package.
+	if _, err := db.Exec("CREATE TABLE copy (rname TEXT, start INTEGER, " +
+		"stop INTEGER, copy_number INTEGER, strand INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	var feats []record.OrientedFeature
+	for _, r := range recs {
+		f := record.OrientedFeature{Feature: record.Feature{Rname: r.Rname,
+			Range: record.Range{StartPos: r.Start, StopPos: r.Stop, CopyNumber: r.CopyNumber}}}
+		f.Orient = record.Orientation(r.Strand)
+		feats = append(feats, f)
+	}
+	n, err := importer.BulkInsert(db, "copy", feats, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != len(recs) {
+		t.Fatalf("expected %d inserted records, actual %d", len(recs), n)
+	}
+
+	for _, table := range []string{"sample", "copy"} {
+		query, _, err := OrientedFeatureBuilder().From(table).OrderBy("rowid").ToSql()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(db.DB, SQLite, &record.OrientedFeature{}, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := 0
+		for r.Next() {
+			f := r.Record().(*record.OrientedFeature)
+			if i >= len(recs) {
+				t.Fatalf("%s: unexpected record %v", table, f)
+			}
+			e := recs[i]
+			if f.Rname != e.Rname || f.StartPos != e.Start || f.StopPos != e.Stop ||
+				int(f.Orient) != e.Strand || f.CopyNumber != e.CopyNumber {
+				t.Errorf("%s: record %d: expected %+v, actual %+v", table, i, e, f)
+			}
+			i++
+		}
+		if err = r.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if i != len(recs) {
+			t.Errorf("%s: expected %d records, actual %d", table, len(recs), i)
+		}
+	}
+}
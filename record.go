@@ -1,6 +1,9 @@
 package htsdb
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/Masterminds/squirrel"
 	"github.com/biogo/biogo/feat"
 )
@@ -10,12 +13,30 @@ var (
 	_ feat.Range    = (*Range)(nil)
 	_ feat.Feature  = (*Feature)(nil)
 	_ feat.Orienter = (*OrientedFeature)(nil)
+	_ Stranded      = (*OrientedFeature)(nil)
+	_ Normalizer    = (*Range)(nil)
 )
 
 // CountBuilder is a squirrel select builder to count entries.
 var CountBuilder = squirrel.Select().
 	Column(squirrel.Alias(squirrel.Expr("COUNT(*)"), "count"))
 
+// CopyNumberSum is an SQL expression for the total copy number of grouped
+// records. Unlike SUM(copy_number), it is 0 instead of NULL for groups without
+// records or whose copy numbers are all NULL so that it can be scanned into an
+// int. It is valid in SQLite, PostgreSQL, MySQL and DuckDB, which do not share
+// the type names of CAST.
+const CopyNumberSum = "COALESCE(SUM(copy_number), 0)"
+
+// CopyNumberTotal is like CopyNumberSum but real valued e.g. for counts that
+// are later normalized. The factor is a float literal as DuckDB types 1.0 as
+// DECIMAL.
+const CopyNumberTotal = "COALESCE(SUM(copy_number), 0) * 1e0"
+
+// RecordCount is an SQL expression for the real valued number of grouped
+// records.
+const RecordCount = "COUNT(*) * 1e0"
+
 // RangeBuilder is a squirrel select builder whose columns match Range fields.
 var RangeBuilder = squirrel.Select("start", "stop", "copy_number")
 
@@ -26,6 +47,15 @@ type Range struct {
 	CopyNumber int `db:"copy_number"`
 }
 
+// Normalizer is implemented by records whose coordinates can be converted to
+// HtsdbCoords e.g. records that embed Range.
+type Normalizer interface {
+	Normalize(c Coords)
+}
+
+// Normalize converts the coordinates of Range in place from c to HtsdbCoords.
+func (e *Range) Normalize(c Coords) { c.Normalize(e) }
+
 // Start returns the start position of Range.
 func (e *Range) Start() int { return e.StartPos }
 
@@ -38,6 +68,18 @@ func (e *Range) Len() int { return e.End() - e.Start() }
 // CopyNum returns the copy number of Range.
 func (e *Range) CopyNum() int { return e.CopyNumber }
 
+// FragmentBuilder returns a squirrel select builder whose columns match Range
+// fields but that spans the whole sequenced fragment of paired-end records
+// instead of the alignment of each mate. Each fragment is selected once,
+// through the leftmost mate with positive TLEN. Coordinates follow convention
+// c.
+func FragmentBuilder(c Coords) squirrel.SelectBuilder {
+	return squirrel.Select("start").
+		Column(squirrel.Alias(squirrel.Expr(c.FragmentStopExpr()), "stop")).
+		Column("copy_number").
+		Where("tlen > 0")
+}
+
 // FeatureBuilder is a squirrel select builder whose columns match Feature
 // fields.
 var FeatureBuilder = RangeBuilder.Column("rname")
@@ -65,21 +107,66 @@ func (e *Feature) Location() feat.Feature {
 var OrientedFeatureBuilder = FeatureBuilder.Column("strand")
 
 // OrientedFeature is part of an htsdb record that wraps Feature and has
-// orientation.
+// orientation. The strand column is read with Orientation.Scan so that
+// strands stored as "+", "-" or "." are accepted.
 type OrientedFeature struct {
-	Orient feat.Orientation `db:"strand"`
+	Orient Orientation `db:"strand"`
 	Feature
 }
 
 // Orientation returns the orientation of OrientedFeature.
 func (e *OrientedFeature) Orientation() feat.Orientation {
-	return e.Orient
+	return feat.Orientation(e.Orient)
 }
 
+// Strand returns the strand of OrientedFeature.
+func (e *OrientedFeature) Strand() Orientation { return e.Orient }
+
+// SetStrand sets the strand of OrientedFeature.
+func (e *OrientedFeature) SetStrand(o Orientation) { e.Orient = o }
+
 // SamRecordBuilder is a squirrel select builder whose columns match SamRecord
 // fields.
-var SamRecordBuilder = squirrel.Select("qname", "flag", "rname", "pos",
-	"mapq", "cigar", "rnext", "pnext", "tlen", "seq", "qual", "tags")
+var SamRecordBuilder = squirrel.Select(samColumns...)
+
+// samColumns are the columns of SamRecordBuilder.
+var samColumns = []string{"qname", "flag", "rname", "pos", "mapq", "cigar",
+	"rnext", "pnext", "tlen", "seq", "qual", "tags"}
+
+// samUnavailable are the SAM values of fields whose information is
+// unavailable.
+var samUnavailable = map[string]string{
+	"qname": "'*'", "flag": "0", "rname": "'*'", "pos": "0", "mapq": "255",
+	"cigar": "'*'", "rnext": "'*'", "pnext": "0", "tlen": "0", "seq": "'*'",
+	"qual": "'*'", "tags": "''",
+}
+
+// SamRecordColumnsBuilder returns a squirrel select builder like
+// SamRecordBuilder that only reads cols from the database, which avoids
+// reading large columns such as seq and qual when they are not needed. The
+// other SamRecord fields get the SAM value for unavailable information e.g. *
+// for seq or 255 for mapq. An empty cols reads all columns.
+func SamRecordColumnsBuilder(cols []string) (squirrel.SelectBuilder, error) {
+	if len(cols) == 0 {
+		return SamRecordBuilder, nil
+	}
+	read := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		if _, ok := samUnavailable[c]; !ok {
+			return squirrel.SelectBuilder{}, fmt.Errorf("htsdb: %s is not a SAM column", c)
+		}
+		read[c] = true
+	}
+	b := squirrel.Select()
+	for _, c := range samColumns {
+		if read[c] {
+			b = b.Column(c)
+		} else {
+			b = b.Column(squirrel.Alias(squirrel.Expr(samUnavailable[c]), c))
+		}
+	}
+	return b, nil
+}
 
 // SamRecord is part of an htsdb record that wraps the fields of a SAM file.
 type SamRecord struct {
@@ -100,22 +187,144 @@ type SamRecord struct {
 // Name returns the SAM qname.
 func (s *SamRecord) Name() string { return s.Qname }
 
-// Head returns the head coordinate of r depending on orientation.
+// SAMLine returns s as a line of a SAM file without the trailing newline.
+func (s *SamRecord) SAMLine() string {
+	return s.Qname + "\t" + strconv.Itoa(s.Flag) + "\t" + s.Rname + "\t" +
+		strconv.Itoa(s.Pos) + "\t" + strconv.Itoa(s.Mapq) + "\t" + s.Cigar + "\t" +
+		s.Rnext + "\t" + strconv.Itoa(s.Pnext) + "\t" + strconv.Itoa(s.Tlen) + "\t" +
+		s.Seq + "\t" + s.Qual + "\t" + s.Tags
+}
+
+// Head returns the head coordinate of r depending on orientation. It panics
+// if o is neither forward nor reverse; use HeadOK for ranges that may have
+// unknown orientation.
 func Head(r feat.Range, o feat.Orientation) int {
-	if o == feat.Forward {
-		return r.Start()
-	} else if o == feat.Reverse {
-		return r.End() - 1
+	return mustOriented(HeadOK(r, o))
+}
+
+// HeadOK is like Head but returns false if o is neither forward nor reverse,
+// as ranges of unknown orientation e.g. unstranded reads have no head. r must
+// have positive length; use a RangeChecker to skip malformed ranges.
+func HeadOK(r feat.Range, o feat.Orientation) (int, bool) {
+	switch o {
+	case feat.Forward:
+		return r.Start(), true
+	case feat.Reverse:
+		return r.End() - 1, true
 	}
-	panic("htsdb: orientation must be forward or reverse")
+	return 0, false
 }
 
-// Tail returns the tail coordinate of r depending on orientation.
+// Tail returns the tail coordinate of r depending on orientation. It panics
+// if o is neither forward nor reverse.
 func Tail(r feat.Range, o feat.Orientation) int {
-	if o == feat.Forward {
-		return r.End() - 1
-	} else if o == feat.Reverse {
-		return r.Start()
+	return mustOriented(TailOK(r, o))
+}
+
+// TailOK is like Tail but returns false if o is neither forward nor reverse.
+// r must have positive length.
+func TailOK(r feat.Range, o feat.Orientation) (int, bool) {
+	switch o {
+	case feat.Forward:
+		return r.End() - 1, true
+	case feat.Reverse:
+		return r.Start(), true
+	}
+	return 0, false
+}
+
+// Mid returns the middle coordinate of r depending on orientation. For ranges
+// of even length the position closer to the head is returned. It panics if o
+// is neither forward nor reverse.
+func Mid(r feat.Range, o feat.Orientation) int {
+	return mustOriented(MidOK(r, o))
+}
+
+// MidOK is like Mid but returns false if o is neither forward nor reverse. r
+// must have positive length.
+func MidOK(r feat.Range, o feat.Orientation) (int, bool) {
+	switch o {
+	case feat.Forward:
+		return r.Start() + (r.Len()-1)/2, true
+	case feat.Reverse:
+		return r.End() - 1 - (r.Len()-1)/2, true
+	}
+	return 0, false
+}
+
+// mustOriented returns pos or panics if ok is false.
+func mustOriented(pos int, ok bool) int {
+	if !ok {
+		panic("htsdb: orientation must be forward or reverse")
+	}
+	return pos
+}
+
+// Anchor is a reference point on a range relative to its orientation.
+type Anchor int
+
+// Valid anchors.
+const (
+	AnchorHead Anchor = iota
+	AnchorTail
+	AnchorMid
+)
+
+// ParseAnchor parses an anchor. Valid values are "5p" (head), "3p" (tail) and
+// "mid".
+func ParseAnchor(s string) (Anchor, error) {
+	switch s {
+	case "5p":
+		return AnchorHead, nil
+	case "3p":
+		return AnchorTail, nil
+	case "mid":
+		return AnchorMid, nil
+	}
+	return 0, fmt.Errorf("htsdb: invalid anchor %q", s)
+}
+
+// String returns the command line representation of a.
+func (a Anchor) String() string {
+	switch a {
+	case AnchorHead:
+		return "5p"
+	case AnchorTail:
+		return "3p"
+	case AnchorMid:
+		return "mid"
+	}
+	return fmt.Sprintf("Anchor(%d)", int(a))
+}
+
+// PosAt returns the coordinate of r that is offset bases downstream of anchor
+// depending on orientation. Negative offsets are upstream e.g. the ribosome
+// P-site is PosAt(r, o, AnchorHead, 12). It panics if o is neither forward
+// nor reverse or if anchor is not valid.
+func PosAt(r feat.Range, o feat.Orientation, anchor Anchor, offset int) int {
+	switch anchor {
+	case AnchorHead, AnchorTail, AnchorMid:
+	default:
+		panic("htsdb: invalid anchor")
+	}
+	return mustOriented(PosAtOK(r, o, anchor, offset))
+}
+
+// PosAtOK is like PosAt but returns false if o is neither forward nor reverse
+// or if anchor is not valid.
+func PosAtOK(r feat.Range, o feat.Orientation, anchor Anchor, offset int) (int, bool) {
+	var pos int
+	var ok bool
+	switch anchor {
+	case AnchorHead:
+		pos, ok = HeadOK(r, o)
+	case AnchorTail:
+		pos, ok = TailOK(r, o)
+	case AnchorMid:
+		pos, ok = MidOK(r, o)
+	}
+	if !ok {
+		return 0, false
 	}
-	panic("htsdb: orientation must be forward or reverse")
+	return pos + offset*int(o), true
 }
@@ -0,0 +1,155 @@
+// Package record exports the record types of htsdb and the coordinate
+// conventions of their positions. It is part of the stable API of htsdb
+// together with packages query, analysis, importer and export: its types and
+// constants are aliases of those of package htsdb and its functions call those
+// of package htsdb. They are kept compatible across minor versions of module
+// github.com/mnsmar/htsdb/v2.
+//
+// e.g.
+// q := "SELECT rname, start, stop, strand, copy_number FROM sample"
+// r, err := query.OpenReader(query.SQLite, "reads.db", &record.OrientedFeature{}, q)
+package record
+
+import (
+	"github.com/biogo/biogo/feat"
+	"github.com/mnsmar/htsdb/v2"
+)
+
+// Records stored in htsdb tables.
+type (
+	Range           = htsdb.Range
+	Feature         = htsdb.Feature
+	OrientedFeature = htsdb.OrientedFeature
+	SamRecord       = htsdb.SamRecord
+	Reference       = htsdb.Reference
+	RefSeq          = htsdb.RefSeq
+	Chimera         = htsdb.Chimera
+	Segment         = htsdb.Segment
+	Sample          = htsdb.Sample
+)
+
+// Interfaces implemented by the records.
+type (
+	Stranded   = htsdb.Stranded
+	Normalizer = htsdb.Normalizer
+)
+
+// Orientation is the strand of a record.
+type Orientation = htsdb.Orientation
+
+// Orientations of records.
+const (
+	Reverse = htsdb.Reverse
+	Unknown = htsdb.Unknown
+	Forward = htsdb.Forward
+)
+
+// FlagStrand returns the orientation of a record with the given SAM FLAG:
+// Reverse if the read is reverse complemented, Forward otherwise and Unknown
+// if the read is unmapped.
+func FlagStrand(flag int) Orientation {
+	return htsdb.FlagStrand(flag)
+}
+
+// StrandFlag returns flag with the reverse complemented bit (0x10) set
+// according to o e.g. to rebuild the FLAG of records imported from BED-like
+// sources. flag is returned unchanged if o is not known.
+func StrandFlag(flag int, o Orientation) int {
+	return htsdb.StrandFlag(flag, o)
+}
+
+// Anchor is a position of a record relative to its orientation.
+type Anchor = htsdb.Anchor
+
+// Anchors of records.
+const (
+	AnchorHead = htsdb.AnchorHead
+	AnchorTail = htsdb.AnchorTail
+	AnchorMid  = htsdb.AnchorMid
+)
+
+// ParseAnchor parses an anchor. Valid values are "5p" (head), "3p" (tail) and
+// "mid".
+func ParseAnchor(s string) (Anchor, error) {
+	return htsdb.ParseAnchor(s)
+}
+
+// PosAt returns the coordinate of r that is offset bases downstream of anchor
+// depending on orientation. Negative offsets are upstream e.g. the ribosome
+// P-site is PosAt(r, o, AnchorHead, 12). It panics if o is neither forward
+// nor reverse or if anchor is not valid.
+func PosAt(r feat.Range, o feat.Orientation, anchor Anchor, offset int) int {
+	return htsdb.PosAt(r, o, anchor, offset)
+}
+
+// PosAtOK is like PosAt but returns false if o is neither forward nor reverse
+// or if anchor is not valid.
+func PosAtOK(r feat.Range, o feat.Orientation, anchor Anchor, offset int) (int, bool) {
+	return htsdb.PosAtOK(r, o, anchor, offset)
+}
+
+// Head returns the head coordinate of r depending on orientation. It panics
+// if o is neither forward nor reverse; use HeadOK for ranges that may have
+// unknown orientation.
+func Head(r feat.Range, o feat.Orientation) int {
+	return htsdb.Head(r, o)
+}
+
+// HeadOK is like Head but returns false if o is neither forward nor reverse,
+// as ranges of unknown orientation e.g. unstranded reads have no head. r must
+// have positive length; use a RangeChecker to skip malformed ranges.
+func HeadOK(r feat.Range, o feat.Orientation) (int, bool) {
+	return htsdb.HeadOK(r, o)
+}
+
+// Tail returns the tail coordinate of r depending on orientation. It panics
+// if o is neither forward nor reverse.
+func Tail(r feat.Range, o feat.Orientation) int {
+	return htsdb.Tail(r, o)
+}
+
+// TailOK is like Tail but returns false if o is neither forward nor reverse.
+// r must have positive length.
+func TailOK(r feat.Range, o feat.Orientation) (int, bool) {
+	return htsdb.TailOK(r, o)
+}
+
+// Mid returns the middle coordinate of r depending on orientation. For ranges
+// of even length the position closer to the head is returned. It panics if o
+// is neither forward nor reverse.
+func Mid(r feat.Range, o feat.Orientation) int {
+	return htsdb.Mid(r, o)
+}
+
+// MidOK is like Mid but returns false if o is neither forward nor reverse. r
+// must have positive length.
+func MidOK(r feat.Range, o feat.Orientation) (int, bool) {
+	return htsdb.MidOK(r, o)
+}
+
+// Coords is a coordinate convention of the start and stop of records.
+type Coords = htsdb.Coords
+
+// HtsdbCoords returns the convention assumed by Range: 0-based with an
+// inclusive stop.
+func HtsdbCoords() Coords {
+	return htsdb.HtsdbCoords
+}
+
+// BEDCoords returns the convention of BED files: 0-based with an exclusive
+// stop.
+func BEDCoords() Coords {
+	return htsdb.BEDCoords
+}
+
+// SAMCoords returns the convention of SAM files: 1-based with an inclusive
+// stop.
+func SAMCoords() Coords {
+	return htsdb.SAMCoords
+}
+
+// ParseCoords parses a coordinate convention. Valid values are "bed", "sam",
+// "htsdb" or "<base>-<closed|halfopen>" e.g. "1-closed".
+func ParseCoords(s string) (Coords, error) {
+	return htsdb.ParseCoords(s)
+}
@@ -0,0 +1,160 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/jmoiron/sqlx"
+)
+
+var anchorTests = []struct {
+	Start, Stop int
+	Ori         feat.Orientation
+	Head, Mid   int
+	Tail        int
+}{
+	{Start: 10, Stop: 14, Ori: feat.Forward, Head: 10, Mid: 12, Tail: 14},
+	{Start: 10, Stop: 14, Ori: feat.Reverse, Head: 14, Mid: 12, Tail: 10},
+	{Start: 10, Stop: 13, Ori: feat.Forward, Head: 10, Mid: 11, Tail: 13},
+	{Start: 10, Stop: 13, Ori: feat.Reverse, Head: 13, Mid: 12, Tail: 10},
+	{Start: 10, Stop: 10, Ori: feat.Reverse, Head: 10, Mid: 10, Tail: 10},
+}
+
+func TestAnchors(t *testing.T) {
+	for _, tt := range anchorTests {
+		r := &Range{StartPos: tt.Start, StopPos: tt.Stop}
+		if got := Head(r, tt.Ori); got != tt.Head {
+			t.Errorf("Head(%d-%d, %d): got %d, want %d", tt.Start, tt.Stop, tt.Ori, got, tt.Head)
+		}
+		if got := Mid(r, tt.Ori); got != tt.Mid {
+			t.Errorf("Mid(%d-%d, %d): got %d, want %d", tt.Start, tt.Stop, tt.Ori, got, tt.Mid)
+		}
+		if got := Tail(r, tt.Ori); got != tt.Tail {
+			t.Errorf("Tail(%d-%d, %d): got %d, want %d", tt.Start, tt.Stop, tt.Ori, got, tt.Tail)
+		}
+	}
+	r := &Range{StartPos: 10, StopPos: 14}
+	for _, o := range []feat.Orientation{feat.NotOriented, 2} {
+		if _, ok := HeadOK(r, o); ok {
+			t.Errorf("Head(%d): expected no head", o)
+		}
+		if _, ok := MidOK(r, o); ok {
+			t.Errorf("Mid(%d): expected no mid", o)
+		}
+		if _, ok := TailOK(r, o); ok {
+			t.Errorf("Tail(%d): expected no tail", o)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Head(NotOriented): expected panic")
+		}
+	}()
+	Head(r, feat.NotOriented)
+}
+
+var posAtTests = []struct {
+	Ori    feat.Orientation
+	Anchor string
+	Offset int
+	Pos    int
+}{
+	{Ori: feat.Forward, Anchor: "5p", Offset: 12, Pos: 22},
+	{Ori: feat.Reverse, Anchor: "5p", Offset: 12, Pos: 27},
+	{Ori: feat.Forward, Anchor: "3p", Offset: -2, Pos: 37},
+	{Ori: feat.Reverse, Anchor: "3p", Offset: -2, Pos: 12},
+	{Ori: feat.Forward, Anchor: "mid", Offset: 0, Pos: 24},
+	{Ori: feat.Reverse, Anchor: "mid", Offset: 1, Pos: 24},
+}
+
+func TestPosAt(t *testing.T) {
+	r := &Range{StartPos: 10, StopPos: 39}
+	for _, tt := range posAtTests {
+		a, err := ParseAnchor(tt.Anchor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.String() != tt.Anchor {
+			t.Errorf("ParseAnchor(%q).String(): got %q", tt.Anchor, a)
+		}
+		if got := PosAt(r, tt.Ori, a, tt.Offset); got != tt.Pos {
+			t.Errorf("PosAt(%d, %s, %d): got %d, want %d", tt.Ori, tt.Anchor, tt.Offset, got, tt.Pos)
+		}
+	}
+	if _, err := ParseAnchor("head"); err == nil {
+		t.Error("expected error for invalid anchor")
+	}
+	if _, ok := PosAtOK(r, feat.NotOriented, AnchorHead, 0); ok {
+		t.Error("expected no position for unknown orientation")
+	}
+	if _, ok := PosAtOK(r, feat.Forward, Anchor(5), 0); ok {
+		t.Error("expected no position for invalid anchor")
+	}
+}
+
+func TestCopyNumberSum(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	db.MustExec("ALTER TABLE foo ADD COLUMN copy_number")
+
+	type count struct {
+		Count   int `db:"count"`
+		CopyNum int `db:"copyNum"`
+	}
+	query := "SELECT COUNT(*) AS count, " + CopyNumberSum + " AS copyNum FROM foo"
+	var tests = []struct {
+		Name    string
+		Inserts []string
+		Where   string
+		Count   count
+	}{
+		{Name: "empty table"},
+		{
+			Name:    "null copy numbers",
+			Inserts: []string{"INSERT INTO foo VALUES (1, 2, NULL)"},
+			Count:   count{Count: 1},
+		},
+		{
+			Name:    "filtered out",
+			Inserts: []string{"INSERT INTO foo VALUES (3, 4, 5)"},
+			Where:   " WHERE start > 10",
+		},
+		{
+			Name:  "mixed",
+			Count: count{Count: 2, CopyNum: 5},
+		},
+	}
+	for _, tt := range tests {
+		for _, ins := range tt.Inserts {
+			db.MustExec(ins)
+		}
+		var got count
+		if err := db.Get(&got, query+tt.Where); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+		if got != tt.Count {
+			t.Errorf("%s: expected %+v, actual %+v", tt.Name, tt.Count, got)
+		}
+	}
+}
+
+func TestSamRecordColumnsBuilder(t *testing.T) {
+	b, err := SamRecordColumnsBuilder([]string{"qname", "pos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _, err := b.From("t").ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"SELECT qname, ", " pos, ", "(255) AS mapq", "('*') AS seq"} {
+		if !strings.Contains(query, s) {
+			t.Errorf("expected %q in %q", s, query)
+		}
+	}
+	if _, err := SamRecordColumnsBuilder([]string{"start"}); err == nil {
+		t.Error("expected error for non SAM column")
+	}
+}
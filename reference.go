@@ -1,6 +1,8 @@
 package htsdb
 
 import (
+	"context"
+
 	"github.com/biogo/biogo/feat"
 
 	"github.com/Masterminds/squirrel"
@@ -48,11 +50,18 @@ func (c *Reference) Location() feat.Feature { return nil }
 // e.g.
 // refs, err := SelectReferences(db, ReferenceBuilder)
 func SelectReferences(db *sqlx.DB, b squirrel.SelectBuilder) ([]Reference, error) {
+	return SelectReferencesContext(context.Background(), db, b)
+}
+
+// SelectReferencesContext is like SelectReferences but the query runs with
+// ctx so that it can be cancelled.
+func SelectReferencesContext(ctx context.Context, db *sqlx.DB, b squirrel.SelectBuilder,
+) ([]Reference, error) {
 	refs := []Reference{}
 	query, _, err := b.ToSql()
 	if err != nil {
 		return refs, err
 	}
-	err = db.Select(&refs, query)
+	err = db.SelectContext(ctx, &refs, query)
 	return refs, err
 }
@@ -0,0 +1,89 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RefMap maps reference names to new names e.g. from Ensembl (1, MT) to UCSC
+// (chr1, chrM) style.
+type RefMap map[string]string
+
+// Rename returns the new name for the reference name. Names that are not in m
+// are returned unchanged.
+func (m RefMap) Rename(name string) string {
+	if n, ok := m[name]; ok {
+		return n
+	}
+	return name
+}
+
+// ReadRefMap reads a reference name mapping from r. Each line must contain the
+// old and the new name separated by white space. Empty lines and lines
+// starting with # are ignored.
+func ReadRefMap(r io.Reader) (RefMap, error) {
+	m := make(RefMap)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("htsdb: line %d: expected 2 columns", line)
+		}
+		m[fields[0]] = fields[1]
+	}
+	return m, sc.Err()
+}
+
+// EnsemblToUCSC converts an Ensembl style reference name to UCSC style by
+// adding the chr prefix. The mitochondrial MT is converted to chrM. Names
+// that already have the prefix and the SAM placeholders "*" and "=" are
+// returned unchanged.
+func EnsemblToUCSC(name string) string {
+	if name == "MT" {
+		return "chrM"
+	}
+	if strings.HasPrefix(name, "chr") || name == "*" || name == "=" {
+		return name
+	}
+	return "chr" + name
+}
+
+// UCSCToEnsembl converts a UCSC style reference name to Ensembl style by
+// removing the chr prefix. The mitochondrial chrM is converted to MT.
+func UCSCToEnsembl(name string) string {
+	if name == "chrM" {
+		return "MT"
+	}
+	return strings.TrimPrefix(name, "chr")
+}
+
+// RefRenamer returns a function that renames references according to spec.
+// Spec is "ucsc" or "ensembl" for the built-in style conversions or the path
+// to a mapping file readable by ReadRefMap. The empty spec returns a function
+// that leaves names unchanged.
+func RefRenamer(spec string) (func(string) string, error) {
+	switch spec {
+	case "":
+		return func(name string) string { return name }, nil
+	case "ucsc":
+		return EnsemblToUCSC, nil
+	case "ensembl":
+		return UCSCToEnsembl, nil
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ReadRefMap(f)
+	if err != nil {
+		return nil, err
+	}
+	return m.Rename, nil
+}
@@ -0,0 +1,39 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+)
+
+var renameTests = []struct {
+	Name, Ensembl, UCSC string
+}{
+	{Name: "autosome", Ensembl: "1", UCSC: "chr1"},
+	{Name: "sex", Ensembl: "X", UCSC: "chrX"},
+	{Name: "mitochondrion", Ensembl: "MT", UCSC: "chrM"},
+}
+
+func TestRefStyles(t *testing.T) {
+	for _, tt := range renameTests {
+		if got := EnsemblToUCSC(tt.Ensembl); got != tt.UCSC {
+			t.Errorf("%s:expected %s, actual %s", tt.Name, tt.UCSC, got)
+		}
+		if got := UCSCToEnsembl(tt.UCSC); got != tt.Ensembl {
+			t.Errorf("%s:expected %s, actual %s", tt.Name, tt.Ensembl, got)
+		}
+	}
+}
+
+func TestReadRefMap(t *testing.T) {
+	m, err := ReadRefMap(strings.NewReader("# comment\n1\tchr1\n\nMT chrM\n"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if m.Rename("1") != "chr1" || m.Rename("MT") != "chrM" ||
+		m.Rename("2") != "2" {
+		t.Errorf("wrong mapping: %v", m)
+	}
+	if _, err = ReadRefMap(strings.NewReader("1 chr1 extra\n")); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
@@ -0,0 +1,173 @@
+package htsdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RefSeqTable is the name of the table that stores the reference sequences
+// against which the records were aligned.
+const RefSeqTable = "reference"
+
+// RefSeq is a row of the reference sequence table. Seq is empty if only the
+// length and checksum of the sequence are stored.
+type RefSeq struct {
+	Name   string `db:"name"`
+	Length int    `db:"length"`
+	MD5    string `db:"md5"`
+	Seq    string `db:"seq"`
+}
+
+// NewRefSeq returns the RefSeq for the named sequence, computing its length
+// and checksum. The sequence itself is kept only if keepSeq is true.
+func NewRefSeq(name string, seq []byte, keepSeq bool) RefSeq {
+	s := RefSeq{Name: name, Length: len(seq), MD5: SeqMD5(seq)}
+	if keepSeq {
+		s.Seq = string(seq)
+	}
+	return s
+}
+
+// SeqMD5 returns the checksum of seq as defined for the M5 tag of SAM @SQ
+// header lines: the hex MD5 digest of the upper case sequence without white
+// space or other non printing characters.
+func SeqMD5(seq []byte) string {
+	h := md5.New()
+	clean := make([]byte, 0, len(seq))
+	for _, c := range seq {
+		if c < '!' || c > '~' {
+			continue
+		}
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		clean = append(clean, c)
+	}
+	h.Write(clean)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ReadFasta reads FASTA formatted sequences from r and calls fn for each one.
+// The name of a sequence is the first word of its header line. Reading stops
+// at the first error returned by fn.
+func ReadFasta(r io.Reader, fn func(name string, seq []byte) error) error {
+	br := bufio.NewReader(r)
+	var name string
+	var seq []byte
+	inSeq := false
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] == '>' {
+			if inSeq {
+				if err := fn(name, seq); err != nil {
+					return err
+				}
+			}
+			fields := strings.Fields(string(line[1:]))
+			if len(fields) == 0 {
+				return fmt.Errorf("htsdb: FASTA header without name")
+			}
+			name, seq, inSeq = fields[0], nil, true
+		} else if len(line) > 0 {
+			if !inSeq {
+				return fmt.Errorf("htsdb: FASTA sequence before header")
+			}
+			seq = append(seq, line...)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if inSeq {
+		return fn(name, seq)
+	}
+	return nil
+}
+
+// CreateRefSeqTable creates the reference sequence table in db if it does
+// not exist.
+func CreateRefSeqTable(db *sqlx.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + RefSeqTable +
+		" (name VARCHAR(255) PRIMARY KEY, length INTEGER, md5 TEXT, seq " + longText(db) + ")")
+	return err
+}
+
+// InsertRefSeq stores s in the reference sequence table of db, replacing any
+// sequence with the same name.
+func InsertRefSeq(db *sqlx.DB, s RefSeq) error {
+	var seq interface{}
+	if s.Seq != "" {
+		seq = s.Seq
+	}
+	_, err := db.Exec(db.Rebind(upsertSQL(db, RefSeqTable, "name", "length", "md5", "seq")),
+		s.Name, s.Length, s.MD5, seq)
+	return err
+}
+
+// SelectRefSeqs returns the names, lengths and checksums of the reference
+// sequences stored in db, sorted by name. Sequences are not loaded. It returns
+// nil if the reference sequence table does not exist.
+func SelectRefSeqs(db *sqlx.DB) ([]RefSeq, error) {
+	ok, err := TableExists(db, RefSeqTable)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var seqs []RefSeq
+	err = db.Select(&seqs, "SELECT name, length, md5 FROM "+RefSeqTable+
+		" ORDER BY name")
+	return seqs, err
+}
+
+// RefSubseq returns the subsequence of the named reference between start and
+// stop in HtsdbCoords.
+func RefSubseq(db *sqlx.DB, name string, start, stop int) (string, error) {
+	var seq sql.NullString
+	err := db.Get(&seq, db.Rebind("SELECT substr(seq, ?, ?) FROM "+
+		RefSeqTable+" WHERE name = ?"), start+1, stop-start+1, name)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("htsdb: no such reference: %s", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !seq.Valid {
+		return "", fmt.Errorf("htsdb: no sequence stored for reference: %s", name)
+	}
+	return seq.String, nil
+}
+
+// CheckRefSeqs compares the references that records align to against the
+// stored reference sequences and returns a description of each mismatch e.g.
+// a reference that is missing or shorter than its records. It helps detect
+// records and sequences that come from different genome builds.
+func CheckRefSeqs(refs []Reference, seqs []RefSeq) []string {
+	lens := make(map[string]int, len(seqs))
+	for _, s := range seqs {
+		lens[s.Name] = s.Length
+	}
+	var problems []string
+	for _, r := range refs {
+		l, ok := lens[r.Chrom]
+		if !ok {
+			problems = append(problems,
+				fmt.Sprintf("%s: not in reference sequences", r.Chrom))
+		} else if r.Length > l {
+			problems = append(problems, fmt.Sprintf(
+				"%s: records extend to %d beyond reference length %d",
+				r.Chrom, r.Length, l))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,49 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadFasta(t *testing.T) {
+	in := ">chr1 first\nACGT\nnacgt\n\n>chr2\r\nGG\r\n>chr3\n"
+	var names, seqs []string
+	err := ReadFasta(strings.NewReader(in), func(name string, seq []byte) error {
+		names = append(names, name)
+		seqs = append(seqs, string(seq))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "chr1,chr2,chr3" {
+		t.Errorf("unexpected names: %v", names)
+	}
+	if strings.Join(seqs, ",") != "ACGTnacgt,GG," {
+		t.Errorf("unexpected sequences: %v", seqs)
+	}
+
+	err = ReadFasta(strings.NewReader("ACGT\n"), func(string, []byte) error { return nil })
+	if err == nil {
+		t.Error("expected error for sequence without header")
+	}
+}
+
+func TestSeqMD5(t *testing.T) {
+	want := "1614297c8c8fab5c60fa10e5f27127a5"
+	for _, seq := range []string{"ACGTNACGT", "acgtnacgt", "ACGTN\nACGT"} {
+		if got := SeqMD5([]byte(seq)); got != want {
+			t.Errorf("SeqMD5(%q): got %s, want %s", seq, got, want)
+		}
+	}
+}
+
+func TestCheckRefSeqs(t *testing.T) {
+	refs := []Reference{{Chrom: "chr1", Length: 100}, {Chrom: "chr2", Length: 50},
+		{Chrom: "chrUn", Length: 10}}
+	seqs := []RefSeq{{Name: "chr1", Length: 100}, {Name: "chr2", Length: 40}}
+	problems := CheckRefSeqs(refs, seqs)
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+}
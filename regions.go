@@ -0,0 +1,292 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Region is a genomic interval on reference Rname. Start and Stop follow
+// HtsdbCoords i.e. they are 0-based and Stop is inclusive.
+type Region struct {
+	Rname       string
+	Start, Stop int
+}
+
+// ReadRegions reads regions from r in BED format. Only the first three
+// columns are used. Empty lines and track, browser or comment lines are
+// ignored.
+func ReadRegions(r io.Reader) ([]Region, error) {
+	var regions []Region
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") ||
+			fields[0] == "track" || fields[0] == "browser" {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("htsdb: line %d: expected at least 3 columns", line)
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("htsdb: line %d: %v", line, err)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("htsdb: line %d: %v", line, err)
+		}
+		start, stop := BEDCoords.ToHtsdb(start, end)
+		regions = append(regions, Region{Rname: fields[0], Start: start, Stop: stop})
+	}
+	return regions, sc.Err()
+}
+
+// ReadRegionsFile reads regions from the BED file f. The empty string returns
+// no regions.
+func ReadRegionsFile(f string) ([]Region, error) {
+	if f == "" {
+		return nil, nil
+	}
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return ReadRegions(fh)
+}
+
+// ParseRegion parses a region in samtools syntax: rname, rname:start or
+// rname:start-end with 1-based inclusive positions and optional thousands
+// separators e.g. chr1:1,000-2,000. Without start or end the region extends to
+// the start or end of the reference. Reference names that contain colons are
+// taken whole unless the part after the last colon is a valid range.
+func ParseRegion(s string) (Region, error) {
+	r := Region{Rname: s, Start: 0, Stop: math.MaxInt32}
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		if s == "" {
+			return Region{}, fmt.Errorf("htsdb: empty region")
+		}
+		return r, nil
+	}
+	rng := strings.Replace(s[i+1:], ",", "", -1)
+	first, last := rng, ""
+	if j := strings.IndexByte(rng, '-'); j >= 0 {
+		first, last = rng[:j], rng[j+1:]
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 1 {
+		if strings.ContainsAny(s[i+1:], ",-") || i == 0 {
+			return Region{}, fmt.Errorf("htsdb: invalid region %q", s)
+		}
+		return r, nil
+	}
+	r.Rname, r.Start = s[:i], start-1
+	if last != "" {
+		end, err := strconv.Atoi(last)
+		if err != nil || end < start {
+			return Region{}, fmt.Errorf("htsdb: invalid region %q", s)
+		}
+		r.Stop = end - 1
+	}
+	if r.Rname == "" {
+		return Region{}, fmt.Errorf("htsdb: invalid region %q", s)
+	}
+	return r, nil
+}
+
+// MergeRegions returns the regions sorted by reference and start with
+// overlapping and adjacent regions merged.
+func MergeRegions(regions []Region) []Region {
+	sorted := append([]Region(nil), regions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Rname != sorted[j].Rname {
+			return sorted[i].Rname < sorted[j].Rname
+		}
+		return sorted[i].Start < sorted[j].Start
+	})
+	var merged []Region
+	for _, r := range sorted {
+		n := len(merged)
+		if n > 0 && merged[n-1].Rname == r.Rname && r.Start <= merged[n-1].Stop+1 {
+			if r.Stop > merged[n-1].Stop {
+				merged[n-1].Stop = r.Stop
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// RegionRefs returns the set of reference names in regions.
+func RegionRefs(regions []Region) map[string]bool {
+	refs := make(map[string]bool)
+	for _, r := range regions {
+		refs[r.Rname] = true
+	}
+	return refs
+}
+
+// RegionIndex holds merged regions per reference for fast lookup of records
+// against many regions, where inlined SQL filters would become too large.
+type RegionIndex map[string][]Region
+
+// NewRegionIndex returns a RegionIndex of regions. Overlapping and adjacent
+// regions are merged.
+func NewRegionIndex(regions []Region) RegionIndex {
+	idx := make(RegionIndex)
+	for _, r := range MergeRegions(regions) {
+		idx[r.Rname] = append(idx[r.Rname], r)
+	}
+	return idx
+}
+
+// Overlaps returns true if start-stop on rname overlaps any region. start and
+// stop follow HtsdbCoords.
+func (idx RegionIndex) Overlaps(rname string, start, stop int) bool {
+	regs := idx[rname]
+	i := sort.Search(len(regs), func(i int) bool { return regs[i].Stop >= start })
+	return i < len(regs) && regs[i].Start <= stop
+}
+
+// Contains returns true if start-stop on rname is contained in a region.
+// start and stop follow HtsdbCoords.
+func (idx RegionIndex) Contains(rname string, start, stop int) bool {
+	regs := idx[rname]
+	i := sort.Search(len(regs), func(i int) bool { return regs[i].Stop >= start })
+	return i < len(regs) && regs[i].Start <= start && regs[i].Stop >= stop
+}
+
+// RegionsFilter returns an SQL clause that selects records overlapping any of
+// regions. The region coordinates are converted to c, the convention of the
+// database. Values are inlined in the clause so that it can be combined with
+// prepared statements that take positional arguments. It returns the empty
+// string if regions is empty.
+func RegionsFilter(regions []Region, c Coords) string {
+	return RegionsFilterColumns(regions, c, "rname", "start", "stop")
+}
+
+// RegionsFilterColumns is like RegionsFilter for records whose reference,
+// start and stop are in columns rname, start and stop e.g. the arms of the
+// chimera table.
+func RegionsFilterColumns(regions []Region, c Coords, rname, start, stop string) string {
+	if len(regions) == 0 {
+		return ""
+	}
+	pred := "(" + rname + " = %s AND " + start + " <= %d AND " + stop + " >= %d)"
+	if c.HalfOpen {
+		pred = "(" + rname + " = %s AND " + start + " < %d AND " + stop + " > %d)"
+	}
+	merged := MergeRegions(regions)
+	preds := make([]string, len(merged))
+	for i, r := range merged {
+		rStart, rStop := c.FromHtsdb(r.Start, r.Stop)
+		preds[i] = fmt.Sprintf(pred, quote(r.Rname), rStop, rStart)
+	}
+	return orTree(preds)
+}
+
+// TempRegionsThreshold is the number of merged regions above which
+// RegionsClause looks regions up in a TEMP table instead of inlining them.
+const TempRegionsThreshold = 500
+
+// tempRegionsID numbers the TEMP tables created by RegionsClause.
+var tempRegionsID int64
+
+// RegionsClause returns an SQL clause like RegionsFilter for queries on db.
+// Above TempRegionsThreshold merged regions, which would make statements long
+// and slow to parse and evaluate, the regions are loaded into an indexed TEMP
+// table and the clause looks up the single candidate region of each record
+// in it. TEMP tables are private to a connection so db is then limited to a
+// single open connection; callers must not run concurrent queries on db. The
+// table lasts as long as the connection. It returns the empty string if
+// regions is empty.
+func RegionsClause(db *sqlx.DB, regions []Region, c Coords) (string, error) {
+	merged := MergeRegions(regions)
+	if len(merged) <= TempRegionsThreshold {
+		return RegionsFilter(merged, c), nil
+	}
+	db.SetMaxOpenConns(1)
+	name := fmt.Sprintf("htsdb_regions_%d", atomic.AddInt64(&tempRegionsID, 1))
+	table, index := "temp."+name, "temp."+name+"_idx"
+	if db.DriverName() != SQLite {
+		// PostgreSQL, MySQL and DuckDB resolve unqualified names to TEMP
+		// tables first.
+		table, index = name, name+"_idx"
+	}
+	_, err := db.Exec("CREATE TEMPORARY TABLE " + name +
+		" (r_rname VARCHAR(255), r_start INTEGER, r_stop INTEGER)")
+	if err != nil {
+		return "", err
+	}
+	l, err := NewLoader(db, table, []string{"r_rname", "r_start", "r_stop"}, DefaultLoadOptions)
+	if err != nil {
+		return "", err
+	}
+	l.DeferIndex("CREATE INDEX " + index + " ON " + name + " (r_rname, r_stop)")
+	for _, r := range merged {
+		start, stop := c.FromHtsdb(r.Start, r.Stop)
+		if err = l.Add(r.Rname, start, stop); err != nil {
+			l.Close()
+			return "", err
+		}
+	}
+	if err = l.Close(); err != nil {
+		return "", err
+	}
+	// merged regions are disjoint and sorted so the only region that can
+	// overlap a record is the first one that stops after its start.
+	clause := "(SELECT r_start FROM %s WHERE r_rname = rname AND r_stop >= start" +
+		" ORDER BY r_stop LIMIT 1) <= stop"
+	if c.HalfOpen {
+		clause = "(SELECT r_start FROM %s WHERE r_rname = rname AND r_stop > start" +
+			" ORDER BY r_stop LIMIT 1) < stop"
+	}
+	return fmt.Sprintf(clause, table), nil
+}
+
+// ContainedFilter returns an SQL clause that selects records contained in any
+// of regions. Regions are merged first so that records contained in the union
+// of overlapping or adjacent regions are selected once. Coordinates are
+// converted to c and inlined as for RegionsFilter. It returns the empty string
+// if regions is empty.
+func ContainedFilter(regions []Region, c Coords) string {
+	if len(regions) == 0 {
+		return ""
+	}
+	merged := MergeRegions(regions)
+	preds := make([]string, len(merged))
+	for i, r := range merged {
+		start, stop := c.FromHtsdb(r.Start, r.Stop)
+		preds[i] = fmt.Sprintf(
+			"(rname = %s AND start BETWEEN %d AND %d AND stop BETWEEN %d AND %d)",
+			quote(r.Rname), start, stop, start, stop)
+	}
+	return orTree(preds)
+}
+
+// orTree joins preds with OR. Long lists are nested in a balanced tree so that
+// the parsed expression stays within the SQLite expression depth limit.
+func orTree(preds []string) string {
+	const leaf = 64
+	if len(preds) <= leaf {
+		return "(" + strings.Join(preds, " OR ") + ")"
+	}
+	mid := len(preds) / 2
+	return "(" + orTree(preds[:mid]) + " OR " + orTree(preds[mid:]) + ")"
+}
+
+// quote returns s as an SQL string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,156 @@
+package htsdb
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRegions(t *testing.T) {
+	in := "track name=foo\nchr1\t10\t20\tgene\nchr1\t15\t30\nchr1\t30\t40\nchr2\t0\t5\n"
+	regions, err := ReadRegions(strings.NewReader(in))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(regions) != 4 || regions[0] != (Region{"chr1", 10, 19}) {
+		t.Errorf("wrong regions: %v", regions)
+	}
+
+	expected := []Region{{"chr1", 10, 39}, {"chr2", 0, 4}}
+	if merged := MergeRegions(regions); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("wrong merged regions: expected %v, actual %v", expected, merged)
+	}
+}
+
+func TestContainedFilter(t *testing.T) {
+	regions := []Region{{"chr1", 10, 19}, {"chr1", 20, 29}, {"chr1", 50, 59}}
+	expected := "((rname = 'chr1' AND start BETWEEN 10 AND 30 AND stop BETWEEN 10 AND 30)" +
+		" OR (rname = 'chr1' AND start BETWEEN 50 AND 60 AND stop BETWEEN 50 AND 60))"
+	if f := ContainedFilter(regions, BEDCoords); f != expected {
+		t.Errorf("wrong filter: expected %q, actual %q", expected, f)
+	}
+	if f := ContainedFilter(nil, BEDCoords); f != "" {
+		t.Errorf("expected empty filter, actual %q", f)
+	}
+}
+
+func TestRegionsFilterColumns(t *testing.T) {
+	regions := []Region{{"chr1", 10, 19}, {"chr2", 0, 4}}
+	expected := "((rname1 = 'chr1' AND start1 < 20 AND stop1 > 10)" +
+		" OR (rname1 = 'chr2' AND start1 < 5 AND stop1 > 0))"
+	if f := RegionsFilterColumns(regions, BEDCoords, "rname1", "start1", "stop1"); f != expected {
+		t.Errorf("wrong filter: expected %q, actual %q", expected, f)
+	}
+}
+
+func TestRegionIndex(t *testing.T) {
+	idx := NewRegionIndex([]Region{{"chr1", 10, 19}, {"chr1", 20, 29}, {"chr1", 50, 59}})
+	tests := []struct {
+		rname              string
+		start, stop        int
+		overlaps, contains bool
+	}{
+		{"chr1", 0, 9, false, false},
+		{"chr1", 5, 10, true, false},
+		{"chr1", 15, 25, true, true},
+		{"chr1", 25, 35, true, false},
+		{"chr1", 30, 49, false, false},
+		{"chr1", 40, 70, true, false},
+		{"chr1", 59, 59, true, true},
+		{"chr2", 15, 25, false, false},
+	}
+	for _, tt := range tests {
+		if got := idx.Overlaps(tt.rname, tt.start, tt.stop); got != tt.overlaps {
+			t.Errorf("Overlaps(%s, %d, %d): got %t", tt.rname, tt.start, tt.stop, got)
+		}
+		if got := idx.Contains(tt.rname, tt.start, tt.stop); got != tt.contains {
+			t.Errorf("Contains(%s, %d, %d): got %t", tt.rname, tt.start, tt.stop, got)
+		}
+	}
+}
+
+func TestOrTree(t *testing.T) {
+	preds := make([]string, 1000)
+	for i := range preds {
+		preds[i] = "p"
+	}
+	f := orTree(preds)
+	if n := strings.Count(f, "p"); n != len(preds) {
+		t.Errorf("expected %d predicates, got %d", len(preds), n)
+	}
+	depth, max := 0, 0
+	for _, c := range f {
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		}
+		if depth > max {
+			max = depth
+		}
+	}
+	if max > 6 {
+		t.Errorf("expected balanced nesting, got depth %d", max)
+	}
+}
+
+func TestRegionsClause(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	for i := 0; i < 100; i++ {
+		db.MustExec("INSERT INTO t (rname, start, stop) VALUES ('chr1', ?, ?)", i*10, i*10+4)
+	}
+
+	small := []Region{{"chr1", 0, 20}}
+	f, err := RegionsClause(db, small, HtsdbCoords)
+	if err != nil || f != RegionsFilter(small, HtsdbCoords) {
+		t.Errorf("expected inlined filter for few regions, actual %s, %v", f, err)
+	}
+
+	// every third record is overlapped by a single base region.
+	var regions []Region
+	for i := 0; i <= TempRegionsThreshold; i++ {
+		regions = append(regions, Region{"chr1", i * 30, i * 30}, Region{"chr2", i * 10, i * 10})
+	}
+	for _, c := range []Coords{HtsdbCoords, BEDCoords} {
+		f, err := RegionsClause(db, regions, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cnt int
+		if err = db.Get(&cnt, "SELECT COUNT(*) FROM t WHERE "+f); err != nil {
+			t.Fatal(err)
+		}
+		if cnt != 34 {
+			t.Errorf("%s: expected 34 records, actual %d", c, cnt)
+		}
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	tests := map[string]Region{
+		"chr1":               {"chr1", 0, math.MaxInt32},
+		"chr1:1,000-2,000":   {"chr1", 999, 1999},
+		"chr1:1000":          {"chr1", 999, math.MaxInt32},
+		"chr1:1000-":         {"chr1", 999, math.MaxInt32},
+		"HLA-A*01:01":        {"HLA-A*01", 0, math.MaxInt32},
+		"HLA-A*01:01:1-10":   {"HLA-A*01:01", 0, 9},
+		"HLA-A*01:01:01:abc": {"HLA-A*01:01:01:abc", 0, math.MaxInt32},
+	}
+	for s, expected := range tests {
+		r, err := ParseRegion(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		if r != expected {
+			t.Errorf("%s: expected %v, actual %v", s, expected, r)
+		}
+	}
+	for _, s := range []string{"", ":1-10", "chr1:20-10", "chr1:1,000-x"} {
+		if _, err := ParseRegion(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package htsdb
+
+import (
+	"fmt"
+	"io"
+)
+
+// ResultWriter writes grouped results, rows of key columns followed by value
+// columns, as tab separated lines. By default each row is written as is. In
+// long format each value is written on its own line after the keys and the
+// name of its value column, the tidy layout that ggplot2 and dplyr consume
+// without reshaping.
+//
+// e.g. for keys category and len and values count and copyNumber the row
+// "all 21 10 12" is written in long format as "all 21 count 10" and
+// "all 21 copyNumber 12".
+type ResultWriter struct {
+	*TSVWriter
+	// Long selects the long format.
+	Long bool
+	// Variable and Value are the header names of the value column name and
+	// value columns in long format.
+	Variable, Value string
+
+	keys, values []string
+}
+
+// NewResultWriter returns a ResultWriter that writes rows with keys and values
+// columns to w, in long format if long is true.
+func NewResultWriter(w io.Writer, keys, values []string, long bool) *ResultWriter {
+	return &ResultWriter{
+		TSVWriter: NewTSVWriter(w),
+		Long:      long,
+		Variable:  "variable",
+		Value:     "value",
+		keys:      keys,
+		values:    values,
+	}
+}
+
+// WriteHeader writes the header line.
+func (r *ResultWriter) WriteHeader() error {
+	if !r.Long {
+		return r.WriteStrings(append(append([]string{}, r.keys...), r.values...))
+	}
+	return r.WriteStrings(append(append([]string{}, r.keys...), r.Variable, r.Value))
+}
+
+// WriteRow writes a row with the key values followed by the values.
+func (r *ResultWriter) WriteRow(vals ...interface{}) error {
+	if len(vals) != len(r.keys)+len(r.values) {
+		return fmt.Errorf("htsdb: expected %d values in row, actual %d",
+			len(r.keys)+len(r.values), len(vals))
+	}
+	if !r.Long {
+		return r.Write(vals...)
+	}
+	row := make([]interface{}, len(r.keys)+2)
+	copy(row, vals[:len(r.keys)])
+	for i, name := range r.values {
+		row[len(r.keys)] = name
+		row[len(r.keys)+1] = vals[len(r.keys)+i]
+		if err := r.Write(row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
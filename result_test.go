@@ -0,0 +1,36 @@
+package htsdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResultWriter(t *testing.T) {
+	tests := []struct {
+		Long bool
+		Out  string
+	}{
+		{false, "category\tlen\tcount\tcopyNumber\nall\t21\t10\t12\n"},
+		{true, "category\tlen\tvariable\tvalue\nall\t21\tcount\t10\nall\t21\tcopyNumber\t12\n"},
+	}
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		w := NewResultWriter(&buf, []string{"category", "len"},
+			[]string{"count", "copyNumber"}, tt.Long)
+		if err := w.WriteHeader(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteRow("all", 21, int64(10), int64(12)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteRow("all", 21); err == nil {
+			t.Errorf("test %d: expected error for short row", i)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.Out {
+			t.Errorf("test %d: expected %q, actual %q", i, tt.Out, got)
+		}
+	}
+}
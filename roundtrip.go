@@ -0,0 +1,108 @@
+package htsdb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// RoundTrip is the result of comparing the alignments of two sources with
+// CompareAlignments e.g. a BAM file and the database imported from it.
+type RoundTrip struct {
+	// Records are the number of records of the first and the second source.
+	Records [2]int
+	// Missing is the number of records of the first source without an
+	// identical record in the second one.
+	Missing int
+	// Extra is the number of records of the second source without an
+	// identical record in the first one.
+	Extra int
+	// Examples are SAM lines of the second source without an identical
+	// record in the first one e.g. lines with a truncated field.
+	Examples []string
+}
+
+// OK returns true if both sources contain the same alignments.
+func (rt RoundTrip) OK() bool {
+	return rt.Missing == 0 && rt.Extra == 0
+}
+
+// samLiner is implemented by SamRecord and the records that embed it.
+type samLiner interface {
+	SAMLine() string
+}
+
+// CompareAlignments compares the alignments of a and b regardless of their
+// order. Records must be *SamRecord or embed it and are compared as complete
+// SAM lines so that any difference, e.g. a truncated quality string or a
+// missing tag, is detected. Only a hash of each distinct record of a is kept
+// in memory. Up to maxExamples records of b that differ are returned as
+// examples.
+func CompareAlignments(a, b RecordSource, maxExamples int) (RoundTrip, error) {
+	var rt RoundTrip
+	counts := make(map[[16]byte]int)
+	for i, src := range []RecordSource{a, b} {
+		for src.Next() {
+			s, ok := src.Record().(samLiner)
+			if !ok {
+				return rt, fmt.Errorf("htsdb: record of type %T has no SAM fields", src.Record())
+			}
+			line := s.SAMLine()
+			h := fnv.New128a()
+			h.Write([]byte(line))
+			var key [16]byte
+			copy(key[:], h.Sum(nil))
+			rt.Records[i]++
+			if i == 0 {
+				counts[key]++
+				continue
+			}
+			if counts[key] > 0 {
+				counts[key]--
+				continue
+			}
+			rt.Extra++
+			if len(rt.Examples) < maxExamples {
+				rt.Examples = append(rt.Examples, line)
+			}
+		}
+		if err := src.Err(); err != nil {
+			return rt, err
+		}
+	}
+	for _, n := range counts {
+		rt.Missing += n
+	}
+	return rt, nil
+}
+
+// CompareReferences compares the references of the headers of two sources,
+// e.g. the @SQ lines of a BAM file and the reference table of a database, and
+// returns a description of each difference.
+func CompareReferences(a, b []Reference) []string {
+	lens := make(map[string]int, len(b))
+	for _, r := range b {
+		lens[r.Chrom] = r.Length
+	}
+	var problems []string
+	for _, r := range a {
+		l, ok := lens[r.Chrom]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: missing", r.Chrom))
+		case l != r.Length:
+			problems = append(problems,
+				fmt.Sprintf("%s: length %d instead of %d", r.Chrom, l, r.Length))
+		}
+		delete(lens, r.Chrom)
+	}
+	extra := make([]string, 0, len(lens))
+	for name := range lens {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		problems = append(problems, fmt.Sprintf("%s: not expected", name))
+	}
+	return problems
+}
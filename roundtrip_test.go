@@ -0,0 +1,158 @@
+package htsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestCompareAlignments(t *testing.T) {
+	recs := []interface{}{
+		&SamRecord{Qname: "r1", Rname: "chr1", Pos: 10, Cigar: "4M", Seq: "ACGT", Qual: "IIII"},
+		&SamRecord{Qname: "r2", Flag: 16, Rname: "chr1", Pos: 20, Cigar: "4M", Seq: "TTGA", Qual: "IIHH", Tags: "NM:i:1"},
+	}
+	same := []interface{}{recs[1], recs[0]}
+	rt, err := CompareAlignments(NewSliceSource(recs, nil), NewSliceSource(same, nil), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rt.OK() || rt.Records != [2]int{2, 2} {
+		t.Errorf("expected identical alignments, actual %+v", rt)
+	}
+
+	truncated := *recs[1].(*SamRecord)
+	truncated.Qual = "II"
+	diff := []interface{}{recs[0], &truncated}
+	rt, err = CompareAlignments(NewSliceSource(recs, nil), NewSliceSource(diff, nil), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.OK() || rt.Missing != 1 || rt.Extra != 1 || len(rt.Examples) != 1 {
+		t.Errorf("expected one differing record, actual %+v", rt)
+	}
+}
+
+func TestCompareReferences(t *testing.T) {
+	a := []Reference{{Chrom: "chr1", Length: 100}, {Chrom: "chr2", Length: 50}}
+	b := []Reference{{Chrom: "chr1", Length: 90}, {Chrom: "chr3", Length: 10}}
+	expected := []string{"chr1: length 90 instead of 100", "chr2: missing", "chr3: not expected"}
+	got := CompareReferences(a, b)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %q, actual %q", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], got[i])
+		}
+	}
+}
+
+// writeTestBAM writes a BAM file with a few alignments on chr1 to path.
+func writeTestBAM(t *testing.T, path string) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm, err := sam.NewAux(sam.NewTag("NM"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	for i, seq := range []string{"ACGT", "GGCA", "TTAG"} {
+		rec, err := sam.NewRecord("r"+seq, ref, nil, 10*i, -1, 0, 60, cigar,
+			[]byte(seq), []byte{30, 31, 32, 33}, []sam.Aux{nm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			rec.Flags |= sam.Reverse
+		}
+		if err = w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bamPath := filepath.Join(dir, "test.bam")
+	writeTestBAM(t, bamPath)
+
+	// import the BAM file.
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.MustExec("CREATE TABLE sample (qname TEXT, flag INTEGER, rname TEXT," +
+		" pos INTEGER, mapq INTEGER, cigar TEXT, rnext TEXT, pnext INTEGER," +
+		" tlen INTEGER, seq TEXT, qual TEXT, tags TEXT)")
+	var rec SamRecord
+	in, err := NewBAMReader(bamPath, &rec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoader(db, "sample", samColumns, DefaultLoadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in.Next() {
+		err = l.Add(rec.Qname, rec.Flag, rec.Rname, rec.Pos, rec.Mapq, rec.Cigar,
+			rec.Rnext, rec.Pnext, rec.Tlen, rec.Seq, rec.Qual, rec.Tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	in.Close()
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// export the database and compare with the BAM file.
+	orig, err := NewBAMReader(bamPath, &SamRecord{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	query, _, err := SamRecordBuilder.From("sample").OrderBy("pos DESC").ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := NewReader(db.DB, "sqlite3", &SamRecord{}, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	rt, err := CompareAlignments(orig, out, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rt.OK() || rt.Records[0] != 3 {
+		t.Errorf("round trip failed: %+v", rt)
+	}
+}
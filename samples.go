@@ -0,0 +1,133 @@
+package htsdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SamplesTable is the name of the table that registers the samples stored in
+// a database with several samples.
+const SamplesTable = "samples"
+
+// SampleIDColumn is the column of record tables shared by several samples
+// that holds the ID of the sample of each record.
+const SampleIDColumn = "sample_id"
+
+// Sample is an entry of the samples registry. The records of a sample are
+// either all records of RecordTable or, if ID is valid, the records of
+// RecordTable whose sample_id is ID. The records of the latter are also
+// exposed by a view named after the sample.
+type Sample struct {
+	Name        string        `db:"name"`
+	RecordTable string        `db:"record_table"`
+	ID          sql.NullInt64 `db:"sample_id"`
+}
+
+// Table returns the table or view with the records of s.
+func (s Sample) Table() string {
+	if s.ID.Valid {
+		return s.Name
+	}
+	return s.RecordTable
+}
+
+// CreateSamplesTable creates the samples registry in db if it does not
+// exist.
+func CreateSamplesTable(db *sqlx.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + SamplesTable +
+		" (name VARCHAR(255) PRIMARY KEY, record_table TEXT NOT NULL, sample_id INTEGER)")
+	return err
+}
+
+// AddSample registers s in db, creating the registry if needed. If the ID of
+// s is valid, RecordTable must have a sample_id column and a view named after
+// the sample that selects its records is created. The sample name must be a
+// valid identifier.
+func AddSample(db *sqlx.DB, s Sample) error {
+	if !isIdentifier(s.Name) {
+		return fmt.Errorf("htsdb: invalid sample name %q", s.Name)
+	}
+	if err := CreateSamplesTable(db); err != nil {
+		return err
+	}
+	if s.ID.Valid {
+		ok, err := HasColumn(db, s.RecordTable, SampleIDColumn)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("htsdb: table %s has no %s column", s.RecordTable, SampleIDColumn)
+		}
+		_, err = db.Exec("CREATE VIEW " + s.Name + " AS SELECT * FROM " + s.RecordTable +
+			" WHERE " + SampleIDColumn + " = " + strconv.FormatInt(s.ID.Int64, 10))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(db.Rebind("INSERT INTO "+SamplesTable+
+		" (name, record_table, sample_id) VALUES (?, ?, ?)"), s.Name, s.RecordTable, s.ID)
+	return err
+}
+
+// SelectSamples returns the samples registered in db sorted by name. It
+// returns nil if db has no samples registry.
+func SelectSamples(db *sqlx.DB) ([]Sample, error) {
+	ok, err := TableExists(db, SamplesTable)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var samples []Sample
+	err = db.Select(&samples, "SELECT name, record_table, sample_id FROM "+
+		SamplesTable+" ORDER BY name")
+	return samples, err
+}
+
+// SampleTable returns the table or view with the records of the registered
+// sample name in db. It returns table if name is empty so that commands can
+// select either a sample or a table.
+func SampleTable(db *sqlx.DB, name, table string) (string, error) {
+	if name == "" {
+		return table, nil
+	}
+	samples, err := SelectSamples(db)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range samples {
+		if s.Name == name {
+			return s.Table(), nil
+		}
+	}
+	return "", fmt.Errorf("htsdb: no such sample: %s", name)
+}
+
+// SampleNames returns the names of the samples of db that share table,
+// keyed by sample ID, e.g. to label results grouped by sample_id.
+func SampleNames(db *sqlx.DB, table string) (map[int64]string, error) {
+	samples, err := SelectSamples(db)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string)
+	for _, s := range samples {
+		if s.ID.Valid && s.RecordTable == table {
+			names[s.ID.Int64] = s.Name
+		}
+	}
+	return names, nil
+}
+
+// isIdentifier returns true if s is a non-empty SQL identifier of ASCII
+// letters, digits and underscores that does not start with a digit.
+func isIdentifier(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(isAlpha(c) || c == '_' || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
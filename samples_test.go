@@ -0,0 +1,74 @@
+package htsdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestIsIdentifier(t *testing.T) {
+	tests := []struct {
+		S  string
+		OK bool
+	}{
+		{"sample", true},
+		{"_s1", true},
+		{"S_2", true},
+		{"", false},
+		{"1s", false},
+		{"s-1", false},
+		{"s; DROP TABLE t", false},
+	}
+	for _, tt := range tests {
+		if got := isIdentifier(tt.S); got != tt.OK {
+			t.Errorf("%q: expected %t, actual %t", tt.S, tt.OK, got)
+		}
+	}
+}
+
+func TestSamples(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	db.MustExec("CREATE TABLE shared (rname TEXT, start INTEGER, stop INTEGER, sample_id INTEGER)")
+	db.MustExec("INSERT INTO shared VALUES ('chr1', 1, 10, 1), ('chr1', 5, 20, 2), ('chr2', 3, 8, 2)")
+
+	if err := AddSample(db, Sample{Name: "a", RecordTable: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"b", "c"} {
+		s := Sample{Name: name, RecordTable: "shared", ID: sql.NullInt64{Int64: int64(i + 1), Valid: true}}
+		if err := AddSample(db, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddSample(db, Sample{Name: "d", RecordTable: "t", ID: sql.NullInt64{Valid: true}}); err == nil {
+		t.Error("expected error for table without sample_id")
+	}
+
+	for name, expected := range map[string]string{"": "other", "a": "t", "c": "c"} {
+		table, err := SampleTable(db, name, "other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table != expected {
+			t.Errorf("sample %q: expected table %s, actual %s", name, expected, table)
+		}
+	}
+	if _, err := SampleTable(db, "x", "other"); err == nil {
+		t.Error("expected error for missing sample")
+	}
+
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM c"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 2 {
+		t.Errorf("expected 2 records in view c, actual %d", cnt)
+	}
+	names, err := SampleNames(db, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[1] != "b" || names[2] != "c" {
+		t.Errorf("unexpected sample names %v", names)
+	}
+}
@@ -0,0 +1,311 @@
+package htsdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// pgSchemas restricts PostgreSQL catalog queries to the schemas in the
+// search path.
+const pgSchemas = " AND schemaname = ANY (current_schemas(false))"
+
+// mysqlSchema restricts MySQL catalog queries to the current database.
+const mysqlSchema = " AND table_schema = DATABASE()"
+
+// duckdbSchema restricts DuckDB catalog queries to the current schema.
+const duckdbSchema = " AND schema_name = current_schema()"
+
+// TableExists returns true if a table with the given name exists in db.
+func TableExists(db *sqlx.DB, name string) (bool, error) {
+	return TableExistsContext(context.Background(), db, name)
+}
+
+// TableExistsContext is like TableExists but the query runs with ctx.
+func TableExistsContext(ctx context.Context, db *sqlx.DB, name string) (bool, error) {
+	q := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if isPostgres(db) {
+		q = "SELECT COUNT(*) FROM pg_tables WHERE tablename = ?" + pgSchemas
+	} else if isMySQL(db) {
+		q = "SELECT COUNT(*) FROM information_schema.tables WHERE table_name = ?" + mysqlSchema
+	} else if isDuckDB(db) {
+		q = "SELECT COUNT(*) FROM duckdb_tables() WHERE table_name = ?" + duckdbSchema
+	}
+	var cnt int
+	err := db.GetContext(ctx, &cnt, db.Rebind(q), name)
+	return cnt > 0, err
+}
+
+// IndexExists returns true if an index with the given name exists in db.
+func IndexExists(db *sqlx.DB, name string) (bool, error) {
+	q := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?"
+	if isPostgres(db) {
+		q = "SELECT COUNT(*) FROM pg_indexes WHERE indexname = ?" + pgSchemas
+	} else if isMySQL(db) {
+		q = "SELECT COUNT(DISTINCT index_name) FROM information_schema.statistics" +
+			" WHERE index_name = ?" + mysqlSchema
+	} else if isDuckDB(db) {
+		q = "SELECT COUNT(*) FROM duckdb_indexes() WHERE index_name = ?" + duckdbSchema
+	}
+	var cnt int
+	err := db.Get(&cnt, db.Rebind(q), name)
+	return cnt > 0, err
+}
+
+// TableSQL returns the CREATE TABLE statement of table in db. It is only
+// available for SQLite and DuckDB databases.
+func TableSQL(db *sqlx.DB, table string) (string, error) {
+	q := "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if isDuckDB(db) {
+		q = "SELECT sql FROM duckdb_tables() WHERE table_name = ?" + duckdbSchema
+	} else if db.DriverName() != SQLite {
+		return "", fmt.Errorf("htsdb: CREATE TABLE statements are only stored by SQLite and DuckDB")
+	}
+	var stmt string
+	err := db.Get(&stmt, db.Rebind(q), table)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("htsdb: no such table: %s", table)
+	}
+	return stmt, err
+}
+
+// IndexSQL returns the CREATE INDEX statements of the explicit indexes of
+// table in db e.g. to recreate them on a copy of the table. It is not
+// available for MySQL databases.
+func IndexSQL(db *sqlx.DB, table string) ([]string, error) {
+	if isMySQL(db) {
+		return nil, fmt.Errorf("htsdb: CREATE INDEX statements are not stored by MySQL")
+	}
+	q := "SELECT sql FROM sqlite_master" +
+		" WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL"
+	if isPostgres(db) {
+		q = "SELECT indexdef FROM pg_indexes WHERE tablename = ?" + pgSchemas
+	} else if isDuckDB(db) {
+		q = "SELECT sql FROM duckdb_indexes() WHERE table_name = ? AND sql IS NOT NULL" + duckdbSchema
+	}
+	var stmts []string
+	err := db.Select(&stmts, db.Rebind(q), table)
+	return stmts, err
+}
+
+// TableColumns returns the column names of table in db.
+func TableColumns(db *sqlx.DB, table string) ([]string, error) {
+	rows, err := db.Queryx("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// CheckColumns returns an error if any of cols is not a column of table in
+// db. table can also be a table expression e.g. from ColumnMap.Table.
+func CheckColumns(db *sqlx.DB, table string, cols []string) error {
+	have, err := TableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	ok := make(map[string]bool, len(have))
+	for _, c := range have {
+		ok[c] = true
+	}
+	for _, c := range cols {
+		if !ok[c] {
+			return fmt.Errorf("htsdb: no such column: %s", c)
+		}
+	}
+	return nil
+}
+
+// HasColumn returns true if table in db has a column with the given name.
+func HasColumn(db *sqlx.DB, table, col string) (bool, error) {
+	cols, err := TableColumns(db, table)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range cols {
+		if c == col {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SchemaVersionTable is the name of the table that stores the version of the
+// layout of a database.
+const SchemaVersionTable = "schema_version"
+
+// SchemaVersion is the version of the layout created by CreateSchema. Version
+// 0 is a database without a schema version table e.g. one created by external
+// scripts.
+const SchemaVersion = 1
+
+// migrations are the steps that upgrade a database layout; migrations[i]
+// upgrades version i to version i+1.
+var migrations = []func(db *sqlx.DB) error{
+	migrateRecordTables,
+}
+
+// SampleIndexes returns the CREATE INDEX statements of the canonical indexes
+// of a record table.
+func SampleIndexes(table string) []string {
+	return []string{
+		"CREATE INDEX IF NOT EXISTS " + table + "_rname_start ON " + table + " (rname, start)",
+	}
+}
+
+// PosIndexName returns the name of the index on the reference names and
+// positions of table.
+func PosIndexName(table string) string {
+	return table + "_rname_pos"
+}
+
+// CreatePosIndex creates an index on the columns rname and pos of table, which
+// hold the reference names and positions, if it does not exist. It lets
+// queries ordered by reference and position stream records without sorting
+// the table.
+func CreatePosIndex(db *sqlx.DB, table, rname, pos string) error {
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS " + PosIndexName(table) +
+		" ON " + table + " (" + rname + ", " + pos + ")")
+	return err
+}
+
+// CreateSchema creates the canonical record table with ImportColumns and its
+// indexes in db and stores SchemaVersion. It fails if the table exists or if
+// db has record tables with a layout older than SchemaVersion; use Migrate
+// first.
+func CreateSchema(db *sqlx.DB, table string) error {
+	v, err := SelectSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if v < SchemaVersion {
+		tables, err := RecordTables(db)
+		if err != nil {
+			return err
+		}
+		if len(tables) > 0 {
+			return fmt.Errorf("htsdb: schema version %d is older than %d; migrate first",
+				v, SchemaVersion)
+		}
+	}
+	if err = CreateImportTable(db, table); err != nil {
+		return err
+	}
+	for _, stmt := range SampleIndexes(table) {
+		if _, err = db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return SetSchemaVersion(db, SchemaVersion)
+}
+
+// SelectSchemaVersion returns the schema version of db. It returns 0 if db
+// has no schema version table.
+func SelectSchemaVersion(db *sqlx.DB) (int, error) {
+	ok, err := TableExists(db, SchemaVersionTable)
+	if err != nil || !ok {
+		return 0, err
+	}
+	var v int
+	err = db.Get(&v, "SELECT COALESCE(MAX(version), 0) FROM "+SchemaVersionTable)
+	return v, err
+}
+
+// SetSchemaVersion stores v as the schema version of db. The schema version
+// table is created if it does not exist.
+func SetSchemaVersion(db *sqlx.DB, v int) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + SchemaVersionTable +
+		" (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	if _, err = db.Exec("DELETE FROM " + SchemaVersionTable); err != nil {
+		return err
+	}
+	_, err = db.Exec(db.Rebind("INSERT INTO "+SchemaVersionTable+" (version) VALUES (?)"), v)
+	return err
+}
+
+// Migrate upgrades the layout of db to SchemaVersion and returns the version
+// it started from. The version is stored after each step so that a failed
+// migration resumes from the last completed one. Databases newer than
+// SchemaVersion are an error.
+func Migrate(db *sqlx.DB) (int, error) {
+	from, err := SelectSchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if from > SchemaVersion {
+		return from, fmt.Errorf("htsdb: schema version %d is newer than %d", from, SchemaVersion)
+	}
+	for v := from; v < SchemaVersion; v++ {
+		if err = migrations[v](db); err != nil {
+			return from, fmt.Errorf("htsdb: migrate to schema version %d: %v", v+1, err)
+		}
+		if err = SetSchemaVersion(db, v+1); err != nil {
+			return from, err
+		}
+	}
+	return from, nil
+}
+
+// RecordTables returns the tables of db with the rname, start and stop
+// columns of records, sorted by name.
+func RecordTables(db *sqlx.DB) ([]string, error) {
+	q := "SELECT name FROM sqlite_master" +
+		" WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	if isPostgres(db) {
+		q = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()" +
+			" ORDER BY tablename"
+	} else if isMySQL(db) {
+		q = "SELECT table_name FROM information_schema.tables" +
+			" WHERE table_type = 'BASE TABLE'" + mysqlSchema + " ORDER BY table_name"
+	} else if isDuckDB(db) {
+		q = "SELECT table_name FROM duckdb_tables() WHERE NOT temporary" + duckdbSchema +
+			" ORDER BY table_name"
+	}
+	var tables []string
+	err := db.Select(&tables, q)
+	if err != nil {
+		return nil, err
+	}
+	var recs []string
+	for _, t := range tables {
+		if err = CheckColumns(db, t, []string{"rname", "start", "stop"}); err == nil {
+			recs = append(recs, t)
+		}
+	}
+	return recs, nil
+}
+
+// migrateRecordTables upgrades the record tables of databases created by
+// external scripts: a missing copy_number column is added with 1 for every
+// record and the canonical indexes are created.
+func migrateRecordTables(db *sqlx.DB) error {
+	tables, err := RecordTables(db)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		ok, err := HasColumn(db, t, "copy_number")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			_, err = db.Exec("ALTER TABLE " + t +
+				" ADD COLUMN copy_number INTEGER NOT NULL DEFAULT 1")
+			if err != nil {
+				return err
+			}
+		}
+		for _, stmt := range SampleIndexes(t) {
+			if _, err = db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
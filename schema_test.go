@@ -0,0 +1,51 @@
+package htsdb
+
+import (
+	"testing"
+)
+
+func TestCreateSchema(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+
+	// t is a record table of an external script without copy_number.
+	if err := CreateSchema(db, "sample"); err == nil {
+		t.Error("expected error for unmigrated record table")
+	}
+	from, err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 {
+		t.Errorf("expected migration from version 0, actual %d", from)
+	}
+	if ok, err := HasColumn(db, "t", "copy_number"); err != nil || !ok {
+		t.Errorf("expected copy_number column after migration, %v", err)
+	}
+	if ok, err := IndexExists(db, "t_rname_start"); err != nil || !ok {
+		t.Errorf("expected index after migration, %v", err)
+	}
+
+	if err = CreateSchema(db, "sample"); err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckColumns(db, "sample", ImportColumns); err != nil {
+		t.Error(err)
+	}
+	if v, err := SelectSchemaVersion(db); err != nil || v != SchemaVersion {
+		t.Errorf("expected schema version %d, actual %d, %v", SchemaVersion, v, err)
+	}
+	if tables, err := RecordTables(db); err != nil || len(tables) != 2 {
+		t.Errorf("expected 2 record tables, actual %v, %v", tables, err)
+	}
+	if from, err = Migrate(db); err != nil || from != SchemaVersion {
+		t.Errorf("expected no migration, actual from %d, %v", from, err)
+	}
+
+	if err = SetSchemaVersion(db, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Migrate(db); err == nil {
+		t.Error("expected error for newer schema version")
+	}
+}
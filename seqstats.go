@@ -0,0 +1,149 @@
+package htsdb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// GCFraction returns the fraction of G and C bases in seq, ignoring case. N
+// and other ambiguous bases count towards the length. It returns 0 for an
+// empty sequence.
+func GCFraction(seq string) float64 {
+	if len(seq) == 0 {
+		return 0
+	}
+	var gc int
+	for i := 0; i < len(seq); i++ {
+		switch seq[i] {
+		case 'G', 'C', 'g', 'c':
+			gc++
+		}
+	}
+	return float64(gc) / float64(len(seq))
+}
+
+// MaxHomopolymer returns the length of the longest run of the same base in
+// seq, ignoring case.
+func MaxHomopolymer(seq string) int {
+	var max, run int
+	var prev byte
+	for i := 0; i < len(seq); i++ {
+		c := seq[i] | 0x20
+		if i > 0 && c == prev {
+			run++
+		} else {
+			run = 1
+		}
+		if run > max {
+			max = run
+		}
+		prev = c
+	}
+	return max
+}
+
+// DustWindow is the number of bases of the windows scored by DustScore, as in
+// the DUST low-complexity filter.
+const DustWindow = 64
+
+// Columns in which htsdb-annotate-seq and htsdb-import --annotate-seq store
+// the GCFraction, MaxHomopolymer and DustScore of each read.
+const (
+	GCColumn          = "gc"
+	HomopolymerColumn = "max_homopolymer"
+	DustColumn        = "dust"
+)
+
+// ReverseComplement returns the upper case reverse complement of seq. Bases
+// other than A, C, G, T and U are complemented to N.
+func ReverseComplement(seq string) string {
+	b := make([]byte, len(seq))
+	for i := 0; i < len(seq); i++ {
+		var c byte
+		switch upper(seq[i]) {
+		case 'A':
+			c = 'T'
+		case 'C':
+			c = 'G'
+		case 'G':
+			c = 'C'
+		case 'T', 'U':
+			c = 'A'
+		default:
+			c = 'N'
+		}
+		b[len(seq)-1-i] = c
+	}
+	return string(b)
+}
+
+// DustScore returns the low-complexity score of seq, ignoring case: the
+// largest score of its windows of DustWindow bases, where the score of a
+// window is sum(c*(c-1)/2)/(l-1) over the counts c of each of its l
+// overlapping triplets. Homopolymers and short tandem repeats score high, e.g.
+// 31 for 64 A's, while random sequences score below 1. Triplets with bases
+// other than A, C, G and T are skipped. It returns 0 for sequences with fewer
+// than two scored triplets.
+func DustScore(seq string) float64 {
+	trip := make([]int, 0, len(seq))
+	code := 0
+	valid := 0
+	for i := 0; i < len(seq); i++ {
+		b := symbolIndex(seq[i])
+		if b > 3 {
+			valid = 0
+		} else {
+			code = (code<<2 | b) & 63
+			valid++
+		}
+		if i >= 2 {
+			if valid >= 3 {
+				trip = append(trip, code)
+			} else {
+				trip = append(trip, -1)
+			}
+		}
+	}
+	var counts [64]int
+	var sum, l int
+	var max float64
+	w := DustWindow - 2
+	for i, t := range trip {
+		if t >= 0 {
+			sum += counts[t]
+			counts[t]++
+			l++
+		}
+		if i >= w {
+			if old := trip[i-w]; old >= 0 {
+				counts[old]--
+				sum -= counts[old]
+				l--
+			}
+		}
+		if l > 1 {
+			if score := float64(sum) / float64(l-1); score > max {
+				max = score
+			}
+		}
+	}
+	return max
+}
+
+// LowComplexityFilter returns an SQL clause that excludes the records of
+// table whose DustScore, stored in DustColumn by htsdb-annotate-seq, is above
+// threshold. Records without a score e.g. without sequence are kept. It
+// returns an error if table has no DustColumn.
+func LowComplexityFilter(db *sqlx.DB, table string, threshold float64) (string, error) {
+	ok, err := HasColumn(db, table, DustColumn)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("htsdb: no %s column; annotate the table with htsdb-annotate-seq", DustColumn)
+	}
+	return "(" + DustColumn + " IS NULL OR " + DustColumn + " <= " +
+		strconv.FormatFloat(threshold, 'g', -1, 64) + ")", nil
+}
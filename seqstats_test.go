@@ -0,0 +1,81 @@
+package htsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var seqStatsTests = []struct {
+	Seq  string
+	GC   float64
+	Homo int
+}{
+	{Seq: "", GC: 0, Homo: 0},
+	{Seq: "ACGT", GC: 0.5, Homo: 1},
+	{Seq: "AAaaCG", GC: 2.0 / 6, Homo: 4},
+	{Seq: "GGCCNN", GC: 4.0 / 6, Homo: 2},
+	{Seq: "TTTTTTTT", GC: 0, Homo: 8},
+}
+
+func TestSeqStats(t *testing.T) {
+	for _, tt := range seqStatsTests {
+		if got := GCFraction(tt.Seq); got != tt.GC {
+			t.Errorf("GCFraction(%q): got %v, want %v", tt.Seq, got, tt.GC)
+		}
+		if got := MaxHomopolymer(tt.Seq); got != tt.Homo {
+			t.Errorf("MaxHomopolymer(%q): got %d, want %d", tt.Seq, got, tt.Homo)
+		}
+	}
+}
+
+func TestReverseComplement(t *testing.T) {
+	for seq, want := range map[string]string{"": "", "ACGT": "ACGT", "aacU": "AGTT", "GNC": "GNC", "AC-": "NGT"} {
+		if got := ReverseComplement(seq); got != want {
+			t.Errorf("ReverseComplement(%q): got %q, want %q", seq, got, want)
+		}
+	}
+}
+
+func TestDustScore(t *testing.T) {
+	tests := []struct {
+		Seq      string
+		Expected float64
+	}{
+		{"", 0},
+		{"ACG", 0},
+		{"AAAAA", 1.5},
+		{strings.Repeat("a", 64), 31},
+		{strings.Repeat("A", 100), 31},
+		{"ACGTNACGT", 2.0 / 3},
+	}
+	for _, tt := range tests {
+		if got := DustScore(tt.Seq); got != tt.Expected {
+			t.Errorf("DustScore(%q): got %v, want %v", tt.Seq, got, tt.Expected)
+		}
+	}
+	random := "ACGTTGCAAGCTTCGATCGGATCCTAGGCATGCAATTGCGCGTACGATCAGTCAGTACGTAGCT"
+	if got := DustScore(random); got >= 1 {
+		t.Errorf("DustScore of random sequence: got %v, want < 1", got)
+	}
+	if DustScore(strings.Repeat("CA", 40)) < 10 {
+		t.Errorf("DustScore of dinucleotide repeat: got %v, want >= 10", DustScore(strings.Repeat("CA", 40)))
+	}
+}
+
+func TestLowComplexityFilter(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	if _, err := LowComplexityFilter(db, "foo", 2); err == nil {
+		t.Error("expected error for table without dust column")
+	}
+	db.MustExec("ALTER TABLE foo ADD COLUMN dust REAL")
+	f, err := LowComplexityFilter(db, "foo", 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "(dust IS NULL OR dust <= 2.5)"; f != exp {
+		t.Errorf("expected %q, actual %q", exp, f)
+	}
+}
@@ -0,0 +1,26 @@
+package htsdb
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// InterruptedMarker is printed after the partial results of a command that
+// was interrupted by a signal.
+const InterruptedMarker = "# INTERRUPTED"
+
+// SignalContext returns a context that is cancelled when the process receives
+// SIGINT or SIGTERM. After cancellation, signal handling is reset so that a
+// second signal terminates the process immediately. The returned function
+// releases the resources associated with the context.
+func SignalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
@@ -0,0 +1,65 @@
+package htsdb
+
+import (
+	"fmt"
+)
+
+// SNV is a non-reference base at a position with its counts on each strand.
+// Pos is 0-based.
+type SNV struct {
+	Rname    string
+	Pos      int
+	Ref, Alt byte
+	// Depth is the count of all symbols at the position on both strands,
+	// including deletions.
+	Depth float64
+	// Fwd and Rev are the counts of Alt on the forward and reverse strands.
+	Fwd, Rev float64
+}
+
+// Count returns the count of the alternative base on both strands.
+func (s SNV) Count() float64 { return s.Fwd + s.Rev }
+
+// Fraction returns the fraction of the depth that is the alternative base.
+func (s SNV) Fraction() float64 {
+	if s.Depth == 0 {
+		return 0
+	}
+	return s.Count() / s.Depth
+}
+
+// TallySNVs compares the pileups of the forward and reverse strand reads of
+// the same region to ref, the reference sequence of the region, and returns
+// the non-reference bases whose count is at least minCount and whose fraction
+// of the depth is at least minFrac, in position order. Positions with
+// reference N, other bases and deletions are not reported.
+func TallySNVs(fwd, rev *Pileup, ref string, minCount, minFrac float64) ([]SNV, error) {
+	if fwd.region != rev.region {
+		return nil, fmt.Errorf("htsdb: pileups of different regions")
+	}
+	fc, rc := fwd.Columns(), rev.Columns()
+	if len(ref) != len(fc) {
+		return nil, fmt.Errorf("htsdb: reference of length %d for region of length %d",
+			len(ref), len(fc))
+	}
+	var snvs []SNV
+	for i := range fc {
+		refIdx := symbolIndex(ref[i])
+		if refIdx > 3 {
+			continue
+		}
+		depth := fc[i].Depth() + rc[i].Depth()
+		for alt := 0; alt < 4; alt++ {
+			if alt == refIdx {
+				continue
+			}
+			s := SNV{Rname: fc[i].Rname, Pos: fc[i].Pos, Ref: PileupSymbols[refIdx],
+				Alt: PileupSymbols[alt], Depth: depth,
+				Fwd: fc[i].Counts[alt], Rev: rc[i].Counts[alt]}
+			if s.Count() > 0 && s.Count() >= minCount && s.Fraction() >= minFrac {
+				snvs = append(snvs, s)
+			}
+		}
+	}
+	return snvs, nil
+}
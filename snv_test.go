@@ -0,0 +1,42 @@
+package htsdb
+
+import (
+	"testing"
+)
+
+func TestTallySNVs(t *testing.T) {
+	reg := Region{Rname: "chr1", Start: 0, Stop: 4}
+	fwd, rev := NewPileup(reg), NewPileup(reg)
+	fwd.Add(1, "5M", "ACGTA", 3)
+	fwd.Add(1, "5M", "ATGTA", 1)
+	rev.Add(1, "5M", "ATGTC", 2)
+	rev.Add(1, "5M", "ACGNA", 2)
+
+	snvs, err := TallySNVs(fwd, rev, "acgta", 2, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SNV{
+		{Rname: "chr1", Pos: 1, Ref: 'C', Alt: 'T', Depth: 8, Fwd: 1, Rev: 2},
+		{Rname: "chr1", Pos: 4, Ref: 'A', Alt: 'C', Depth: 8, Fwd: 0, Rev: 2},
+	}
+	if len(snvs) != len(expected) {
+		t.Fatalf("expected %d SNVs, actual %v", len(expected), snvs)
+	}
+	for i, e := range expected {
+		if snvs[i] != e {
+			t.Errorf("expected %+v, actual %+v", e, snvs[i])
+		}
+	}
+	if f := snvs[1].Fraction(); f != 0.25 {
+		t.Errorf("expected fraction 0.25, actual %v", f)
+	}
+
+	if _, err := TallySNVs(fwd, rev, "ACG", 1, 0); err == nil {
+		t.Error("expected error for reference of wrong length")
+	}
+	other := NewPileup(Region{Rname: "chr2", Start: 0, Stop: 4})
+	if _, err := TallySNVs(fwd, other, "ACGTA", 1, 0); err == nil {
+		t.Error("expected error for pileups of different regions")
+	}
+}
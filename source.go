@@ -0,0 +1,175 @@
+package htsdb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RecordSource is an iterator on htsdb records independent of their storage.
+// It is implemented by Reader for databases, BAMReader for BAM files,
+// MultiSource for several sources and SliceSource for records in memory, so
+// that algorithms written against it run on any of them. It is consumed by the
+// tools that scan every record once e.g. htsdb-saturation and the SAM and BAM
+// export. Tools that push work into SQL, such as the indexed counts of
+// htsdb-count-reads-on-feats and the per-reference, range-restricted or sorted
+// queries of htsdb-coverage and htsdb-relative-pos-distro, query the database
+// directly, since a RecordSource cannot filter, restrict or order its records.
+type RecordSource interface {
+	// Next advances to the next record and returns false when the records
+	// are exhausted or on error.
+	Next() bool
+	// Record returns the record read by the last call to Next.
+	Record() interface{}
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	// Close releases the resources of the source.
+	Close()
+	// References returns the references on which the records align.
+	References() ([]Reference, error)
+}
+
+// Assert that interfaces are satisfied
+var (
+	_ RecordSource = (*Reader)(nil)
+	_ RecordSource = (*BAMReader)(nil)
+	_ RecordSource = (*MultiSource)(nil)
+	_ RecordSource = (*SliceSource)(nil)
+)
+
+// MultiSource reads the records of several sources one after the other e.g.
+// the replicates of a sample stored in different databases.
+type MultiSource struct {
+	srcs []RecordSource
+	cur  int
+	err  error
+}
+
+// NewMultiSource returns a MultiSource that reads srcs in order.
+func NewMultiSource(srcs ...RecordSource) *MultiSource {
+	return &MultiSource{srcs: srcs}
+}
+
+// Next advances to the next record of the current source, moving to the next
+// source when the current one is exhausted.
+func (m *MultiSource) Next() bool {
+	for m.err == nil && m.cur < len(m.srcs) {
+		if m.srcs[m.cur].Next() {
+			return true
+		}
+		if m.err = m.srcs[m.cur].Err(); m.err != nil {
+			return false
+		}
+		m.cur++
+	}
+	return false
+}
+
+// Record returns the record read by the last call to Next.
+func (m *MultiSource) Record() interface{} {
+	if m.cur >= len(m.srcs) {
+		return nil
+	}
+	return m.srcs[m.cur].Record()
+}
+
+// Err returns the error of the source that stopped the iteration.
+func (m *MultiSource) Err() error { return m.err }
+
+// Close closes all sources.
+func (m *MultiSource) Close() {
+	for _, s := range m.srcs {
+		s.Close()
+	}
+}
+
+// References returns the union of the references of all sources sorted by
+// name. References with different lengths in different sources get the
+// largest one.
+func (m *MultiSource) References() ([]Reference, error) {
+	lens := make(map[string]int)
+	for _, s := range m.srcs {
+		refs, err := s.References()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refs {
+			if l, ok := lens[r.Chrom]; !ok || r.Length > l {
+				lens[r.Chrom] = r.Length
+			}
+		}
+	}
+	refs := make([]Reference, 0, len(lens))
+	for name, l := range lens {
+		refs = append(refs, Reference{Chrom: name, Length: l})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Chrom < refs[j].Chrom })
+	return refs, nil
+}
+
+// SliceSource reads records held in memory e.g. in tests or for records that
+// were computed rather than stored.
+type SliceSource struct {
+	recs []interface{}
+	refs []Reference
+	cur  int
+}
+
+// NewSliceSource returns a SliceSource for recs that align on refs. If refs
+// is nil, References derives them from the records, which must then be
+// *Feature or *OrientedFeature.
+func NewSliceSource(recs []interface{}, refs []Reference) *SliceSource {
+	return &SliceSource{recs: recs, refs: refs, cur: -1}
+}
+
+// Next advances to the next record.
+func (s *SliceSource) Next() bool {
+	if s.cur < len(s.recs) {
+		s.cur++
+	}
+	return s.cur < len(s.recs)
+}
+
+// Record returns the record read by the last call to Next.
+func (s *SliceSource) Record() interface{} {
+	if s.cur < 0 || s.cur >= len(s.recs) {
+		return nil
+	}
+	return s.recs[s.cur]
+}
+
+// Err returns nil.
+func (s *SliceSource) Err() error { return nil }
+
+// Close does nothing.
+func (s *SliceSource) Close() {}
+
+// References returns the references of s.
+func (s *SliceSource) References() ([]Reference, error) {
+	if s.refs != nil {
+		return s.refs, nil
+	}
+	lens := make(map[string]int)
+	var names []string
+	for _, rec := range s.recs {
+		f, ok := rec.(*Feature)
+		if !ok {
+			if o, isOriented := rec.(*OrientedFeature); isOriented {
+				f, ok = &o.Feature, true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("htsdb: cannot derive references from record of type %T", rec)
+		}
+		if _, seen := lens[f.Rname]; !seen {
+			names = append(names, f.Rname)
+		}
+		if f.End() > lens[f.Rname] {
+			lens[f.Rname] = f.End()
+		}
+	}
+	refs := make([]Reference, len(names))
+	for i, name := range names {
+		refs[i] = Reference{Chrom: name, Length: lens[name]}
+	}
+	return refs, nil
+}
@@ -0,0 +1,54 @@
+package htsdb
+
+import (
+	"testing"
+
+	"github.com/biogo/biogo/feat"
+)
+
+func TestMultiSource(t *testing.T) {
+	s1 := NewSliceSource([]interface{}{
+		&Feature{Rname: "chr1", Range: Range{StartPos: 0, StopPos: 9}},
+		&Feature{Rname: "chr2", Range: Range{StartPos: 5, StopPos: 19}},
+	}, nil)
+	s2 := NewSliceSource([]interface{}{
+		&OrientedFeature{Orient: feat.Forward,
+			Feature: Feature{Rname: "chr1", Range: Range{StartPos: 30, StopPos: 39}}},
+	}, nil)
+	m := NewMultiSource(s1, NewSliceSource(nil, nil), s2)
+	defer m.Close()
+
+	var starts []int
+	for m.Next() {
+		switch r := m.Record().(type) {
+		case *Feature:
+			starts = append(starts, r.Start())
+		case *OrientedFeature:
+			starts = append(starts, r.Start())
+		}
+	}
+	if m.Err() != nil {
+		t.Fatal(m.Err())
+	}
+	if len(starts) != 3 || starts[0] != 0 || starts[1] != 5 || starts[2] != 30 {
+		t.Errorf("unexpected records %v", starts)
+	}
+
+	refs, err := m.References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Reference{{"chr1", 40}, {"chr2", 20}}
+	if len(refs) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, refs)
+	}
+	for i := range refs {
+		if refs[i] != expected[i] {
+			t.Errorf("expected %v, actual %v", expected[i], refs[i])
+		}
+	}
+
+	if _, err = NewSliceSource([]interface{}{1}, nil).References(); err == nil {
+		t.Error("expected error for records without reference")
+	}
+}
@@ -0,0 +1,97 @@
+package htsdb
+
+import (
+	"math"
+	"sort"
+)
+
+// Pearson returns the Pearson correlation coefficient of x and y, which must
+// have the same length. It returns NaN if either has zero variance.
+func Pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	if n == 0 {
+		return math.NaN()
+	}
+	var mx, my float64
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx, my = mx/n, my/n
+	var sxy, sxx, syy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// Spearman returns the Spearman rank correlation coefficient of x and y.
+func Spearman(x, y []float64) float64 {
+	return Pearson(Ranks(x), Ranks(y))
+}
+
+// Ranks returns the 1-based ranks of the values of x. Tied values get the
+// average of their ranks.
+func Ranks(x []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return x[idx[i]] < x[idx[j]] })
+	ranks := make([]float64, len(x))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && x[idx[j+1]] == x[idx[i]] {
+			j++
+		}
+		r := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			ranks[idx[k]] = r
+		}
+		i = j + 1
+	}
+	return ranks
+}
+
+// Uniformity describes how evenly the per-base coverage of a feature is
+// distributed along it.
+type Uniformity struct {
+	// Covered is the fraction of bases with non-zero depth.
+	Covered float64
+	// Gini is the Gini coefficient of the depths; 0 for uniform coverage and
+	// close to 1 for a pileup at a single position.
+	Gini float64
+	// MaxMean is the ratio of the maximum to the mean depth.
+	MaxMean float64
+}
+
+// CoverageUniformity returns the uniformity of depth, the per-base coverage of
+// a feature. Gini and MaxMean are NaN if no base is covered.
+func CoverageUniformity(depth []float64) Uniformity {
+	u := Uniformity{Gini: math.NaN(), MaxMean: math.NaN()}
+	sorted := append([]float64(nil), depth...)
+	sort.Float64s(sorted)
+	var sum, weighted float64
+	covered := 0
+	for i, d := range sorted {
+		sum += d
+		weighted += float64(i+1) * d
+		if d > 0 {
+			covered++
+		}
+	}
+	if covered == 0 {
+		return u
+	}
+	n := float64(len(sorted))
+	u.Covered = float64(covered) / n
+	u.Gini = 2*weighted/(n*sum) - (n+1)/n
+	u.MaxMean = sorted[len(sorted)-1] / (sum / n)
+	return u
+}
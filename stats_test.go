@@ -0,0 +1,58 @@
+package htsdb
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRanks(t *testing.T) {
+	got := Ranks([]float64{10, 30, 20, 20, 5})
+	expected := []float64{2, 5, 3.5, 3.5, 1}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong ranks: expected %v, actual %v", expected, got)
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		y                 []float64
+		pearson, spearman float64
+	}{
+		{[]float64{2, 4, 6, 8, 10}, 1, 1},
+		{[]float64{5, 4, 3, 2, 1}, -1, -1},
+		{[]float64{1, 4, 9, 16, 100}, 0.7952, 1},
+	}
+	for _, tt := range tests {
+		if p := Pearson(x, tt.y); math.Abs(p-tt.pearson) > 1e-4 {
+			t.Errorf("Pearson(%v): expected %v, actual %v", tt.y, tt.pearson, p)
+		}
+		if s := Spearman(x, tt.y); math.Abs(s-tt.spearman) > 1e-4 {
+			t.Errorf("Spearman(%v): expected %v, actual %v", tt.y, tt.spearman, s)
+		}
+	}
+	if p := Pearson(x, []float64{1, 1, 1, 1, 1}); !math.IsNaN(p) {
+		t.Errorf("expected NaN for constant input, actual %v", p)
+	}
+}
+
+func TestCoverageUniformity(t *testing.T) {
+	tests := []struct {
+		depth                  []float64
+		covered, gini, maxMean float64
+	}{
+		{[]float64{2, 2, 2, 2}, 1, 0, 1},
+		{[]float64{0, 4, 0, 0}, 0.25, 0.75, 4},
+		{[]float64{1, 3, 0, 0}, 0.5, 0.625, 3},
+	}
+	for _, tt := range tests {
+		u := CoverageUniformity(tt.depth)
+		if u.Covered != tt.covered || math.Abs(u.Gini-tt.gini) > 1e-9 || u.MaxMean != tt.maxMean {
+			t.Errorf("%v: expected %v %v %v, actual %+v", tt.depth, tt.covered, tt.gini, tt.maxMean, u)
+		}
+	}
+	if u := CoverageUniformity([]float64{0, 0}); u.Covered != 0 || !math.IsNaN(u.Gini) || !math.IsNaN(u.MaxMean) {
+		t.Errorf("expected no coverage, actual %+v", u)
+	}
+}
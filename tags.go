@@ -0,0 +1,57 @@
+package htsdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TagExpr returns an SQL expression for the value of the integer SAM tag tag
+// e.g. NM or AS of the records of table in db. If table has a column named
+// after the lower case tag e.g. nm, the column is used; otherwise the value is
+// parsed from the tab separated tags column, which is slower. Records without
+// the tag have NULL value.
+func TagExpr(db *sqlx.DB, table, tag string) (string, error) {
+	if err := checkTag(tag); err != nil {
+		return "", err
+	}
+	col := strings.ToLower(tag)
+	ok, err := HasColumn(db, table, col)
+	if err != nil || ok {
+		return col, err
+	}
+	if isPostgres(db) {
+		return "CAST(SUBSTRING(tags FROM '(?:^|\t)" + tag + ":i:(-?[0-9]+)') AS INTEGER)", nil
+	}
+	if isMySQL(db) {
+		return "CAST(SUBSTRING_INDEX(REGEXP_SUBSTR(tags, '(^|\t)" + tag +
+			":i:-?[0-9]+'), ':', -1) AS SIGNED)", nil
+	}
+	if isDuckDB(db) {
+		// regexp_extract returns an empty string, not NULL, without a match.
+		return "TRY_CAST(regexp_extract(tags, '(^|\t)" + tag + ":i:(-?[0-9]+)', 2) AS INTEGER)", nil
+	}
+	return tagParseExpr(tag), nil
+}
+
+// checkTag returns an error if tag is not a valid SAM tag name.
+func checkTag(tag string) error {
+	if len(tag) != 2 || !isAlpha(tag[0]) || !(isAlpha(tag[1]) || tag[1] >= '0' && tag[1] <= '9') {
+		return fmt.Errorf("htsdb: invalid SAM tag %q", tag)
+	}
+	return nil
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// tagParseExpr returns an SQL expression that parses the value of integer tag
+// from the tags column. The tag is searched after a tab so that it does not
+// match the end of another tag; CAST stops at the tab after the value.
+func tagParseExpr(tag string) string {
+	pos := "INSTR(char(9) || tags, char(9) || '" + tag + ":i:')"
+	return "CASE WHEN " + pos + " = 0 THEN NULL ELSE CAST(SUBSTR(tags, " + pos +
+		" + 5) AS INTEGER) END"
+}
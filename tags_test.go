@@ -0,0 +1,65 @@
+package htsdb
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestTagExpr(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), "sqlite3")
+	defer db.Close()
+	db.MustExec("CREATE TABLE sample (tags, nm)")
+	db.MustExec(`INSERT INTO sample VALUES
+		('XAS:i:9' || char(9) || 'NM:i:2' || char(9) || 'AS:i:-12', 7),
+		('NM:i:0', 0), ('MD:Z:5', NULL)`)
+
+	tests := []struct {
+		Tag      string
+		Expr     string
+		Expected []sql.NullInt64
+	}{
+		{"AS", tagParseExpr("AS"), []sql.NullInt64{{Int64: -12, Valid: true}, {}, {}}},
+		{"NM", "nm", []sql.NullInt64{{Int64: 7, Valid: true}, {Int64: 0, Valid: true}, {}}},
+	}
+	for _, tt := range tests {
+		expr, err := TagExpr(db, "sample", tt.Tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expr != tt.Expr {
+			t.Errorf("%s: expected %q, actual %q", tt.Tag, tt.Expr, expr)
+		}
+		var got []sql.NullInt64
+		if err = db.Select(&got, "SELECT "+expr+" FROM sample ORDER BY rowid"); err != nil {
+			t.Fatal(err)
+		}
+		for i := range tt.Expected {
+			if got[i] != tt.Expected[i] {
+				t.Errorf("%s row %d: expected %v, actual %v", tt.Tag, i, tt.Expected[i], got[i])
+			}
+		}
+	}
+
+	var nm sql.NullInt64
+	if err := db.Get(&nm, "SELECT "+tagParseExpr("NM")+" FROM sample LIMIT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if !nm.Valid || nm.Int64 != 2 {
+		t.Errorf("parsed NM: expected 2, actual %v", nm)
+	}
+}
+
+func TestCheckTag(t *testing.T) {
+	for _, tag := range []string{"NM", "AS", "X0", "nm"} {
+		if err := checkTag(tag); err != nil {
+			t.Errorf("%s: unexpected error %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"", "N", "NMX", "0A", "N'"} {
+		if err := checkTag(tag); err == nil {
+			t.Errorf("%q: expected error", tag)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package htsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// Tiles holds non-overlapping genomic intervals of arbitrary size, e.g.
+// recombination blocks or custom bins, that are used as aggregation units in
+// place of fixed size bins. Tiles are sorted by start per reference.
+type Tiles map[string][]Region
+
+// NewTiles returns the Tiles made of regions. It returns an error if any two
+// regions overlap because a record would then be counted in both.
+func NewTiles(regions []Region) (Tiles, error) {
+	t := make(Tiles)
+	for _, r := range regions {
+		t[r.Rname] = append(t[r.Rname], r)
+	}
+	for _, regs := range t {
+		sort.Slice(regs, func(i, j int) bool { return regs[i].Start < regs[j].Start })
+		for i := 1; i < len(regs); i++ {
+			if regs[i].Start <= regs[i-1].Stop {
+				return nil, fmt.Errorf("htsdb: tiles %s:%d-%d and %s:%d-%d overlap",
+					regs[i-1].Rname, regs[i-1].Start, regs[i-1].Stop,
+					regs[i].Rname, regs[i].Start, regs[i].Stop)
+			}
+		}
+	}
+	return t, nil
+}
+
+// ReadTilesFile reads tiles from the BED file f. The empty string returns
+// nil tiles.
+func ReadTilesFile(f string) (Tiles, error) {
+	if f == "" {
+		return nil, nil
+	}
+	regions, err := ReadRegionsFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewTiles(regions)
+}
+
+// Regions returns the tiles sorted by reference and start.
+func (t Tiles) Regions() []Region {
+	refs := make([]string, 0, len(t))
+	for rname := range t {
+		refs = append(refs, rname)
+	}
+	sort.Strings(refs)
+	var regions []Region
+	for _, rname := range refs {
+		regions = append(regions, t[rname]...)
+	}
+	return regions
+}
+
+// Find returns the tile that contains pos, in HtsdbCoords, on rname. It
+// returns false if pos is not in any tile.
+func (t Tiles) Find(rname string, pos int) (Region, bool) {
+	regs := t[rname]
+	i := sort.Search(len(regs), func(i int) bool { return regs[i].Stop >= pos })
+	if i < len(regs) && regs[i].Start <= pos {
+		return regs[i], true
+	}
+	return Region{}, false
+}
+
+// Count runs b, a builder from BinCountBuilder with bins of size 1 whose
+// indices are the record starts in HtsdbCoords, and sums the values of the
+// records starting in each tile. Records starting outside all tiles are
+// ignored. The values are returned in the order of Regions.
+func (t Tiles) Count(db *sqlx.DB, b squirrel.SelectBuilder) ([]float64, error) {
+	return t.CountContext(context.Background(), db, b)
+}
+
+// CountContext is like Count but the query runs with ctx.
+func (t Tiles) CountContext(ctx context.Context, db *sqlx.DB, b squirrel.SelectBuilder) ([]float64, error) {
+	query, args, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryxContext(ctx, db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	regions := t.Regions()
+	index := make(map[Region]int, len(regions))
+	for i, r := range regions {
+		index[r] = i
+	}
+	values := make([]float64, len(regions))
+	var bin Bin
+	for rows.Next() {
+		if err = rows.StructScan(&bin); err != nil {
+			return nil, err
+		}
+		if r, ok := t.Find(bin.Rname, bin.Bin); ok {
+			values[index[r]] += bin.Value
+		}
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,43 @@
+package htsdb
+
+import "testing"
+
+func TestTiles(t *testing.T) {
+	tiles, err := NewTiles([]Region{
+		{Rname: "chr1", Start: 100, Stop: 149},
+		{Rname: "chr1", Start: 0, Stop: 99},
+		{Rname: "chr2", Start: 10, Stop: 19},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs := tiles.Regions()
+	if len(regs) != 3 || regs[0].Start != 0 || regs[2].Rname != "chr2" {
+		t.Errorf("unexpected regions: %v", regs)
+	}
+	tests := []struct {
+		Rname string
+		Pos   int
+		Start int
+		Found bool
+	}{
+		{"chr1", 0, 0, true},
+		{"chr1", 99, 0, true},
+		{"chr1", 100, 100, true},
+		{"chr1", 150, 0, false},
+		{"chr2", 5, 0, false},
+		{"chr3", 5, 0, false},
+	}
+	for _, tt := range tests {
+		r, ok := tiles.Find(tt.Rname, tt.Pos)
+		if ok != tt.Found || (ok && r.Start != tt.Start) {
+			t.Errorf("Find(%s, %d): expected %d, %v, actual %v, %v", tt.Rname, tt.Pos,
+				tt.Start, tt.Found, r, ok)
+		}
+	}
+
+	_, err = NewTiles([]Region{{Rname: "chr1", Start: 0, Stop: 10}, {Rname: "chr1", Start: 10, Stop: 20}})
+	if err == nil {
+		t.Error("expected error for overlapping tiles")
+	}
+}
@@ -0,0 +1,386 @@
+package htsdb
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Track holds per-base values on a single reference. Keys are 0-based
+// positions.
+type Track map[int]float64
+
+// Positions returns the positions of t in increasing order.
+func (t Track) Positions() []int {
+	pos := make([]int, 0, len(t))
+	for p := range t {
+		pos = append(pos, p)
+	}
+	sort.Ints(pos)
+	return pos
+}
+
+// WriteBedGraph writes the positions of t with non-zero values to w in
+// bedGraph format. Consecutive positions with equal values are merged into a
+// single interval.
+func WriteBedGraph(w io.Writer, rname string, t Track) error {
+	bw := NewBedGraphWriter(w)
+	for _, p := range t.Positions() {
+		if err := bw.Add(rname, p, t[p]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Coverage holds the per-base depth of intervals on a single reference as the
+// changes of depth at their boundaries so that its size depends on the number
+// of intervals rather than on the reference length.
+type Coverage map[int]float64
+
+// Add adds depth v to the 0-based half-open interval [start, end).
+func (c Coverage) Add(start, end int, v float64) {
+	c[start] += v
+	c[end] -= v
+}
+
+// WriteBedGraph writes the intervals of c with non-zero depth to w in
+// bedGraph format with depths formatted by f. Adjacent intervals with equal
+// depth are merged.
+func (c Coverage) WriteBedGraph(w io.Writer, rname string, f FloatFormat) error {
+	bw := bufio.NewWriter(w)
+	err := c.Intervals(func(start, end int, depth float64) error {
+		_, err := bw.WriteString(rname + "\t" + strconv.Itoa(start) + "\t" +
+			strconv.Itoa(end) + "\t" + f.Format(depth) + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Intervals calls fn for each maximal 0-based half-open interval of c with
+// non-zero depth in increasing position order.
+func (c Coverage) Intervals(fn func(start, end int, depth float64) error) error {
+	var depth float64
+	start := 0
+	for _, p := range Track(c).Positions() {
+		if c[p] == 0 {
+			continue
+		}
+		if depth != 0 {
+			if err := fn(start, p, depth); err != nil {
+				return err
+			}
+		}
+		depth += c[p]
+		start = p
+	}
+	return nil
+}
+
+// CoverageStream computes the same intervals as Coverage for intervals that
+// are added in increasing start order, but only holds the intervals that
+// overlap the current position so that its size does not grow with the
+// number of intervals.
+type CoverageStream struct {
+	fn    func(start, end int, depth float64) error
+	ends  endHeap
+	start int
+	depth float64
+
+	// change of depth at pos that is not yet applied.
+	pos   int
+	delta float64
+}
+
+// NewCoverageStream returns a new CoverageStream that calls fn for each
+// maximal 0-based half-open interval with non-zero depth, as
+// Coverage.Intervals does.
+func NewCoverageStream(fn func(start, end int, depth float64) error) *CoverageStream {
+	return &CoverageStream{fn: fn, pos: -1}
+}
+
+// Add adds depth v to the 0-based half-open interval [start, end). start must
+// not be less than the start of the previously added interval.
+func (s *CoverageStream) Add(start, end int, v float64) error {
+	for len(s.ends) > 0 && s.ends[0].pos <= start {
+		e := heap.Pop(&s.ends).(posChange)
+		if err := s.change(e.pos, e.delta); err != nil {
+			return err
+		}
+	}
+	if err := s.change(start, v); err != nil {
+		return err
+	}
+	heap.Push(&s.ends, posChange{end, -v})
+	return nil
+}
+
+// Flush ends all added intervals. The stream can then be reused for the
+// next reference.
+func (s *CoverageStream) Flush() error {
+	for len(s.ends) > 0 {
+		e := heap.Pop(&s.ends).(posChange)
+		if err := s.change(e.pos, e.delta); err != nil {
+			return err
+		}
+	}
+	if err := s.apply(); err != nil {
+		return err
+	}
+	s.pos, s.delta, s.depth = -1, 0, 0
+	return nil
+}
+
+// change adds d to the depth at pos. Changes at the same position are
+// combined before they are applied.
+func (s *CoverageStream) change(pos int, d float64) error {
+	if pos != s.pos {
+		if err := s.apply(); err != nil {
+			return err
+		}
+		s.pos, s.delta = pos, 0
+	}
+	s.delta += d
+	return nil
+}
+
+func (s *CoverageStream) apply() error {
+	if s.delta == 0 {
+		return nil
+	}
+	if s.depth != 0 {
+		if err := s.fn(s.start, s.pos, s.depth); err != nil {
+			return err
+		}
+	}
+	s.depth += s.delta
+	s.start = s.pos
+	s.delta = 0
+	return nil
+}
+
+type posChange struct {
+	pos   int
+	delta float64
+}
+
+// endHeap is a min-heap of the ends of intervals.
+type endHeap []posChange
+
+func (h endHeap) Len() int            { return len(h) }
+func (h endHeap) Less(i, j int) bool  { return h[i].pos < h[j].pos }
+func (h endHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *endHeap) Push(x interface{}) { *h = append(*h, x.(posChange)) }
+func (h *endHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// TrackWriter is the interface implemented by writers of per-base values that
+// are added in increasing position order for each reference.
+type TrackWriter interface {
+	Add(rname string, pos int, v float64) error
+	Flush() error
+}
+
+// CapWriter is a TrackWriter that caps each per-base value at Max before
+// adding it to the wrapped TrackWriter e.g. to limit the contribution of
+// jackpot positions piled up by PCR duplicates. Values are not capped if Max
+// is 0.
+type CapWriter struct {
+	TrackWriter
+	Max float64
+}
+
+// Add adds value v, capped at Max, at the 0-based position pos of reference
+// rname.
+func (c *CapWriter) Add(rname string, pos int, v float64) error {
+	if c.Max > 0 && v > c.Max {
+		v = c.Max
+	}
+	return c.TrackWriter.Add(rname, pos, v)
+}
+
+// BedGraphWriter writes per-base values in bedGraph format without holding
+// them in memory. Values must be added in increasing position order for each
+// reference. Consecutive positions with equal values are merged into a single
+// interval and zero values are omitted.
+type BedGraphWriter struct {
+	// Format is the format of values.
+	Format FloatFormat
+
+	w          *bufio.Writer
+	rname      string
+	start, end int
+	v          float64
+	open       bool
+}
+
+// NewBedGraphWriter returns a new BedGraphWriter that writes to w.
+func NewBedGraphWriter(w io.Writer) *BedGraphWriter {
+	return &BedGraphWriter{w: bufio.NewWriter(w)}
+}
+
+// Add adds value v at the 0-based position pos of reference rname.
+func (b *BedGraphWriter) Add(rname string, pos int, v float64) error {
+	if b.open && rname == b.rname && pos == b.end && v == b.v {
+		b.end++
+		return nil
+	}
+	if err := b.flushInterval(); err != nil {
+		return err
+	}
+	if v != 0 {
+		b.rname, b.start, b.end, b.v, b.open = rname, pos, pos+1, v, true
+	}
+	return nil
+}
+
+// Flush writes any pending interval and flushes the underlying writer.
+func (b *BedGraphWriter) Flush() error {
+	if err := b.flushInterval(); err != nil {
+		return err
+	}
+	return b.w.Flush()
+}
+
+func (b *BedGraphWriter) flushInterval() error {
+	if !b.open {
+		return nil
+	}
+	b.open = false
+	_, err := b.w.WriteString(b.rname + "\t" + strconv.Itoa(b.start) + "\t" +
+		strconv.Itoa(b.end) + "\t" + b.Format.Format(b.v) + "\n")
+	return err
+}
+
+// BinAgg is the function that aggregates the per-base values of a bin.
+type BinAgg int
+
+// Valid bin aggregation functions. BinMean divides the sum by the bin size so
+// that positions without values count as zero.
+const (
+	BinSum BinAgg = iota
+	BinMean
+	BinMax
+)
+
+// ParseBinAgg parses a bin aggregation function. Valid values are "sum",
+// "mean" and "max".
+func ParseBinAgg(s string) (BinAgg, error) {
+	switch s {
+	case "sum":
+		return BinSum, nil
+	case "mean":
+		return BinMean, nil
+	case "max":
+		return BinMax, nil
+	}
+	return 0, fmt.Errorf("htsdb: invalid bin aggregation %q", s)
+}
+
+// BinWriter aggregates per-base values in fixed size bins and writes them as
+// binned bedGraph or fixedStep wiggle. Values must be added in increasing
+// position order for each reference. Bins with zero value are omitted.
+type BinWriter struct {
+	// Lengths optionally holds reference lengths used to clip the last bin of
+	// each reference.
+	Lengths map[string]int
+	// Format is the format of values.
+	Format FloatFormat
+
+	w    *bufio.Writer
+	size int
+	agg  BinAgg
+	wig  bool
+
+	rname    string
+	bin      int
+	sum, max float64
+	open     bool
+
+	// last bin written in the current fixedStep block.
+	wigRname string
+	wigBin   int
+	wigOpen  bool
+}
+
+// NewBinWriter returns a new BinWriter that writes bins of size bases to w.
+// Output is fixedStep wiggle if wig is true and bedGraph otherwise.
+func NewBinWriter(w io.Writer, size int, agg BinAgg, wig bool) *BinWriter {
+	if size < 1 {
+		size = 1
+	}
+	return &BinWriter{w: bufio.NewWriter(w), size: size, agg: agg, wig: wig}
+}
+
+// Add adds value v at the 0-based position pos of reference rname.
+func (b *BinWriter) Add(rname string, pos int, v float64) error {
+	bin := pos / b.size
+	if b.open && (rname != b.rname || bin != b.bin) {
+		if err := b.flushBin(); err != nil {
+			return err
+		}
+	}
+	if !b.open {
+		b.rname, b.bin, b.sum, b.max, b.open = rname, bin, 0, v, true
+	}
+	b.sum += v
+	if v > b.max {
+		b.max = v
+	}
+	return nil
+}
+
+// Flush writes any pending bin and flushes the underlying writer.
+func (b *BinWriter) Flush() error {
+	if err := b.flushBin(); err != nil {
+		return err
+	}
+	return b.w.Flush()
+}
+
+func (b *BinWriter) flushBin() error {
+	if !b.open {
+		return nil
+	}
+	b.open = false
+	start, end := b.bin*b.size, (b.bin+1)*b.size
+	if l, ok := b.Lengths[b.rname]; ok && end > l && l > start {
+		end = l
+	}
+	v := b.sum
+	switch b.agg {
+	case BinMean:
+		v = b.sum / float64(end-start)
+	case BinMax:
+		v = b.max
+	}
+	if v == 0 {
+		return nil
+	}
+	val := b.Format.Format(v)
+	if !b.wig {
+		_, err := b.w.WriteString(b.rname + "\t" + strconv.Itoa(start) + "\t" +
+			strconv.Itoa(end) + "\t" + val + "\n")
+		return err
+	}
+	if !b.wigOpen || b.rname != b.wigRname || b.bin != b.wigBin+1 {
+		_, err := fmt.Fprintf(b.w, "fixedStep chrom=%s start=%d step=%d span=%d\n",
+			b.rname, start+1, b.size, b.size)
+		if err != nil {
+			return err
+		}
+	}
+	b.wigRname, b.wigBin, b.wigOpen = b.rname, b.bin, true
+	_, err := b.w.WriteString(val + "\n")
+	return err
+}
@@ -0,0 +1,124 @@
+package htsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWriteBedGraph(t *testing.T) {
+	tr := Track{1: 2, 2: 2, 3: 1, 5: 1, 6: 0, 7: 0.5}
+	var buf bytes.Buffer
+	if err := WriteBedGraph(&buf, "chr1", tr); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := "chr1\t1\t3\t2\nchr1\t3\t4\t1\nchr1\t5\t6\t1\nchr1\t7\t8\t0.5\n"
+	if buf.String() != expected {
+		t.Errorf("wrong bedGraph: expected %q, actual %q", expected, buf.String())
+	}
+}
+
+func TestCoverageWriteBedGraph(t *testing.T) {
+	c := make(Coverage)
+	c.Add(2, 5, 1)
+	c.Add(5, 8, 1)
+	c.Add(4, 6, 2)
+	c.Add(10, 12, 0.5)
+	var buf bytes.Buffer
+	if err := c.WriteBedGraph(&buf, "chr1", FloatFormat{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := "chr1\t2\t4\t1\nchr1\t4\t6\t3\nchr1\t6\t8\t1\nchr1\t10\t12\t0.5\n"
+	if buf.String() != expected {
+		t.Errorf("wrong bedGraph: expected %q, actual %q", expected, buf.String())
+	}
+}
+
+func TestCoverageStream(t *testing.T) {
+	intervals := [][3]float64{
+		{2, 5, 1}, {2, 3, 1}, {4, 6, 2}, {5, 8, 1}, {8, 9, 1}, {10, 12, 0.5},
+	}
+	c := make(Coverage)
+	for _, iv := range intervals {
+		c.Add(int(iv[0]), int(iv[1]), iv[2])
+	}
+	var expected, actual bytes.Buffer
+	if err := c.WriteBedGraph(&expected, "chr1", FloatFormat{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	ff := FloatFormat{}
+	s := NewCoverageStream(func(start, end int, depth float64) error {
+		_, err := fmt.Fprintf(&actual, "chr1\t%d\t%d\t%s\n", start, end, ff.Format(depth))
+		return err
+	})
+	for _, iv := range intervals {
+		if err := s.Add(int(iv[0]), int(iv[1]), iv[2]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if actual.String() != expected.String() {
+		t.Errorf("expected %q, actual %q", expected.String(), actual.String())
+	}
+}
+
+func TestBinWriter(t *testing.T) {
+	values := []struct {
+		rname string
+		pos   int
+		v     float64
+	}{
+		{"chr1", 0, 1}, {"chr1", 3, 3}, {"chr1", 4, 2}, {"chr1", 12, 4},
+		{"chr1", 21, 1}, {"chr2", 1, 5},
+	}
+	tests := []struct {
+		agg      BinAgg
+		wig      bool
+		expected string
+	}{
+		{BinSum, false, "chr1\t0\t4\t4\nchr1\t4\t8\t2\nchr1\t12\t16\t4\n" +
+			"chr1\t20\t22\t1\nchr2\t0\t4\t5\n"},
+		{BinMean, false, "chr1\t0\t4\t1\nchr1\t4\t8\t0.5\nchr1\t12\t16\t1\n" +
+			"chr1\t20\t22\t0.5\nchr2\t0\t4\t1.25\n"},
+		{BinMax, true, "fixedStep chrom=chr1 start=1 step=4 span=4\n3\n2\n" +
+			"fixedStep chrom=chr1 start=13 step=4 span=4\n4\n" +
+			"fixedStep chrom=chr1 start=21 step=4 span=4\n1\n" +
+			"fixedStep chrom=chr2 start=1 step=4 span=4\n5\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewBinWriter(&buf, 4, tt.agg, tt.wig)
+		w.Lengths = map[string]int{"chr1": 22}
+		for _, v := range values {
+			if err := w.Add(v.rname, v.pos, v.v); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("agg %d, wig %t: expected %q, actual %q", tt.agg, tt.wig,
+				tt.expected, buf.String())
+		}
+	}
+}
+
+func TestCapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &CapWriter{TrackWriter: NewBedGraphWriter(&buf), Max: 3}
+	for p, v := range []float64{1, 5, 3, 100} {
+		if err := w.Add("chr1", p, v); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := "chr1\t0\t1\t1\nchr1\t1\t4\t3\n"
+	if buf.String() != expected {
+		t.Errorf("wrong bedGraph: expected %q, actual %q", expected, buf.String())
+	}
+}
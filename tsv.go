@@ -0,0 +1,120 @@
+package htsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TSVWriter writes rows of values as tab separated lines. Free text values
+// e.g. read names and SAM tags may contain tabs and newlines that would
+// otherwise break the row; by default they are escaped as \t, \n and \r, and
+// backslashes as \\, so that each record stays on a single line with a fixed
+// number of fields. Invalid UTF-8 bytes are escaped as \xHH so that the output
+// is always valid UTF-8.
+type TSVWriter struct {
+	// NA is written for NULL values.
+	NA string
+	// Quote encloses fields with tabs, newlines or double quotes in double
+	// quotes and doubles embedded quotes, like spreadsheet software, instead
+	// of escaping them. Invalid UTF-8 bytes are replaced by U+FFFD.
+	Quote bool
+	// Format is the format of real numbers.
+	Format FloatFormat
+
+	w *bufio.Writer
+}
+
+// NewTSVWriter returns a TSVWriter that writes to w and prints NULL values as
+// NA.
+func NewTSVWriter(w io.Writer) *TSVWriter {
+	return &TSVWriter{NA: "NA", w: bufio.NewWriter(w)}
+}
+
+// Write writes a row with vals. Values may be nil for NULL, strings, byte
+// slices as returned by the database driver, integers, floats or any value
+// that fmt can print.
+func (t *TSVWriter) Write(vals ...interface{}) error {
+	for i, v := range vals {
+		if i > 0 {
+			if err := t.w.WriteByte('\t'); err != nil {
+				return err
+			}
+		}
+		if _, err := t.w.WriteString(t.field(v)); err != nil {
+			return err
+		}
+	}
+	return t.w.WriteByte('\n')
+}
+
+// WriteStrings writes a row with the strings vals e.g. a header line.
+func (t *TSVWriter) WriteStrings(vals []string) error {
+	row := make([]interface{}, len(vals))
+	for i, v := range vals {
+		row[i] = v
+	}
+	return t.Write(row...)
+}
+
+// Flush flushes the underlying writer.
+func (t *TSVWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// field returns v formatted as a field.
+func (t *TSVWriter) field(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return t.NA
+	case string:
+		return t.text(v)
+	case []byte:
+		return t.text(string(v))
+	case float64:
+		return t.Format.Format(v)
+	case float32:
+		return t.Format.Format(float64(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return t.text(fmt.Sprint(v))
+}
+
+// text returns s escaped or quoted.
+func (t *TSVWriter) text(s string) string {
+	if t.Quote {
+		if !strings.ContainsAny(s, "\t\n\r\"") {
+			return strings.ToValidUTF8(s, "\uFFFD")
+		}
+		return `"` + strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), `"`, `""`) + `"`
+	}
+	if !strings.ContainsAny(s, "\t\n\r\\") && utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02X`, s[i])
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package htsdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTSVWriter(t *testing.T) {
+	tests := []struct {
+		Quote bool
+		NA    string
+		In    []interface{}
+		Out   string
+	}{
+		{false, "NA", []interface{}{"r1", int64(3), 0.5, nil}, "r1\t3\t0.5\tNA\n"},
+		{false, "", []interface{}{nil, []byte("chr1")}, "\tchr1\n"},
+		{false, "NA", []interface{}{"NM:i:0\tMD:Z:5", "a\nb\\c"}, "NM:i:0\\tMD:Z:5\ta\\nb\\\\c\n"},
+		{false, "NA", []interface{}{"r\xff1", "réad"}, "r\\xFF1\tréad\n"},
+		{true, "NA", []interface{}{"NM:i:0\tMD:Z:5", `say "hi"`, `a\b`}, "\"NM:i:0\tMD:Z:5\"\t\"say \"\"hi\"\"\"\ta\\b\n"},
+		{true, "NA", []interface{}{"r\xff1"}, "r�1\n"},
+	}
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		w := NewTSVWriter(&buf)
+		w.Quote, w.NA = tt.Quote, tt.NA
+		if err := w.Write(tt.In...); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.Out {
+			t.Errorf("test %d: expected %q, actual %q", i, tt.Out, got)
+		}
+	}
+}
+
+func TestTSVWriterFormat(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTSVWriter(&buf)
+	w.Format = FloatFormat{Notation: 'f', Precision: 2}
+	if err := w.WriteStrings([]string{"qname", "score"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("r1", 1.0/3); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	if exp := "qname\tscore\nr1\t0.33\n"; buf.String() != exp {
+		t.Errorf("expected %q, actual %q", exp, buf.String())
+	}
+}
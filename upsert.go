@@ -0,0 +1,41 @@
+package htsdb
+
+import "fmt"
+
+// DupPolicy determines how an imported record that is identical to a stored
+// record, except for its copy number, is handled e.g. when appending to an
+// existing database or re-importing part of a file.
+type DupPolicy int
+
+// Valid duplicate policies. DupKeep is the default.
+const (
+	// DupKeep stores the record as a new row.
+	DupKeep DupPolicy = iota
+	// DupSkip discards the record.
+	DupSkip
+	// DupSum adds the copy number of the record to the stored row.
+	DupSum
+	// DupError stops the import with an error.
+	DupError
+)
+
+// DupPolicies lists the command line names of the policies in order.
+var DupPolicies = []string{"keep", "skip", "sum", "error"}
+
+// ParseDupPolicy parses a duplicate policy name.
+func ParseDupPolicy(s string) (DupPolicy, error) {
+	for i, p := range DupPolicies {
+		if s == p {
+			return DupPolicy(i), nil
+		}
+	}
+	return DupKeep, fmt.Errorf("htsdb: invalid duplicate policy %q", s)
+}
+
+// String returns the command line name of p.
+func (p DupPolicy) String() string {
+	if p >= 0 && int(p) < len(DupPolicies) {
+		return DupPolicies[p]
+	}
+	return fmt.Sprintf("DupPolicy(%d)", int(p))
+}
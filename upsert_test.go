@@ -0,0 +1,15 @@
+package htsdb
+
+import "testing"
+
+func TestParseDupPolicy(t *testing.T) {
+	for i, s := range DupPolicies {
+		p, err := ParseDupPolicy(s)
+		if err != nil || p != DupPolicy(i) || p.String() != s {
+			t.Errorf("ParseDupPolicy(%q): got %v, %v", s, p, err)
+		}
+	}
+	if _, err := ParseDupPolicy("merge"); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+}
@@ -0,0 +1,63 @@
+package htsdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MaxRowid returns the largest rowid of table in db or 0 if it is empty. db
+// must be a SQLite database.
+func MaxRowid(db *sqlx.DB, table string) (int64, error) {
+	if err := requireSQLite(db, "rowid"); err != nil {
+		return 0, err
+	}
+	var max int64
+	err := db.Get(&max, "SELECT COALESCE(MAX(rowid), 0) FROM "+table)
+	return max, err
+}
+
+// Watcher polls a table that is being filled e.g. by an importer during a
+// sequencing run for appended rows. It tracks the largest rowid seen so far
+// and therefore assumes that rows are only appended, never deleted or
+// updated. Only SQLite databases have a rowid and can be watched.
+type Watcher struct {
+	db       *sqlx.DB
+	table    string
+	interval time.Duration
+	last     int64
+	started  bool
+}
+
+// NewWatcher returns a Watcher for table in db that polls every interval.
+func NewWatcher(db *sqlx.DB, table string, interval time.Duration) *Watcher {
+	return &Watcher{db: db, table: table, interval: interval}
+}
+
+// Next returns the rowid range (from, to] of the rows appended since the
+// previous call. The first call returns all existing rows without waiting,
+// even if there are none; later calls block until rows are appended or ctx
+// is done, in which case ctx.Err() is returned.
+func (w *Watcher) Next(ctx context.Context) (from, to int64, err error) {
+	for {
+		max, err := MaxRowid(w.db, w.table)
+		if err != nil {
+			return 0, 0, err
+		}
+		if max > w.last || !w.started {
+			from, w.last, w.started = w.last, max, true
+			return from, max, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+// Last returns the largest rowid returned by Next.
+func (w *Watcher) Last() int64 {
+	return w.last
+}
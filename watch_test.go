@@ -0,0 +1,54 @@
+package htsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWatcher(t *testing.T) {
+	sqlDB := newTestDB(t)
+	sqlDB.SetMaxOpenConns(1)
+	db := sqlx.NewDb(sqlDB, "sqlite3")
+	defer db.Close()
+
+	w := NewWatcher(db, "foo", time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if from, to, err := w.Next(ctx); from != 0 || to != 0 || err != nil {
+		t.Fatalf("expected empty first range, got (%d, %d], %v", from, to, err)
+	}
+	if _, err := db.Exec("INSERT INTO foo VALUES (1, 2), (3, 4)"); err != nil {
+		t.Fatal(err)
+	}
+	if from, to, err := w.Next(ctx); from != 0 || to != 2 || err != nil {
+		t.Fatalf("expected (0, 2], got (%d, %d], %v", from, to, err)
+	}
+	if _, err := db.Exec("INSERT INTO foo VALUES (5, 6)"); err != nil {
+		t.Fatal(err)
+	}
+	if from, to, err := w.Next(ctx); from != 2 || to != 3 || err != nil {
+		t.Fatalf("expected (2, 3], got (%d, %d], %v", from, to, err)
+	}
+	var n int
+	if err := db.Get(&n, "SELECT COUNT(*) FROM "+ColumnMap{}.TableRows("foo", 2, 3)); err != nil || n != 1 {
+		t.Errorf("expected 1 row, got %d, %v", n, err)
+	}
+
+	// no appended rows.
+	cancel()
+	if _, _, err := w.Next(ctx); err == nil {
+		t.Error("expected context error")
+	}
+}
+
+func TestMaxRowidNotSQLite(t *testing.T) {
+	db := sqlx.NewDb(newTestDB(t), Postgres)
+	defer db.Close()
+
+	if _, err := MaxRowid(db, "foo"); err == nil {
+		t.Error("expected error for a postgres database")
+	}
+}
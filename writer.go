@@ -0,0 +1,178 @@
+package htsdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Writer inserts records into a table. It is the counterpart of Reader: the
+// fields of the record struct are mapped to columns by their db tag or lower
+// case name, like sqlx, and the fields of embedded structs are flattened so
+// that Range, Feature, OrientedFeature, SamRecord and records that embed them
+// can be written directly. Like Reader, the integer start and stop fields of
+// records are in HtsdbCoords and are converted to the convention stored in the
+// database. Rows are inserted with the prepared statements and transactions
+// of a Loader. A Writer is not safe for concurrent use.
+type Writer struct {
+	l           *Loader
+	typ         reflect.Type
+	fields      [][]int
+	vals        []interface{}
+	coords      Coords
+	start, stop int // indexes of the start and stop columns or -1.
+}
+
+// NewWriter returns a Writer that inserts records of the struct type of rec,
+// a struct or a pointer to one, into table in db.
+func NewWriter(db *sqlx.DB, table string, rec interface{}, opts LoadOptions) (*Writer, error) {
+	t := reflect.TypeOf(rec)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("htsdb: record must be a struct or a pointer to struct, got %T", rec)
+	}
+	cols, fields := structColumns(t, nil)
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("htsdb: record of type %s has no columns", t)
+	}
+	coords, err := SelectCoords(db)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLoader(db, table, cols, opts)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{l: l, typ: t, fields: fields, vals: make([]interface{}, len(cols)),
+		coords: coords, start: -1, stop: -1}
+	for i, col := range cols {
+		if kind := t.FieldByIndex(fields[i]).Type.Kind(); kind != reflect.Int {
+			continue
+		}
+		switch col {
+		case "start":
+			w.start = i
+		case "stop":
+			w.stop = i
+		}
+	}
+	return w, nil
+}
+
+// structColumns returns the columns of struct type t and the index of the
+// field of each one.
+func structColumns(t reflect.Type, parent []int) ([]string, [][]int) {
+	var cols []string
+	var fields [][]int
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append([]int(nil), parent...), i)
+		col := sf.Tag.Get("db")
+		if col == "-" || (sf.PkgPath != "" && !sf.Anonymous) {
+			continue
+		}
+		if sf.Anonymous && col == "" && sf.Type.Kind() == reflect.Struct {
+			c, f := structColumns(sf.Type, index)
+			cols, fields = append(cols, c...), append(fields, f...)
+			continue
+		}
+		if col == "" {
+			col = strings.ToLower(sf.Name)
+		}
+		cols, fields = append(cols, col), append(fields, index)
+	}
+	return cols, fields
+}
+
+// Columns returns the columns that the Writer inserts into.
+func (w *Writer) Columns() []string { return w.l.cols }
+
+// SetCoords sets the coordinate convention of the inserted rows, which is
+// otherwise read from the metadata of the database e.g. HtsdbCoords for
+// records whose coordinates are already converted.
+func (w *Writer) SetCoords(c Coords) { w.coords = c }
+
+// Write buffers rec for insertion. rec must be of the type given to NewWriter
+// or a pointer to it.
+func (w *Writer) Write(rec interface{}) error {
+	v := reflect.ValueOf(rec)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Type() != w.typ {
+		return fmt.Errorf("htsdb: cannot write %T with a writer of %s", rec, w.typ)
+	}
+	for i, index := range w.fields {
+		w.vals[i] = v.FieldByIndex(index).Interface()
+	}
+	if w.start >= 0 && w.stop >= 0 && w.coords != HtsdbCoords {
+		w.vals[w.start], w.vals[w.stop] = w.coords.FromHtsdb(w.vals[w.start].(int),
+			w.vals[w.stop].(int))
+	}
+	return w.l.Add(w.vals...)
+}
+
+// DeferIndex registers a CREATE INDEX statement that is executed by Close,
+// after all records are written, as Loader.DeferIndex.
+func (w *Writer) DeferIndex(stmt string) { w.l.DeferIndex(stmt) }
+
+// Flush inserts the buffered records and commits them.
+func (w *Writer) Flush() error { return w.l.Flush() }
+
+// Rows returns the number of records inserted so far, excluding buffered
+// records.
+func (w *Writer) Rows() int64 { return w.l.Rows() }
+
+// Close flushes the buffered records and releases the connection of the
+// Writer.
+func (w *Writer) Close() error { return w.l.Close() }
+
+// Abort discards the buffered and uncommitted records and releases the
+// connection of the Writer.
+func (w *Writer) Abort() error { return w.l.Abort() }
+
+// BulkInsert inserts records, a slice of structs or of pointers to structs,
+// into table of db in transactions of batchSize rows and returns the number of
+// inserted rows. Fields are mapped to columns as by Writer. If batchSize is
+// not positive, all records are inserted in a single transaction. On error
+// the transaction of the failed record is rolled back and only the rows of
+// the transactions committed before it are kept and counted.
+func BulkInsert(db *sqlx.DB, table string, records interface{}, batchSize int) (int64, error) {
+	opts := DefaultLoadOptions
+	opts.TxRows = batchSize
+	if batchSize > 0 && batchSize < opts.BatchRows {
+		opts.BatchRows = batchSize
+	}
+	return BulkInsertOptions(db, table, records, opts)
+}
+
+// BulkInsertOptions is like BulkInsert but loads with opts e.g. to disable
+// synchronous writes during the load with LoadOptions.Unsafe.
+func BulkInsertOptions(db *sqlx.DB, table string, records interface{}, opts LoadOptions,
+) (int64, error) {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("htsdb: records must be a slice, got %T", records)
+	}
+	w, err := NewWriter(db, table, reflect.Zero(v.Type().Elem()).Interface(), opts)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < v.Len(); i++ {
+		rec := v.Index(i)
+		if rec.Kind() == reflect.Ptr && rec.IsNil() {
+			w.Abort()
+			return w.Rows(), fmt.Errorf("htsdb: nil record at index %d", i)
+		}
+		if err = w.Write(rec.Interface()); err != nil {
+			w.Abort()
+			return w.Rows(), err
+		}
+	}
+	err = w.Close()
+	return w.Rows(), err
+}
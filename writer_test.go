@@ -0,0 +1,156 @@
+package htsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStructColumns(t *testing.T) {
+	type record struct {
+		OrientedFeature
+		Qname string
+		Score int `db:"-"`
+		seen  bool
+	}
+	cols, fields := structColumns(reflect.TypeOf(record{}), nil)
+	expected := []string{"strand", "rname", "start", "stop", "copy_number", "qname"}
+	if !reflect.DeepEqual(cols, expected) {
+		t.Errorf("expected %v, actual %v", expected, cols)
+	}
+	if !reflect.DeepEqual(fields[len(fields)-1], []int{1}) {
+		t.Errorf("expected field index [1], actual %v", fields[len(fields)-1])
+	}
+}
+
+func TestWriter(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	db.MustExec("CREATE TABLE f (rname TEXT, start INTEGER, stop INTEGER, copy_number INTEGER)")
+
+	if _, err := NewWriter(db, "f", 1, DefaultLoadOptions); err == nil {
+		t.Error("expected error for non-struct record")
+	}
+	w, err := NewWriter(db, "f", &Feature{}, LoadOptions{BatchRows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(Range{}); err == nil {
+		t.Error("expected error for record of other type")
+	}
+	for i := 0; i < 3; i++ {
+		f := &Feature{Rname: "chr1", Range: Range{StartPos: i, StopPos: i + 10}}
+		if err = w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Rows() != 3 {
+		t.Errorf("expected 3 rows, actual %d", w.Rows())
+	}
+	var sum int
+	if err = db.Get(&sum, "SELECT SUM(stop) FROM f"); err != nil || sum != 33 {
+		t.Errorf("expected sum of stops 33, actual %d, %v", sum, err)
+	}
+}
+
+func TestWriterCoords(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+	db.MustExec("CREATE TABLE f (rname TEXT, start INTEGER, stop INTEGER, copy_number INTEGER)")
+	if err := InitCoords(db, SAMCoords); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(db, "f", Feature{}, DefaultLoadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := Feature{Rname: "chr1", Range: Range{StartPos: 10, StopPos: 19, CopyNumber: 1}}
+	if err = w.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var stored Range
+	if err = db.Get(&stored, "SELECT start, stop, copy_number FROM f"); err != nil {
+		t.Fatal(err)
+	}
+	if stored.StartPos != 11 || stored.StopPos != 20 {
+		t.Errorf("expected stored 11-20, actual %d-%d", stored.StartPos, stored.StopPos)
+	}
+
+	r, err := NewReader(db.DB, SQLite, &Feature{}, "SELECT rname, start, stop, copy_number FROM f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Next() {
+		t.Fatal(r.Error())
+	}
+	if got := *r.Record().(*Feature); got != f {
+		t.Errorf("expected %v, actual %v", f, got)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	db, cleanup := loaderDB(t)
+	defer cleanup()
+
+	type pos struct {
+		Start int `db:"start"`
+		Stop  int `db:"stop"`
+	}
+	type rec struct {
+		Rname string `db:"rname"`
+		pos
+		Note string `db:"-"`
+	}
+	recs := []*rec{{"chr1", pos{1, 10}, "a"}, {"chr2", pos{5, 20}, "b"}, {"chr1", pos{7, 9}, "c"}}
+	n, err := BulkInsert(db, "t", recs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 inserted rows, actual %d", n)
+	}
+	var sum int
+	if err := db.Get(&sum, "SELECT SUM(stop - start) FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 26 {
+		t.Errorf("expected sum 26, actual %d", sum)
+	}
+
+	if _, err = BulkInsert(db, "t", rec{}, 2); err == nil {
+		t.Error("expected error for non-slice records")
+	}
+	if _, err = BulkInsert(db, "t", []*rec{nil}, 2); err == nil {
+		t.Error("expected error for nil record")
+	}
+
+	// a failed insert keeps only the transactions committed before the error.
+	db.MustExec("DELETE FROM t")
+	failed := []*rec{recs[0], recs[1], recs[2], nil}
+	if n, err = BulkInsert(db, "t", failed, 2); err == nil || n != 2 {
+		t.Errorf("expected error after 2 rows, actual %d, %v", n, err)
+	}
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 2 {
+		t.Errorf("expected 2 committed rows, actual %d", cnt)
+	}
+	db.MustExec("DELETE FROM t")
+	if n, err = BulkInsert(db, "t", failed, 0); err == nil || n != 0 {
+		t.Errorf("expected error without rows, actual %d, %v", n, err)
+	}
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 0 {
+		t.Errorf("expected no committed rows, actual %d", cnt)
+	}
+}